@@ -0,0 +1,97 @@
+// Package apierr defines the errors the api reports to clients, each with a
+// machine-readable code that maps to one http status.
+package apierr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+type Code string
+
+const (
+	NotFound        Code = "not_found"
+	InvalidArgument Code = "invalid_argument"
+	Unavailable     Code = "unavailable"
+	Unauthenticated Code = "unauthenticated"
+	Conflict        Code = "conflict"
+	Internal        Code = "internal"
+)
+
+// Status returns the http status code is reported with.
+func (code Code) Status() int {
+	switch code {
+	case NotFound:
+		return http.StatusNotFound
+	case InvalidArgument:
+		return http.StatusBadRequest
+	case Unavailable:
+		return http.StatusServiceUnavailable
+	case Unauthenticated:
+		return http.StatusUnauthorized
+	case Conflict:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+type Error struct {
+	Code    Code
+	Message string
+	// Err is the underlying error, if any.
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func New(code Code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Wrap returns err as an Error with code, keeping its message.
+func Wrap(code Code, err error) *Error {
+	return &Error{Code: code, Message: err.Error(), Err: err}
+}
+
+func NotFoundf(format string, args ...interface{}) *Error {
+	return New(NotFound, format, args...)
+}
+
+func InvalidArgumentf(format string, args ...interface{}) *Error {
+	return New(InvalidArgument, format, args...)
+}
+
+func Unavailablef(format string, args ...interface{}) *Error {
+	return New(Unavailable, format, args...)
+}
+
+func Unauthenticatedf(format string, args ...interface{}) *Error {
+	return New(Unauthenticated, format, args...)
+}
+
+func Conflictf(format string, args ...interface{}) *Error {
+	return New(Conflict, format, args...)
+}
+
+// From classifies any error: an Error anywhere in its chain is returned as
+// is, a deadline as Unavailable, and anything else is Internal. The last
+// two get a fixed message, so that what went wrong inside, such as a
+// database address, isn't reported to clients; err is kept as the cause.
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &Error{Code: Unavailable, Message: "timed out", Err: err}
+	}
+	return &Error{Code: Internal, Message: "internal error", Err: err}
+}
@@ -0,0 +1,55 @@
+package apierr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestFrom(t *testing.T) {
+	notFound := NotFoundf("block %d not found", 7)
+	cause := errors.New("dial tcp 10.0.0.5:27017: connection refused")
+	tests := []struct {
+		name    string
+		err     error
+		code    Code
+		message string
+		cause   error
+	}{
+		{name: "api error", err: notFound, code: NotFound, message: "block 7 not found"},
+		{name: "wrapped api error", err: fmt.Errorf("looking up: %w", notFound), code: NotFound, message: "block 7 not found"},
+		{name: "wrap keeps the message", err: Wrap(InvalidArgument, errors.New("bad bytecode")), code: InvalidArgument, message: "bad bytecode"},
+		{name: "deadline", err: fmt.Errorf("query blocks on 10.0.0.5: %w", context.DeadlineExceeded), code: Unavailable, message: "timed out", cause: context.DeadlineExceeded},
+		{name: "unknown", err: cause, code: Internal, message: "internal error", cause: cause},
+		{name: "wrapped unknown", err: fmt.Errorf("reading blocks: %w", cause), code: Internal, message: "internal error", cause: cause},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := From(tt.err)
+			if e.Code != tt.code || e.Message != tt.message || e.Error() != tt.message {
+				t.Errorf("From(%v) = %s %q", tt.err, e.Code, e.Message)
+			}
+			if tt.cause != nil && !errors.Is(e, tt.cause) {
+				t.Errorf("From(%v) lost its cause", tt.err)
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	for code, want := range map[Code]int{
+		NotFound:        http.StatusNotFound,
+		InvalidArgument: http.StatusBadRequest,
+		Unavailable:     http.StatusServiceUnavailable,
+		Unauthenticated: http.StatusUnauthorized,
+		Conflict:        http.StatusConflict,
+		Internal:        http.StatusInternalServerError,
+		Code("other"):   http.StatusInternalServerError,
+	} {
+		if got := code.Status(); got != want {
+			t.Errorf("%s.Status() = %d, want %d", code, got, want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/ubiq/spectrum-api/apierr"
+	. "github.com/ubiq/spectrum-api/dao"
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+// accountAddress reads the {address} of the account routes.
+func accountAddress(r *http.Request) (string, error) {
+	address := mux.Vars(r)["address"]
+	if !validHex(address, []int{20}) {
+		return "", apierr.InvalidArgumentf("address must be 0x-prefixed hex of 20 bytes")
+	}
+	return address, nil
+}
+
+// getBalance returns the native and token balances of an account at the
+// end of ?block=, the latest block by default.
+func getBalance(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	address, err := accountAddress(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	head, err := chainHead(ctx)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	number := head
+	if v := r.URL.Query().Get("block"); v != "" {
+		if number, err = parseBlockNumber(v); err != nil {
+			respondWithError(w, r, err)
+			return
+		}
+		if number > head {
+			respondWithError(w, r, apierr.NotFoundf("block %d is not indexed yet", number))
+			return
+		}
+	}
+
+	balance, err := BalanceAt(ctx, backend(ctx), address, number)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	respondWithJson(w, r, http.StatusOK, balance)
+}
+
+// getBalanceHistory returns the balance of an account, in the coin or the
+// ?token= contract, at the end of every ?interval= period it changed in
+// from ?fromBlock= to ?toBlock=, oldest first. The balances are summed from
+// the latest checkpoint before fromBlock.
+func getBalanceHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	address, err := accountAddress(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	interval, err := parseInterval(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	q := r.URL.Query()
+	token := strings.ToLower(q.Get("token"))
+	if token != "" && !validHex(token, []int{20}) {
+		respondWithError(w, r, apierr.InvalidArgumentf("token must be 0x-prefixed hex of 20 bytes"))
+		return
+	}
+	head, err := chainHead(ctx)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	from, to := uint64(0), head
+	for name, v := range map[string]*uint64{"fromBlock": &from, "toBlock": &to} {
+		if s := q.Get(name); s != "" {
+			if *v, err = parseBlockNumber(s); err != nil {
+				respondWithError(w, r, err)
+				return
+			}
+		}
+	}
+	if to > head {
+		to = head
+	}
+
+	start, since := Balance{Address: address, Balance: "0"}, uint64(0)
+	if from > 0 {
+		checkpoint, err := backend(ctx).BalanceCheckpoint(ctx, address, from-1)
+		switch {
+		case err == nil:
+			start, since = checkpoint, checkpoint.BlockNumber+1
+		case err != ErrNotFound:
+			respondWithError(w, r, err)
+			return
+		}
+	}
+	changes, err := backend(ctx).BalanceChanges(ctx, address, since, to)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	history := []BalancePoint{}
+	set := NewBalanceSet(start)
+	for _, c := range changes {
+		set.Add(c)
+		if c.Contract != token || c.BlockNumber < from {
+			continue
+		}
+		period := periodStart(interval, c.Timestamp)
+		if n := len(history); n == 0 || history[n-1].Timestamp != period {
+			history = append(history, BalancePoint{Timestamp: period})
+		}
+		p := &history[len(history)-1]
+		p.BlockNumber = c.BlockNumber
+		p.Balance = set[token].String()
+	}
+	respondWithJson(w, r, http.StatusOK, history)
+}
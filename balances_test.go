@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	. "github.com/ubiq/spectrum-api/config"
+	. "github.com/ubiq/spectrum-api/dao"
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+const miner = "0x3fb9f5e5b1e8b9d1d95e0b5ee1d3b83fa6d0e6a9"
+
+// testBalances serves a chain of blocks 1..5, a day apart, each paying
+// miner 10 wei, with a checkpoint of 1000 wei at block 2 that the ledger
+// can't account for. The chain has no node to ask.
+func testBalances(t *testing.T) *BoltDAO {
+	saved := chains
+	t.Cleanup(func() { chains = saved })
+	db := &BoltDAO{DataDir: t.TempDir()}
+	db.Connect()
+	t.Cleanup(db.Close)
+	ctx := context.Background()
+	for i := uint64(1); i <= 5; i++ {
+		b := Block{Number: i, Hash: "0x" + strconv.FormatUint(i, 16), Miner: miner, Timestamp: 1500000000 + 86400*i, BlockReward: "10"}
+		if err := db.AddBlock(ctx, b, nil, nil, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.SetBalanceCheckpoint(ctx, Balance{Address: miner, BlockNumber: 2, Balance: "1000", Tokens: []TokenBalance{}}); err != nil {
+		t.Fatal(err)
+	}
+	chains = []*chain{{Chain: Chain{Name: "ubiq"}, dao: db}}
+	return db
+}
+
+func getJSON(t *testing.T, path string, out interface{}) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s answered %d %s", path, rec.Code, rec.Body)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetBalance(t *testing.T) {
+	db := testBalances(t)
+
+	tests := []struct {
+		block string
+		want  string
+	}{
+		{block: "1", want: "10"},
+		{block: "2", want: "1000"},
+		{block: "4", want: "1020"},
+		{block: "", want: "1030"},
+	}
+	for _, tt := range tests {
+		var b Balance
+		getJSON(t, "/v1/account/"+miner+"/balance?block="+tt.block, &b)
+		if b.Balance != tt.want {
+			t.Errorf("balance at %q = %s, want %s", tt.block, b.Balance, tt.want)
+		}
+	}
+
+	// Lookups only read: the checkpoint at block 2 is still the latest.
+	if b, err := db.BalanceCheckpoint(context.Background(), miner, 5); err != nil || b.BlockNumber != 2 {
+		t.Errorf("latest checkpoint = %+v, %v, want block 2", b, err)
+	}
+}
+
+func TestGetBalanceHistory(t *testing.T) {
+	testBalances(t)
+	day := func(i uint64) uint64 { return 1500000000 + 86400*i - (1500000000+86400*i)%86400 }
+
+	tests := []struct {
+		query string
+		want  []BalancePoint
+	}{
+		{
+			query: "",
+			want: []BalancePoint{
+				{Timestamp: day(1), BlockNumber: 1, Balance: "10"},
+				{Timestamp: day(2), BlockNumber: 2, Balance: "20"},
+				{Timestamp: day(3), BlockNumber: 3, Balance: "30"},
+				{Timestamp: day(4), BlockNumber: 4, Balance: "40"},
+				{Timestamp: day(5), BlockNumber: 5, Balance: "50"},
+			},
+		},
+		{
+			query: "fromBlock=3",
+			want: []BalancePoint{
+				{Timestamp: day(3), BlockNumber: 3, Balance: "1010"},
+				{Timestamp: day(4), BlockNumber: 4, Balance: "1020"},
+				{Timestamp: day(5), BlockNumber: 5, Balance: "1030"},
+			},
+		},
+		{
+			query: "fromBlock=4&toBlock=4",
+			want:  []BalancePoint{{Timestamp: day(4), BlockNumber: 4, Balance: "1020"}},
+		},
+		{
+			query: "fromBlock=6",
+			want:  []BalancePoint{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			var history []BalancePoint
+			getJSON(t, "/v1/account/"+miner+"/balance/history?"+tt.query, &history)
+			if !reflect.DeepEqual(history, tt.want) {
+				t.Errorf("history = %+v\nwant %+v", history, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	. "github.com/ubiq/spectrum-api/config"
+	. "github.com/ubiq/spectrum-api/dao"
+	"github.com/ubiq/spectrum-api/indexer"
+	"github.com/ubiq/spectrum-api/prices"
+)
+
+// chain is a network the api serves, with the backend, node, pending pool
+// and caches kept for it.
+type chain struct {
+	Chain
+	dao      Backend
+	rpc      *indexer.RPC
+	pool     *indexer.Pool
+	gas      *gasOracle
+	emission *emissionCache
+	labels   *labelCache
+}
+
+// chains are the served networks, the default first.
+var chains []*chain
+
+type ChainInfo struct {
+	Name    string `bson:"name" json:"name"`
+	ChainId uint64 `bson:"chainId" json:"chainId"`
+	Symbol  string `bson:"symbol" json:"symbol"`
+	Default bool   `bson:"default" json:"default"`
+}
+
+// configuredChain returns the configured chain called name, or the default
+// one when name is empty.
+func configuredChain(name string) Chain {
+	list := config_.ChainList()
+	if name == "" {
+		return list[0]
+	}
+	for _, ch := range list {
+		if ch.Name == name {
+			return ch
+		}
+	}
+	log.Fatal("Unknown chain ", name)
+	return Chain{}
+}
+
+// openChains connects to the database of every configured chain, stores its
+// labels file, and starts the pending pools, price pollers and webhook
+// notifiers until ctx is done.
+func openChains(ctx context.Context) {
+	for _, ch := range config_.ChainList() {
+		c := &chain{
+			Chain:    ch,
+			dao:      openBackend(ch),
+			rpc:      indexer.NewRPC(ch.RpcUrl),
+			gas:      &gasOracle{},
+			emission: &emissionCache{},
+			labels:   &labelCache{},
+		}
+		if ch.LabelsFile != "" {
+			seedLabels(ctx, c)
+		}
+		if ch.PendingRpcUrl != "" {
+			c.pool = indexer.NewPool(indexer.NewRPC(ch.PendingRpcUrl))
+			go c.pool.Run(ctx, config_.PendingPollInterval)
+		}
+		if ch.PriceFetcher != "" && ch.PriceFetcher != "none" {
+			fetcher, err := prices.New(ch.PriceFetcher, ch.PriceCoinId)
+			if err != nil {
+				log.Fatal(err)
+			}
+			writer, ok := c.dao.(Writer)
+			if !ok {
+				log.Fatal("The ", ch.Name, " backend can't store prices")
+			}
+			poller := &prices.Poller{Fetcher: fetcher, DB: writer, Fiats: config_.PriceFiats, Interval: config_.PricePollInterval}
+			go poller.Run(ctx)
+		}
+		if config_.WebhookPollInterval > 0 {
+			writer, ok := c.dao.(Writer)
+			if !ok {
+				log.Fatal("The ", ch.Name, " backend can't store webhook deliveries")
+			}
+			go newNotifier(c, writer).run(ctx)
+		}
+		chains = append(chains, c)
+	}
+}
+
+func closeChains() {
+	for _, c := range chains {
+		c.dao.Close()
+	}
+}
+
+type chainKey struct{}
+
+// withChain puts the chain named by the {chain} route variable, or the
+// default chain for routes without one, in the request's context.
+func withChain(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := chains[0]
+		if name := mux.Vars(r)["chain"]; name != "" {
+			for _, ch := range chains {
+				if ch.Name == name {
+					c = ch
+				}
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), chainKey{}, c)))
+	})
+}
+
+func chainOf(ctx context.Context) *chain {
+	if c, ok := ctx.Value(chainKey{}).(*chain); ok {
+		return c
+	}
+	return chains[0]
+}
+
+// backend is the database of the chain a request is for.
+func backend(ctx context.Context) Backend {
+	return chainOf(ctx).dao
+}
+
+// chainPrefix matches the /{chain} segment of a route template, which is
+// registered with the names of the chains as its pattern.
+var chainPrefix = regexp.MustCompile(`^/\{chain:[^}]*\}`)
+
+// routesChains registers the versioned routes, and the deprecated root
+// aliases of v1, again under /{chain}.
+func routesChains(r *mux.Router) {
+	names := make([]string, len(chains))
+	for i, c := range chains {
+		names[i] = regexp.QuoteMeta(c.Name)
+	}
+	prefix := r.PathPrefix("/{chain:" + strings.Join(names, "|") + "}").Subrouter()
+	routesV1(prefix.PathPrefix("/v1").Subrouter())
+	routesV2(prefix.PathPrefix("/v2").Subrouter())
+	legacy := prefix.NewRoute().Subrouter()
+	legacy.Use(deprecated)
+	routesV1(legacy)
+}
+
+func getChains(w http.ResponseWriter, r *http.Request) {
+	list := make([]ChainInfo, len(chains))
+	for i, c := range chains {
+		list[i] = ChainInfo{Name: c.Name, ChainId: c.ChainId, Symbol: c.Symbol, Default: i == 0}
+	}
+	respondWithJson(w, r, http.StatusOK, list)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	. "github.com/ubiq/spectrum-api/config"
+	. "github.com/ubiq/spectrum-api/dao"
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+// testChains serves a ubiq and a testnet chain, each from a bolt store
+// whose head is block 1 with a hash naming the chain.
+func testChains(t *testing.T) {
+	saved := chains
+	t.Cleanup(func() { chains = saved })
+	chains = nil
+	for _, name := range []string{"ubiq", "testnet"} {
+		db := &BoltDAO{DataDir: t.TempDir()}
+		db.Connect()
+		t.Cleanup(db.Close)
+		if err := db.AddBlock(context.Background(), Block{Number: 1, Hash: name}, nil, nil, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		chains = append(chains, &chain{Chain: Chain{Name: name}, dao: db})
+	}
+}
+
+func TestChainRoutes(t *testing.T) {
+	testChains(t)
+	r := newRouter()
+
+	tests := []struct {
+		path  string
+		chain string
+		link  string
+	}{
+		{path: "/latest", chain: "ubiq", link: "</v1/latest>; rel=\"successor-version\""},
+		{path: "/v1/latest", chain: "ubiq"},
+		{path: "/ubiq/latest", chain: "ubiq", link: "</ubiq/v1/latest>; rel=\"successor-version\""},
+		{path: "/testnet/latest", chain: "testnet", link: "</testnet/v1/latest>; rel=\"successor-version\""},
+		{path: "/testnet/v1/latest", chain: "testnet"},
+		{path: "/testnet/block/1", chain: "testnet", link: "</testnet/v1/block/1>; rel=\"successor-version\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("answered %d %s", rec.Code, rec.Body)
+			}
+			var b Block
+			if err := json.Unmarshal(rec.Body.Bytes(), &b); err != nil {
+				t.Fatal(err)
+			}
+			if b.Hash != tt.chain {
+				t.Errorf("served the block of %s, want %s", b.Hash, tt.chain)
+			}
+			if got := rec.Header().Get("Link"); got != tt.link {
+				t.Errorf("Link %q, want %q", got, tt.link)
+			}
+			if deprecated := rec.Header().Get("Deprecation") == "true"; deprecated != (tt.link != "") {
+				t.Errorf("Deprecation header %q", rec.Header().Get("Deprecation"))
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/mainnet/latest", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown chain answered %d", rec.Code)
+	}
+}
+
+// TestReservedNames checks that no chain can be named after a route served
+// at the root, which its /{chain} prefix would shadow.
+func TestReservedNames(t *testing.T) {
+	testChains(t)
+	r := newRouter()
+	serveOpenAPI(r)
+
+	r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || strings.HasPrefix(path, "/{chain") {
+			return nil
+		}
+		segment := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+		if segment != "" && !ReservedName(segment) {
+			t.Errorf("chains may be called %q, the first segment of %s", segment, path)
+		}
+		return nil
+	})
+}
@@ -1,19 +1,554 @@
 package config
 
 import (
-  "log"
+  "errors"
+  "flag"
+  "fmt"
+  "math/big"
+  "os"
+  "reflect"
+  "regexp"
+  "sort"
+  "strconv"
+  "strings"
+  "time"
+  "unicode"
 
   "github.com/BurntSushi/toml"
 )
 
+// DefaultFile is read when no --config is given, if it exists.
+const DefaultFile = "/etc/spectrum-api/config.toml"
+
+// EnvPrefix is prepended to the upper snake case name of a key to set it
+// from the environment, e.g SPECTRUM_API_RPC_URL for rpcUrl.
+const EnvPrefix = "SPECTRUM_API_"
+
+// Config holds every setting. The key of a field is its name with a
+// lowercase first letter (rpcUrl), the flag its kebab case (--rpc-url) and
+// the environment variable its upper snake case (SPECTRUM_API_RPC_URL).
 type Config struct {
-  Server   string
-  Database string
-  Port     string
+  Port string `help:"address to listen on (e.g :3000)"`
+
+  // Backend selects the storage engine: "mongo" (default), "postgres" or
+  // "bolt" for an embedded database kept in DataDir.
+  Backend     string `help:"storage backend, mongo, postgres or bolt"`
+  Server      string `help:"mongodb server or connection string (e.g localhost, mongodb://a,b/?replicaSet=rs0)"`
+  Database    string `help:"mongodb database (e.g spectrumdb)"`
+  PostgresUrl string `help:"postgres connection string, used when backend is postgres"`
+  DataDir     string `help:"directory for the embedded database, used when backend is bolt"`
+
+  // Mongo credentials, kept apart from server so they needn't be in the
+  // connection string.
+  MongoUri        string `help:"mongodb connection string, used instead of server when set"`
+  MongoUsername   string `help:"mongodb user, empty to connect without authenticating"`
+  MongoPassword   string `help:"mongodb password"`
+  MongoAuthSource string `help:"database mongoUsername is defined in (default the driver's, admin)"`
+
+  ReadTimeout     time.Duration `help:"max time to read a request"`
+  WriteTimeout    time.Duration `help:"max time to write a response"`
+  IdleTimeout     time.Duration `help:"max keep-alive idle time"`
+  ShutdownTimeout time.Duration `help:"how long to drain connections on SIGTERM"`
+  QueryTimeout    time.Duration `help:"per-query database deadline, sent to mongodb as maxTimeMS"`
+
+  MaxPoolSize         uint64 `help:"max mongodb/postgres connections"`
+  MinPoolSize         uint64 `help:"idle mongodb connections kept open"`
+  HeavyReadPreference string `help:"read preference for account history and count queries"`
+
+  // Serve https when both are set.
+  TlsCertFile string `help:"tls certificate, with any intermediates, to serve https"`
+  TlsKeyFile  string `help:"private key of tlsCertFile"`
+
+  LogLevel  string `help:"panic, fatal, error, warn, info, debug or trace"`
+  LogFormat string `help:"text or json"`
+
+  CorsOrigins []string `help:"origins allowed to call the api from a browser, * for any"`
+
+  // Caps on the number of items a request can ask for. RouteLimits is keyed
+  // by route path without its version prefix, e.g "/latestblocks/{limit}".
+  MaxLimit    int            `help:"most items a list route returns"`
+  RouteLimits map[string]int `help:"maxLimit overrides by route (e.g /latestblocks/{limit}=100,/reorgs=20)"`
+
+  // Settings for the `index` command.
+  RpcUrl            string        `help:"node json-rpc endpoint for the index command"`
+  IndexStart        uint64        `help:"first block indexed into an empty database"`
+  IndexPollInterval time.Duration `help:"how often the indexer checks for new blocks"`
+  IndexWorkers      int           `help:"concurrent block fetches during a backfill"`
+  MaxReorgDepth     int           `help:"deepest reorg the indexer will unwind"`
+  IndexTracer       string        `help:"how the indexer fetches internal transactions, debug (debug_traceTransaction), trace (trace_block) or empty to skip them"`
+
+  // Node polled for the pending transaction pool; empty disables it.
+  PendingRpcUrl       string        `help:"node json-rpc endpoint polled for pending transactions, empty to disable"`
+  PendingPollInterval time.Duration `help:"how often the pending pool is refreshed"`
+
+  // Number of latest blocks the gas price oracle samples.
+  GasOracleBlocks int `help:"latest blocks sampled by the gas price oracle"`
+
+  // Date announced in the Sunset header of the unversioned routes.
+  LegacySunset time.Time `help:"date announced in the Sunset header of the unversioned routes"`
+
+  // Supply reported by /supply: coins allocated at genesis, in wei, and
+  // addresses whose balance isn't circulating, like treasury or locked funds.
+  GenesisSupply  string   `help:"wei allocated at genesis, added to the mined supply"`
+  SupplyExcluded []string `help:"addresses whose balance is left out of the circulating supply"`
+
+  // Genesis file whose alloc is added to the account balances summed from
+  // the database, which no transaction shows.
+  GenesisFile string `help:"geth genesis.json whose alloc is added to account balances and snapshots, empty for none"`
+
+  // Fiat prices of the coin, polled every PricePollInterval from the
+  // PriceFetcher source when one is set.
+  PriceFetcher      string        `help:"source the coin's fiat price is polled from, coingecko or stub, empty to only use imported prices"`
+  PriceCoinId       string        `help:"id of the coin at the price source"`
+  PriceFiats        []string      `help:"fiat currencies polled"`
+  PricePollInterval time.Duration `help:"how often prices are polled"`
+
+  // Labels of known addresses, written to the database of each chain from
+  // LabelsFile at startup. The admin routes are served only with AdminToken
+  // set, to requests bearing it.
+  LabelsFile string `help:"yaml or json list of address labels to store at startup, empty for none"`
+  AdminToken string `help:"bearer token of the admin routes, empty to disable them"`
+
+  // Compiler used by /contract/{address}/verify. {version} in SolcPath is
+  // replaced by the requested compiler version.
+  SolcPath    string        `help:"solc binary, {version} is replaced by the requested version (e.g /opt/solc/solc-{version})"`
+  SolcTimeout time.Duration `help:"max time a verification may compile for"`
+
+  // Account balances are summed from the latest checkpoint before the
+  // block asked for, which the indexer stores every
+  // BalanceCheckpointInterval blocks.
+  BalanceCheckpointInterval uint64 `help:"blocks between the balance checkpoints stored for an account, 0 to store none"`
+
+  // New blocks are matched against the watchlists every
+  // WebhookPollInterval, and each webhook is tried up to WebhookAttempts
+  // times, waiting WebhookRetryDelay before the first retry and twice as
+  // long before each next one. The webhooks of up to WebhookWorkers
+  // watches are posted at once.
+  WebhookPollInterval time.Duration `help:"how often new blocks are matched against the watchlists, 0 to send no webhooks from this instance"`
+  WebhookTimeout      time.Duration `help:"max time a webhook receiver may take to answer"`
+  WebhookAttempts     int           `help:"tries of a webhook before it is marked failed"`
+  WebhookRetryDelay   time.Duration `help:"wait before the first retry of a webhook, doubled after each (up to an hour)"`
+  WebhookWorkers      int           `help:"watches whose webhooks are posted concurrently"`
+
+  // Chains served under /{name}. Only settable in the config file; without
+  // any, the top-level settings describe a single chain named ubiq.
+  Chains       []Chain `help:"networks served, each under /{name}"`
+  DefaultChain string  `help:"chain the routes without a /{chain} prefix serve (default the first)"`
+}
+
+// Chain is one network the api serves, with its own database. Empty
+// connection settings fall back to the top-level ones.
+type Chain struct {
+  Name    string
+  ChainId uint64
+  Symbol  string
+
+  Server        string
+  Database      string
+  MongoUri      string
+  PostgresUrl   string
+  DataDir       string
+  RpcUrl        string
+  PendingRpcUrl string
+
+  GenesisSupply  string
+  SupplyExcluded []string
+  GenesisFile    string
+
+  // PriceFetcher "none" turns polling off for a chain when it is set at
+  // the top level.
+  PriceFetcher string
+  PriceCoinId  string
+
+  LabelsFile string
+}
+
+// ChainList returns the configured chains, the default first, with their
+// empty settings filled from the top-level ones.
+func (c *Config) ChainList() []Chain {
+  chains := c.Chains
+  if len(chains) == 0 {
+    chains = []Chain{{Name: "ubiq", ChainId: 8, Symbol: "UBQ"}}
+  }
+  inherit := func(v *string, top string) {
+    if *v == "" {
+      *v = top
+    }
+  }
+  list := make([]Chain, 0, len(chains))
+  for _, ch := range chains {
+    inherit(&ch.Server, c.Server)
+    inherit(&ch.Database, c.Database)
+    inherit(&ch.MongoUri, c.MongoUri)
+    inherit(&ch.PostgresUrl, c.PostgresUrl)
+    inherit(&ch.DataDir, c.DataDir)
+    inherit(&ch.RpcUrl, c.RpcUrl)
+    inherit(&ch.PendingRpcUrl, c.PendingRpcUrl)
+    inherit(&ch.GenesisSupply, c.GenesisSupply)
+    inherit(&ch.GenesisFile, c.GenesisFile)
+    inherit(&ch.PriceFetcher, c.PriceFetcher)
+    inherit(&ch.PriceCoinId, c.PriceCoinId)
+    inherit(&ch.LabelsFile, c.LabelsFile)
+    if ch.SupplyExcluded == nil {
+      ch.SupplyExcluded = c.SupplyExcluded
+    }
+    if ch.Name == c.DefaultChain {
+      list = append([]Chain{ch}, list...)
+    } else {
+      list = append(list, ch)
+    }
+  }
+  return list
+}
+
+func (c *Config) defaults() {
+  *c = Config{}
+  c.Port = ":3000"
+  c.Backend = "mongo"
+  c.Server = "localhost"
+  c.Database = "spectrumdb"
+  c.DataDir = "/var/lib/spectrum-api"
+  c.ReadTimeout = 10 * time.Second
+  c.WriteTimeout = 30 * time.Second
+  c.IdleTimeout = 120 * time.Second
+  c.ShutdownTimeout = 15 * time.Second
+  c.QueryTimeout = 20 * time.Second
+  c.MaxPoolSize = 100
+  c.HeavyReadPreference = "secondaryPreferred"
+  c.LogLevel = "info"
+  c.LogFormat = "text"
+  c.CorsOrigins = []string{"*"}
+  c.MaxLimit = 1000
+  c.RpcUrl = "http://localhost:8588"
+  c.IndexPollInterval = 5 * time.Second
+  c.IndexWorkers = 8
+  c.MaxReorgDepth = 64
+  c.PendingPollInterval = 2 * time.Second
+  c.GasOracleBlocks = 200
+  c.PriceCoinId = "ubiq"
+  c.PriceFiats = []string{"usd"}
+  c.PricePollInterval = 15 * time.Minute
+  c.SolcPath = "solc"
+  c.SolcTimeout = 2 * time.Minute
+  c.BalanceCheckpointInterval = 100000
+  c.WebhookPollInterval = 5 * time.Second
+  c.WebhookTimeout = 10 * time.Second
+  c.WebhookAttempts = 8
+  c.WebhookRetryDelay = 30 * time.Second
+  c.WebhookWorkers = 4
+  c.LegacySunset = time.Date(2027, time.June, 30, 0, 0, 0, 0, time.UTC)
+}
+
+// key describes how one field is named in each source.
+type key struct {
+  index int
+  flag  string
+  env   string
+  help  string
+}
+
+// keys lists the settings that have a flag and environment variable; lists
+// of tables, like chains, are only read from the file.
+func keys() []key {
+  t := reflect.TypeOf(Config{})
+  var keys []key
+  for i := 0; i < t.NumField(); i++ {
+    f := t.Field(i)
+    if f.Type.Kind() == reflect.Slice && f.Type.Elem().Kind() == reflect.Struct {
+      continue
+    }
+    var words []string
+    start := 0
+    for j, r := range f.Name {
+      if j > 0 && unicode.IsUpper(r) {
+        words = append(words, strings.ToLower(f.Name[start:j]))
+        start = j
+      }
+    }
+    words = append(words, strings.ToLower(f.Name[start:]))
+    keys = append(keys, key{
+      index: i,
+      flag:  strings.Join(words, "-"),
+      env:   EnvPrefix + strings.ToUpper(strings.Join(words, "_")),
+      help:  f.Tag.Get("help"),
+    })
+  }
+  return keys
+}
+
+// Load fills c from, in increasing precedence, the defaults, the config
+// file, SPECTRUM_API_* environment variables and the flags in args. Every
+// key is registered on fs as a flag, along with --config, so commands can
+// define their own flags on fs beforehand.
+func (c *Config) Load(fs *flag.FlagSet, args []string) error {
+  c.defaults()
+  keys := keys()
+  v := reflect.ValueOf(c).Elem()
+
+  file := fs.String("config", "", "config file (default $"+EnvPrefix+"CONFIG, or "+DefaultFile+" if it exists)")
+  flags := make([]*string, len(keys))
+  for i, k := range keys {
+    flags[i] = fs.String(k.flag, format(v.Field(k.index)), k.help)
+  }
+  if err := fs.Parse(args); err != nil {
+    return err
+  }
+
+  path, required := *file, true
+  if path == "" {
+    path = os.Getenv(EnvPrefix + "CONFIG")
+  }
+  if path == "" {
+    path, required = DefaultFile, false
+  }
+  if err := c.decodeFile(path, required); err != nil {
+    return err
+  }
+
+  for _, k := range keys {
+    if s, ok := os.LookupEnv(k.env); ok {
+      if err := set(v.Field(k.index), s); err != nil {
+        return fmt.Errorf("%s: %v", k.env, err)
+      }
+    }
+  }
+
+  visited := map[string]bool{}
+  fs.Visit(func(f *flag.Flag) { visited[f.Name] = true })
+  for i, k := range keys {
+    if visited[k.flag] {
+      if err := set(v.Field(k.index), *flags[i]); err != nil {
+        return fmt.Errorf("--%s: %v", k.flag, err)
+      }
+    }
+  }
+  return nil
+}
+
+func (c *Config) decodeFile(path string, required bool) error {
+  if _, err := os.Stat(path); err != nil && !required && os.IsNotExist(err) {
+    return nil
+  }
+  md, err := toml.DecodeFile(path, c)
+  if err != nil {
+    return fmt.Errorf("%s: %v", path, err)
+  }
+  if undecoded := md.Undecoded(); len(undecoded) > 0 {
+    return fmt.Errorf("%s: unknown key %s", path, undecoded[0])
+  }
+  return nil
+}
+
+var (
+  durationType = reflect.TypeOf(time.Duration(0))
+  timeType     = reflect.TypeOf(time.Time{})
+)
+
+// set parses s into v. Lists are comma separated, and maps comma separated
+// key=value pairs.
+func set(v reflect.Value, s string) error {
+  switch {
+  case v.Type() == durationType:
+    d, err := time.ParseDuration(s)
+    if err != nil {
+      return err
+    }
+    v.SetInt(int64(d))
+  case v.Type() == timeType:
+    t, err := time.Parse("2006-01-02", s)
+    if err != nil {
+      if t, err = time.Parse(time.RFC3339, s); err != nil {
+        return fmt.Errorf("%q is not a date (2006-01-02) or time (RFC 3339)", s)
+      }
+    }
+    v.Set(reflect.ValueOf(t))
+  case v.Kind() == reflect.String:
+    v.SetString(s)
+  case v.Kind() == reflect.Int:
+    n, err := strconv.Atoi(s)
+    if err != nil {
+      return fmt.Errorf("%q is not a number", s)
+    }
+    v.SetInt(int64(n))
+  case v.Kind() == reflect.Uint64:
+    n, err := strconv.ParseUint(s, 10, 64)
+    if err != nil {
+      return fmt.Errorf("%q is not a positive number", s)
+    }
+    v.SetUint(n)
+  case v.Kind() == reflect.Slice:
+    list := []string{}
+    for _, item := range strings.Split(s, ",") {
+      if item = strings.TrimSpace(item); item != "" {
+        list = append(list, item)
+      }
+    }
+    v.Set(reflect.ValueOf(list))
+  case v.Kind() == reflect.Map:
+    m := map[string]int{}
+    for _, pair := range strings.Split(s, ",") {
+      if pair = strings.TrimSpace(pair); pair == "" {
+        continue
+      }
+      k, n, ok := strings.Cut(pair, "=")
+      limit, err := strconv.Atoi(n)
+      if !ok || err != nil {
+        return fmt.Errorf("%q is not key=number", pair)
+      }
+      m[strings.TrimSpace(k)] = limit
+    }
+    v.Set(reflect.ValueOf(m))
+  default:
+    return fmt.Errorf("unsupported type %s", v.Type())
+  }
+  return nil
+}
+
+// format is the inverse of set, used to show defaults in the flag usage.
+func format(v reflect.Value) string {
+  switch {
+  case v.Type() == durationType:
+    return time.Duration(v.Int()).String()
+  case v.Type() == timeType:
+    return v.Interface().(time.Time).Format("2006-01-02")
+  case v.Kind() == reflect.Slice:
+    return strings.Join(v.Interface().([]string), ",")
+  case v.Kind() == reflect.Map:
+    m := v.Interface().(map[string]int)
+    pairs := make([]string, 0, len(m))
+    for k, n := range m {
+      pairs = append(pairs, k+"="+strconv.Itoa(n))
+    }
+    sort.Strings(pairs)
+    return strings.Join(pairs, ",")
+  }
+  return fmt.Sprint(v.Interface())
+}
+
+var (
+  chainName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+  address   = regexp.MustCompile(`^0x[0-9a-fA-F]{40}$`)
+  fiat      = regexp.MustCompile(`^[a-z]{3,5}$`)
+)
+
+// reservedNames are the first path segments of the routes served at the
+// root, which would be taken for the /{name} prefix of a chain.
+var reservedNames = []string{
+  "account", "block", "blockbyhash", "blocktransactions", "chains", "contract", "contracts",
+  "docs", "gas", "labels", "latest", "latestaccounttokentxns", "latestaccounttxns",
+  "latestblocks", "latestforkedblocks", "latesttokentransfers", "latesttransactions",
+  "latesttransfersbytoken", "latestuncles", "openapi.json", "pending", "price", "reorg",
+  "reorgs", "snapshot", "status", "supply", "tokentransfersbyaccount", "transaction",
+  "transactionbycontract", "uncle", "v1", "v2", "watches",
+}
+
+// ReservedName reports whether a chain can't be called name because a
+// route served at the root starts with it.
+func ReservedName(name string) bool {
+  return oneOf(name, reservedNames...)
+}
+
+func oneOf(v string, allowed ...string) bool {
+  for _, a := range allowed {
+    if v == a {
+      return true
+    }
+  }
+  return false
+}
+
+// Validate reports every setting that is out of range or inconsistent with
+// the others.
+func (c *Config) Validate() error {
+  var errs []string
+  check := func(ok bool, format string, args ...interface{}) {
+    if !ok {
+      errs = append(errs, fmt.Sprintf(format, args...))
+    }
+  }
+
+  check(c.Port != "", "port is required")
+  switch c.Backend {
+  case "mongo":
+    check(c.Server != "" || c.MongoUri != "", "server or mongoUri is required with backend mongo")
+    check(c.Database != "", "database is required with backend mongo")
+    check(c.MongoPassword == "" || c.MongoUsername != "", "mongoPassword is set without mongoUsername")
+  case "postgres":
+    check(c.PostgresUrl != "", "postgresUrl is required with backend postgres")
+  case "bolt":
+    check(c.DataDir != "", "dataDir is required with backend bolt")
+  default:
+    check(false, "backend must be mongo, postgres or bolt, not %q", c.Backend)
+  }
+
+  check(c.ReadTimeout > 0, "readTimeout must be positive")
+  check(c.WriteTimeout > 0, "writeTimeout must be positive")
+  check(c.IdleTimeout > 0, "idleTimeout must be positive")
+  check(c.ShutdownTimeout >= 0, "shutdownTimeout can't be negative")
+  check(c.QueryTimeout > 0, "queryTimeout must be positive")
+  check(c.MaxPoolSize == 0 || c.MinPoolSize <= c.MaxPoolSize, "minPoolSize is larger than maxPoolSize")
+  check(oneOf(c.HeavyReadPreference, "", "primary", "primaryPreferred", "secondary", "secondaryPreferred", "nearest"),
+    "heavyReadPreference %q is not a mongodb read preference", c.HeavyReadPreference)
+
+  check((c.TlsCertFile == "") == (c.TlsKeyFile == ""), "tlsCertFile and tlsKeyFile must be set together")
+  check(oneOf(c.LogLevel, "panic", "fatal", "error", "warn", "warning", "info", "debug", "trace"), "logLevel %q is not a level", c.LogLevel)
+  check(oneOf(c.LogFormat, "text", "json"), "logFormat must be text or json, not %q", c.LogFormat)
+  for _, o := range c.CorsOrigins {
+    check(o == "*" || strings.HasPrefix(o, "http://") || strings.HasPrefix(o, "https://"), "corsOrigins: %q is not * or an http(s) origin", o)
+  }
+  check(c.MaxLimit > 0, "maxLimit must be positive")
+  for route, n := range c.RouteLimits {
+    check(strings.HasPrefix(route, "/"), "routeLimits: %q is not a route path", route)
+    check(n > 0, "routeLimits: %s must be positive", route)
+  }
+
+  check(c.IndexPollInterval > 0, "indexPollInterval must be positive")
+  check(c.IndexWorkers > 0, "indexWorkers must be positive")
+  check(c.MaxReorgDepth > 0, "maxReorgDepth must be positive")
+  check(oneOf(c.IndexTracer, "", "debug", "trace"), "indexTracer must be empty, debug or trace, not %q", c.IndexTracer)
+  check(c.PendingRpcUrl == "" || c.PendingPollInterval > 0, "pendingPollInterval must be positive")
+  check(c.GasOracleBlocks > 0, "gasOracleBlocks must be positive")
+  for _, f := range c.PriceFiats {
+    check(fiat.MatchString(f), "priceFiats: %q is not a lowercase currency code", f)
+  }
+  check(c.PricePollInterval > 0, "pricePollInterval must be positive")
+  check(c.SolcTimeout > 0, "solcTimeout must be positive")
+  check(c.AdminToken == "" || len(c.AdminToken) >= 16, "adminToken must be at least 16 characters")
+  check(c.WebhookPollInterval >= 0, "webhookPollInterval must not be negative")
+  check(c.WebhookTimeout > 0, "webhookTimeout must be positive")
+  check(c.WebhookAttempts > 0, "webhookAttempts must be positive")
+  check(c.WebhookRetryDelay > 0, "webhookRetryDelay must be positive")
+  check(c.WebhookWorkers > 0, "webhookWorkers must be positive")
+
+  names := map[string]bool{}
+  for _, ch := range c.Chains {
+    check(chainName.MatchString(ch.Name), "chains: name %q must be lowercase letters, digits and dashes", ch.Name)
+    check(!ReservedName(ch.Name), "chains: name %q is reserved", ch.Name)
+    check(!names[ch.Name], "chains: %q is defined twice", ch.Name)
+    names[ch.Name] = true
+  }
+  check(c.DefaultChain == "" || names[c.DefaultChain], "defaultChain %q is not in chains", c.DefaultChain)
+
+  for _, ch := range c.ChainList() {
+    if ch.GenesisSupply != "" {
+      n, ok := new(big.Int).SetString(ch.GenesisSupply, 10)
+      check(ok && n.Sign() >= 0, "%s: genesisSupply %q is not an amount of wei", ch.Name, ch.GenesisSupply)
+    }
+    for _, a := range ch.SupplyExcluded {
+      check(address.MatchString(a), "%s: supplyExcluded %q is not an address", ch.Name, a)
+    }
+  }
+  if len(errs) > 0 {
+    return errors.New(strings.Join(errs, "; "))
+  }
+  return nil
 }
 
-func (c *Config) Read() {
-  if _, err := toml.DecodeFile("/etc/spectrum-api/config.toml", &c); err != nil {
-    log.Fatal(err)
+// RouteLimit is the most items the route at path, without its version
+// prefix, returns.
+func (c *Config) RouteLimit(path string) int {
+  if n, ok := c.RouteLimits[path]; ok {
+    return n
   }
+  return c.MaxLimit
 }
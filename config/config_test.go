@@ -0,0 +1,161 @@
+package config
+
+import (
+  "flag"
+  "io"
+  "os"
+  "path/filepath"
+  "reflect"
+  "strings"
+  "testing"
+  "time"
+)
+
+func TestValidateChainNames(t *testing.T) {
+  tests := []struct {
+    names []string
+    err   string
+  }{
+    {names: []string{"ubiq", "testnet"}},
+    {names: []string{"ubiq-2"}},
+    {names: []string{"Ubiq"}, err: "must be lowercase letters"},
+    {names: []string{"v1"}, err: `name "v1" is reserved`},
+    {names: []string{"docs"}, err: `name "docs" is reserved`},
+    {names: []string{"block"}, err: `name "block" is reserved`},
+    {names: []string{"status"}, err: `name "status" is reserved`},
+    {names: []string{"supply"}, err: `name "supply" is reserved`},
+    {names: []string{"ubiq", "ubiq"}, err: `"ubiq" is defined twice`},
+  }
+  for _, tt := range tests {
+    var c Config
+    c.defaults()
+    for _, name := range tt.names {
+      c.Chains = append(c.Chains, Chain{Name: name})
+    }
+    err := c.Validate()
+    if tt.err == "" && err != nil {
+      t.Errorf("%v: %v", tt.names, err)
+    }
+    if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+      t.Errorf("%v: got %v, want an error containing %q", tt.names, err, tt.err)
+    }
+  }
+}
+
+func TestLoad(t *testing.T) {
+  file := `port=":4000"
+readTimeout="5s"
+corsOrigins=["https://a.example", "https://b.example"]
+maxLimit=500
+`
+  tests := []struct {
+    name   string
+    file   string
+    env    map[string]string
+    args   []string
+    port   string
+    read   time.Duration
+    cors   []string
+    max    int
+    limits map[string]int
+  }{
+    {
+      name: "defaults",
+      port: ":3000", read: 10 * time.Second, cors: []string{"*"}, max: 1000,
+    },
+    {
+      name: "file over defaults",
+      file: file,
+      port: ":4000", read: 5 * time.Second, cors: []string{"https://a.example", "https://b.example"}, max: 500,
+    },
+    {
+      name: "env over file",
+      file: file,
+      env:  map[string]string{"SPECTRUM_API_PORT": ":5000", "SPECTRUM_API_READ_TIMEOUT": "7s", "SPECTRUM_API_CORS_ORIGINS": "https://c.example, ,https://d.example"},
+      port: ":5000", read: 7 * time.Second, cors: []string{"https://c.example", "https://d.example"}, max: 500,
+    },
+    {
+      name: "flags over env",
+      file: file,
+      env:  map[string]string{"SPECTRUM_API_PORT": ":5000", "SPECTRUM_API_MAX_LIMIT": "200"},
+      args: []string{"--port=:6000", "--route-limits", "/reorgs=20, /latestblocks/{limit}=100"},
+      port: ":6000", read: 5 * time.Second, cors: []string{"https://a.example", "https://b.example"}, max: 200,
+      limits: map[string]int{"/reorgs": 20, "/latestblocks/{limit}": 100},
+    },
+    {
+      name: "flag set to the default",
+      file: file,
+      env:  map[string]string{"SPECTRUM_API_MAX_LIMIT": "200"},
+      args: []string{"--port", ":3000", "--max-limit=1000"},
+      port: ":3000", read: 5 * time.Second, cors: []string{"https://a.example", "https://b.example"}, max: 1000,
+    },
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      path := filepath.Join(t.TempDir(), "config.toml")
+      if err := os.WriteFile(path, []byte(tt.file), 0600); err != nil {
+        t.Fatal(err)
+      }
+      t.Setenv(EnvPrefix+"CONFIG", path)
+      for k, v := range tt.env {
+        t.Setenv(k, v)
+      }
+
+      var c Config
+      if err := c.Load(flag.NewFlagSet("test", flag.ContinueOnError), tt.args); err != nil {
+        t.Fatal(err)
+      }
+      if c.Port != tt.port || c.ReadTimeout != tt.read || !reflect.DeepEqual(c.CorsOrigins, tt.cors) || c.MaxLimit != tt.max {
+        t.Errorf("port %q, readTimeout %s, corsOrigins %q, maxLimit %d, want %q, %s, %q, %d",
+          c.Port, c.ReadTimeout, c.CorsOrigins, c.MaxLimit, tt.port, tt.read, tt.cors, tt.max)
+      }
+      if len(c.RouteLimits) > 0 || len(tt.limits) > 0 {
+        if !reflect.DeepEqual(c.RouteLimits, tt.limits) {
+          t.Errorf("routeLimits %v, want %v", c.RouteLimits, tt.limits)
+        }
+      }
+      // Keys no source sets keep their defaults.
+      if c.Backend != "mongo" || c.IndexWorkers != 8 {
+        t.Errorf("backend %q, indexWorkers %d", c.Backend, c.IndexWorkers)
+      }
+    })
+  }
+}
+
+func TestLoadErrors(t *testing.T) {
+  tests := []struct {
+    name string
+    file string
+    env  map[string]string
+    args []string
+    err  string
+  }{
+    {name: "unknown file key", file: `prot=":3000"`, err: "unknown key prot"},
+    {name: "bad file value", file: `maxLimit="many"`, err: "config.toml"},
+    {name: "bad env value", env: map[string]string{"SPECTRUM_API_MAX_LIMIT": "ten"}, err: `SPECTRUM_API_MAX_LIMIT: "ten" is not a number`},
+    {name: "bad env duration", env: map[string]string{"SPECTRUM_API_QUERY_TIMEOUT": "20"}, err: "SPECTRUM_API_QUERY_TIMEOUT: time: missing unit"},
+    {name: "bad flag value", args: []string{"--max-pool-size=-1"}, err: `--max-pool-size: "-1" is not a positive number`},
+    {name: "bad route limit", args: []string{"--route-limits=/reorgs"}, err: `"/reorgs" is not key=number`},
+    {name: "unknown flag", args: []string{"--prot=:3000"}, err: "flag provided but not defined: -prot"},
+    {name: "missing --config", args: []string{"--config=/nonexistent/config.toml"}, err: "/nonexistent/config.toml"},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      path := filepath.Join(t.TempDir(), "config.toml")
+      if err := os.WriteFile(path, []byte(tt.file), 0600); err != nil {
+        t.Fatal(err)
+      }
+      t.Setenv(EnvPrefix+"CONFIG", path)
+      for k, v := range tt.env {
+        t.Setenv(k, v)
+      }
+
+      fs := flag.NewFlagSet("test", flag.ContinueOnError)
+      fs.SetOutput(io.Discard)
+      var c Config
+      if err := c.Load(fs, tt.args); err == nil || !strings.Contains(err.Error(), tt.err) {
+        t.Errorf("got %v, want an error containing %q", err, tt.err)
+      }
+    })
+  }
+}
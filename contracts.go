@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ubiq/spectrum-api/apierr"
+	. "github.com/ubiq/spectrum-api/dao"
+	. "github.com/ubiq/spectrum-api/models"
+	"github.com/ubiq/spectrum-api/verify"
+)
+
+// maxVerifyBody caps the size of a verification request.
+const maxVerifyBody = 8 << 20
+
+// compiles bounds the solc processes verifications run at once.
+var compiles = make(chan struct{}, runtime.NumCPU())
+
+// VerifyRequest is the body of POST /contract/{address}/verify. Source is
+// a single file, named contract.sol; Sources maps file names to content for
+// contracts spread over several.
+type VerifyRequest struct {
+	ContractName         string            `json:"contractName"`
+	CompilerVersion      string            `json:"compilerVersion"`
+	Optimization         bool              `json:"optimization"`
+	Runs                 uint64            `json:"runs"`
+	EvmVersion           string            `json:"evmVersion"`
+	Source               string            `json:"source"`
+	Sources              map[string]string `json:"sources"`
+	ConstructorArguments string            `json:"constructorArguments"`
+}
+
+// compile runs solc for in, waiting for a free slot.
+func compile(ctx context.Context, in verify.Input) (verify.Compiled, error) {
+	ctx, cancel := context.WithTimeout(ctx, config_.SolcTimeout)
+	defer cancel()
+	select {
+	case compiles <- struct{}{}:
+		defer func() { <-compiles }()
+	case <-ctx.Done():
+		return verify.Compiled{}, apierr.Unavailablef("too many verifications running, try again later")
+	}
+	solc := &verify.Solc{Path: config_.SolcPath}
+	return solc.Compile(ctx, in)
+}
+
+// verifyContract compiles the submitted source and stores it as the
+// contract's when the result is the code the contract was created with,
+// followed by its constructor arguments.
+func verifyContract(w http.ResponseWriter, r *http.Request) {
+	address := mux.Vars(r)["address"]
+	var req VerifyRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxVerifyBody)).Decode(&req); err != nil {
+		respondWithError(w, r, apierr.InvalidArgumentf("invalid verification request: %v", err))
+		return
+	}
+	sources := req.Sources
+	if len(sources) == 0 && req.Source != "" {
+		sources = map[string]string{"contract.sol": req.Source}
+	}
+	runs := req.Runs
+	if req.Optimization && runs == 0 {
+		runs = 200
+	}
+
+	creation, err := backend(r.Context()).TransactionByContractAddress(r.Context(), address)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	compiled, err := compile(r.Context(), verify.Input{
+		ContractName:    req.ContractName,
+		Sources:         sources,
+		CompilerVersion: req.CompilerVersion,
+		Optimization:    req.Optimization,
+		Runs:            runs,
+		EvmVersion:      req.EvmVersion,
+	})
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	match, args, err := verify.Compare(compiled.Bytecode, creation.Input)
+	if err != nil {
+		respondWithError(w, r, apierr.Wrap(apierr.InvalidArgument, err))
+		return
+	}
+	if want := strings.ToLower(strings.TrimPrefix(req.ConstructorArguments, "0x")); want != "" && want != args {
+		respondWithError(w, r, apierr.InvalidArgumentf("constructorArguments don't match those the contract was created with, 0x%s", args))
+		return
+	}
+
+	source := ContractSource{
+		Address:              address,
+		ContractName:         compiled.Name,
+		CompilerVersion:      req.CompilerVersion,
+		Optimization:         req.Optimization,
+		Runs:                 runs,
+		EvmVersion:           req.EvmVersion,
+		Sources:              sources,
+		ABI:                  compiled.ABI,
+		ConstructorArguments: "0x" + args,
+		Match:                string(match),
+		VerifiedAt:           uint64(time.Now().Unix()),
+	}
+	db, err := writer(r.Context())
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	if err := db.SetContractSource(r.Context(), source); err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	respondWithJson(w, r, http.StatusOK, source)
+}
+
+func getContractSource(w http.ResponseWriter, r *http.Request) {
+	source, err := backend(r.Context()).ContractSource(r.Context(), mux.Vars(r)["address"])
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	respondWithJson(w, r, http.StatusOK, source)
+}
+
+// getContracts lists the contracts created by ?creator= from ?fromBlock= to
+// ?toBlock=, newest first.
+func getContracts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := ContractFilter{Creator: strings.ToLower(q.Get("creator"))}
+	if filter.Creator != "" && !validHex(filter.Creator, []int{20}) {
+		respondWithError(w, r, apierr.InvalidArgumentf("creator must be 0x-prefixed hex of 20 bytes"))
+		return
+	}
+	for name, v := range map[string]*uint64{"fromBlock": &filter.FromBlock, "toBlock": &filter.ToBlock} {
+		if s := q.Get(name); s != "" {
+			n, err := parseBlockNumber(s)
+			if err != nil {
+				respondWithError(w, r, err)
+				return
+			}
+			*v = n
+		}
+	}
+	limit := config_.RouteLimit("/contracts")
+	if v := q.Get("limit"); v != "" {
+		var err error
+		if limit, err = parseLimit(r, v); err != nil {
+			respondWithError(w, r, err)
+			return
+		}
+	}
+
+	contracts, err := backend(r.Context()).Contracts(r.Context(), filter, limit)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	if contracts == nil {
+		contracts = []Contract{}
+	}
+	respondWithJson(w, r, http.StatusOK, contracts)
+}
+
+// getContractHistory returns the number of contracts created in every
+// ?interval= period, oldest first.
+func getContractHistory(w http.ResponseWriter, r *http.Request) {
+	interval, err := parseInterval(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	days, err := backend(r.Context()).ContractCounts(r.Context(), 0, day)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	history := []ContractCount{}
+	for _, d := range days {
+		start := periodStart(interval, d.Timestamp)
+		if n := len(history); n == 0 || history[n-1].Timestamp != start {
+			history = append(history, ContractCount{Timestamp: start})
+		}
+		history[len(history)-1].Contracts += d.Contracts
+	}
+	respondWithJson(w, r, http.StatusOK, history)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/ubiq/spectrum-api/config"
+	. "github.com/ubiq/spectrum-api/dao"
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+const contractAddress = "0x6d1c2a1b7d3e5b4f0a9c8e7d6b5a4f3e2d1c0b9a"
+
+// creationCode is creation code ending in solc's metadata, whose ipfs hash
+// is filled with digit.
+func creationCode(digit string) string {
+	return "0x608060405234801561001057600080fd5b506001" +
+		"a2646970667358221220" + strings.Repeat(digit, 64) + "64736f6c63430008130033"
+}
+
+// testVerify serves a chain with contractAddress created by creationCode("1")
+// and a fake solc 0.8.19 that compiles any source to the code in the file it
+// returns.
+func testVerify(t *testing.T) string {
+	saved, savedConfig := chains, config_
+	t.Cleanup(func() { chains, config_ = saved, savedConfig })
+
+	db := &BoltDAO{DataDir: t.TempDir()}
+	db.Connect()
+	t.Cleanup(db.Close)
+	creation := Transaction{Hash: "0xc1", BlockNumber: 1, From: miner, ContractAddress: contractAddress, Input: creationCode("1")}
+	if err := db.AddBlock(context.Background(), Block{Number: 1, Hash: "0x1"}, []Transaction{creation}, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	chains = []*chain{{Chain: Chain{Name: "ubiq"}, dao: db}}
+
+	dir := t.TempDir()
+	compiled := filepath.Join(dir, "compiled")
+	solc := filepath.Join(dir, "solc")
+	script := `#!/bin/sh
+if [ "$1" = "--version" ]; then
+  echo "Version: 0.8.19+commit.7dd6d404.Linux.g++"
+  exit 0
+fi
+cat > /dev/null
+printf '{"contracts": {"contract.sol": {"Token": {"abi": [], "evm": {"bytecode": {"object": "%s"}}}}}}' "$(cat ` + compiled + `)"
+`
+	if err := os.WriteFile(solc, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	config_.SolcPath = solc
+	config_.SolcTimeout = 10 * time.Second
+	config_.AdminToken = "0123456789abcdef"
+	return compiled
+}
+
+func TestVerifyContract(t *testing.T) {
+	compiled := testVerify(t)
+	r := newRouter()
+	body := `{"compilerVersion": "0.8.19", "source": "contract Token {}"}`
+
+	tests := []struct {
+		name   string
+		token  string
+		digit  string
+		status int
+		match  string
+	}{
+		{name: "no token", digit: "2", status: http.StatusUnauthorized},
+		{name: "wrong token", token: "fedcba9876543210", digit: "2", status: http.StatusUnauthorized},
+		{name: "partial", token: config_.AdminToken, digit: "2", status: http.StatusOK, match: "partial"},
+		{name: "partial again", token: config_.AdminToken, digit: "3", status: http.StatusOK, match: "partial"},
+		{name: "full over partial", token: config_.AdminToken, digit: "1", status: http.StatusOK, match: "full"},
+		{name: "partial over full", token: config_.AdminToken, digit: "2", status: http.StatusConflict},
+		{name: "full over full", token: config_.AdminToken, digit: "1", status: http.StatusConflict},
+	}
+	for _, tt := range tests {
+		if err := os.WriteFile(compiled, []byte(creationCode(tt.digit)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("POST", "/v1/contract/"+contractAddress+"/verify", strings.NewReader(body))
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: answered %d %s", tt.name, rec.Code, rec.Body)
+			continue
+		}
+		if tt.match == "" {
+			continue
+		}
+		var source ContractSource
+		if err := json.Unmarshal(rec.Body.Bytes(), &source); err != nil {
+			t.Fatal(err)
+		}
+		if source.Match != tt.match {
+			t.Errorf("%s: match %q, want %q", tt.name, source.Match, tt.match)
+		}
+	}
+
+	// The full match stays stored.
+	var source ContractSource
+	getJSON(t, "/v1/contract/"+contractAddress+"/source", &source)
+	if source.Match != "full" {
+		t.Errorf("stored match %q", source.Match)
+	}
+}
+
+// testContracts serves a chain with five contracts, created in blocks 1 to 5
+// over two weeks, the even ones by creator.
+func testContracts(t *testing.T) {
+	const creator = "0x2222222222222222222222222222222222222222"
+	saved, savedConfig := chains, config_
+	t.Cleanup(func() { chains, config_ = saved, savedConfig })
+	config_.MaxLimit = 100
+	config_.RouteLimits = map[string]int{"/contracts": 3}
+
+	db := &BoltDAO{DataDir: t.TempDir()}
+	db.Connect()
+	t.Cleanup(db.Close)
+	// Friday 2017-07-14 twice, Saturday, then Tuesday and Wednesday of the
+	// next week.
+	timestamps := []uint64{1500000000, 1500003600, 1500086400, 1500345600, 1500432000}
+	for i, ts := range timestamps {
+		n := uint64(i + 1)
+		from := miner
+		if n%2 == 0 {
+			from = creator
+		}
+		created := Transaction{Hash: "0xc" + strconv.Itoa(i+1), BlockNumber: n, Timestamp: ts, From: from,
+			ContractAddress: "0x" + strings.Repeat(strconv.Itoa(i+1), 40), Input: "0x6080"}
+		if err := db.AddBlock(context.Background(), Block{Number: n, Hash: "0x" + strconv.Itoa(i+1), Timestamp: ts}, []Transaction{created}, nil, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	chains = []*chain{{Chain: Chain{Name: "ubiq"}, dao: db}}
+}
+
+func TestGetContracts(t *testing.T) {
+	testContracts(t)
+
+	tests := []struct {
+		query  string
+		blocks []uint64
+	}{
+		{query: "", blocks: []uint64{5, 4, 3}},
+		{query: "limit=2", blocks: []uint64{5, 4}},
+		{query: "limit=50", blocks: []uint64{5, 4, 3}},
+		{query: "toBlock=2", blocks: []uint64{2, 1}},
+		{query: "fromBlock=2&toBlock=3", blocks: []uint64{3, 2}},
+		{query: "fromBlock=2&limit=1", blocks: []uint64{5}},
+		{query: "fromBlock=6", blocks: nil},
+		{query: "creator=0x2222222222222222222222222222222222222222", blocks: []uint64{4, 2}},
+		{query: "creator=0x2222222222222222222222222222222222222222&toBlock=3", blocks: []uint64{2}},
+	}
+	for _, tt := range tests {
+		var contracts []Contract
+		getJSON(t, "/v1/contracts?"+tt.query, &contracts)
+		var blocks []uint64
+		for _, c := range contracts {
+			blocks = append(blocks, c.BlockNumber)
+		}
+		if !reflect.DeepEqual(blocks, tt.blocks) {
+			t.Errorf("%s: contracts of blocks %v, want %v", tt.query, blocks, tt.blocks)
+		}
+	}
+
+	r := newRouter()
+	for _, query := range []string{"limit=0", "limit=x", "fromBlock=-1", "toBlock=x", "creator=0x22"} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/contracts?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: answered %d %s", query, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestGetContractHistory(t *testing.T) {
+	testContracts(t)
+
+	tests := []struct {
+		interval string
+		want     []ContractCount
+	}{
+		{interval: "day", want: []ContractCount{
+			{Timestamp: 1499990400, Contracts: 2}, {Timestamp: 1500076800, Contracts: 1},
+			{Timestamp: 1500336000, Contracts: 1}, {Timestamp: 1500422400, Contracts: 1},
+		}},
+		{interval: "week", want: []ContractCount{{Timestamp: 1499644800, Contracts: 3}, {Timestamp: 1500249600, Contracts: 2}}},
+		{interval: "month", want: []ContractCount{{Timestamp: 1498867200, Contracts: 5}}},
+	}
+	for _, tt := range tests {
+		var history []ContractCount
+		getJSON(t, "/v1/contracts/history?interval="+tt.interval, &history)
+		if !reflect.DeepEqual(history, tt.want) {
+			t.Errorf("by %s: %v, want %v", tt.interval, history, tt.want)
+		}
+	}
+}
@@ -0,0 +1,552 @@
+package dao
+
+import (
+	"context"
+	"math/big"
+	"sort"
+
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+// Backend is the set of queries the api serves from. SpectrumDAO implements
+// it on mongodb, PostgresDAO on postgres and BoltDAO on an embedded bbolt
+// file; all must return ErrNotFound when a single document lookup matches
+// nothing.
+type Backend interface {
+	Connect()
+	Close()
+
+	BlockByNumber(ctx context.Context, number uint64) (Block, error)
+	BlockByHash(ctx context.Context, hash string) (Block, error)
+	LatestBlock(ctx context.Context) (Block, error)
+	LatestBlocks(ctx context.Context, limit int) ([]Block, error)
+	LatestForkedBlocks(ctx context.Context, limit int) ([]Block, error)
+	ForkedBlockByHash(ctx context.Context, hash string) (Block, error)
+	ForkedTransactions(ctx context.Context, blockHash string) ([]Transaction, error)
+	Store(ctx context.Context) (Store, error)
+
+	TransactionByHash(ctx context.Context, hash string) (Transaction, error)
+	TransactionByContractAddress(ctx context.Context, hash string) (Transaction, error)
+	TransactionsByBlockNumber(ctx context.Context, number uint64) ([]Transaction, error)
+	LatestTransactions(ctx context.Context, limit int) ([]Transaction, error)
+	LatestTransactionsByAccount(ctx context.Context, hash string, filter TxnFilter) ([]Transaction, error)
+
+	TracesByTransaction(ctx context.Context, hash string) ([]Trace, error)
+	LatestTracesByAccount(ctx context.Context, hash string) ([]Trace, error)
+
+	UncleByHash(ctx context.Context, hash string) (Uncle, error)
+	LatestUncles(ctx context.Context, limit int) ([]Uncle, error)
+	// UnclesByBlockNumber returns the uncles included by the canonical block
+	// number, by position.
+	UnclesByBlockNumber(ctx context.Context, number uint64) ([]Uncle, error)
+
+	LatestTokenTransfersByAccount(ctx context.Context, hash string) ([]TokenTransfer, error)
+	TokenTransfersByAccount(ctx context.Context, token string, account string) ([]TokenTransfer, error)
+	LatestTransfersByToken(ctx context.Context, hash string) ([]TokenTransfer, error)
+	LatestTokenTransfers(ctx context.Context, limit int) ([]TokenTransfer, error)
+	// TokenTransfersByBlockNumber returns the transfers of the canonical
+	// block number in the order they were indexed.
+	TokenTransfersByBlockNumber(ctx context.Context, number uint64) ([]TokenTransfer, error)
+
+	TxnCount(ctx context.Context, hash string, filter TxnFilter) (int, error)
+	TotalTxnCount(ctx context.Context) (int, error)
+	TraceCount(ctx context.Context, hash string) (int, error)
+	TokenTransferCount(ctx context.Context, hash string) (int, error)
+	TokenTransferCountByContract(ctx context.Context, hash string) (int, error)
+	TotalTokenTransferCount(ctx context.Context) (int, error)
+	TokenTransferByAccountCount(ctx context.Context, token string, account string) (int, error)
+	TotalBlockCount(ctx context.Context) (int, error)
+	TotalUncleCount(ctx context.Context) (int, error)
+
+	// Emission sums the rewards of the blocks and uncles with a timestamp
+	// from from on, in periods of interval seconds starting at multiples of
+	// interval, oldest first. Periods without blocks are left out.
+	Emission(ctx context.Context, from uint64, interval uint64) ([]Emission, error)
+
+	// PriceAt returns the latest price of token, empty for the native coin,
+	// in fiat at or before timestamp.
+	PriceAt(ctx context.Context, token string, fiat string, timestamp uint64) (PricePoint, error)
+	// PriceHistory returns up to limit prices of token in fiat from from to
+	// to, oldest first.
+	PriceHistory(ctx context.Context, token string, fiat string, from uint64, to uint64, limit int) ([]PricePoint, error)
+
+	// Label returns the label of address.
+	Label(ctx context.Context, address string) (Label, error)
+	// Labels returns the labels tagged tag, or every label when tag is
+	// empty, by address.
+	Labels(ctx context.Context, tag string) ([]Label, error)
+
+	// ContractSource returns the verified source of the contract at address.
+	ContractSource(ctx context.Context, address string) (ContractSource, error)
+	// Contracts returns up to limit contracts created within filter, newest
+	// first.
+	Contracts(ctx context.Context, filter ContractFilter, limit int) ([]Contract, error)
+	// ContractCounts returns the number of contracts created from from on,
+	// in periods of interval seconds, oldest first. Periods without any are
+	// left out.
+	ContractCounts(ctx context.Context, from uint64, interval uint64) ([]ContractCount, error)
+
+	// BalanceChanges returns what moved the native and token balances of
+	// address in the blocks from from to to, both included, summed by block
+	// and token, oldest first. Blocks where nothing changed are left out.
+	BalanceChanges(ctx context.Context, address string, from uint64, to uint64) ([]BalanceChange, error)
+	// BalanceCheckpoint returns the latest balances of address stored at or
+	// before block number.
+	BalanceCheckpoint(ctx context.Context, address string, number uint64) (Balance, error)
+	// BalanceAccounts returns the addresses whose native or token balances
+	// moved in the blocks from from to to, both included, sorted.
+	BalanceAccounts(ctx context.Context, from uint64, to uint64) ([]string, error)
+	// Holders returns the balance of every account holding the token at
+	// contract, or the coin when contract is empty, at the end of block
+	// number, by address. It reads every transfer, or every transaction,
+	// internal transaction and reward, up to the block, so it is meant for
+	// offline use.
+	Holders(ctx context.Context, contract string, number uint64) ([]Holder, error)
+
+	// Watch returns the watch id.
+	Watch(ctx context.Context, id string) (Watch, error)
+	// Watches returns every watch, by id.
+	Watches(ctx context.Context) ([]Watch, error)
+	// Delivery returns the webhook delivery id.
+	Delivery(ctx context.Context, id string) (Delivery, error)
+	// Deliveries returns up to limit webhook deliveries within filter, by
+	// block number and id, newest first.
+	Deliveries(ctx context.Context, filter DeliveryFilter, limit int) ([]Delivery, error)
+	// DueDeliveries returns up to limit pending deliveries of the watch
+	// watchID, of blocks up to toBlock, whose next attempt is due by now,
+	// oldest first.
+	DueDeliveries(ctx context.Context, watchID string, toBlock uint64, now uint64, limit int) ([]Delivery, error)
+	// WatchCursor returns the latest block the watches were matched
+	// against.
+	WatchCursor(ctx context.Context) (uint64, error)
+}
+
+// TxnFilter narrows account transaction queries. Status is "success",
+// "failed" or empty for all transactions; transactions stored without a
+// receipt status only match the empty filter.
+type TxnFilter struct {
+	Status string
+}
+
+// status returns the receipt status filter selects, and false if it selects
+// any.
+func (f TxnFilter) status() (uint64, bool) {
+	switch f.Status {
+	case "success":
+		return 1, true
+	case "failed":
+		return 0, true
+	}
+	return 0, false
+}
+
+// ContractFilter narrows contract listings to those created by Creator, when
+// set, from FromBlock to ToBlock. A ToBlock of 0 leaves the range open.
+type ContractFilter struct {
+	Creator   string
+	FromBlock uint64
+	ToBlock   uint64
+}
+
+func (f ContractFilter) matches(creator string, number uint64) bool {
+	return (f.Creator == "" || creator == f.Creator) && number >= f.FromBlock && (f.ToBlock == 0 || number <= f.ToBlock)
+}
+
+// DeliveryFilter narrows webhook deliveries to those of the watch WatchID
+// and in Status, when set.
+type DeliveryFilter struct {
+	WatchID string
+	Status  string
+}
+
+func (f DeliveryFilter) matches(d Delivery) bool {
+	return (f.WatchID == "" || d.WatchID == f.WatchID) && (f.Status == "" || d.Status == f.Status)
+}
+
+// inputSize is the size in bytes of a 0x-prefixed hex input.
+func inputSize(hexLength uint64) uint64 {
+	if hexLength < 2 {
+		return 0
+	}
+	return (hexLength - 2) / 2
+}
+
+// countPeriods lists counts keyed by period start, oldest first.
+func countPeriods(counts map[uint64]uint64) []ContractCount {
+	list := []ContractCount{}
+	for start, n := range counts {
+		list = append(list, ContractCount{Timestamp: start, Contracts: n})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Timestamp < list[j].Timestamp })
+	return list
+}
+
+// movement is an amount a transaction, internal transaction, reward or
+// token transfer added to the balance of address, or took from it when
+// negative.
+type movement struct {
+	address string
+	amount  *big.Int
+}
+
+// parseWei reads a decimal wei amount, taking empty or invalid values as 0.
+func parseWei(wei string) *big.Int {
+	n, ok := new(big.Int).SetString(wei, 10)
+	if !ok {
+		return new(big.Int)
+	}
+	return n
+}
+
+// txnMovements are the fee the sender of t paid and, unless t failed, the
+// value it moved to the recipient or the contract it created. Transactions
+// stored without a receipt status count as successful.
+func txnMovements(t Transaction) []movement {
+	price := t.EffectiveGasPrice
+	if price == "" {
+		price = t.GasPrice
+	}
+	fee := new(big.Int).Mul(parseWei(price), new(big.Int).SetUint64(t.GasUsed))
+	moves := []movement{{t.From, fee.Neg(fee)}}
+	if t.Status != nil && *t.Status != 1 {
+		return moves
+	}
+	to := t.To
+	if to == "" {
+		to = t.ContractAddress
+	}
+	value := parseWei(t.Value)
+	return append(moves, movement{t.From, new(big.Int).Neg(value)}, movement{to, value})
+}
+
+// traceMovements is the value an internal transaction moved, unless it was
+// reverted.
+func traceMovements(t Trace) []movement {
+	if t.Error != "" {
+		return nil
+	}
+	value := parseWei(t.Value)
+	return []movement{{t.From, new(big.Int).Neg(value)}, {t.To, value}}
+}
+
+// blockMovement is what the miner of b was paid: the block and uncle
+// inclusion rewards and the transaction fees.
+func blockMovement(b Block) movement {
+	n := parseWei(b.BlockReward)
+	n.Add(n, parseWei(b.UnclesReward))
+	return movement{b.Miner, n.Add(n, parseWei(b.TxFees))}
+}
+
+// uncleMovement is the reward of an uncle's miner, paid in the block that
+// included it.
+func uncleMovement(u Uncle) movement {
+	return movement{u.Miner, parseWei(u.Reward)}
+}
+
+func transferMovements(t TokenTransfer) []movement {
+	value := parseWei(t.Value)
+	return []movement{{t.From, new(big.Int).Neg(value)}, {t.To, value}}
+}
+
+// balanceLedger sums the rows a BalanceChanges query reads into the amounts
+// they moved in and out of address, by block and token.
+type balanceLedger struct {
+	address string
+	amounts map[balanceKey]*big.Int
+	times   map[uint64]uint64
+}
+
+type balanceKey struct {
+	number   uint64
+	contract string
+}
+
+func newBalanceLedger(address string) *balanceLedger {
+	return &balanceLedger{address: address, amounts: map[balanceKey]*big.Int{}, times: map[uint64]uint64{}}
+}
+
+func (l *balanceLedger) add(number uint64, timestamp uint64, contract string, moves ...movement) {
+	if timestamp > l.times[number] {
+		l.times[number] = timestamp
+	}
+	key := balanceKey{number, contract}
+	n, ok := l.amounts[key]
+	if !ok {
+		n = new(big.Int)
+		l.amounts[key] = n
+	}
+	for _, m := range moves {
+		if m.address == l.address {
+			n.Add(n, m.amount)
+		}
+	}
+}
+
+func (l *balanceLedger) addTxn(t Transaction) {
+	l.add(t.BlockNumber, t.Timestamp, "", txnMovements(t)...)
+}
+
+func (l *balanceLedger) addTrace(t Trace) {
+	l.add(t.BlockNumber, t.Timestamp, "", traceMovements(t)...)
+}
+
+func (l *balanceLedger) addBlock(b Block) {
+	l.add(b.Number, b.Timestamp, "", blockMovement(b))
+}
+
+func (l *balanceLedger) addUncle(u Uncle) {
+	l.add(u.BlockNumber, u.Timestamp, "", uncleMovement(u))
+}
+
+// addGenesis adds the allocation of g in block 0, when there is one.
+func (l *balanceLedger) addGenesis(g *Genesis) {
+	if g != nil {
+		l.add(0, g.Timestamp, "", g.movements()...)
+	}
+}
+
+func (l *balanceLedger) addTransfer(t TokenTransfer) {
+	l.add(t.BlockNumber, t.Timestamp, t.Contract, transferMovements(t)...)
+}
+
+// list returns the non-zero amounts by block, oldest first, and by token.
+func (l *balanceLedger) list() []BalanceChange {
+	list := []BalanceChange{}
+	for key, n := range l.amounts {
+		if n.Sign() == 0 {
+			continue
+		}
+		list = append(list, BalanceChange{BlockNumber: key.number, Timestamp: l.times[key.number], Contract: key.contract, Amount: n.String()})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].BlockNumber != list[j].BlockNumber {
+			return list[i].BlockNumber < list[j].BlockNumber
+		}
+		return list[i].Contract < list[j].Contract
+	})
+	return list
+}
+
+// holderLedger sums the rows a Holders query reads into the balance of
+// every account they moved funds of.
+type holderLedger map[string]*big.Int
+
+func (h holderLedger) add(moves ...movement) {
+	for _, m := range moves {
+		n, ok := h[m.address]
+		if !ok {
+			n = new(big.Int)
+			h[m.address] = n
+		}
+		n.Add(n, m.amount)
+	}
+}
+
+// list returns the positive balances by address.
+func (h holderLedger) list() []Holder {
+	list := []Holder{}
+	for address, n := range h {
+		if address != "" && n.Sign() > 0 {
+			list = append(list, Holder{Address: address, Balance: n.String()})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Address < list[j].Address })
+	return list
+}
+
+// accountSet collects the accounts a BalanceAccounts query reads movements
+// of.
+type accountSet map[string]bool
+
+func (s accountSet) add(moves ...movement) {
+	for _, m := range moves {
+		s[m.address] = true
+	}
+}
+
+// list returns the addresses, sorted.
+func (s accountSet) list() []string {
+	list := []string{}
+	for address := range s {
+		if address != "" {
+			list = append(list, address)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// BalanceSet is an account's balances being summed from a checkpoint, by
+// token contract, "" for the native coin.
+type BalanceSet map[string]*big.Int
+
+// NewBalanceSet starts from the balances of checkpoint b.
+func NewBalanceSet(b Balance) BalanceSet {
+	set := BalanceSet{"": parseWei(b.Balance)}
+	for _, t := range b.Tokens {
+		set[t.Contract] = parseWei(t.Balance)
+	}
+	return set
+}
+
+// Add applies what c moved.
+func (s BalanceSet) Add(c BalanceChange) {
+	n, ok := s[c.Contract]
+	if !ok {
+		n = new(big.Int)
+		s[c.Contract] = n
+	}
+	n.Add(n, parseWei(c.Amount))
+}
+
+// Balance lists the set as of block number, with the tokens held by
+// contract.
+func (s BalanceSet) Balance(address string, number uint64) Balance {
+	b := Balance{Address: address, BlockNumber: number, Balance: s[""].String(), Tokens: []TokenBalance{}}
+	for contract, n := range s {
+		if contract != "" && n.Sign() != 0 {
+			b.Tokens = append(b.Tokens, TokenBalance{Contract: contract, Balance: n.String()})
+		}
+	}
+	sort.Slice(b.Tokens, func(i, j int) bool { return b.Tokens[i].Contract < b.Tokens[j].Contract })
+	return b
+}
+
+// BalanceAt returns the balances of address at the end of block number,
+// from its latest checkpoint at or before number and what changed since.
+func BalanceAt(ctx context.Context, db Backend, address string, number uint64) (Balance, error) {
+	start, err := db.BalanceCheckpoint(ctx, address, number)
+	from := uint64(0)
+	switch {
+	case err == ErrNotFound:
+		start = Balance{Address: address, Balance: "0"}
+	case err != nil:
+		return Balance{}, err
+	default:
+		from = start.BlockNumber + 1
+	}
+	changes, err := db.BalanceChanges(ctx, address, from, number)
+	if err != nil {
+		return Balance{}, err
+	}
+	set := NewBalanceSet(start)
+	for _, c := range changes {
+		set.Add(c)
+	}
+	return set.Balance(address, number), nil
+}
+
+// emissionPeriods accumulates the rewards read by an Emission query.
+type emissionPeriods struct {
+	interval uint64
+	periods  map[uint64]*emissionSums
+}
+
+type emissionSums struct {
+	blocks                       uint64
+	blockRewards, nephews, uncle *big.Int
+}
+
+func newEmissionPeriods(interval uint64) *emissionPeriods {
+	return &emissionPeriods{interval: interval, periods: map[uint64]*emissionSums{}}
+}
+
+func (p *emissionPeriods) period(timestamp uint64) *emissionSums {
+	start := timestamp - timestamp%p.interval
+	s, ok := p.periods[start]
+	if !ok {
+		s = &emissionSums{blockRewards: new(big.Int), nephews: new(big.Int), uncle: new(big.Int)}
+		p.periods[start] = s
+	}
+	return s
+}
+
+// addWei adds a decimal wei amount to sum, ignoring the empty or invalid
+// values of documents stored without rewards.
+func addWei(sum *big.Int, wei string) {
+	if n, ok := new(big.Int).SetString(wei, 10); ok {
+		sum.Add(sum, n)
+	}
+}
+
+func (p *emissionPeriods) addBlock(timestamp uint64, count uint64, blockReward, unclesReward string) {
+	s := p.period(timestamp)
+	s.blocks += count
+	addWei(s.blockRewards, blockReward)
+	addWei(s.nephews, unclesReward)
+}
+
+func (p *emissionPeriods) addUncle(timestamp uint64, reward string) {
+	addWei(p.period(timestamp).uncle, reward)
+}
+
+// list returns the periods holding blocks, oldest first. Uncle rewards whose
+// timestamp falls in a period without blocks, at the edge of the range, are
+// dropped along with it.
+func (p *emissionPeriods) list() []Emission {
+	list := []Emission{}
+	for start, s := range p.periods {
+		if s.blocks == 0 {
+			continue
+		}
+		list = append(list, Emission{
+			Timestamp:     start,
+			Blocks:        s.blocks,
+			BlockRewards:  s.blockRewards.String(),
+			NephewRewards: s.nephews.String(),
+			UncleRewards:  s.uncle.String(),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Timestamp < list[j].Timestamp })
+	return list
+}
+
+// Writer is implemented by backends the built-in indexer can fill.
+type Writer interface {
+	// AddBlock stores a canonical block with everything mined in it. The
+	// block itself is written last, so that a block being present means its
+	// transactions, uncles, transfers and traces are too, and rewriting a
+	// block replaces what was stored for it.
+	AddBlock(ctx context.Context, block Block, txns []Transaction, uncles []Uncle, transfers []TokenTransfer, traces []Trace) error
+	// ForkBlock moves the canonical block at number to forkedblocks and its
+	// transactions to forkedtransactions, and removes the uncles, transfers
+	// and traces stored with it.
+	ForkBlock(ctx context.Context, number uint64) (Block, error)
+	// SetLatestBlock records block as the head in the status document.
+	SetLatestBlock(ctx context.Context, block Block) error
+	// AddPrices stores price points, replacing those of the same token,
+	// fiat and timestamp.
+	AddPrices(ctx context.Context, points []PricePoint) error
+	// SetLabel stores label, replacing the label of the same address.
+	SetLabel(ctx context.Context, label Label) error
+	// DeleteLabel removes the label of address, or returns ErrNotFound.
+	DeleteLabel(ctx context.Context, address string) error
+	// SetContractSource stores the verified source of a contract, replacing
+	// an earlier verification of it unless that was a full match or source's
+	// match is weaker, which return an apierr.Conflict.
+	SetContractSource(ctx context.Context, source ContractSource) error
+	// SetBalanceCheckpoint stores the balances of an account at a block,
+	// replacing a checkpoint of the same account and block. The indexer
+	// takes checkpoints maxReorgDepth blocks behind the head, which it never
+	// unwinds, so ForkBlock leaves them.
+	SetBalanceCheckpoint(ctx context.Context, balance Balance) error
+	// SetWatch stores watch, replacing the watch of the same id.
+	SetWatch(ctx context.Context, watch Watch) error
+	// DeleteWatch removes the watch id and its deliveries, or returns
+	// ErrNotFound.
+	DeleteWatch(ctx context.Context, id string) error
+	// SetDelivery stores a webhook delivery, replacing the delivery of the
+	// same id.
+	SetDelivery(ctx context.Context, delivery Delivery) error
+	// SetWatchCursor records number as the latest block the watches were
+	// matched against.
+	SetWatchCursor(ctx context.Context, number uint64) error
+}
+
+var _ Backend = (*SpectrumDAO)(nil)
+var _ Backend = (*PostgresDAO)(nil)
+var _ Backend = (*BoltDAO)(nil)
+
+var _ Writer = (*SpectrumDAO)(nil)
+var _ Writer = (*PostgresDAO)(nil)
+var _ Writer = (*BoltDAO)(nil)
@@ -0,0 +1,83 @@
+package dao
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+// store is a backend the tests can write to.
+type store interface {
+	Backend
+	Writer
+}
+
+// testRewriteBlock writes block 5 twice, as the indexer does when it
+// refetches a block, and checks that only the second version's
+// transactions, uncles and transfers remain.
+func testRewriteBlock(t *testing.T, db store) {
+	ctx := context.Background()
+	first := Block{Number: 5, Hash: "0xb1", Timestamp: 1500000005}
+	err := db.AddBlock(ctx, first,
+		[]Transaction{{Hash: "0xa1", BlockNumber: 5, BlockHash: "0xb1"}, {Hash: "0xa2", BlockNumber: 5, BlockHash: "0xb1", TransactionIndex: 1}},
+		[]Uncle{{Hash: "0xc1", BlockNumber: 5}},
+		[]TokenTransfer{{Hash: "0xa2", BlockNumber: 5, Contract: "0xd1", Value: "1"}},
+		nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := Block{Number: 5, Hash: "0xb2", Timestamp: 1500000006}
+	if err := db.AddBlock(ctx, second, []Transaction{{Hash: "0xa3", BlockNumber: 5, BlockHash: "0xb2"}}, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := db.BlockByNumber(ctx, 5); err != nil || b.Hash != "0xb2" {
+		t.Errorf("block 5 is %s, %v", b.Hash, err)
+	}
+	txns, err := db.TransactionsByBlockNumber(ctx, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txns) != 1 || txns[0].Hash != "0xa3" {
+		t.Errorf("block 5 has transactions %v", txns)
+	}
+	if _, err := db.TransactionByHash(ctx, "0xa1"); err != ErrNotFound {
+		t.Errorf("dropped transaction 0xa1: %v", err)
+	}
+	if uncles, err := db.UnclesByBlockNumber(ctx, 5); err != nil || len(uncles) != 0 {
+		t.Errorf("block 5 has uncles %v, %v", uncles, err)
+	}
+	if transfers, err := db.TokenTransfersByBlockNumber(ctx, 5); err != nil || len(transfers) != 0 {
+		t.Errorf("block 5 has transfers %v, %v", transfers, err)
+	}
+}
+
+// testForkBlock forks block 7 and checks that its transactions, logs
+// included, are kept under the forked block's hash.
+func testForkBlock(t *testing.T, db store) {
+	ctx := context.Background()
+	log := TxLog{Address: "0xd1", Topics: []string{"0xe1", "0xe2"}, Data: "0x01", BlockNumber: 7, TransactionHash: "0xa1", BlockHash: "0xb7", LogIndex: 3}
+	txn := Transaction{Hash: "0xa1", BlockNumber: 7, BlockHash: "0xb7", Logs: []TxLog{log}}
+	if err := db.AddBlock(ctx, Block{Number: 7, Hash: "0xb7"}, []Transaction{txn}, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := db.ForkBlock(ctx, 7); err != nil || b.Hash != "0xb7" {
+		t.Fatalf("forked %s, %v", b.Hash, err)
+	}
+
+	if _, err := db.BlockByNumber(ctx, 7); err != ErrNotFound {
+		t.Errorf("block 7 after the fork: %v", err)
+	}
+	txns, err := db.ForkedTransactions(ctx, "0xb7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txns) != 1 || txns[0].Hash != "0xa1" {
+		t.Fatalf("forked transactions %v", txns)
+	}
+	if len(txns[0].Logs) != 1 || !reflect.DeepEqual(txns[0].Logs[0], log) {
+		t.Errorf("forked logs %+v", txns[0].Logs)
+	}
+}
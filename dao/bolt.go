@@ -0,0 +1,1213 @@
+package dao
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	. "github.com/ubiq/spectrum-api/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltDAO serves the api from an embedded bbolt file, for small deployments
+// that don't want to run a database server. Documents are stored as json
+// under their natural key and every query the api makes is backed by an
+// index bucket whose keys sort in the order the query reads them.
+type BoltDAO struct {
+	DataDir string
+	// Genesis, when set, is added to the coin balances summed.
+	Genesis *Genesis
+
+	db *bolt.DB
+}
+
+var (
+	boltBlocks         = []byte(BLOCKS)                // number -> Block
+	boltBlockHashes    = []byte("blockhashes")         // hash -> number
+	boltForked         = []byte(REORGS)                // number|hash -> Block
+	boltForkedHashes   = []byte("forkedhashes")        // hash -> number|hash
+	boltForkedTxns     = []byte(FORKEDTXNS)            // blockHash|index -> Transaction
+	boltTxns           = []byte(TXNS)                  // hash -> Transaction
+	boltTxnsByBlock    = []byte("txnsbyblock")         // number|index|hash
+	boltTxnsByAccount  = []byte("txnsbyaccount")       // address|0|number|index|hash
+	boltTxnsByContract = []byte("txnsbycontract")      // contractAddress -> hash
+	boltUncles         = []byte(UNCLES)                // hash -> Uncle
+	boltUnclesByBlock  = []byte("unclesbyblock")       // blockNumber|position|hash
+	boltTransfers      = []byte(TRANSFERS)             // number|seq -> TokenTransfer
+	boltTransfersByAcc = []byte("transfersbyaccount")  // address|0|number|seq
+	boltTransfersByCon = []byte("transfersbycontract") // contract|0|number|seq
+	boltTraces         = []byte(TRACES)                // number|txIndex|index -> Trace
+	boltTracesByAcc    = []byte("tracesbyaccount")     // address|0|number|txIndex|index
+	boltStore          = []byte(STORE)                 // "store" -> Store
+	boltPrices         = []byte(PRICES)                // token|0|fiat|0|timestamp -> PricePoint
+	boltLabels         = []byte(LABELS)                // address -> Label
+	boltSources        = []byte(SOURCES)               // address -> ContractSource
+	boltBlocksByMiner  = []byte("blocksbyminer")       // miner|0|number
+	boltUnclesByMiner  = []byte("unclesbyminer")       // miner|0|blockNumber|position|hash
+	boltBalances       = []byte(BALANCES)              // address|0|number -> Balance
+	boltWatches        = []byte(WATCHES)               // id -> Watch
+	boltDeliveries     = []byte(DELIVERIES)            // id -> Delivery
+	boltDeliveryLog    = []byte("deliverylog")         // watchId|0|blockNumber|id
+	boltPending        = []byte("pendingdeliveries")   // blockNumber|id
+
+	boltBuckets = [][]byte{boltBlocks, boltBlockHashes, boltForked, boltForkedHashes, boltForkedTxns, boltTxns,
+		boltTxnsByBlock, boltTxnsByAccount, boltTxnsByContract, boltUncles, boltUnclesByBlock, boltTransfers,
+		boltTransfersByAcc, boltTransfersByCon, boltTraces, boltTracesByAcc, boltStore, boltPrices,
+		boltLabels, boltSources, boltBlocksByMiner, boltUnclesByMiner, boltBalances, boltWatches, boltDeliveries,
+		boltDeliveryLog, boltPending}
+)
+
+func (e *BoltDAO) Connect() {
+	if err := os.MkdirAll(e.DataDir, 0750); err != nil {
+		log.Fatal(err)
+	}
+
+	var err error
+	e.db, err = bolt.Open(filepath.Join(e.DataDir, "spectrum.db"), 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = e.db.Update(func(tx *bolt.Tx) error {
+		indexMiners := tx.Bucket(boltBlocksByMiner) == nil
+		for _, name := range boltBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		// Files written before the miner indexes existed get them built from
+		// their blocks and uncles.
+		if indexMiners {
+			return buildMinerIndexes(tx)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (e *BoltDAO) Close() {
+	e.db.Close()
+}
+
+func (e *BoltDAO) view(ctx context.Context, fn func(tx *bolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.db.View(fn)
+}
+
+func u64(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
+
+func boltKey(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// addrPrefix terminates an address so that a prefix scan for it can't match
+// a longer string that happens to start with it.
+func addrPrefix(address string) []byte {
+	return append([]byte(address), 0)
+}
+
+// prefixEnd returns the first key that sorts after every key with prefix, or
+// nil if there is none.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// reverse walks the keys of b that start with prefix from last to first,
+// stopping early when fn returns false.
+func reverse(b *bolt.Bucket, prefix []byte, fn func(k, v []byte) (bool, error)) error {
+	c := b.Cursor()
+	var k, v []byte
+	if end := prefixEnd(prefix); end == nil {
+		k, v = c.Last()
+	} else if k, v = c.Seek(end); k == nil {
+		k, v = c.Last()
+	} else {
+		k, v = c.Prev()
+	}
+	for ; k != nil && bytes.HasPrefix(k, prefix); k, v = c.Prev() {
+		more, err := fn(k, v)
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+// forward walks the keys of b that start with prefix in order.
+func forward(b *bolt.Bucket, prefix []byte, fn func(k, v []byte) error) error {
+	c := b.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func get(b *bolt.Bucket, key []byte, out interface{}) error {
+	v := b.Get(key)
+	if v == nil {
+		return ErrNotFound
+	}
+	return json.Unmarshal(v, out)
+}
+
+// latestDocs decodes up to limit values of b, newest first. A limit of 0
+// means no limit, as with mongo.
+func latestDocs(b *bolt.Bucket, limit int, decode func(v []byte) error) error {
+	n := 0
+	return reverse(b, nil, func(k, v []byte) (bool, error) {
+		if err := decode(v); err != nil {
+			return false, err
+		}
+		n++
+		return limit <= 0 || n < limit, nil
+	})
+}
+
+func (e *BoltDAO) BlockByNumber(ctx context.Context, number uint64) (Block, error) {
+	var block Block
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		return get(tx.Bucket(boltBlocks), u64(number), &block)
+	})
+	return block, err
+}
+
+func (e *BoltDAO) BlockByHash(ctx context.Context, hash string) (Block, error) {
+	var block Block
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		number := tx.Bucket(boltBlockHashes).Get([]byte(hash))
+		if number == nil {
+			return ErrNotFound
+		}
+		return get(tx.Bucket(boltBlocks), number, &block)
+	})
+	return block, err
+}
+
+func (e *BoltDAO) LatestBlock(ctx context.Context) (Block, error) {
+	var block Block
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		_, v := tx.Bucket(boltBlocks).Cursor().Last()
+		if v == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(v, &block)
+	})
+	return block, err
+}
+
+func (e *BoltDAO) Store(ctx context.Context) (Store, error) {
+	var store Store
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		return get(tx.Bucket(boltStore), []byte("store"), &store)
+	})
+	return store, err
+}
+
+func (e *BoltDAO) LatestBlocks(ctx context.Context, limit int) ([]Block, error) {
+	var blocks []Block
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		return latestDocs(tx.Bucket(boltBlocks), limit, func(v []byte) error {
+			var b Block
+			err := json.Unmarshal(v, &b)
+			blocks = append(blocks, b)
+			return err
+		})
+	})
+	return blocks, err
+}
+
+func (e *BoltDAO) LatestForkedBlocks(ctx context.Context, limit int) ([]Block, error) {
+	var blocks []Block
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		return latestDocs(tx.Bucket(boltForked), limit, func(v []byte) error {
+			var b Block
+			err := json.Unmarshal(v, &b)
+			blocks = append(blocks, b)
+			return err
+		})
+	})
+	return blocks, err
+}
+
+func (e *BoltDAO) ForkedBlockByHash(ctx context.Context, hash string) (Block, error) {
+	var block Block
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		key := tx.Bucket(boltForkedHashes).Get([]byte(hash))
+		if key == nil {
+			return ErrNotFound
+		}
+		return get(tx.Bucket(boltForked), key, &block)
+	})
+	return block, err
+}
+
+func (e *BoltDAO) ForkedTransactions(ctx context.Context, blockHash string) ([]Transaction, error) {
+	var txns []Transaction
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		return forward(tx.Bucket(boltForkedTxns), []byte(blockHash), func(_, v []byte) error {
+			var t Transaction
+			err := json.Unmarshal(v, &t)
+			txns = append(txns, t)
+			return err
+		})
+	})
+	return txns, err
+}
+
+func (e *BoltDAO) LatestUncles(ctx context.Context, limit int) ([]Uncle, error) {
+	var uncles []Uncle
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		all := tx.Bucket(boltUncles)
+		n := 0
+		return reverse(tx.Bucket(boltUnclesByBlock), nil, func(k, _ []byte) (bool, error) {
+			var u Uncle
+			if err := get(all, k[16:], &u); err != nil {
+				return false, err
+			}
+			uncles = append(uncles, u)
+			n++
+			return limit <= 0 || n < limit, nil
+		})
+	})
+	return uncles, err
+}
+
+func (e *BoltDAO) UncleByHash(ctx context.Context, hash string) (Uncle, error) {
+	var uncle Uncle
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		return get(tx.Bucket(boltUncles), []byte(hash), &uncle)
+	})
+	return uncle, err
+}
+
+func (e *BoltDAO) TransactionByHash(ctx context.Context, hash string) (Transaction, error) {
+	var txn Transaction
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		return get(tx.Bucket(boltTxns), []byte(hash), &txn)
+	})
+	return txn, err
+}
+
+func (e *BoltDAO) TransactionByContractAddress(ctx context.Context, hash string) (Transaction, error) {
+	var txn Transaction
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		txHash := tx.Bucket(boltTxnsByContract).Get([]byte(hash))
+		if txHash == nil {
+			return ErrNotFound
+		}
+		return get(tx.Bucket(boltTxns), txHash, &txn)
+	})
+	return txn, err
+}
+
+func (e *BoltDAO) TransactionsByBlockNumber(ctx context.Context, number uint64) ([]Transaction, error) {
+	var txns []Transaction
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		all := tx.Bucket(boltTxns)
+		return forward(tx.Bucket(boltTxnsByBlock), u64(number), func(k, _ []byte) error {
+			var t Transaction
+			err := get(all, k[16:], &t)
+			txns = append(txns, t)
+			return err
+		})
+	})
+	return txns, err
+}
+
+func (e *BoltDAO) UnclesByBlockNumber(ctx context.Context, number uint64) ([]Uncle, error) {
+	var uncles []Uncle
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		all := tx.Bucket(boltUncles)
+		return forward(tx.Bucket(boltUnclesByBlock), u64(number), func(k, _ []byte) error {
+			var u Uncle
+			err := get(all, k[16:], &u)
+			uncles = append(uncles, u)
+			return err
+		})
+	})
+	return uncles, err
+}
+
+func (e *BoltDAO) TokenTransfersByBlockNumber(ctx context.Context, number uint64) ([]TokenTransfer, error) {
+	var transfers []TokenTransfer
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		return forward(tx.Bucket(boltTransfers), u64(number), func(_, v []byte) error {
+			var t TokenTransfer
+			err := json.Unmarshal(v, &t)
+			transfers = append(transfers, t)
+			return err
+		})
+	})
+	return transfers, err
+}
+
+// latestTxns reads up to limit transactions through an index whose keys end
+// in number|index|hash after prefix, skipping those keep rejects.
+func latestTxns(tx *bolt.Tx, index []byte, prefix []byte, limit int, keep func(Transaction) bool) ([]Transaction, error) {
+	var txns []Transaction
+	all := tx.Bucket(boltTxns)
+	n := 0
+	err := reverse(tx.Bucket(index), prefix, func(k, _ []byte) (bool, error) {
+		var t Transaction
+		if err := get(all, k[len(prefix)+16:], &t); err != nil {
+			return false, err
+		}
+		if keep != nil && !keep(t) {
+			return true, nil
+		}
+		txns = append(txns, t)
+		n++
+		return limit <= 0 || n < limit, nil
+	})
+	return txns, err
+}
+
+func (e *BoltDAO) LatestTransactions(ctx context.Context, limit int) ([]Transaction, error) {
+	var txns []Transaction
+	err := e.view(ctx, func(tx *bolt.Tx) (err error) {
+		txns, err = latestTxns(tx, boltTxnsByBlock, nil, limit, nil)
+		return err
+	})
+	return txns, err
+}
+
+// keepTxn returns the keep func of latestTxns for filter, nil if it selects
+// every transaction.
+func keepTxn(filter TxnFilter) func(Transaction) bool {
+	status, ok := filter.status()
+	if !ok {
+		return nil
+	}
+	return func(t Transaction) bool { return t.Status != nil && *t.Status == status }
+}
+
+func (e *BoltDAO) LatestTransactionsByAccount(ctx context.Context, hash string, filter TxnFilter) ([]Transaction, error) {
+	var txns []Transaction
+	err := e.view(ctx, func(tx *bolt.Tx) (err error) {
+		txns, err = latestTxns(tx, boltTxnsByAccount, addrPrefix(hash), 100, keepTxn(filter))
+		return err
+	})
+	return txns, err
+}
+
+// latestTransfers reads up to limit transfers through an index whose keys
+// end in number|seq after prefix, skipping those keep rejects.
+func latestTransfers(tx *bolt.Tx, index []byte, prefix []byte, limit int, keep func(TokenTransfer) bool) ([]TokenTransfer, error) {
+	var transfers []TokenTransfer
+	all := tx.Bucket(boltTransfers)
+	n := 0
+	err := reverse(tx.Bucket(index), prefix, func(k, _ []byte) (bool, error) {
+		var t TokenTransfer
+		if err := get(all, k[len(prefix):], &t); err != nil {
+			return false, err
+		}
+		if keep != nil && !keep(t) {
+			return true, nil
+		}
+		transfers = append(transfers, t)
+		n++
+		return limit <= 0 || n < limit, nil
+	})
+	return transfers, err
+}
+
+func traceKey(t Trace) []byte {
+	return boltKey(u64(t.BlockNumber), u64(t.TransactionIndex), u64(t.Index))
+}
+
+// TracesByTransaction finds the traces through the transaction's position,
+// which prefixes their keys.
+func (e *BoltDAO) TracesByTransaction(ctx context.Context, hash string) ([]Trace, error) {
+	var traces []Trace
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		var txn Transaction
+		if err := get(tx.Bucket(boltTxns), []byte(hash), &txn); err != nil {
+			if err == ErrNotFound {
+				return nil
+			}
+			return err
+		}
+		return forward(tx.Bucket(boltTraces), boltKey(u64(txn.BlockNumber), u64(txn.TransactionIndex)), func(_, v []byte) error {
+			var t Trace
+			err := json.Unmarshal(v, &t)
+			traces = append(traces, t)
+			return err
+		})
+	})
+	return traces, err
+}
+
+func (e *BoltDAO) LatestTracesByAccount(ctx context.Context, hash string) ([]Trace, error) {
+	var traces []Trace
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		prefix := addrPrefix(hash)
+		all := tx.Bucket(boltTraces)
+		return reverse(tx.Bucket(boltTracesByAcc), prefix, func(k, _ []byte) (bool, error) {
+			var t Trace
+			if err := get(all, k[len(prefix):], &t); err != nil {
+				return false, err
+			}
+			traces = append(traces, t)
+			return len(traces) < 100, nil
+		})
+	})
+	return traces, err
+}
+
+func (e *BoltDAO) LatestTokenTransfersByAccount(ctx context.Context, hash string) ([]TokenTransfer, error) {
+	var transfers []TokenTransfer
+	err := e.view(ctx, func(tx *bolt.Tx) (err error) {
+		transfers, err = latestTransfers(tx, boltTransfersByAcc, addrPrefix(hash), 100, nil)
+		return err
+	})
+	return transfers, err
+}
+
+func (e *BoltDAO) TokenTransfersByAccount(ctx context.Context, token string, account string) ([]TokenTransfer, error) {
+	var transfers []TokenTransfer
+	err := e.view(ctx, func(tx *bolt.Tx) (err error) {
+		transfers, err = latestTransfers(tx, boltTransfersByAcc, addrPrefix(account), 0, func(t TokenTransfer) bool {
+			return t.Contract == token
+		})
+		return err
+	})
+	return transfers, err
+}
+
+func (e *BoltDAO) LatestTransfersByToken(ctx context.Context, hash string) ([]TokenTransfer, error) {
+	var transfers []TokenTransfer
+	err := e.view(ctx, func(tx *bolt.Tx) (err error) {
+		transfers, err = latestTransfers(tx, boltTransfersByCon, addrPrefix(hash), 1000, nil)
+		return err
+	})
+	return transfers, err
+}
+
+func (e *BoltDAO) LatestTokenTransfers(ctx context.Context, limit int) ([]TokenTransfer, error) {
+	var transfers []TokenTransfer
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		return latestDocs(tx.Bucket(boltTransfers), limit, func(v []byte) error {
+			var t TokenTransfer
+			err := json.Unmarshal(v, &t)
+			transfers = append(transfers, t)
+			return err
+		})
+	})
+	return transfers, err
+}
+
+func (e *BoltDAO) countPrefix(ctx context.Context, bucket []byte, prefix []byte) (int, error) {
+	n := 0
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		return forward(tx.Bucket(bucket), prefix, func(_, _ []byte) error {
+			n++
+			return nil
+		})
+	})
+	return n, err
+}
+
+func (e *BoltDAO) countAll(ctx context.Context, bucket []byte) (int, error) {
+	n := 0
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		n = tx.Bucket(bucket).Stats().KeyN
+		return nil
+	})
+	return n, err
+}
+
+func (e *BoltDAO) TxnCount(ctx context.Context, hash string, filter TxnFilter) (int, error) {
+	keep := keepTxn(filter)
+	if keep == nil {
+		return e.countPrefix(ctx, boltTxnsByAccount, addrPrefix(hash))
+	}
+	var txns []Transaction
+	err := e.view(ctx, func(tx *bolt.Tx) (err error) {
+		txns, err = latestTxns(tx, boltTxnsByAccount, addrPrefix(hash), 0, keep)
+		return err
+	})
+	return len(txns), err
+}
+
+func (e *BoltDAO) TraceCount(ctx context.Context, hash string) (int, error) {
+	return e.countPrefix(ctx, boltTracesByAcc, addrPrefix(hash))
+}
+
+func (e *BoltDAO) TotalTxnCount(ctx context.Context) (int, error) {
+	return e.countAll(ctx, boltTxns)
+}
+
+func (e *BoltDAO) TokenTransferCount(ctx context.Context, hash string) (int, error) {
+	return e.countPrefix(ctx, boltTransfersByAcc, addrPrefix(hash))
+}
+
+func (e *BoltDAO) TokenTransferCountByContract(ctx context.Context, hash string) (int, error) {
+	return e.countPrefix(ctx, boltTransfersByCon, addrPrefix(hash))
+}
+
+func (e *BoltDAO) TotalTokenTransferCount(ctx context.Context) (int, error) {
+	return e.countAll(ctx, boltTransfers)
+}
+
+func (e *BoltDAO) TokenTransferByAccountCount(ctx context.Context, token string, account string) (int, error) {
+	transfers, err := e.TokenTransfersByAccount(ctx, token, account)
+	return len(transfers), err
+}
+
+func (e *BoltDAO) TotalBlockCount(ctx context.Context) (int, error) {
+	return e.countAll(ctx, boltBlocks)
+}
+
+func (e *BoltDAO) TotalUncleCount(ctx context.Context) (int, error) {
+	return e.countAll(ctx, boltUncles)
+}
+
+func (e *BoltDAO) Emission(ctx context.Context, from uint64, interval uint64) ([]Emission, error) {
+	periods := newEmissionPeriods(interval)
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		// Blocks are keyed by number, and so sorted by timestamp too: walk
+		// back from the head to the first block before from.
+		first := ^uint64(0)
+		err := reverse(tx.Bucket(boltBlocks), nil, func(k, v []byte) (bool, error) {
+			var b Block
+			if err := json.Unmarshal(v, &b); err != nil {
+				return false, err
+			}
+			if b.Timestamp < from {
+				return false, nil
+			}
+			periods.addBlock(b.Timestamp, 1, b.BlockReward, b.UnclesReward)
+			first = b.Number
+			return true, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		all := tx.Bucket(boltUncles)
+		return reverse(tx.Bucket(boltUnclesByBlock), nil, func(k, _ []byte) (bool, error) {
+			if binary.BigEndian.Uint64(k[:8]) < first {
+				return false, nil
+			}
+			var u Uncle
+			if err := get(all, k[16:], &u); err != nil {
+				return false, err
+			}
+			if u.Timestamp >= from {
+				periods.addUncle(u.Timestamp, u.Reward)
+			}
+			return true, nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return periods.list(), nil
+}
+
+func pricePrefix(token string, fiat string) []byte {
+	return boltKey(addrPrefix(token), []byte(fiat), []byte{0})
+}
+
+func (e *BoltDAO) PriceAt(ctx context.Context, token string, fiat string, timestamp uint64) (PricePoint, error) {
+	var point PricePoint
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		prefix := pricePrefix(token, fiat)
+		c := tx.Bucket(boltPrices).Cursor()
+		k, v := c.Seek(boltKey(prefix, u64(timestamp+1)))
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		if k == nil || !bytes.HasPrefix(k, prefix) {
+			return ErrNotFound
+		}
+		return json.Unmarshal(v, &point)
+	})
+	return point, err
+}
+
+func (e *BoltDAO) PriceHistory(ctx context.Context, token string, fiat string, from uint64, to uint64, limit int) ([]PricePoint, error) {
+	var points []PricePoint
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		prefix := pricePrefix(token, fiat)
+		c := tx.Bucket(boltPrices).Cursor()
+		for k, v := c.Seek(boltKey(prefix, u64(from))); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if limit > 0 && len(points) == limit {
+				break
+			}
+			var p PricePoint
+			if err := json.Unmarshal(v, &p); err != nil {
+				return err
+			}
+			if p.Timestamp > to {
+				break
+			}
+			points = append(points, p)
+		}
+		return nil
+	})
+	return points, err
+}
+
+func (e *BoltDAO) Label(ctx context.Context, address string) (Label, error) {
+	var label Label
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltLabels).Get([]byte(address))
+		if v == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(v, &label)
+	})
+	return label, err
+}
+
+func (e *BoltDAO) Labels(ctx context.Context, tag string) ([]Label, error) {
+	var labels []Label
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket(boltLabels).ForEach(func(k, v []byte) error {
+			var l Label
+			if err := json.Unmarshal(v, &l); err != nil {
+				return err
+			}
+			if tag == "" || hasTag(l, tag) {
+				labels = append(labels, l)
+			}
+			return nil
+		})
+	})
+	return labels, err
+}
+
+func hasTag(l Label, tag string) bool {
+	for _, t := range l.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *BoltDAO) ContractSource(ctx context.Context, address string) (ContractSource, error) {
+	var source ContractSource
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltSources).Get([]byte(address))
+		if v == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(v, &source)
+	})
+	return source, err
+}
+
+// eachContractCreation calls fn with the creating transaction of every contract.
+func eachContractCreation(tx *bolt.Tx, fn func(t Transaction) error) error {
+	all := tx.Bucket(boltTxns)
+	return tx.Bucket(boltTxnsByContract).ForEach(func(_, txHash []byte) error {
+		var t Transaction
+		if err := get(all, txHash, &t); err != nil {
+			return err
+		}
+		return fn(t)
+	})
+}
+
+func (e *BoltDAO) Contracts(ctx context.Context, filter ContractFilter, limit int) ([]Contract, error) {
+	var contracts []Contract
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		var txns []Transaction
+		err := eachContractCreation(tx, func(t Transaction) error {
+			if filter.matches(t.From, t.BlockNumber) {
+				t.Logs = nil
+				txns = append(txns, t)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		sort.Slice(txns, func(i, j int) bool {
+			if txns[i].BlockNumber != txns[j].BlockNumber {
+				return txns[i].BlockNumber > txns[j].BlockNumber
+			}
+			return txns[i].TransactionIndex > txns[j].TransactionIndex
+		})
+		if limit > 0 && len(txns) > limit {
+			txns = txns[:limit]
+		}
+
+		transfers := tx.Bucket(boltTransfersByCon).Cursor()
+		sources := tx.Bucket(boltSources)
+		for _, t := range txns {
+			c := Contract{
+				Address:         t.ContractAddress,
+				Creator:         t.From,
+				BlockNumber:     t.BlockNumber,
+				Timestamp:       t.Timestamp,
+				TransactionHash: t.Hash,
+				BytecodeSize:    inputSize(uint64(len(t.Input))),
+			}
+			prefix := addrPrefix(t.ContractAddress)
+			if k, _ := transfers.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix) {
+				c.IsToken = true
+			}
+			if v := sources.Get([]byte(t.ContractAddress)); v != nil {
+				var s ContractSource
+				if err := json.Unmarshal(v, &s); err != nil {
+					return err
+				}
+				c.Verified = s.Match
+			}
+			contracts = append(contracts, c)
+		}
+		return nil
+	})
+	return contracts, err
+}
+
+func (e *BoltDAO) ContractCounts(ctx context.Context, from uint64, interval uint64) ([]ContractCount, error) {
+	counts := map[uint64]uint64{}
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		return eachContractCreation(tx, func(t Transaction) error {
+			if t.Timestamp >= from {
+				counts[t.Timestamp-t.Timestamp%interval]++
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return countPeriods(counts), nil
+}
+
+// buildMinerIndexes adds every stored block and uncle to the miner indexes.
+func buildMinerIndexes(tx *bolt.Tx) error {
+	err := forward(tx.Bucket(boltBlocks), nil, func(k, v []byte) error {
+		var b Block
+		if err := json.Unmarshal(v, &b); err != nil || b.Miner == "" {
+			return err
+		}
+		return tx.Bucket(boltBlocksByMiner).Put(boltKey(addrPrefix(b.Miner), k), nil)
+	})
+	if err != nil {
+		return err
+	}
+	return forward(tx.Bucket(boltUncles), nil, func(_, v []byte) error {
+		var u Uncle
+		if err := json.Unmarshal(v, &u); err != nil {
+			return err
+		}
+		return tx.Bucket(boltUnclesByMiner).Put(uncleMinerKey(u), nil)
+	})
+}
+
+func uncleMinerKey(u Uncle) []byte {
+	return boltKey(addrPrefix(u.Miner), u64(u.BlockNumber), u64(u.Position), []byte(u.Hash))
+}
+
+// span walks the keys of b made of prefix and a block number from from to
+// to, in order.
+func span(b *bolt.Bucket, prefix []byte, from uint64, to uint64, fn func(k []byte) error) error {
+	c := b.Cursor()
+	for k, _ := c.Seek(boltKey(prefix, u64(from))); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		if len(k) < len(prefix)+8 || binary.BigEndian.Uint64(k[len(prefix):]) > to {
+			break
+		}
+		if err := fn(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *BoltDAO) BalanceChanges(ctx context.Context, address string, from uint64, to uint64) ([]BalanceChange, error) {
+	ledger := newBalanceLedger(address)
+	if from == 0 {
+		ledger.addGenesis(e.Genesis)
+	}
+	prefix := addrPrefix(address)
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		txns := tx.Bucket(boltTxns)
+		err := span(tx.Bucket(boltTxnsByAccount), prefix, from, to, func(k []byte) error {
+			var t Transaction
+			if err := get(txns, k[len(prefix)+16:], &t); err != nil {
+				return err
+			}
+			ledger.addTxn(t)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if hash := tx.Bucket(boltTxnsByContract).Get([]byte(address)); hash != nil {
+			var t Transaction
+			if err := get(txns, hash, &t); err != nil {
+				return err
+			}
+			if t.BlockNumber >= from && t.BlockNumber <= to {
+				ledger.addTxn(t)
+			}
+		}
+
+		traces := tx.Bucket(boltTraces)
+		err = span(tx.Bucket(boltTracesByAcc), prefix, from, to, func(k []byte) error {
+			var t Trace
+			if err := get(traces, k[len(prefix):], &t); err != nil {
+				return err
+			}
+			ledger.addTrace(t)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		blocks := tx.Bucket(boltBlocks)
+		err = span(tx.Bucket(boltBlocksByMiner), prefix, from, to, func(k []byte) error {
+			var b Block
+			if err := get(blocks, k[len(prefix):], &b); err != nil {
+				return err
+			}
+			ledger.addBlock(b)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		uncles := tx.Bucket(boltUncles)
+		err = span(tx.Bucket(boltUnclesByMiner), prefix, from, to, func(k []byte) error {
+			var u Uncle
+			if err := get(uncles, k[len(prefix)+16:], &u); err != nil {
+				return err
+			}
+			ledger.addUncle(u)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		transfers := tx.Bucket(boltTransfers)
+		return span(tx.Bucket(boltTransfersByAcc), prefix, from, to, func(k []byte) error {
+			var t TokenTransfer
+			if err := get(transfers, k[len(prefix):], &t); err != nil {
+				return err
+			}
+			ledger.addTransfer(t)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ledger.list(), nil
+}
+
+func (e *BoltDAO) BalanceCheckpoint(ctx context.Context, address string, number uint64) (Balance, error) {
+	var balance Balance
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		prefix := addrPrefix(address)
+		c := tx.Bucket(boltBalances).Cursor()
+		k, v := c.Seek(boltKey(prefix, u64(number+1)))
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		if k == nil || !bytes.HasPrefix(k, prefix) {
+			return ErrNotFound
+		}
+		return json.Unmarshal(v, &balance)
+	})
+	return balance, err
+}
+
+func (e *BoltDAO) BalanceAccounts(ctx context.Context, from uint64, to uint64) ([]string, error) {
+	accounts := accountSet{}
+	if from == 0 {
+		accounts.add(e.Genesis.movements()...)
+	}
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		txns := tx.Bucket(boltTxns)
+		err := span(tx.Bucket(boltTxnsByBlock), nil, from, to, func(k []byte) error {
+			var t Transaction
+			if err := get(txns, k[16:], &t); err != nil {
+				return err
+			}
+			accounts.add(txnMovements(t)...)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		traces := tx.Bucket(boltTraces)
+		err = span(traces, nil, from, to, func(k []byte) error {
+			var t Trace
+			if err := get(traces, k, &t); err != nil {
+				return err
+			}
+			accounts.add(traceMovements(t)...)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		blocks := tx.Bucket(boltBlocks)
+		err = span(blocks, nil, from, to, func(k []byte) error {
+			var b Block
+			if err := get(blocks, k, &b); err != nil {
+				return err
+			}
+			accounts.add(blockMovement(b))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		uncles := tx.Bucket(boltUncles)
+		err = span(tx.Bucket(boltUnclesByBlock), nil, from, to, func(k []byte) error {
+			var u Uncle
+			if err := get(uncles, k[16:], &u); err != nil {
+				return err
+			}
+			accounts.add(uncleMovement(u))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		transfers := tx.Bucket(boltTransfers)
+		return span(transfers, nil, from, to, func(k []byte) error {
+			var t TokenTransfer
+			if err := get(transfers, k, &t); err != nil {
+				return err
+			}
+			accounts.add(transferMovements(t)...)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return accounts.list(), nil
+}
+
+// upTo walks the keys of b, which start with a block number, from the
+// first to those of block number.
+func upTo(b *bolt.Bucket, number uint64, fn func(k, v []byte) error) error {
+	c := b.Cursor()
+	for k, v := c.First(); k != nil && binary.BigEndian.Uint64(k) <= number; k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *BoltDAO) Holders(ctx context.Context, contract string, number uint64) ([]Holder, error) {
+	ledger := holderLedger{}
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		transfers := tx.Bucket(boltTransfers)
+		if contract != "" {
+			prefix := addrPrefix(contract)
+			return span(tx.Bucket(boltTransfersByCon), prefix, 0, number, func(k []byte) error {
+				var t TokenTransfer
+				if err := get(transfers, k[len(prefix):], &t); err != nil {
+					return err
+				}
+				ledger.add(transferMovements(t)...)
+				return nil
+			})
+		}
+
+		ledger.add(e.Genesis.movements()...)
+		txns := tx.Bucket(boltTxns)
+		err := upTo(tx.Bucket(boltTxnsByBlock), number, func(k, _ []byte) error {
+			var t Transaction
+			if err := get(txns, k[16:], &t); err != nil {
+				return err
+			}
+			ledger.add(txnMovements(t)...)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = upTo(tx.Bucket(boltTraces), number, func(_, v []byte) error {
+			var t Trace
+			if err := json.Unmarshal(v, &t); err != nil {
+				return err
+			}
+			ledger.add(traceMovements(t)...)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = upTo(tx.Bucket(boltBlocks), number, func(_, v []byte) error {
+			var b Block
+			if err := json.Unmarshal(v, &b); err != nil {
+				return err
+			}
+			ledger.add(blockMovement(b))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		uncles := tx.Bucket(boltUncles)
+		return upTo(tx.Bucket(boltUnclesByBlock), number, func(k, _ []byte) error {
+			var u Uncle
+			if err := get(uncles, k[16:], &u); err != nil {
+				return err
+			}
+			ledger.add(uncleMovement(u))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ledger.list(), nil
+}
+
+func (e *BoltDAO) Watch(ctx context.Context, id string) (Watch, error) {
+	var watch Watch
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		return get(tx.Bucket(boltWatches), []byte(id), &watch)
+	})
+	return watch, err
+}
+
+func (e *BoltDAO) Watches(ctx context.Context) ([]Watch, error) {
+	var watches []Watch
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket(boltWatches).ForEach(func(k, v []byte) error {
+			var w Watch
+			if err := json.Unmarshal(v, &w); err != nil {
+				return err
+			}
+			watches = append(watches, w)
+			return nil
+		})
+	})
+	return watches, err
+}
+
+func (e *BoltDAO) Delivery(ctx context.Context, id string) (Delivery, error) {
+	var delivery Delivery
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		return get(tx.Bucket(boltDeliveries), []byte(id), &delivery)
+	})
+	return delivery, err
+}
+
+// Deliveries walks the delivery log of the watch, or the pending
+// deliveries, newest first. Other filters read every delivery.
+func (e *BoltDAO) Deliveries(ctx context.Context, filter DeliveryFilter, limit int) ([]Delivery, error) {
+	var deliveries []Delivery
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		docs := tx.Bucket(boltDeliveries)
+		keep := func(id []byte) (bool, error) {
+			var d Delivery
+			if err := get(docs, id, &d); err != nil {
+				return false, err
+			}
+			if filter.matches(d) {
+				deliveries = append(deliveries, d)
+			}
+			return limit <= 0 || len(deliveries) < limit, nil
+		}
+		switch {
+		case filter.WatchID != "":
+			prefix := addrPrefix(filter.WatchID)
+			return reverse(tx.Bucket(boltDeliveryLog), prefix, func(k, v []byte) (bool, error) {
+				return keep(k[len(prefix)+8:])
+			})
+		case filter.Status == "pending":
+			return reverse(tx.Bucket(boltPending), nil, func(k, v []byte) (bool, error) {
+				return keep(k[8:])
+			})
+		}
+		err := docs.ForEach(func(k, v []byte) error {
+			var d Delivery
+			if err := json.Unmarshal(v, &d); err != nil {
+				return err
+			}
+			if filter.matches(d) {
+				deliveries = append(deliveries, d)
+			}
+			return nil
+		})
+		sort.Slice(deliveries, func(i, j int) bool {
+			a, b := deliveries[i], deliveries[j]
+			return a.BlockNumber > b.BlockNumber || a.BlockNumber == b.BlockNumber && a.ID > b.ID
+		})
+		if limit > 0 && len(deliveries) > limit {
+			deliveries = deliveries[:limit]
+		}
+		return err
+	})
+	return deliveries, err
+}
+
+func (e *BoltDAO) DueDeliveries(ctx context.Context, watchID string, toBlock uint64, now uint64, limit int) ([]Delivery, error) {
+	var deliveries []Delivery
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		docs := tx.Bucket(boltDeliveries)
+		c := tx.Bucket(boltPending).Cursor()
+		for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) <= toBlock; k, _ = c.Next() {
+			var d Delivery
+			if err := get(docs, k[8:], &d); err != nil {
+				return err
+			}
+			if d.WatchID != watchID || d.NextAttempt > now {
+				continue
+			}
+			if deliveries = append(deliveries, d); limit > 0 && len(deliveries) >= limit {
+				break
+			}
+		}
+		return nil
+	})
+	return deliveries, err
+}
+
+func (e *BoltDAO) WatchCursor(ctx context.Context) (uint64, error) {
+	var cursor uint64
+	err := e.view(ctx, func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltStore).Get([]byte("watchcursor"))
+		if v == nil {
+			return ErrNotFound
+		}
+		cursor = binary.BigEndian.Uint64(v)
+		return nil
+	})
+	return cursor, err
+}
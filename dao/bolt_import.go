@@ -0,0 +1,314 @@
+package dao
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	. "github.com/ubiq/spectrum-api/models"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const importBatchSize = 1000
+
+func putJSON(b *bolt.Bucket, key []byte, doc interface{}) error {
+	v, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, v)
+}
+
+func putBlock(tx *bolt.Tx, block Block) error {
+	number := u64(block.Number)
+	if err := deleteMinerKey(tx, block.Number); err != nil {
+		return err
+	}
+	if err := putJSON(tx.Bucket(boltBlocks), number, block); err != nil {
+		return err
+	}
+	if block.Miner != "" {
+		if err := tx.Bucket(boltBlocksByMiner).Put(boltKey(addrPrefix(block.Miner), number), nil); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(boltBlockHashes).Put([]byte(block.Hash), number)
+}
+
+// deleteMinerKey removes the block stored at number, if any, from the miner
+// index.
+func deleteMinerKey(tx *bolt.Tx, number uint64) error {
+	var old Block
+	if err := get(tx.Bucket(boltBlocks), u64(number), &old); err != nil {
+		if err == ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	return tx.Bucket(boltBlocksByMiner).Delete(boltKey(addrPrefix(old.Miner), u64(number)))
+}
+
+func putForkedBlock(tx *bolt.Tx, block Block) error {
+	key := boltKey(u64(block.Number), []byte(block.Hash))
+	if err := putJSON(tx.Bucket(boltForked), key, block); err != nil {
+		return err
+	}
+	return tx.Bucket(boltForkedHashes).Put([]byte(block.Hash), key)
+}
+
+func putTransaction(tx *bolt.Tx, txn Transaction) error {
+	hash := []byte(txn.Hash)
+	if err := putJSON(tx.Bucket(boltTxns), hash, txn); err != nil {
+		return err
+	}
+
+	position := boltKey(u64(txn.BlockNumber), u64(txn.TransactionIndex), hash)
+	if err := tx.Bucket(boltTxnsByBlock).Put(position, nil); err != nil {
+		return err
+	}
+	byAccount := tx.Bucket(boltTxnsByAccount)
+	for _, address := range []string{txn.From, txn.To} {
+		if address == "" {
+			continue
+		}
+		if err := byAccount.Put(boltKey(addrPrefix(address), position), nil); err != nil {
+			return err
+		}
+	}
+	if txn.ContractAddress != "" {
+		return tx.Bucket(boltTxnsByContract).Put([]byte(txn.ContractAddress), hash)
+	}
+	return nil
+}
+
+func putUncle(tx *bolt.Tx, uncle Uncle) error {
+	hash := []byte(uncle.Hash)
+	if err := putJSON(tx.Bucket(boltUncles), hash, uncle); err != nil {
+		return err
+	}
+	if err := tx.Bucket(boltUnclesByMiner).Put(uncleMinerKey(uncle), nil); err != nil {
+		return err
+	}
+	return tx.Bucket(boltUnclesByBlock).Put(boltKey(u64(uncle.BlockNumber), u64(uncle.Position), hash), nil)
+}
+
+func putTransfer(tx *bolt.Tx, transfer TokenTransfer) error {
+	all := tx.Bucket(boltTransfers)
+	seq, err := all.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	key := boltKey(u64(transfer.BlockNumber), u64(seq))
+	if err := putJSON(all, key, transfer); err != nil {
+		return err
+	}
+	byAccount := tx.Bucket(boltTransfersByAcc)
+	for _, address := range []string{transfer.From, transfer.To} {
+		if address == "" {
+			continue
+		}
+		if err := byAccount.Put(boltKey(addrPrefix(address), key), nil); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(boltTransfersByCon).Put(boltKey(addrPrefix(transfer.Contract), key), nil)
+}
+
+func putTrace(tx *bolt.Tx, trace Trace) error {
+	key := traceKey(trace)
+	if err := putJSON(tx.Bucket(boltTraces), key, trace); err != nil {
+		return err
+	}
+	byAccount := tx.Bucket(boltTracesByAcc)
+	for _, address := range []string{trace.From, trace.To} {
+		if address == "" {
+			continue
+		}
+		if err := byAccount.Put(boltKey(addrPrefix(address), key), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func putPrice(tx *bolt.Tx, p PricePoint) error {
+	return putJSON(tx.Bucket(boltPrices), boltKey(pricePrefix(p.Token, p.Fiat), u64(p.Timestamp)), p)
+}
+
+func putLabel(tx *bolt.Tx, l Label) error {
+	return putJSON(tx.Bucket(boltLabels), []byte(l.Address), l)
+}
+
+func putContractSource(tx *bolt.Tx, s ContractSource) error {
+	return putJSON(tx.Bucket(boltSources), []byte(s.Address), s)
+}
+
+func putBalance(tx *bolt.Tx, b Balance) error {
+	return putJSON(tx.Bucket(boltBalances), boltKey(addrPrefix(b.Address), u64(b.BlockNumber)), b)
+}
+
+// putDelivery stores d with its log entry, and keeps it among the pending
+// deliveries while its status is pending.
+func putDelivery(tx *bolt.Tx, d Delivery) error {
+	key := boltKey(u64(d.BlockNumber), []byte(d.ID))
+	if err := putJSON(tx.Bucket(boltDeliveries), []byte(d.ID), d); err != nil {
+		return err
+	}
+	if err := tx.Bucket(boltDeliveryLog).Put(boltKey(addrPrefix(d.WatchID), key), nil); err != nil {
+		return err
+	}
+	if d.Status == "pending" {
+		return tx.Bucket(boltPending).Put(key, nil)
+	}
+	return tx.Bucket(boltPending).Delete(key)
+}
+
+func putStore(tx *bolt.Tx, store Store) error {
+	return putJSON(tx.Bucket(boltStore), []byte("store"), store)
+}
+
+// importers decode one extended json document of a mongo collection and
+// store it.
+var importers = map[string]func(tx *bolt.Tx, doc []byte) error{
+	BLOCKS: func(tx *bolt.Tx, doc []byte) error {
+		var block Block
+		if err := bson.UnmarshalExtJSON(doc, false, &block); err != nil {
+			return err
+		}
+		return putBlock(tx, block)
+	},
+	REORGS: func(tx *bolt.Tx, doc []byte) error {
+		var block Block
+		if err := bson.UnmarshalExtJSON(doc, false, &block); err != nil {
+			return err
+		}
+		return putForkedBlock(tx, block)
+	},
+	TXNS: func(tx *bolt.Tx, doc []byte) error {
+		var txn Transaction
+		if err := bson.UnmarshalExtJSON(doc, false, &txn); err != nil {
+			return err
+		}
+		return putTransaction(tx, txn)
+	},
+	UNCLES: func(tx *bolt.Tx, doc []byte) error {
+		var uncle Uncle
+		if err := bson.UnmarshalExtJSON(doc, false, &uncle); err != nil {
+			return err
+		}
+		return putUncle(tx, uncle)
+	},
+	TRANSFERS: func(tx *bolt.Tx, doc []byte) error {
+		var transfer TokenTransfer
+		if err := bson.UnmarshalExtJSON(doc, false, &transfer); err != nil {
+			return err
+		}
+		return putTransfer(tx, transfer)
+	},
+	TRACES: func(tx *bolt.Tx, doc []byte) error {
+		var trace Trace
+		if err := bson.UnmarshalExtJSON(doc, false, &trace); err != nil {
+			return err
+		}
+		return putTrace(tx, trace)
+	},
+	PRICES: func(tx *bolt.Tx, doc []byte) error {
+		var p PricePoint
+		if err := bson.UnmarshalExtJSON(doc, false, &p); err != nil {
+			return err
+		}
+		return putPrice(tx, p)
+	},
+	LABELS: func(tx *bolt.Tx, doc []byte) error {
+		var l Label
+		if err := bson.UnmarshalExtJSON(doc, false, &l); err != nil {
+			return err
+		}
+		return putLabel(tx, l)
+	},
+	SOURCES: func(tx *bolt.Tx, doc []byte) error {
+		var s ContractSource
+		if err := bson.UnmarshalExtJSON(doc, false, &s); err != nil {
+			return err
+		}
+		return putContractSource(tx, s)
+	},
+	STORE: func(tx *bolt.Tx, doc []byte) error {
+		var store Store
+		if err := bson.UnmarshalExtJSON(doc, false, &store); err != nil {
+			return err
+		}
+		return putStore(tx, store)
+	},
+}
+
+// ImportMongoExport loads the output of `mongoexport --collection <name>`,
+// either one document per line or a --jsonArray, into the matching buckets
+// and indexes. Token transfers have no natural key, so importing the same
+// tokentransfers dump twice duplicates them.
+func (e *BoltDAO) ImportMongoExport(collection string, r io.Reader) (int, error) {
+	importer, ok := importers[collection]
+	if !ok {
+		return 0, fmt.Errorf("unknown collection %q", collection)
+	}
+
+	br := bufio.NewReader(r)
+	dec := json.NewDecoder(br)
+	if first, err := peekNonSpace(br); err == nil && first == '[' {
+		if _, err := dec.Token(); err != nil {
+			return 0, err
+		}
+	}
+
+	total := 0
+	batch := make([]json.RawMessage, 0, importBatchSize)
+	flush := func() error {
+		err := e.db.Update(func(tx *bolt.Tx) error {
+			for i, doc := range batch {
+				if err := importer(tx, doc); err != nil {
+					return fmt.Errorf("document %d: %v", total+i+1, err)
+				}
+			}
+			return nil
+		})
+		if err == nil {
+			total += len(batch)
+		}
+		batch = batch[:0]
+		return err
+	}
+
+	for dec.More() {
+		var doc json.RawMessage
+		if err := dec.Decode(&doc); err != nil {
+			return total, err
+		}
+		batch = append(batch, doc)
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return total, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return total, err
+	}
+	return total, nil
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for n := 1; ; n++ {
+		b, err := br.Peek(n)
+		if err != nil {
+			return 0, err
+		}
+		switch c := b[n-1]; c {
+		case ' ', '\t', '\r', '\n':
+		default:
+			return c, nil
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package dao
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	. "github.com/ubiq/spectrum-api/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+// testBolt opens a bolt database in a directory of the test's.
+func testBolt(t *testing.T) *BoltDAO {
+	db := &BoltDAO{DataDir: t.TempDir()}
+	db.Connect()
+	t.Cleanup(db.Close)
+	return db
+}
+
+func TestBoltRewriteBlock(t *testing.T) {
+	testRewriteBlock(t, testBolt(t))
+}
+
+func TestBoltForkBlock(t *testing.T) {
+	testForkBlock(t, testBolt(t))
+}
+
+func TestBoltLatest(t *testing.T) {
+	const from, to = "0x1111111111111111111111111111111111111111", "0x2222222222222222222222222222222222222222"
+	db := testBolt(t)
+	ctx := context.Background()
+
+	// Blocks 1 to 5 each have a transaction, whose status alternates, and a
+	// transfer; block 5 has a second transaction and blocks 2 and 4 an uncle.
+	for n := uint64(1); n <= 5; n++ {
+		hash := "0xa" + strconv.FormatUint(n, 10)
+		status := n % 2
+		txns := []Transaction{{Hash: hash, BlockNumber: n, From: from, To: to, Status: &status}}
+		if n == 5 {
+			txns = append(txns, Transaction{Hash: "0xa6", BlockNumber: 5, TransactionIndex: 1, From: from, To: to, Status: &status})
+		}
+		var uncles []Uncle
+		if n%2 == 0 {
+			uncles = []Uncle{{Hash: "0xc" + strconv.FormatUint(n, 10), BlockNumber: n}}
+		}
+		transfers := []TokenTransfer{{Hash: hash, BlockNumber: n, From: from, To: to, Contract: "0xd1", Value: "1"}}
+		if err := db.AddBlock(ctx, Block{Number: n, Hash: "0xb" + strconv.FormatUint(n, 10)}, txns, uncles, transfers, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	blocks, err := db.LatestBlocks(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var numbers []uint64
+	for _, b := range blocks {
+		numbers = append(numbers, b.Number)
+	}
+	if !reflect.DeepEqual(numbers, []uint64{5, 4, 3}) {
+		t.Errorf("latest blocks %v", numbers)
+	}
+
+	txns, err := db.LatestTransactions(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashes := txnHashes(txns); !reflect.DeepEqual(hashes, []string{"0xa6", "0xa5", "0xa4"}) {
+		t.Errorf("latest transactions %v", hashes)
+	}
+	txns, err = db.LatestTransactionsByAccount(ctx, to, TxnFilter{Status: "failed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashes := txnHashes(txns); !reflect.DeepEqual(hashes, []string{"0xa4", "0xa2"}) {
+		t.Errorf("failed transactions of %s: %v", to, hashes)
+	}
+
+	uncles, err := db.LatestUncles(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uncles) != 1 || uncles[0].Hash != "0xc4" {
+		t.Errorf("latest uncles %v", uncles)
+	}
+
+	transfers, err := db.LatestTokenTransfers(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 2 || transfers[0].BlockNumber != 5 || transfers[1].BlockNumber != 4 {
+		t.Errorf("latest transfers %v", transfers)
+	}
+}
+
+func txnHashes(txns []Transaction) []string {
+	var hashes []string
+	for _, t := range txns {
+		hashes = append(hashes, t.Hash)
+	}
+	return hashes
+}
+
+// A file written before the miner indexes existed gets them when opened.
+func TestBoltConnectBuildsMinerIndexes(t *testing.T) {
+	const miner = "0x3333333333333333333333333333333333333333"
+	db := &BoltDAO{DataDir: t.TempDir()}
+	db.Connect()
+	ctx := context.Background()
+	for n := uint64(1); n <= 2; n++ {
+		var uncles []Uncle
+		if n == 2 {
+			uncles = []Uncle{{Hash: "0xc1", BlockNumber: 2, Miner: miner, Reward: "5"}}
+		}
+		b := Block{Number: n, Hash: "0xb" + strconv.FormatUint(n, 10), Miner: miner, BlockReward: "10"}
+		if err := db.AddBlock(ctx, b, nil, uncles, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(boltBlocksByMiner); err != nil {
+			return err
+		}
+		return tx.DeleteBucket(boltUnclesByMiner)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db.Connect()
+	defer db.Close()
+	changes, err := db.BalanceChanges(ctx, miner, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []BalanceChange{{BlockNumber: 1, Amount: "10"}, {BlockNumber: 2, Amount: "15"}}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes of %s: %+v", miner, changes)
+	}
+}
+
+// TestBoltImport loads blocks exported one document per line and
+// transactions exported as a json array.
+func TestBoltImport(t *testing.T) {
+	db := testBolt(t)
+	ctx := context.Background()
+	for _, c := range []struct {
+		collection string
+		file       string
+		n          int
+	}{{BLOCKS, "blocks.json", 2}, {TXNS, "transactions.json", 2}} {
+		f, err := os.Open(filepath.Join("testdata", c.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := db.ImportMongoExport(c.collection, f)
+		f.Close()
+		if err != nil || n != c.n {
+			t.Fatalf("imported %d %s, %v", n, c.collection, err)
+		}
+	}
+
+	b, err := db.BlockByHash(ctx, "0xb2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Number != 2 || b.Timestamp != 1500000089 || b.Transactions != 2 || b.TxFees != "42000" {
+		t.Errorf("block 0xb2: %+v", b)
+	}
+	if latest, err := db.LatestBlock(ctx); err != nil || latest.Number != 2 {
+		t.Errorf("latest block %d, %v", latest.Number, err)
+	}
+
+	txns, err := db.TransactionsByBlockNumber(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashes := txnHashes(txns); !reflect.DeepEqual(hashes, []string{"0xa1", "0xa2"}) {
+		t.Fatalf("transactions of block 2: %v", hashes)
+	}
+	failed := txns[1]
+	if failed.Status == nil || *failed.Status != 0 || failed.Gas != 21000 || len(failed.Logs) != 1 || failed.Logs[0].Topics[0] != "0xe1" {
+		t.Errorf("transaction 0xa2: %+v", failed)
+	}
+	if n, err := db.TxnCount(ctx, "0x2222222222222222222222222222222222222222", TxnFilter{Status: "success"}); err != nil || n != 1 {
+		t.Errorf("successful transactions %d, %v", n, err)
+	}
+
+	if _, err := db.ImportMongoExport("accounts", nil); err == nil {
+		t.Error("imported an unknown collection")
+	}
+}
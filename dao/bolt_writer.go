@@ -0,0 +1,334 @@
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	. "github.com/ubiq/spectrum-api/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+// deleteTransaction removes a transaction and its index entries.
+func deleteTransaction(tx *bolt.Tx, hash []byte) error {
+	var txn Transaction
+	if err := get(tx.Bucket(boltTxns), hash, &txn); err != nil {
+		if err == ErrNotFound {
+			return nil
+		}
+		return err
+	}
+
+	position := boltKey(u64(txn.BlockNumber), u64(txn.TransactionIndex), hash)
+	if err := tx.Bucket(boltTxnsByBlock).Delete(position); err != nil {
+		return err
+	}
+	for _, address := range []string{txn.From, txn.To} {
+		if err := tx.Bucket(boltTxnsByAccount).Delete(boltKey(addrPrefix(address), position)); err != nil {
+			return err
+		}
+	}
+	if txn.ContractAddress != "" {
+		if err := tx.Bucket(boltTxnsByContract).Delete([]byte(txn.ContractAddress)); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(boltTxns).Delete(hash)
+}
+
+// deleteBlockKeys removes the transactions, uncles, transfers and traces
+// stored for the block at number, along with their index entries.
+func deleteBlockKeys(tx *bolt.Tx, number uint64) error {
+	prefix := u64(number)
+
+	var keys [][]byte
+	collect := func(k, _ []byte) error {
+		keys = append(keys, append([]byte{}, k...))
+		return nil
+	}
+
+	if err := forward(tx.Bucket(boltTxnsByBlock), prefix, collect); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := deleteTransaction(tx, k[16:]); err != nil {
+			return err
+		}
+	}
+
+	keys = keys[:0]
+	if err := forward(tx.Bucket(boltUnclesByBlock), prefix, collect); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		var u Uncle
+		if err := get(tx.Bucket(boltUncles), k[16:], &u); err != nil {
+			return err
+		}
+		if err := tx.Bucket(boltUnclesByMiner).Delete(uncleMinerKey(u)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(boltUncles).Delete(k[16:]); err != nil {
+			return err
+		}
+		if err := tx.Bucket(boltUnclesByBlock).Delete(k); err != nil {
+			return err
+		}
+	}
+
+	keys = keys[:0]
+	if err := forward(tx.Bucket(boltTransfers), prefix, collect); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		var t TokenTransfer
+		if err := get(tx.Bucket(boltTransfers), k, &t); err != nil {
+			return err
+		}
+		for _, address := range []string{t.From, t.To} {
+			if err := tx.Bucket(boltTransfersByAcc).Delete(boltKey(addrPrefix(address), k)); err != nil {
+				return err
+			}
+		}
+		if err := tx.Bucket(boltTransfersByCon).Delete(boltKey(addrPrefix(t.Contract), k)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(boltTransfers).Delete(k); err != nil {
+			return err
+		}
+	}
+
+	keys = keys[:0]
+	if err := forward(tx.Bucket(boltTraces), prefix, collect); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		var t Trace
+		if err := get(tx.Bucket(boltTraces), k, &t); err != nil {
+			return err
+		}
+		for _, address := range []string{t.From, t.To} {
+			if err := tx.Bucket(boltTracesByAcc).Delete(boltKey(addrPrefix(address), k)); err != nil {
+				return err
+			}
+		}
+		if err := tx.Bucket(boltTraces).Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *BoltDAO) AddBlock(ctx context.Context, block Block, txns []Transaction, uncles []Uncle, transfers []TokenTransfer, traces []Trace) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.db.Update(func(tx *bolt.Tx) error {
+		if err := deleteBlockKeys(tx, block.Number); err != nil {
+			return err
+		}
+		for _, txn := range txns {
+			if err := deleteTransaction(tx, []byte(txn.Hash)); err != nil {
+				return err
+			}
+			if err := putTransaction(tx, txn); err != nil {
+				return err
+			}
+		}
+		for _, uncle := range uncles {
+			if err := putUncle(tx, uncle); err != nil {
+				return err
+			}
+		}
+		for _, transfer := range transfers {
+			if err := putTransfer(tx, transfer); err != nil {
+				return err
+			}
+		}
+		for _, trace := range traces {
+			if err := putTrace(tx, trace); err != nil {
+				return err
+			}
+		}
+		return putBlock(tx, block)
+	})
+}
+
+func (e *BoltDAO) ForkBlock(ctx context.Context, number uint64) (Block, error) {
+	var block Block
+	if err := ctx.Err(); err != nil {
+		return block, err
+	}
+	err := e.db.Update(func(tx *bolt.Tx) error {
+		if err := get(tx.Bucket(boltBlocks), u64(number), &block); err != nil {
+			return err
+		}
+		if err := putForkedBlock(tx, block); err != nil {
+			return err
+		}
+		err := forward(tx.Bucket(boltTxnsByBlock), u64(number), func(k, _ []byte) error {
+			var txn Transaction
+			if err := get(tx.Bucket(boltTxns), k[16:], &txn); err != nil {
+				return err
+			}
+			return putJSON(tx.Bucket(boltForkedTxns), boltKey([]byte(txn.BlockHash), u64(txn.TransactionIndex)), txn)
+		})
+		if err != nil {
+			return err
+		}
+		if err := deleteBlockKeys(tx, number); err != nil {
+			return err
+		}
+		if err := deleteMinerKey(tx, number); err != nil {
+			return err
+		}
+		if err := tx.Bucket(boltBlockHashes).Delete([]byte(block.Hash)); err != nil {
+			return err
+		}
+		return tx.Bucket(boltBlocks).Delete(u64(number))
+	})
+	return block, err
+}
+
+func (e *BoltDAO) SetLatestBlock(ctx context.Context, block Block) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.db.Update(func(tx *bolt.Tx) error {
+		var store Store
+		if v := tx.Bucket(boltStore).Get([]byte("store")); v != nil {
+			if err := json.Unmarshal(v, &store); err != nil {
+				return err
+			}
+		}
+		store.LatestBlock = block
+		store.Timestamp = uint64(time.Now().Unix())
+		return putStore(tx, store)
+	})
+}
+
+func (e *BoltDAO) AddPrices(ctx context.Context, points []PricePoint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.db.Update(func(tx *bolt.Tx) error {
+		for _, p := range points {
+			if err := putPrice(tx, p); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (e *BoltDAO) SetLabel(ctx context.Context, label Label) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.db.Update(func(tx *bolt.Tx) error {
+		return putLabel(tx, label)
+	})
+}
+
+func (e *BoltDAO) DeleteLabel(ctx context.Context, address string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltLabels)
+		if b.Get([]byte(address)) == nil {
+			return ErrNotFound
+		}
+		return b.Delete([]byte(address))
+	})
+}
+
+func (e *BoltDAO) SetContractSource(ctx context.Context, source ContractSource) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.db.Update(func(tx *bolt.Tx) error {
+		var stored ContractSource
+		err := get(tx.Bucket(boltSources), []byte(source.Address), &stored)
+		if err == nil {
+			err = replaceSource(stored, source)
+		}
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		return putContractSource(tx, source)
+	})
+}
+
+func (e *BoltDAO) SetBalanceCheckpoint(ctx context.Context, balance Balance) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.db.Update(func(tx *bolt.Tx) error {
+		return putBalance(tx, balance)
+	})
+}
+
+func (e *BoltDAO) SetWatch(ctx context.Context, watch Watch) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(boltWatches), []byte(watch.ID), watch)
+	})
+}
+
+func (e *BoltDAO) DeleteWatch(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltWatches)
+		if b.Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+		if err := b.Delete([]byte(id)); err != nil {
+			return err
+		}
+		prefix := addrPrefix(id)
+		var keys [][]byte
+		err := forward(tx.Bucket(boltDeliveryLog), prefix, func(k, v []byte) error {
+			keys = append(keys, append([]byte{}, k...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			key := k[len(prefix):]
+			if err := tx.Bucket(boltDeliveries).Delete(key[8:]); err != nil {
+				return err
+			}
+			if err := tx.Bucket(boltPending).Delete(key); err != nil {
+				return err
+			}
+			if err := tx.Bucket(boltDeliveryLog).Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (e *BoltDAO) SetDelivery(ctx context.Context, delivery Delivery) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.db.Update(func(tx *bolt.Tx) error {
+		return putDelivery(tx, delivery)
+	})
+}
+
+func (e *BoltDAO) SetWatchCursor(ctx context.Context, number uint64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltStore).Put([]byte("watchcursor"), u64(number))
+	})
+}
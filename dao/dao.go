@@ -1,176 +1,806 @@
 package dao
 
 import (
+	"context"
 	"log"
+	"math/big"
+	"strings"
+	"time"
 
+	"github.com/ubiq/spectrum-api/apierr"
 	. "github.com/ubiq/spectrum-api/models"
-	mgo "gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
 type SpectrumDAO struct {
 	Server   string
 	Database string
+	// Username, when set, authenticates against AuthSource, or the driver's
+	// default, with Password.
+	Username     string
+	Password     string
+	AuthSource   string
+	QueryTimeout time.Duration
+	MaxPoolSize  uint64
+	MinPoolSize  uint64
+	// HeavyReadPreference is used for account history and count queries,
+	// which are expensive enough to be worth pushing to secondaries.
+	HeavyReadPreference string
+	// Genesis, when set, is added to the coin balances summed.
+	Genesis *Genesis
+
+	client *mongo.Client
+	db     *mongo.Database
+	heavy  *mongo.Database
+}
+
+// ErrNotFound is returned by every backend when a single document lookup
+// matches nothing. It keeps the message the mgo driver used, and is an
+// apierr.NotFound so handlers report it as a 404.
+var ErrNotFound error = apierr.NotFoundf("not found")
+
+// matchRank orders the matches of verified contract sources, closest last.
+var matchRank = map[string]int{"partial": 1, "full": 2}
+
+// replaceSource returns an apierr.Conflict unless source may replace stored,
+// an earlier verification of the same contract: a full match is final, and
+// no match gives way to a weaker one.
+func replaceSource(stored ContractSource, source ContractSource) error {
+	if stored.Match == "full" || matchRank[source.Match] < matchRank[stored.Match] {
+		return apierr.Conflictf("contract %s is already verified with a %s match", stored.Address, stored.Match)
+	}
+	return nil
 }
 
-var db *mgo.Database
+// translate maps driver errors to the ones the api reports: no documents to
+// ErrNotFound and timeouts, including maxTimeMS, to apierr.Unavailable. The
+// driver's message names the server, so timeouts only keep it as the cause.
+func translate(err error) error {
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return ErrNotFound
+	case mongo.IsTimeout(err):
+		return &apierr.Error{Code: apierr.Unavailable, Message: "database timed out", Err: err}
+	}
+	return err
+}
 
 const (
-	BLOCKS    = "blocks"
-	TXNS      = "transactions"
-	UNCLES    = "uncles"
-	TRANSFERS = "tokentransfers"
-	REORGS    = "forkedblocks"
-	STORE     = "sysstores"
+	BLOCKS     = "blocks"
+	TXNS       = "transactions"
+	UNCLES     = "uncles"
+	TRANSFERS  = "tokentransfers"
+	REORGS     = "forkedblocks"
+	FORKEDTXNS = "forkedtransactions"
+	TRACES     = "traces"
+	STORE      = "sysstores"
+	PRICES     = "prices"
+	LABELS     = "labels"
+	SOURCES    = "contractsources"
+	BALANCES   = "balancecheckpoints"
+	WATCHES    = "watches"
+	DELIVERIES = "webhookdeliveries"
 )
 
 func (e *SpectrumDAO) Connect() {
-	session, err := mgo.Dial(e.Server)
+	uri := e.Server
+	if !strings.HasPrefix(uri, "mongodb://") && !strings.HasPrefix(uri, "mongodb+srv://") {
+		uri = "mongodb://" + uri
+	}
+
+	// Timeout is applied to every operation and sent to the server as
+	// maxTimeMS, shortened to whatever is left of the request's context.
+	opts := options.Client().
+		ApplyURI(uri).
+		SetRetryReads(true).
+		SetTimeout(e.QueryTimeout)
+	if e.Username != "" {
+		opts.SetAuth(options.Credential{
+			Username:   e.Username,
+			Password:   e.Password,
+			AuthSource: e.AuthSource,
+		})
+	}
+	if e.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(e.MaxPoolSize)
+	}
+	if e.MinPoolSize > 0 {
+		opts.SetMinPoolSize(e.MinPoolSize)
+	}
+
+	pref := readpref.Primary()
+	if e.HeavyReadPreference != "" {
+		mode, err := readpref.ModeFromString(e.HeavyReadPreference)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if pref, err = readpref.New(mode); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, opts)
 	if err != nil {
 		log.Fatal(err)
 	}
-	db = session.DB(e.Database)
+	if err = client.Ping(ctx, readpref.Primary()); err != nil {
+		log.Fatal(err)
+	}
+	e.client = client
+	e.db = client.Database(e.Database)
+	e.heavy = client.Database(e.Database, options.Database().SetReadPreference(pref))
+}
+
+func (e *SpectrumDAO) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	e.client.Disconnect(ctx)
 }
 
-func (e *SpectrumDAO) BlockByNumber(number uint64) (Block, error) {
+func findOne(ctx context.Context, c *mongo.Collection, filter interface{}, result interface{}, opts ...*options.FindOneOptions) error {
+	return translate(c.FindOne(ctx, filter, opts...).Decode(result))
+}
+
+func findAll(ctx context.Context, c *mongo.Collection, filter interface{}, results interface{}, opts ...*options.FindOptions) error {
+	cur, err := c.Find(ctx, filter, opts...)
+	if err != nil {
+		return translate(err)
+	}
+	return translate(cur.All(ctx, results))
+}
+
+// each calls fn with the cursor at every document filter matches, for
+// results too large to decode at once.
+func each(ctx context.Context, c *mongo.Collection, filter interface{}, fn func(cur *mongo.Cursor) error, opts ...*options.FindOptions) error {
+	cur, err := c.Find(ctx, filter, opts...)
+	if err != nil {
+		return translate(err)
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		if err := fn(cur); err != nil {
+			return err
+		}
+	}
+	return translate(cur.Err())
+}
+
+func count(ctx context.Context, c *mongo.Collection, filter interface{}) (int, error) {
+	n, err := c.CountDocuments(ctx, filter)
+	return int(n), translate(err)
+}
+
+func latest(field string, limit int) *options.FindOptions {
+	return options.Find().SetSort(bson.D{{Key: field, Value: -1}}).SetLimit(int64(limit))
+}
+
+func (e *SpectrumDAO) BlockByNumber(ctx context.Context, number uint64) (Block, error) {
 	var block Block
-	err := db.C(BLOCKS).Find(bson.M{"number": number}).One(&block)
+	err := findOne(ctx, e.db.Collection(BLOCKS), bson.M{"number": number}, &block)
 	return block, err
 }
 
-func (e *SpectrumDAO) BlockByHash(hash string) (Block, error) {
+func (e *SpectrumDAO) BlockByHash(ctx context.Context, hash string) (Block, error) {
 	var block Block
-	err := db.C(BLOCKS).Find(bson.M{"hash": hash}).One(&block)
+	err := findOne(ctx, e.db.Collection(BLOCKS), bson.M{"hash": hash}, &block)
 	return block, err
 }
 
-func (e *SpectrumDAO) LatestBlock() (Block, error) {
+func (e *SpectrumDAO) LatestBlock(ctx context.Context) (Block, error) {
 	var block Block
-	err := db.C(BLOCKS).Find(bson.M{}).Sort("-number").Limit(1).One(&block)
+	err := findOne(ctx, e.db.Collection(BLOCKS), bson.M{}, &block, options.FindOne().SetSort(bson.D{{Key: "number", Value: -1}}))
 	return block, err
 }
 
-func (e *SpectrumDAO) Store() (Store, error) {
+func (e *SpectrumDAO) Store(ctx context.Context) (Store, error) {
 	var store Store
-	err := db.C(STORE).Find(bson.M{}).Limit(1).One(&store)
+	err := findOne(ctx, e.db.Collection(STORE), bson.M{}, &store)
 	return store, err
 }
 
-func (e *SpectrumDAO) LatestBlocks(limit int) ([]Block, error) {
+func (e *SpectrumDAO) LatestBlocks(ctx context.Context, limit int) ([]Block, error) {
 	var blocks []Block
-	err := db.C(BLOCKS).Find(bson.M{}).Sort("-number").Limit(limit).All(&blocks)
+	err := findAll(ctx, e.db.Collection(BLOCKS), bson.M{}, &blocks, latest("number", limit))
 	return blocks, err
 }
 
-func (e *SpectrumDAO) LatestUncles(limit int) ([]Uncle, error) {
+func (e *SpectrumDAO) LatestUncles(ctx context.Context, limit int) ([]Uncle, error) {
 	var uncles []Uncle
-	err := db.C(UNCLES).Find(bson.M{}).Sort("-blockNumber").Limit(limit).All(&uncles)
+	err := findAll(ctx, e.db.Collection(UNCLES), bson.M{}, &uncles, latest("blockNumber", limit))
 	return uncles, err
 }
 
-func (e *SpectrumDAO) LatestForkedBlocks(limit int) ([]Block, error) {
+func (e *SpectrumDAO) LatestForkedBlocks(ctx context.Context, limit int) ([]Block, error) {
 	var blocks []Block
-	err := db.C(REORGS).Find(bson.M{}).Sort("-number").Limit(limit).All(&blocks)
+	err := findAll(ctx, e.db.Collection(REORGS), bson.M{}, &blocks, latest("number", limit))
 	return blocks, err
 }
 
-func (e *SpectrumDAO) TransactionByHash(hash string) (Transaction, error) {
+func (e *SpectrumDAO) ForkedBlockByHash(ctx context.Context, hash string) (Block, error) {
+	var block Block
+	err := findOne(ctx, e.db.Collection(REORGS), bson.M{"hash": hash}, &block)
+	return block, err
+}
+
+func (e *SpectrumDAO) ForkedTransactions(ctx context.Context, blockHash string) ([]Transaction, error) {
+	var txns []Transaction
+	err := findAll(ctx, e.db.Collection(FORKEDTXNS), bson.M{"blockHash": blockHash}, &txns, options.Find().SetSort(bson.D{{Key: "transactionIndex", Value: 1}}))
+	return txns, err
+}
+
+func (e *SpectrumDAO) TransactionByHash(ctx context.Context, hash string) (Transaction, error) {
 	var txn Transaction
-	err := db.C(TXNS).Find(bson.M{"hash": hash}).One(&txn)
+	err := findOne(ctx, e.db.Collection(TXNS), bson.M{"hash": hash}, &txn)
 	return txn, err
 }
 
-func (e *SpectrumDAO) TransactionByContractAddress(hash string) (Transaction, error) {
+func (e *SpectrumDAO) TransactionByContractAddress(ctx context.Context, hash string) (Transaction, error) {
 	var txn Transaction
-	err := db.C(TXNS).Find(bson.M{"contractAddress": hash}).One(&txn)
+	err := findOne(ctx, e.db.Collection(TXNS), bson.M{"contractAddress": hash}, &txn)
 	return txn, err
 }
 
-func (e *SpectrumDAO) TransactionsByBlockNumber(number uint64) ([]Transaction, error) {
+func (e *SpectrumDAO) TransactionsByBlockNumber(ctx context.Context, number uint64) ([]Transaction, error) {
 	var txns []Transaction
-	err := db.C(TXNS).Find(bson.M{"blockNumber": number}).All(&txns)
+	err := findAll(ctx, e.db.Collection(TXNS), bson.M{"blockNumber": number}, &txns)
 	return txns, err
 }
 
-func (e *SpectrumDAO) UncleByHash(hash string) (Uncle, error) {
+func (e *SpectrumDAO) UncleByHash(ctx context.Context, hash string) (Uncle, error) {
 	var uncle Uncle
-	err := db.C(UNCLES).Find(bson.M{"hash": hash}).One(&uncle)
+	err := findOne(ctx, e.db.Collection(UNCLES), bson.M{"hash": hash}, &uncle)
 	return uncle, err
 }
 
-func (e *SpectrumDAO) LatestTransactions(limit int) ([]Transaction, error) {
+func (e *SpectrumDAO) UnclesByBlockNumber(ctx context.Context, number uint64) ([]Uncle, error) {
+	var uncles []Uncle
+	err := findAll(ctx, e.db.Collection(UNCLES), bson.M{"blockNumber": number}, &uncles, options.Find().SetSort(bson.D{{Key: "position", Value: 1}}))
+	return uncles, err
+}
+
+func (e *SpectrumDAO) TokenTransfersByBlockNumber(ctx context.Context, number uint64) ([]TokenTransfer, error) {
+	var transfers []TokenTransfer
+	err := findAll(ctx, e.db.Collection(TRANSFERS), bson.M{"blockNumber": number}, &transfers, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	return transfers, err
+}
+
+func (e *SpectrumDAO) LatestTransactions(ctx context.Context, limit int) ([]Transaction, error) {
 	var txns []Transaction
-	err := db.C(TXNS).Find(bson.M{}).Sort("-blockNumber").Limit(limit).All(&txns)
+	err := findAll(ctx, e.db.Collection(TXNS), bson.M{}, &txns, latest("blockNumber", limit))
 	return txns, err
 }
 
-func (e *SpectrumDAO) LatestTransactionsByAccount(hash string) ([]Transaction, error) {
+// accountTxns selects the transactions sent or received by hash that match
+// filter.
+func accountTxns(hash string, filter TxnFilter) bson.M {
+	query := bson.M{"$or": []bson.M{{"from": hash}, {"to": hash}}}
+	if status, ok := filter.status(); ok {
+		query["status"] = status
+	}
+	return query
+}
+
+func (e *SpectrumDAO) LatestTransactionsByAccount(ctx context.Context, hash string, filter TxnFilter) ([]Transaction, error) {
 	var txns []Transaction
-	err := db.C(TXNS).Find(bson.M{"$or": []bson.M{bson.M{"from": hash}, bson.M{"to": hash}}}).Sort("-blockNumber").Limit(100).All(&txns)
+	err := findAll(ctx, e.heavy.Collection(TXNS), accountTxns(hash, filter), &txns, latest("blockNumber", 100))
 	return txns, err
 }
 
-func (e *SpectrumDAO) LatestTokenTransfersByAccount(hash string) ([]TokenTransfer, error) {
+func (e *SpectrumDAO) TracesByTransaction(ctx context.Context, hash string) ([]Trace, error) {
+	var traces []Trace
+	err := findAll(ctx, e.db.Collection(TRACES), bson.M{"hash": hash}, &traces, options.Find().SetSort(bson.D{{Key: "index", Value: 1}}))
+	return traces, err
+}
+
+func (e *SpectrumDAO) LatestTracesByAccount(ctx context.Context, hash string) ([]Trace, error) {
+	var traces []Trace
+	err := findAll(ctx, e.heavy.Collection(TRACES), bson.M{"$or": []bson.M{{"from": hash}, {"to": hash}}}, &traces, latest("blockNumber", 100))
+	return traces, err
+}
+
+func (e *SpectrumDAO) LatestTokenTransfersByAccount(ctx context.Context, hash string) ([]TokenTransfer, error) {
 	var transfers []TokenTransfer
-	err := db.C(TRANSFERS).Find(bson.M{"$or": []bson.M{bson.M{"from": hash}, bson.M{"to": hash}}}).Sort("-blockNumber").Limit(100).All(&transfers)
+	err := findAll(ctx, e.heavy.Collection(TRANSFERS), bson.M{"$or": []bson.M{{"from": hash}, {"to": hash}}}, &transfers, latest("blockNumber", 100))
 	return transfers, err
 }
 
-func (e *SpectrumDAO) TokenTransfersByAccount(token string, account string) ([]TokenTransfer, error) {
+func (e *SpectrumDAO) TokenTransfersByAccount(ctx context.Context, token string, account string) ([]TokenTransfer, error) {
 	var transfers []TokenTransfer
-	err := db.C(TRANSFERS).Find(bson.M{"$or": []bson.M{bson.M{"$and": []bson.M{bson.M{"from": account}, bson.M{"contract": token}}}, bson.M{"$and": []bson.M{bson.M{"to": account}, bson.M{"contract": token}}}}}).Sort("-blockNumber").All(&transfers)
+	err := findAll(ctx, e.heavy.Collection(TRANSFERS), bson.M{"$or": []bson.M{{"$and": []bson.M{{"from": account}, {"contract": token}}}, {"$and": []bson.M{{"to": account}, {"contract": token}}}}}, &transfers, latest("blockNumber", 0))
 	return transfers, err
 }
 
-func (e *SpectrumDAO) LatestTransfersByToken(hash string) ([]TokenTransfer, error) {
+func (e *SpectrumDAO) LatestTransfersByToken(ctx context.Context, hash string) ([]TokenTransfer, error) {
 	var transfers []TokenTransfer
-	err := db.C(TRANSFERS).Find(bson.M{"contract": hash}).Sort("-blockNumber").Limit(1000).All(&transfers)
+	err := findAll(ctx, e.heavy.Collection(TRANSFERS), bson.M{"contract": hash}, &transfers, latest("blockNumber", 1000))
 	return transfers, err
 }
 
-func (e *SpectrumDAO) LatestTokenTransfers(limit int) ([]TokenTransfer, error) {
+func (e *SpectrumDAO) LatestTokenTransfers(ctx context.Context, limit int) ([]TokenTransfer, error) {
 	var transfers []TokenTransfer
-	err := db.C(TRANSFERS).Find(bson.M{}).Sort("-blockNumber").Limit(limit).All(&transfers)
+	err := findAll(ctx, e.db.Collection(TRANSFERS), bson.M{}, &transfers, latest("blockNumber", limit))
 	return transfers, err
 }
 
-func (e *SpectrumDAO) TxnCount(hash string) (int, error) {
-	count, err := db.C(TXNS).Find(bson.M{"$or": []bson.M{bson.M{"from": hash}, bson.M{"to": hash}}}).Count()
-	return count, err
+func (e *SpectrumDAO) TxnCount(ctx context.Context, hash string, filter TxnFilter) (int, error) {
+	return count(ctx, e.heavy.Collection(TXNS), accountTxns(hash, filter))
+}
+
+func (e *SpectrumDAO) TraceCount(ctx context.Context, hash string) (int, error) {
+	return count(ctx, e.heavy.Collection(TRACES), bson.M{"$or": []bson.M{{"from": hash}, {"to": hash}}})
+}
+
+func (e *SpectrumDAO) TotalTxnCount(ctx context.Context) (int, error) {
+	return count(ctx, e.db.Collection(TXNS), bson.M{})
+}
+
+func (e *SpectrumDAO) TokenTransferCount(ctx context.Context, hash string) (int, error) {
+	return count(ctx, e.heavy.Collection(TRANSFERS), bson.M{"$or": []bson.M{{"from": hash}, {"to": hash}}})
+}
+
+func (e *SpectrumDAO) TokenTransferCountByContract(ctx context.Context, hash string) (int, error) {
+	return count(ctx, e.heavy.Collection(TRANSFERS), bson.M{"contract": hash})
+}
+
+func (e *SpectrumDAO) TotalTokenTransferCount(ctx context.Context) (int, error) {
+	return count(ctx, e.db.Collection(TRANSFERS), bson.M{})
+}
+
+func (e *SpectrumDAO) TokenTransferByAccountCount(ctx context.Context, token string, account string) (int, error) {
+	return count(ctx, e.heavy.Collection(TRANSFERS),
+		bson.M{"$or": []bson.M{{"$and": []bson.M{{"from": account}, {"contract": token}}}, {"$and": []bson.M{{"to": account}, {"contract": token}}}}})
+}
+
+func (e *SpectrumDAO) TotalBlockCount(ctx context.Context) (int, error) {
+	return count(ctx, e.db.Collection(BLOCKS), bson.M{})
 }
 
-func (e *SpectrumDAO) TotalTxnCount() (int, error) {
-	count, err := db.C(TXNS).Find(bson.M{}).Count()
-	return count, err
+func (e *SpectrumDAO) TotalUncleCount(ctx context.Context) (int, error) {
+	return count(ctx, e.db.Collection(UNCLES), bson.M{})
+}
+
+// decimalWei formats a sum of wei amounts computed as Decimal128.
+func decimalWei(d primitive.Decimal128) string {
+	n, exp, err := d.BigInt()
+	if err != nil {
+		return "0"
+	}
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(exp))), nil)
+	if exp > 0 {
+		n.Mul(n, scale)
+	} else {
+		n.Quo(n, scale)
+	}
+	return n.String()
 }
 
-func (e *SpectrumDAO) TokenTransferCount(hash string) (int, error) {
-	count, err := db.C(TRANSFERS).Find(bson.M{"$or": []bson.M{bson.M{"from": hash}, bson.M{"to": hash}}}).Count()
-	return count, err
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
 }
 
-func (e *SpectrumDAO) TokenTransferCountByContract(hash string) (int, error) {
-	count, err := db.C(TRANSFERS).Find(bson.M{"contract": hash}).Count()
-	return count, err
+func (e *SpectrumDAO) Emission(ctx context.Context, from uint64, interval uint64) ([]Emission, error) {
+	zero, _ := primitive.ParseDecimal128("0")
+	wei := func(field string) bson.M {
+		return bson.M{"$sum": bson.M{"$convert": bson.M{"input": "$" + field, "to": "decimal", "onError": zero, "onNull": zero}}}
+	}
+	group := func(sums bson.M) mongo.Pipeline {
+		sums["_id"] = bson.M{"$subtract": bson.A{"$timestamp", bson.M{"$mod": bson.A{"$timestamp", int64(interval)}}}}
+		return mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"timestamp": bson.M{"$gte": from}}}},
+			{{Key: "$group", Value: sums}},
+		}
+	}
+	periods := newEmissionPeriods(interval)
+
+	var blocks []struct {
+		Start        uint64               `bson:"_id"`
+		Blocks       uint64               `bson:"blocks"`
+		BlockRewards primitive.Decimal128 `bson:"blockRewards"`
+		UnclesReward primitive.Decimal128 `bson:"unclesReward"`
+	}
+	cur, err := e.heavy.Collection(BLOCKS).Aggregate(ctx, group(bson.M{"blocks": bson.M{"$sum": 1}, "blockRewards": wei("blockReward"), "unclesReward": wei("unclesReward")}))
+	if err != nil {
+		return nil, translate(err)
+	}
+	if err := cur.All(ctx, &blocks); err != nil {
+		return nil, translate(err)
+	}
+	for _, b := range blocks {
+		periods.addBlock(b.Start, b.Blocks, decimalWei(b.BlockRewards), decimalWei(b.UnclesReward))
+	}
+
+	var uncles []struct {
+		Start  uint64               `bson:"_id"`
+		Reward primitive.Decimal128 `bson:"reward"`
+	}
+	cur, err = e.heavy.Collection(UNCLES).Aggregate(ctx, group(bson.M{"reward": wei("reward")}))
+	if err != nil {
+		return nil, translate(err)
+	}
+	if err := cur.All(ctx, &uncles); err != nil {
+		return nil, translate(err)
+	}
+	for _, u := range uncles {
+		periods.addUncle(u.Start, decimalWei(u.Reward))
+	}
+	return periods.list(), nil
 }
 
-func (e *SpectrumDAO) TotalTokenTransferCount() (int, error) {
-	count, err := db.C(TRANSFERS).Find(bson.M{}).Count()
-	return count, err
+func priceFilter(token string, fiat string) bson.M {
+	return bson.M{"token": token, "fiat": fiat}
 }
 
-func (e *SpectrumDAO) TokenTransferByAccountCount(token string, account string) (int, error) {
-	count, err := db.C(TRANSFERS).Find(
-		bson.M{"$or": []bson.M{bson.M{"$and": []bson.M{bson.M{"from": account}, bson.M{"contract": token}}}, bson.M{"$and": []bson.M{bson.M{"to": account}, bson.M{"contract": token}}}}}).Count()
-	return count, err
+func (e *SpectrumDAO) PriceAt(ctx context.Context, token string, fiat string, timestamp uint64) (PricePoint, error) {
+	var point PricePoint
+	filter := priceFilter(token, fiat)
+	filter["timestamp"] = bson.M{"$lte": timestamp}
+	err := findOne(ctx, e.db.Collection(PRICES), filter, &point, options.FindOne().SetSort(bson.D{{Key: "timestamp", Value: -1}}))
+	return point, err
 }
 
-func (e *SpectrumDAO) TotalBlockCount() (int, error) {
-	count, err := db.C(BLOCKS).Find(bson.M{}).Count()
-	return count, err
+func (e *SpectrumDAO) PriceHistory(ctx context.Context, token string, fiat string, from uint64, to uint64, limit int) ([]PricePoint, error) {
+	var points []PricePoint
+	filter := priceFilter(token, fiat)
+	filter["timestamp"] = bson.M{"$gte": from, "$lte": to}
+	err := findAll(ctx, e.db.Collection(PRICES), filter, &points, options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}}).SetLimit(int64(limit)))
+	return points, err
 }
 
-func (e *SpectrumDAO) TotalUncleCount() (int, error) {
-	count, err := db.C(UNCLES).Find(bson.M{}).Count()
-	return count, err
+func (e *SpectrumDAO) Label(ctx context.Context, address string) (Label, error) {
+	var label Label
+	err := findOne(ctx, e.db.Collection(LABELS), bson.M{"address": address}, &label)
+	return label, err
+}
+
+func (e *SpectrumDAO) Labels(ctx context.Context, tag string) ([]Label, error) {
+	var labels []Label
+	filter := bson.M{}
+	if tag != "" {
+		filter["tags"] = tag
+	}
+	err := findAll(ctx, e.db.Collection(LABELS), filter, &labels, options.Find().SetSort(bson.D{{Key: "address", Value: 1}}))
+	return labels, err
+}
+
+func (e *SpectrumDAO) ContractSource(ctx context.Context, address string) (ContractSource, error) {
+	var source ContractSource
+	err := findOne(ctx, e.db.Collection(SOURCES), bson.M{"address": address}, &source)
+	return source, err
+}
+
+// contractCreations matches the transactions that created a contract.
+func contractCreations() bson.M {
+	return bson.M{"contractAddress": bson.M{"$nin": bson.A{"", nil}}}
+}
+
+func (e *SpectrumDAO) Contracts(ctx context.Context, filter ContractFilter, limit int) ([]Contract, error) {
+	match := contractCreations()
+	if filter.Creator != "" {
+		match["from"] = filter.Creator
+	}
+	number := bson.M{"$gte": filter.FromBlock}
+	if filter.ToBlock > 0 {
+		number["$lte"] = filter.ToBlock
+	}
+	match["blockNumber"] = number
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$sort", Value: bson.D{{Key: "blockNumber", Value: -1}, {Key: "transactionIndex", Value: -1}}}},
+	}
+	if limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: limit}})
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$project", Value: bson.M{
+		"address":         "$contractAddress",
+		"creator":         "$from",
+		"blockNumber":     1,
+		"timestamp":       1,
+		"transactionHash": "$hash",
+		"bytecodeSize":    bson.M{"$strLenCP": bson.M{"$ifNull": bson.A{"$input", ""}}},
+	}}})
+	cur, err := e.heavy.Collection(TXNS).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, translate(err)
+	}
+	var contracts []Contract
+	if err := cur.All(ctx, &contracts); err != nil {
+		return nil, translate(err)
+	}
+	if len(contracts) == 0 {
+		return contracts, nil
+	}
+
+	addresses := make(bson.A, len(contracts))
+	for i := range contracts {
+		contracts[i].BytecodeSize = inputSize(contracts[i].BytecodeSize)
+		addresses[i] = contracts[i].Address
+	}
+	tokens, err := e.heavy.Collection(TRANSFERS).Distinct(ctx, "contract", bson.M{"contract": bson.M{"$in": addresses}})
+	if err != nil {
+		return nil, translate(err)
+	}
+	isToken := map[interface{}]bool{}
+	for _, t := range tokens {
+		isToken[t] = true
+	}
+	var sources []ContractSource
+	err = findAll(ctx, e.db.Collection(SOURCES), bson.M{"address": bson.M{"$in": addresses}}, &sources,
+		options.Find().SetProjection(bson.M{"address": 1, "match": 1}))
+	if err != nil {
+		return nil, err
+	}
+	verified := map[string]string{}
+	for _, s := range sources {
+		verified[s.Address] = s.Match
+	}
+	for i := range contracts {
+		contracts[i].IsToken = isToken[contracts[i].Address]
+		contracts[i].Verified = verified[contracts[i].Address]
+	}
+	return contracts, nil
+}
+
+func (e *SpectrumDAO) ContractCounts(ctx context.Context, from uint64, interval uint64) ([]ContractCount, error) {
+	match := contractCreations()
+	match["timestamp"] = bson.M{"$gte": from}
+	cur, err := e.heavy.Collection(TXNS).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id":       bson.M{"$subtract": bson.A{"$timestamp", bson.M{"$mod": bson.A{"$timestamp", int64(interval)}}}},
+			"contracts": bson.M{"$sum": 1},
+		}}},
+	})
+	if err != nil {
+		return nil, translate(err)
+	}
+	var periods []struct {
+		Start     uint64 `bson:"_id"`
+		Contracts uint64 `bson:"contracts"`
+	}
+	if err := cur.All(ctx, &periods); err != nil {
+		return nil, translate(err)
+	}
+	counts := map[uint64]uint64{}
+	for _, p := range periods {
+		counts[p.Start] += p.Contracts
+	}
+	return countPeriods(counts), nil
+}
+
+func (e *SpectrumDAO) BalanceChanges(ctx context.Context, address string, from uint64, to uint64) ([]BalanceChange, error) {
+	ledger := newBalanceLedger(address)
+	if from == 0 {
+		ledger.addGenesis(e.Genesis)
+	}
+	inRange := func(field string, filter bson.M) bson.M {
+		filter[field] = bson.M{"$gte": from, "$lte": to}
+		return filter
+	}
+	account := func(fields ...string) bson.M {
+		or := make([]bson.M, len(fields))
+		for i, f := range fields {
+			or[i] = bson.M{f: address}
+		}
+		return inRange("blockNumber", bson.M{"$or": or})
+	}
+
+	var txns []Transaction
+	err := findAll(ctx, e.heavy.Collection(TXNS), account("from", "to", "contractAddress"), &txns,
+		options.Find().SetProjection(bson.M{"input": 0, "logs": 0, "logsBloom": 0}))
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range txns {
+		ledger.addTxn(t)
+	}
+	var traces []Trace
+	if err := findAll(ctx, e.heavy.Collection(TRACES), account("from", "to"), &traces); err != nil {
+		return nil, err
+	}
+	for _, t := range traces {
+		ledger.addTrace(t)
+	}
+	var blocks []Block
+	if err := findAll(ctx, e.heavy.Collection(BLOCKS), inRange("number", bson.M{"miner": address}), &blocks); err != nil {
+		return nil, err
+	}
+	for _, b := range blocks {
+		ledger.addBlock(b)
+	}
+	var uncles []Uncle
+	if err := findAll(ctx, e.heavy.Collection(UNCLES), inRange("blockNumber", bson.M{"miner": address}), &uncles); err != nil {
+		return nil, err
+	}
+	for _, u := range uncles {
+		ledger.addUncle(u)
+	}
+	var transfers []TokenTransfer
+	if err := findAll(ctx, e.heavy.Collection(TRANSFERS), account("from", "to"), &transfers); err != nil {
+		return nil, err
+	}
+	for _, t := range transfers {
+		ledger.addTransfer(t)
+	}
+	return ledger.list(), nil
+}
+
+func (e *SpectrumDAO) BalanceCheckpoint(ctx context.Context, address string, number uint64) (Balance, error) {
+	var balance Balance
+	err := findOne(ctx, e.db.Collection(BALANCES), bson.M{"address": address, "blockNumber": bson.M{"$lte": number}}, &balance,
+		options.FindOne().SetSort(bson.D{{Key: "blockNumber", Value: -1}}))
+	return balance, err
+}
+
+func (e *SpectrumDAO) BalanceAccounts(ctx context.Context, from uint64, to uint64) ([]string, error) {
+	inRange := bson.M{"$gte": from, "$lte": to}
+	accounts := accountSet{}
+	if from == 0 {
+		accounts.add(e.Genesis.movements()...)
+	}
+	for _, q := range []struct {
+		collection string
+		number     string
+		fields     []string
+	}{
+		{TXNS, "blockNumber", []string{"from", "to", "contractAddress"}},
+		{TRACES, "blockNumber", []string{"from", "to"}},
+		{BLOCKS, "number", []string{"miner"}},
+		{UNCLES, "blockNumber", []string{"miner"}},
+		{TRANSFERS, "blockNumber", []string{"from", "to"}},
+	} {
+		for _, field := range q.fields {
+			values, err := e.heavy.Collection(q.collection).Distinct(ctx, field, bson.M{q.number: inRange})
+			if err != nil {
+				return nil, translate(err)
+			}
+			for _, v := range values {
+				if address, ok := v.(string); ok {
+					accounts[address] = true
+				}
+			}
+		}
+	}
+	return accounts.list(), nil
+}
+
+func (e *SpectrumDAO) Holders(ctx context.Context, contract string, number uint64) ([]Holder, error) {
+	ledger := holderLedger{}
+	upTo := bson.M{"$lte": number}
+	if contract != "" {
+		err := each(ctx, e.heavy.Collection(TRANSFERS), bson.M{"contract": contract, "blockNumber": upTo}, func(cur *mongo.Cursor) error {
+			var t TokenTransfer
+			if err := cur.Decode(&t); err != nil {
+				return err
+			}
+			ledger.add(transferMovements(t)...)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return ledger.list(), nil
+	}
+
+	ledger.add(e.Genesis.movements()...)
+	fields := func(names ...string) *options.FindOptions {
+		projection := bson.M{}
+		for _, n := range names {
+			projection[n] = 1
+		}
+		return options.Find().SetProjection(projection)
+	}
+	err := each(ctx, e.heavy.Collection(TXNS), bson.M{"blockNumber": upTo}, func(cur *mongo.Cursor) error {
+		var t Transaction
+		if err := cur.Decode(&t); err != nil {
+			return err
+		}
+		ledger.add(txnMovements(t)...)
+		return nil
+	}, fields("from", "to", "contractAddress", "value", "gasUsed", "gasPrice", "effectiveGasPrice", "status"))
+	if err != nil {
+		return nil, err
+	}
+	err = each(ctx, e.heavy.Collection(TRACES), bson.M{"blockNumber": upTo, "error": ""}, func(cur *mongo.Cursor) error {
+		var t Trace
+		if err := cur.Decode(&t); err != nil {
+			return err
+		}
+		ledger.add(traceMovements(t)...)
+		return nil
+	}, fields("from", "to", "value"))
+	if err != nil {
+		return nil, err
+	}
+	err = each(ctx, e.heavy.Collection(BLOCKS), bson.M{"number": upTo}, func(cur *mongo.Cursor) error {
+		var b Block
+		if err := cur.Decode(&b); err != nil {
+			return err
+		}
+		ledger.add(blockMovement(b))
+		return nil
+	}, fields("miner", "blockReward", "unclesReward", "txFees"))
+	if err != nil {
+		return nil, err
+	}
+	err = each(ctx, e.heavy.Collection(UNCLES), bson.M{"blockNumber": upTo}, func(cur *mongo.Cursor) error {
+		var u Uncle
+		if err := cur.Decode(&u); err != nil {
+			return err
+		}
+		ledger.add(uncleMovement(u))
+		return nil
+	}, fields("miner", "reward"))
+	if err != nil {
+		return nil, err
+	}
+	return ledger.list(), nil
+}
+
+func (e *SpectrumDAO) Watch(ctx context.Context, id string) (Watch, error) {
+	var watch Watch
+	err := findOne(ctx, e.db.Collection(WATCHES), bson.M{"id": id}, &watch)
+	return watch, err
+}
+
+func (e *SpectrumDAO) Watches(ctx context.Context) ([]Watch, error) {
+	var watches []Watch
+	err := findAll(ctx, e.db.Collection(WATCHES), bson.M{}, &watches, options.Find().SetSort(bson.D{{Key: "id", Value: 1}}))
+	return watches, err
+}
+
+func (e *SpectrumDAO) Delivery(ctx context.Context, id string) (Delivery, error) {
+	var delivery Delivery
+	err := findOne(ctx, e.db.Collection(DELIVERIES), bson.M{"id": id}, &delivery)
+	return delivery, err
+}
+
+func (e *SpectrumDAO) Deliveries(ctx context.Context, filter DeliveryFilter, limit int) ([]Delivery, error) {
+	var deliveries []Delivery
+	query := bson.M{}
+	if filter.WatchID != "" {
+		query["watchId"] = filter.WatchID
+	}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+	err := findAll(ctx, e.db.Collection(DELIVERIES), query, &deliveries,
+		options.Find().SetSort(bson.D{{Key: "blockNumber", Value: -1}, {Key: "id", Value: -1}}).SetLimit(int64(limit)))
+	return deliveries, err
+}
+
+func (e *SpectrumDAO) DueDeliveries(ctx context.Context, watchID string, toBlock uint64, now uint64, limit int) ([]Delivery, error) {
+	var deliveries []Delivery
+	query := bson.M{
+		"watchId":     watchID,
+		"status":      "pending",
+		"blockNumber": bson.M{"$lte": toBlock},
+		"nextAttempt": bson.M{"$lte": now},
+	}
+	err := findAll(ctx, e.db.Collection(DELIVERIES), query, &deliveries,
+		options.Find().SetSort(bson.D{{Key: "blockNumber", Value: 1}, {Key: "id", Value: 1}}).SetLimit(int64(limit)))
+	return deliveries, err
+}
+
+func (e *SpectrumDAO) WatchCursor(ctx context.Context) (uint64, error) {
+	var store struct {
+		WatchCursor *uint64 `bson:"watchCursor"`
+	}
+	if err := findOne(ctx, e.db.Collection(STORE), bson.M{}, &store); err != nil {
+		return 0, err
+	}
+	if store.WatchCursor == nil {
+		return 0, ErrNotFound
+	}
+	return *store.WatchCursor, nil
 }
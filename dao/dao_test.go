@@ -0,0 +1,34 @@
+package dao
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ubiq/spectrum-api/apierr"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestTranslate(t *testing.T) {
+	timeout := mongo.CommandError{Code: 50, Name: "MaxTimeMSExpired", Message: "operation exceeded time limit on 10.0.0.5:27017"}
+	other := errors.New("connection to 10.0.0.5:27017 closed")
+
+	if err := translate(mongo.ErrNoDocuments); err != ErrNotFound {
+		t.Errorf("no documents: %v", err)
+	}
+	if err := translate(other); err != other {
+		t.Errorf("other errors: %v", err)
+	}
+
+	e := apierr.From(translate(timeout))
+	if e.Code != apierr.Unavailable || e.Message != "database timed out" {
+		t.Errorf("timeout: %s %q", e.Code, e.Message)
+	}
+	if strings.Contains(e.Error(), "10.0.0.5") {
+		t.Errorf("the driver's message reached the client: %q", e.Error())
+	}
+	var cause mongo.CommandError
+	if !errors.As(e, &cause) || cause.Code != 50 {
+		t.Errorf("timeout lost its cause: %v", e.Err)
+	}
+}
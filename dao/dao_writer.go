@@ -0,0 +1,184 @@
+package dao
+
+import (
+	"context"
+	"time"
+
+	. "github.com/ubiq/spectrum-api/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (e *SpectrumDAO) AddBlock(ctx context.Context, block Block, txns []Transaction, uncles []Uncle, transfers []TokenTransfer, traces []Trace) error {
+	upsert := options.Replace().SetUpsert(true)
+
+	// A rewrite of the block replaces its transactions and uncles, so that
+	// those the new version dropped don't linger.
+	if _, err := e.db.Collection(TXNS).DeleteMany(ctx, bson.M{"blockNumber": block.Number}); err != nil {
+		return err
+	}
+	if _, err := e.db.Collection(UNCLES).DeleteMany(ctx, bson.M{"blockNumber": block.Number}); err != nil {
+		return err
+	}
+	if len(txns) > 0 {
+		models := make([]mongo.WriteModel, len(txns))
+		for i, txn := range txns {
+			models[i] = mongo.NewReplaceOneModel().SetFilter(bson.M{"hash": txn.Hash}).SetReplacement(txn).SetUpsert(true)
+		}
+		if _, err := e.db.Collection(TXNS).BulkWrite(ctx, models); err != nil {
+			return err
+		}
+	}
+
+	for _, uncle := range uncles {
+		if _, err := e.db.Collection(UNCLES).ReplaceOne(ctx, bson.M{"hash": uncle.Hash}, uncle, upsert); err != nil {
+			return err
+		}
+	}
+
+	// Transfers have no natural key, so a rewrite replaces the block's set.
+	if _, err := e.db.Collection(TRANSFERS).DeleteMany(ctx, bson.M{"blockNumber": block.Number}); err != nil {
+		return err
+	}
+	if len(transfers) > 0 {
+		docs := make([]interface{}, len(transfers))
+		for i, transfer := range transfers {
+			docs[i] = transfer
+		}
+		if _, err := e.db.Collection(TRANSFERS).InsertMany(ctx, docs); err != nil {
+			return err
+		}
+	}
+
+	if _, err := e.db.Collection(TRACES).DeleteMany(ctx, bson.M{"blockNumber": block.Number}); err != nil {
+		return err
+	}
+	if len(traces) > 0 {
+		docs := make([]interface{}, len(traces))
+		for i, trace := range traces {
+			docs[i] = trace
+		}
+		if _, err := e.db.Collection(TRACES).InsertMany(ctx, docs); err != nil {
+			return err
+		}
+	}
+
+	_, err := e.db.Collection(BLOCKS).ReplaceOne(ctx, bson.M{"number": block.Number}, block, upsert)
+	return err
+}
+
+func (e *SpectrumDAO) ForkBlock(ctx context.Context, number uint64) (Block, error) {
+	block, err := e.BlockByNumber(ctx, number)
+	if err != nil {
+		return block, err
+	}
+
+	if _, err = e.db.Collection(REORGS).ReplaceOne(ctx, bson.M{"hash": block.Hash}, block, options.Replace().SetUpsert(true)); err != nil {
+		return block, err
+	}
+	if _, err = e.db.Collection(BLOCKS).DeleteOne(ctx, bson.M{"number": number}); err != nil {
+		return block, err
+	}
+
+	txns, err := e.TransactionsByBlockNumber(ctx, number)
+	if err != nil {
+		return block, err
+	}
+	for _, txn := range txns {
+		_, err = e.db.Collection(FORKEDTXNS).ReplaceOne(ctx, bson.M{"hash": txn.Hash, "blockHash": txn.BlockHash}, txn, options.Replace().SetUpsert(true))
+		if err != nil {
+			return block, err
+		}
+	}
+	for _, c := range []string{TXNS, UNCLES, TRANSFERS, TRACES} {
+		if _, err = e.db.Collection(c).DeleteMany(ctx, bson.M{"blockNumber": number}); err != nil {
+			return block, err
+		}
+	}
+	return block, nil
+}
+
+func (e *SpectrumDAO) SetLatestBlock(ctx context.Context, block Block) error {
+	_, err := e.db.Collection(STORE).UpdateOne(ctx, bson.M{},
+		bson.M{"$set": bson.M{"latestBlock": block, "timestamp": uint64(time.Now().Unix())}},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+func (e *SpectrumDAO) AddPrices(ctx context.Context, points []PricePoint) error {
+	if len(points) == 0 {
+		return nil
+	}
+	models := make([]mongo.WriteModel, len(points))
+	for i, p := range points {
+		models[i] = mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"token": p.Token, "fiat": p.Fiat, "timestamp": p.Timestamp}).
+			SetReplacement(p).
+			SetUpsert(true)
+	}
+	_, err := e.db.Collection(PRICES).BulkWrite(ctx, models)
+	return err
+}
+
+func (e *SpectrumDAO) SetLabel(ctx context.Context, label Label) error {
+	_, err := e.db.Collection(LABELS).ReplaceOne(ctx, bson.M{"address": label.Address}, label, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (e *SpectrumDAO) DeleteLabel(ctx context.Context, address string) error {
+	res, err := e.db.Collection(LABELS).DeleteOne(ctx, bson.M{"address": address})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (e *SpectrumDAO) SetContractSource(ctx context.Context, source ContractSource) error {
+	var stored ContractSource
+	err := findOne(ctx, e.db.Collection(SOURCES), bson.M{"address": source.Address}, &stored)
+	if err == nil {
+		err = replaceSource(stored, source)
+	}
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	_, err = e.db.Collection(SOURCES).ReplaceOne(ctx, bson.M{"address": source.Address}, source, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (e *SpectrumDAO) SetBalanceCheckpoint(ctx context.Context, balance Balance) error {
+	_, err := e.db.Collection(BALANCES).ReplaceOne(ctx, bson.M{"address": balance.Address, "blockNumber": balance.BlockNumber}, balance,
+		options.Replace().SetUpsert(true))
+	return err
+}
+
+func (e *SpectrumDAO) SetWatch(ctx context.Context, watch Watch) error {
+	_, err := e.db.Collection(WATCHES).ReplaceOne(ctx, bson.M{"id": watch.ID}, watch, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (e *SpectrumDAO) DeleteWatch(ctx context.Context, id string) error {
+	res, err := e.db.Collection(WATCHES).DeleteOne(ctx, bson.M{"id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	_, err = e.db.Collection(DELIVERIES).DeleteMany(ctx, bson.M{"watchId": id})
+	return err
+}
+
+func (e *SpectrumDAO) SetDelivery(ctx context.Context, delivery Delivery) error {
+	_, err := e.db.Collection(DELIVERIES).ReplaceOne(ctx, bson.M{"id": delivery.ID}, delivery, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (e *SpectrumDAO) SetWatchCursor(ctx context.Context, number uint64) error {
+	_, err := e.db.Collection(STORE).UpdateOne(ctx, bson.M{}, bson.M{"$set": bson.M{"watchCursor": number}}, options.Update().SetUpsert(true))
+	return err
+}
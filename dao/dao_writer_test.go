@@ -0,0 +1,33 @@
+package dao
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testMongo connects to the server at MONGO_URL, skipping the test without
+// one, with a database of its own that is dropped afterwards.
+func testMongo(t *testing.T) *SpectrumDAO {
+	url := os.Getenv("MONGO_URL")
+	if url == "" {
+		t.Skip("MONGO_URL is not set")
+	}
+	db := &SpectrumDAO{Server: url, Database: "spectrum_test_" + strconv.FormatInt(time.Now().UnixNano(), 36), QueryTimeout: 10 * time.Second}
+	db.Connect()
+	t.Cleanup(func() {
+		db.db.Drop(context.Background())
+		db.Close()
+	})
+	return db
+}
+
+func TestMongoRewriteBlock(t *testing.T) {
+	testRewriteBlock(t, testMongo(t))
+}
+
+func TestMongoForkBlock(t *testing.T) {
+	testForkBlock(t, testMongo(t))
+}
@@ -0,0 +1,70 @@
+package dao
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// Genesis is the coin allocated to accounts in block 0. No transaction
+// shows it, so the backends add it to the coin balances they sum.
+type Genesis struct {
+	Timestamp uint64
+	Alloc     map[string]*big.Int
+}
+
+// ReadGenesis reads the timestamp and alloc of a geth genesis.json, whose
+// numbers may be hex or decimal.
+func ReadGenesis(path string) (*Genesis, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Timestamp string `json:"timestamp"`
+		Alloc     map[string]struct {
+			Balance string `json:"balance"`
+		} `json:"alloc"`
+	}
+	if err := json.Unmarshal(body, &file); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	g := &Genesis{Alloc: map[string]*big.Int{}}
+	if file.Timestamp != "" {
+		n, ok := new(big.Int).SetString(file.Timestamp, 0)
+		if !ok || !n.IsUint64() {
+			return nil, fmt.Errorf("%s: timestamp %q is not a number", path, file.Timestamp)
+		}
+		g.Timestamp = n.Uint64()
+	}
+	for address, account := range file.Alloc {
+		address = strings.ToLower(address)
+		if !strings.HasPrefix(address, "0x") {
+			address = "0x" + address
+		}
+		if len(address) != 42 {
+			return nil, fmt.Errorf("%s: %q is not an address", path, address)
+		}
+		n, ok := new(big.Int).SetString(account.Balance, 0)
+		if !ok || n.Sign() < 0 {
+			return nil, fmt.Errorf("%s: balance %q of %s is not an amount of wei", path, account.Balance, address)
+		}
+		g.Alloc[address] = n
+	}
+	return g, nil
+}
+
+// movements are the allocations, none for a nil Genesis.
+func (g *Genesis) movements() []movement {
+	if g == nil {
+		return nil
+	}
+	moves := make([]movement, 0, len(g.Alloc))
+	for address, n := range g.Alloc {
+		moves = append(moves, movement{address, new(big.Int).Set(n)})
+	}
+	return moves
+}
@@ -0,0 +1,103 @@
+CREATE TABLE blocks (
+    number           BIGINT PRIMARY KEY,
+    hash             TEXT   NOT NULL UNIQUE,
+    parent_hash      TEXT   NOT NULL,
+    sha3_uncles      TEXT   NOT NULL DEFAULT '',
+    miner            TEXT   NOT NULL DEFAULT '',
+    difficulty       TEXT   NOT NULL DEFAULT '',
+    total_difficulty TEXT   NOT NULL DEFAULT '',
+    size             BIGINT NOT NULL DEFAULT 0,
+    gas_used         BIGINT NOT NULL DEFAULT 0,
+    gas_limit        BIGINT NOT NULL DEFAULT 0,
+    nonce            TEXT   NOT NULL DEFAULT '',
+    timestamp        BIGINT NOT NULL DEFAULT 0,
+    transactions     BIGINT NOT NULL DEFAULT 0,
+    uncles           BIGINT NOT NULL DEFAULT 0,
+    block_reward     TEXT   NOT NULL DEFAULT '',
+    uncles_reward    TEXT   NOT NULL DEFAULT '',
+    avg_gas_price    TEXT   NOT NULL DEFAULT '',
+    tx_fees          TEXT   NOT NULL DEFAULT '',
+    extra_data       TEXT   NOT NULL DEFAULT ''
+);
+
+-- forkedblocks keeps every orphaned block, so several may share a number.
+CREATE TABLE forkedblocks (LIKE blocks INCLUDING DEFAULTS);
+ALTER TABLE forkedblocks ADD PRIMARY KEY (hash);
+CREATE INDEX forkedblocks_number_idx ON forkedblocks (number DESC);
+
+CREATE TABLE transactions (
+    hash              TEXT   PRIMARY KEY,
+    block_hash        TEXT   NOT NULL,
+    block_number      BIGINT NOT NULL,
+    timestamp         BIGINT NOT NULL DEFAULT 0,
+    input             TEXT   NOT NULL DEFAULT '',
+    value             TEXT   NOT NULL DEFAULT '',
+    gas               BIGINT NOT NULL DEFAULT 0,
+    gas_used          BIGINT NOT NULL DEFAULT 0,
+    gas_price         TEXT   NOT NULL DEFAULT '',
+    nonce             BIGINT NOT NULL DEFAULT 0,
+    transaction_index BIGINT NOT NULL DEFAULT 0,
+    from_address      TEXT   NOT NULL DEFAULT '',
+    to_address        TEXT   NOT NULL DEFAULT '',
+    contract_address  TEXT   NOT NULL DEFAULT ''
+);
+CREATE INDEX transactions_block_number_idx ON transactions (block_number DESC, transaction_index DESC);
+CREATE INDEX transactions_from_idx ON transactions (from_address, block_number DESC);
+CREATE INDEX transactions_to_idx ON transactions (to_address, block_number DESC);
+CREATE INDEX transactions_contract_idx ON transactions (contract_address) WHERE contract_address <> '';
+
+CREATE TABLE logs (
+    transaction_hash  TEXT    NOT NULL REFERENCES transactions (hash) ON DELETE CASCADE,
+    log_index         BIGINT  NOT NULL,
+    address           TEXT    NOT NULL DEFAULT '',
+    topics            TEXT[]  NOT NULL DEFAULT '{}',
+    data              TEXT    NOT NULL DEFAULT '',
+    block_number      BIGINT  NOT NULL,
+    transaction_index BIGINT  NOT NULL DEFAULT 0,
+    block_hash        TEXT    NOT NULL DEFAULT '',
+    removed           BOOLEAN NOT NULL DEFAULT FALSE,
+    PRIMARY KEY (transaction_hash, log_index)
+);
+
+CREATE TABLE uncles (
+    hash         TEXT   PRIMARY KEY,
+    number       BIGINT NOT NULL,
+    position     BIGINT NOT NULL DEFAULT 0,
+    block_number BIGINT NOT NULL,
+    parent_hash  TEXT   NOT NULL DEFAULT '',
+    sha3_uncles  TEXT   NOT NULL DEFAULT '',
+    miner        TEXT   NOT NULL DEFAULT '',
+    difficulty   TEXT   NOT NULL DEFAULT '',
+    gas_used     BIGINT NOT NULL DEFAULT 0,
+    gas_limit    BIGINT NOT NULL DEFAULT 0,
+    timestamp    BIGINT NOT NULL DEFAULT 0,
+    reward       TEXT   NOT NULL DEFAULT ''
+);
+CREATE INDEX uncles_block_number_idx ON uncles (block_number DESC);
+
+CREATE TABLE tokentransfers (
+    id           BIGSERIAL PRIMARY KEY,
+    block_number BIGINT NOT NULL,
+    hash         TEXT   NOT NULL,
+    timestamp    BIGINT NOT NULL DEFAULT 0,
+    from_address TEXT   NOT NULL DEFAULT '',
+    to_address   TEXT   NOT NULL DEFAULT '',
+    value        TEXT   NOT NULL DEFAULT '',
+    contract     TEXT   NOT NULL DEFAULT '',
+    method       TEXT   NOT NULL DEFAULT ''
+);
+CREATE INDEX tokentransfers_block_number_idx ON tokentransfers (block_number DESC);
+CREATE INDEX tokentransfers_from_idx ON tokentransfers (from_address, block_number DESC);
+CREATE INDEX tokentransfers_to_idx ON tokentransfers (to_address, block_number DESC);
+CREATE INDEX tokentransfers_contract_idx ON tokentransfers (contract, block_number DESC);
+
+-- sysstore holds the single status document the crawler maintains.
+CREATE TABLE sysstore (
+    id           INT    PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+    timestamp    BIGINT NOT NULL DEFAULT 0,
+    symbol       TEXT   NOT NULL DEFAULT '',
+    supply       TEXT   NOT NULL DEFAULT '',
+    price        TEXT   NOT NULL DEFAULT '',
+    latest_block JSONB  NOT NULL DEFAULT '{}',
+    txn_counts   JSONB  NOT NULL DEFAULT '{}'
+);
@@ -0,0 +1,5 @@
+-- Transactions of blocks moved to forkedblocks, kept so that reorgs can show
+-- which of them were dropped and which made it back into the chain.
+CREATE TABLE forkedtransactions (LIKE transactions INCLUDING DEFAULTS);
+ALTER TABLE forkedtransactions ADD PRIMARY KEY (hash, block_hash);
+CREATE INDEX forkedtransactions_block_hash_idx ON forkedtransactions (block_hash, transaction_index);
@@ -0,0 +1,15 @@
+-- Receipt fields. Rows stored before these were indexed keep a NULL status.
+-- forkedtransactions is copied from transactions with SELECT *, so both get
+-- the same columns in the same order.
+ALTER TABLE transactions
+    ADD COLUMN status SMALLINT,
+    ADD COLUMN cumulative_gas_used BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN logs_bloom TEXT NOT NULL DEFAULT '',
+    ADD COLUMN effective_gas_price TEXT NOT NULL DEFAULT '',
+    ADD COLUMN type SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE forkedtransactions
+    ADD COLUMN status SMALLINT,
+    ADD COLUMN cumulative_gas_used BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN logs_bloom TEXT NOT NULL DEFAULT '',
+    ADD COLUMN effective_gas_price TEXT NOT NULL DEFAULT '',
+    ADD COLUMN type SMALLINT NOT NULL DEFAULT 0;
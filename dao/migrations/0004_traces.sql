@@ -0,0 +1,20 @@
+-- Internal transactions, written by the indexer when tracing is enabled.
+CREATE TABLE traces (
+    hash              TEXT   NOT NULL,
+    trace_index       BIGINT NOT NULL,
+    block_number      BIGINT NOT NULL,
+    transaction_index BIGINT NOT NULL DEFAULT 0,
+    timestamp         BIGINT NOT NULL DEFAULT 0,
+    type              TEXT   NOT NULL DEFAULT '',
+    from_address      TEXT   NOT NULL DEFAULT '',
+    to_address        TEXT   NOT NULL DEFAULT '',
+    value             TEXT   NOT NULL DEFAULT '',
+    gas               BIGINT NOT NULL DEFAULT 0,
+    gas_used          BIGINT NOT NULL DEFAULT 0,
+    depth             BIGINT NOT NULL DEFAULT 0,
+    error             TEXT   NOT NULL DEFAULT '',
+    PRIMARY KEY (hash, trace_index)
+);
+CREATE INDEX traces_block_number_idx ON traces (block_number DESC);
+CREATE INDEX traces_from_idx ON traces (from_address, block_number DESC);
+CREATE INDEX traces_to_idx ON traces (to_address, block_number DESC);
@@ -0,0 +1,3 @@
+-- Emission sums rewards by block and uncle timestamp.
+CREATE INDEX blocks_timestamp_idx ON blocks (timestamp);
+CREATE INDEX uncles_timestamp_idx ON uncles (timestamp);
@@ -0,0 +1,10 @@
+-- Fiat price points, imported from csv or polled from a price source. token
+-- is empty for the native coin.
+CREATE TABLE prices (
+    token     TEXT   NOT NULL DEFAULT '',
+    fiat      TEXT   NOT NULL,
+    timestamp BIGINT NOT NULL,
+    price     TEXT   NOT NULL,
+    decimals  BIGINT NOT NULL DEFAULT 18,
+    PRIMARY KEY (token, fiat, timestamp)
+);
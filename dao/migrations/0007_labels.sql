@@ -0,0 +1,8 @@
+-- Names of known addresses, seeded from labelsFile and edited through the
+-- admin api.
+CREATE TABLE labels (
+    address TEXT   PRIMARY KEY,
+    name    TEXT   NOT NULL,
+    tags    TEXT[] NOT NULL DEFAULT '{}'
+);
+CREATE INDEX labels_tags_idx ON labels USING GIN (tags);
@@ -0,0 +1,14 @@
+-- Verified contract source. sources maps file names to their content.
+CREATE TABLE contract_sources (
+    address               TEXT    PRIMARY KEY,
+    contract_name         TEXT    NOT NULL,
+    compiler_version      TEXT    NOT NULL,
+    optimization          BOOLEAN NOT NULL,
+    runs                  BIGINT  NOT NULL,
+    evm_version           TEXT    NOT NULL DEFAULT '',
+    sources               JSONB   NOT NULL,
+    abi                   TEXT    NOT NULL,
+    constructor_arguments TEXT    NOT NULL DEFAULT '',
+    match                 TEXT    NOT NULL,
+    verified_at           BIGINT  NOT NULL
+);
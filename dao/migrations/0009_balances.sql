@@ -0,0 +1,13 @@
+-- Balance lookups read the blocks and uncles an account mined, and start
+-- from the latest checkpoint of its balances. tokens is a list of
+-- {contract, balance}.
+CREATE INDEX blocks_miner_idx ON blocks (miner, number);
+CREATE INDEX uncles_miner_idx ON uncles (miner, block_number);
+
+CREATE TABLE balance_checkpoints (
+    address      TEXT   NOT NULL,
+    block_number BIGINT NOT NULL,
+    balance      TEXT   NOT NULL,
+    tokens       JSONB  NOT NULL DEFAULT '[]',
+    PRIMARY KEY (address, block_number)
+);
@@ -0,0 +1,36 @@
+-- Watchlists of addresses and token contracts, and the webhooks posted for
+-- the transactions and transfers matching them. txn and transfer hold the
+-- matched document as json. watch_cursor is the latest block the watches
+-- were matched against.
+CREATE TABLE watches (
+    id            TEXT   PRIMARY KEY,
+    address       TEXT   NOT NULL DEFAULT '',
+    contract      TEXT   NOT NULL DEFAULT '',
+    url           TEXT   NOT NULL,
+    secret        TEXT   NOT NULL,
+    confirmations BIGINT NOT NULL DEFAULT 0,
+    from_block    BIGINT NOT NULL DEFAULT 0,
+    created_at    BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE webhook_deliveries (
+    id              TEXT   PRIMARY KEY,
+    watch_id        TEXT   NOT NULL REFERENCES watches (id) ON DELETE CASCADE,
+    event           TEXT   NOT NULL,
+    block_number    BIGINT NOT NULL,
+    block_hash      TEXT   NOT NULL DEFAULT '',
+    hash            TEXT   NOT NULL DEFAULT '',
+    txn             JSONB,
+    transfer        JSONB,
+    status          TEXT   NOT NULL,
+    attempts        BIGINT NOT NULL DEFAULT 0,
+    next_attempt    BIGINT NOT NULL DEFAULT 0,
+    last_attempt    BIGINT NOT NULL DEFAULT 0,
+    response_status BIGINT NOT NULL DEFAULT 0,
+    error           TEXT   NOT NULL DEFAULT '',
+    created_at      BIGINT NOT NULL DEFAULT 0
+);
+CREATE INDEX webhook_deliveries_watch_idx ON webhook_deliveries (watch_id, block_number DESC, id DESC);
+CREATE INDEX webhook_deliveries_pending_idx ON webhook_deliveries (block_number DESC, id DESC) WHERE status = 'pending';
+
+ALTER TABLE sysstore ADD COLUMN watch_cursor BIGINT;
@@ -0,0 +1,3 @@
+-- The notifier asks for the pending deliveries of each watch that are due,
+-- oldest first.
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (watch_id, block_number, id) WHERE status = 'pending';
@@ -0,0 +1,5 @@
+-- Logs of forked transactions, kept as the JSON array the API returns since
+-- the logs table cascades away with the transactions of the forked block.
+-- ForkBlock now names the columns it copies, so forkedtransactions no longer
+-- has to match transactions column for column.
+ALTER TABLE forkedtransactions ADD COLUMN logs JSONB NOT NULL DEFAULT '[]';
@@ -0,0 +1,1031 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+type PostgresDAO struct {
+	URL          string
+	QueryTimeout time.Duration
+	MaxOpenConns int
+	// Genesis, when set, is added to the coin balances summed.
+	Genesis *Genesis
+
+	db *sql.DB
+}
+
+const (
+	blockColumns       = "number, hash, parent_hash, sha3_uncles, miner, difficulty, total_difficulty, size, gas_used, gas_limit, nonce, timestamp, transactions, uncles, block_reward, uncles_reward, avg_gas_price, tx_fees, extra_data"
+	transactionColumns = "hash, block_hash, block_number, timestamp, input, value, gas, gas_used, gas_price, nonce, transaction_index, from_address, to_address, contract_address, status, cumulative_gas_used, logs_bloom, effective_gas_price, type"
+	logColumns         = "address, topics, data, block_number, transaction_index, transaction_hash, block_hash, log_index, removed"
+	uncleColumns       = "number, position, block_number, hash, parent_hash, sha3_uncles, miner, difficulty, gas_used, gas_limit, timestamp, reward"
+	transferColumns    = "block_number, hash, timestamp, from_address, to_address, value, contract, method"
+	traceColumns       = "hash, trace_index, block_number, transaction_index, timestamp, type, from_address, to_address, value, gas, gas_used, depth, error"
+)
+
+func (e *PostgresDAO) Connect() {
+	var err error
+	e.db, err = sql.Open("postgres", e.URL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if e.MaxOpenConns > 0 {
+		e.db.SetMaxOpenConns(e.MaxOpenConns)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err = e.db.PingContext(ctx); err != nil {
+		log.Fatal(err)
+	}
+	if err = e.migrate(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (e *PostgresDAO) Close() {
+	e.db.Close()
+}
+
+// migrate applies every migrations/NNNN_*.sql file newer than the version
+// recorded in schema_migrations, each in its own transaction.
+func (e *PostgresDAO) migrate(ctx context.Context) error {
+	if _, err := e.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INT PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return err
+	}
+
+	var current int
+	if err := e.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return err
+	}
+
+	files, err := migrations.ReadDir("migrations")
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+
+	for _, f := range files {
+		version, err := strconv.Atoi(strings.SplitN(f.Name(), "_", 2)[0])
+		if err != nil {
+			return fmt.Errorf("bad migration name %s: %v", f.Name(), err)
+		}
+		if version <= current {
+			continue
+		}
+		script, err := migrations.ReadFile("migrations/" + f.Name())
+		if err != nil {
+			return err
+		}
+
+		tx, err := e.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, string(script)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s: %v", f.Name(), err)
+		}
+		if _, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", version); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("applied migration %s", f.Name())
+	}
+	return nil
+}
+
+// timeout bounds a single query by QueryTimeout; cancelling the returned
+// context makes lib/pq send a cancel request for the running statement.
+func (e *PostgresDAO) timeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if e.QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, e.QueryTimeout)
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanBlock(row scanner) (Block, error) {
+	var b Block
+	err := row.Scan(&b.Number, &b.Hash, &b.ParentHash, &b.Sha3Uncles, &b.Miner, &b.Difficulty, &b.TotalDifficulty, &b.Size,
+		&b.GasUsed, &b.GasLimit, &b.Nonce, &b.Timestamp, &b.Transactions, &b.Uncles, &b.BlockReward, &b.UnclesReward,
+		&b.AvgGasPrice, &b.TxFees, &b.ExtraData)
+	return b, err
+}
+
+// scanTransaction scans transactionColumns, then any columns after them into
+// extra.
+func scanTransaction(row scanner, extra ...interface{}) (Transaction, error) {
+	var t Transaction
+	dest := []interface{}{&t.Hash, &t.BlockHash, &t.BlockNumber, &t.Timestamp, &t.Input, &t.Value, &t.Gas, &t.GasUsed,
+		&t.GasPrice, &t.Nonce, &t.TransactionIndex, &t.From, &t.To, &t.ContractAddress, &t.Status, &t.CumulativeGasUsed,
+		&t.LogsBloom, &t.EffectiveGasPrice, &t.Type}
+	err := row.Scan(append(dest, extra...)...)
+	return t, err
+}
+
+func scanUncle(row scanner) (Uncle, error) {
+	var u Uncle
+	err := row.Scan(&u.Number, &u.Position, &u.BlockNumber, &u.Hash, &u.ParentHash, &u.Sha3Uncles, &u.Miner,
+		&u.Difficulty, &u.GasUsed, &u.GasLimit, &u.Timestamp, &u.Reward)
+	return u, err
+}
+
+func scanTransfer(row scanner) (TokenTransfer, error) {
+	var t TokenTransfer
+	err := row.Scan(&t.BlockNumber, &t.Hash, &t.Timestamp, &t.From, &t.To, &t.Value, &t.Contract, &t.Method)
+	return t, err
+}
+
+func scanTrace(row scanner) (Trace, error) {
+	var t Trace
+	err := row.Scan(&t.Hash, &t.Index, &t.BlockNumber, &t.TransactionIndex, &t.Timestamp, &t.Type, &t.From, &t.To, &t.Value,
+		&t.Gas, &t.GasUsed, &t.Depth, &t.Error)
+	return t, err
+}
+
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (e *PostgresDAO) block(ctx context.Context, query string, args ...interface{}) (Block, error) {
+	ctx, cancel := e.timeout(ctx)
+	defer cancel()
+	b, err := scanBlock(e.db.QueryRowContext(ctx, query, args...))
+	return b, notFound(err)
+}
+
+func (e *PostgresDAO) blocks(ctx context.Context, query string, args ...interface{}) ([]Block, error) {
+	ctx, cancel := e.timeout(ctx)
+	defer cancel()
+	rows, err := e.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blocks []Block
+	for rows.Next() {
+		b, err := scanBlock(rows)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, rows.Err()
+}
+
+// transactions runs query and attaches each transaction's logs with a second
+// query, so that results match the embedded logs of the mongo documents.
+func (e *PostgresDAO) transactions(ctx context.Context, query string, args ...interface{}) ([]Transaction, error) {
+	ctx, cancel := e.timeout(ctx)
+	defer cancel()
+	rows, err := e.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var txns []Transaction
+	var hashes []string
+	for rows.Next() {
+		t, err := scanTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		txns = append(txns, t)
+		hashes = append(hashes, t.Hash)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(txns) == 0 {
+		return txns, nil
+	}
+
+	logs, err := e.logs(ctx, hashes)
+	if err != nil {
+		return nil, err
+	}
+	for i := range txns {
+		txns[i].Logs = logs[txns[i].Hash]
+		if txns[i].Logs == nil {
+			txns[i].Logs = []TxLog{}
+		}
+	}
+	return txns, nil
+}
+
+func (e *PostgresDAO) transaction(ctx context.Context, query string, args ...interface{}) (Transaction, error) {
+	txns, err := e.transactions(ctx, query+" LIMIT 1", args...)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if len(txns) == 0 {
+		return Transaction{}, ErrNotFound
+	}
+	return txns[0], nil
+}
+
+func (e *PostgresDAO) logs(ctx context.Context, hashes []string) (map[string][]TxLog, error) {
+	rows, err := e.db.QueryContext(ctx, "SELECT "+logColumns+" FROM logs WHERE transaction_hash = ANY($1) ORDER BY log_index", pq.Array(hashes))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	logs := make(map[string][]TxLog)
+	for rows.Next() {
+		var l TxLog
+		if err := rows.Scan(&l.Address, pq.Array(&l.Topics), &l.Data, &l.BlockNumber, &l.TransactionIndex,
+			&l.TransactionHash, &l.BlockHash, &l.LogIndex, &l.Removed); err != nil {
+			return nil, err
+		}
+		logs[l.TransactionHash] = append(logs[l.TransactionHash], l)
+	}
+	return logs, rows.Err()
+}
+
+func (e *PostgresDAO) uncles(ctx context.Context, query string, args ...interface{}) ([]Uncle, error) {
+	ctx, cancel := e.timeout(ctx)
+	defer cancel()
+	rows, err := e.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uncles []Uncle
+	for rows.Next() {
+		u, err := scanUncle(rows)
+		if err != nil {
+			return nil, err
+		}
+		uncles = append(uncles, u)
+	}
+	return uncles, rows.Err()
+}
+
+func (e *PostgresDAO) transfers(ctx context.Context, query string, args ...interface{}) ([]TokenTransfer, error) {
+	ctx, cancel := e.timeout(ctx)
+	defer cancel()
+	rows, err := e.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transfers []TokenTransfer
+	for rows.Next() {
+		t, err := scanTransfer(rows)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, t)
+	}
+	return transfers, rows.Err()
+}
+
+func (e *PostgresDAO) traces(ctx context.Context, query string, args ...interface{}) ([]Trace, error) {
+	ctx, cancel := e.timeout(ctx)
+	defer cancel()
+	rows, err := e.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var traces []Trace
+	for rows.Next() {
+		t, err := scanTrace(rows)
+		if err != nil {
+			return nil, err
+		}
+		traces = append(traces, t)
+	}
+	return traces, rows.Err()
+}
+
+func (e *PostgresDAO) count(ctx context.Context, query string, args ...interface{}) (int, error) {
+	ctx, cancel := e.timeout(ctx)
+	defer cancel()
+	var n int
+	err := e.db.QueryRowContext(ctx, query, args...).Scan(&n)
+	return n, err
+}
+
+// estimatedCount reads the planner's row estimate, since count(*) scans the
+// whole table, and only falls back to count(*) for tables never analyzed.
+func (e *PostgresDAO) estimatedCount(ctx context.Context, table string) (int, error) {
+	n, err := e.count(ctx, "SELECT reltuples::bigint FROM pg_class WHERE oid = $1::regclass", table)
+	if err != nil || n >= 0 {
+		return n, err
+	}
+	return e.count(ctx, "SELECT count(*) FROM "+table)
+}
+
+func (e *PostgresDAO) BlockByNumber(ctx context.Context, number uint64) (Block, error) {
+	return e.block(ctx, "SELECT "+blockColumns+" FROM blocks WHERE number = $1", number)
+}
+
+func (e *PostgresDAO) BlockByHash(ctx context.Context, hash string) (Block, error) {
+	return e.block(ctx, "SELECT "+blockColumns+" FROM blocks WHERE hash = $1", hash)
+}
+
+func (e *PostgresDAO) LatestBlock(ctx context.Context) (Block, error) {
+	return e.block(ctx, "SELECT "+blockColumns+" FROM blocks ORDER BY number DESC LIMIT 1")
+}
+
+func (e *PostgresDAO) Store(ctx context.Context) (Store, error) {
+	ctx, cancel := e.timeout(ctx)
+	defer cancel()
+
+	var store Store
+	var latestBlock, txnCounts []byte
+	err := e.db.QueryRowContext(ctx, "SELECT timestamp, symbol, supply, price, latest_block, txn_counts FROM sysstore LIMIT 1").
+		Scan(&store.Timestamp, &store.Symbol, &store.Supply, &store.Price, &latestBlock, &txnCounts)
+	if err != nil {
+		return store, notFound(err)
+	}
+	if err = json.Unmarshal(latestBlock, &store.LatestBlock); err != nil {
+		return store, err
+	}
+	err = json.Unmarshal(txnCounts, &store.TxnCounts)
+	return store, err
+}
+
+func (e *PostgresDAO) LatestBlocks(ctx context.Context, limit int) ([]Block, error) {
+	return e.blocks(ctx, "SELECT "+blockColumns+" FROM blocks ORDER BY number DESC LIMIT NULLIF($1, 0)", limit)
+}
+
+func (e *PostgresDAO) LatestUncles(ctx context.Context, limit int) ([]Uncle, error) {
+	return e.uncles(ctx, "SELECT "+uncleColumns+" FROM uncles ORDER BY block_number DESC LIMIT NULLIF($1, 0)", limit)
+}
+
+func (e *PostgresDAO) LatestForkedBlocks(ctx context.Context, limit int) ([]Block, error) {
+	return e.blocks(ctx, "SELECT "+blockColumns+" FROM forkedblocks ORDER BY number DESC LIMIT NULLIF($1, 0)", limit)
+}
+
+func (e *PostgresDAO) ForkedBlockByHash(ctx context.Context, hash string) (Block, error) {
+	return e.block(ctx, "SELECT "+blockColumns+" FROM forkedblocks WHERE hash = $1", hash)
+}
+
+// ForkedTransactions are returned without logs; only the canonical copy of a
+// transaction keeps them.
+func (e *PostgresDAO) ForkedTransactions(ctx context.Context, blockHash string) ([]Transaction, error) {
+	ctx, cancel := e.timeout(ctx)
+	defer cancel()
+	rows, err := e.db.QueryContext(ctx, "SELECT "+transactionColumns+", logs FROM forkedtransactions WHERE block_hash = $1 ORDER BY transaction_index", blockHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var txns []Transaction
+	for rows.Next() {
+		var logs []byte
+		t, err := scanTransaction(rows, &logs)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(logs, &t.Logs); err != nil {
+			return nil, err
+		}
+		txns = append(txns, t)
+	}
+	return txns, rows.Err()
+}
+
+func (e *PostgresDAO) TransactionByHash(ctx context.Context, hash string) (Transaction, error) {
+	return e.transaction(ctx, "SELECT "+transactionColumns+" FROM transactions WHERE hash = $1", hash)
+}
+
+func (e *PostgresDAO) TransactionByContractAddress(ctx context.Context, hash string) (Transaction, error) {
+	return e.transaction(ctx, "SELECT "+transactionColumns+" FROM transactions WHERE contract_address = $1", hash)
+}
+
+func (e *PostgresDAO) TransactionsByBlockNumber(ctx context.Context, number uint64) ([]Transaction, error) {
+	return e.transactions(ctx, "SELECT "+transactionColumns+" FROM transactions WHERE block_number = $1 ORDER BY transaction_index", number)
+}
+
+func (e *PostgresDAO) UncleByHash(ctx context.Context, hash string) (Uncle, error) {
+	uncles, err := e.uncles(ctx, "SELECT "+uncleColumns+" FROM uncles WHERE hash = $1", hash)
+	if err != nil {
+		return Uncle{}, err
+	}
+	if len(uncles) == 0 {
+		return Uncle{}, ErrNotFound
+	}
+	return uncles[0], nil
+}
+
+func (e *PostgresDAO) UnclesByBlockNumber(ctx context.Context, number uint64) ([]Uncle, error) {
+	return e.uncles(ctx, "SELECT "+uncleColumns+" FROM uncles WHERE block_number = $1 ORDER BY position", number)
+}
+
+func (e *PostgresDAO) TokenTransfersByBlockNumber(ctx context.Context, number uint64) ([]TokenTransfer, error) {
+	return e.transfers(ctx, "SELECT "+transferColumns+" FROM tokentransfers WHERE block_number = $1 ORDER BY id", number)
+}
+
+func (e *PostgresDAO) LatestTransactions(ctx context.Context, limit int) ([]Transaction, error) {
+	return e.transactions(ctx, "SELECT "+transactionColumns+" FROM transactions ORDER BY block_number DESC, transaction_index DESC LIMIT NULLIF($1, 0)", limit)
+}
+
+// The account queries are written as a UNION so that each side can use its
+// from_address or to_address index.
+// statusClause restricts an account query to the receipt status filter
+// selects; a NULL $2 selects every transaction.
+const statusClause = " AND ($2::smallint IS NULL OR status = $2)"
+
+func statusArg(filter TxnFilter) interface{} {
+	if status, ok := filter.status(); ok {
+		return status
+	}
+	return nil
+}
+
+func (e *PostgresDAO) LatestTransactionsByAccount(ctx context.Context, hash string, filter TxnFilter) ([]Transaction, error) {
+	return e.transactions(ctx, "SELECT "+transactionColumns+" FROM ("+
+		"(SELECT * FROM transactions WHERE from_address = $1"+statusClause+" ORDER BY block_number DESC LIMIT 100) UNION "+
+		"(SELECT * FROM transactions WHERE to_address = $1"+statusClause+" ORDER BY block_number DESC LIMIT 100)"+
+		") t ORDER BY block_number DESC, transaction_index DESC LIMIT 100", hash, statusArg(filter))
+}
+
+func (e *PostgresDAO) TracesByTransaction(ctx context.Context, hash string) ([]Trace, error) {
+	return e.traces(ctx, "SELECT "+traceColumns+" FROM traces WHERE hash = $1 ORDER BY trace_index", hash)
+}
+
+func (e *PostgresDAO) LatestTracesByAccount(ctx context.Context, hash string) ([]Trace, error) {
+	return e.traces(ctx, "SELECT "+traceColumns+" FROM ("+
+		"(SELECT * FROM traces WHERE from_address = $1 ORDER BY block_number DESC LIMIT 100) UNION "+
+		"(SELECT * FROM traces WHERE to_address = $1 ORDER BY block_number DESC LIMIT 100)"+
+		") t ORDER BY block_number DESC, transaction_index DESC, trace_index DESC LIMIT 100", hash)
+}
+
+func (e *PostgresDAO) LatestTokenTransfersByAccount(ctx context.Context, hash string) ([]TokenTransfer, error) {
+	return e.transfers(ctx, "SELECT "+transferColumns+" FROM ("+
+		"(SELECT * FROM tokentransfers WHERE from_address = $1 ORDER BY block_number DESC LIMIT 100) UNION "+
+		"(SELECT * FROM tokentransfers WHERE to_address = $1 ORDER BY block_number DESC LIMIT 100)"+
+		") t ORDER BY block_number DESC, id DESC LIMIT 100", hash)
+}
+
+func (e *PostgresDAO) TokenTransfersByAccount(ctx context.Context, token string, account string) ([]TokenTransfer, error) {
+	return e.transfers(ctx, "SELECT "+transferColumns+" FROM tokentransfers WHERE contract = $1 AND (from_address = $2 OR to_address = $2) ORDER BY block_number DESC, id DESC", token, account)
+}
+
+func (e *PostgresDAO) LatestTransfersByToken(ctx context.Context, hash string) ([]TokenTransfer, error) {
+	return e.transfers(ctx, "SELECT "+transferColumns+" FROM tokentransfers WHERE contract = $1 ORDER BY block_number DESC, id DESC LIMIT 1000", hash)
+}
+
+func (e *PostgresDAO) LatestTokenTransfers(ctx context.Context, limit int) ([]TokenTransfer, error) {
+	return e.transfers(ctx, "SELECT "+transferColumns+" FROM tokentransfers ORDER BY block_number DESC, id DESC LIMIT NULLIF($1, 0)", limit)
+}
+
+func (e *PostgresDAO) TxnCount(ctx context.Context, hash string, filter TxnFilter) (int, error) {
+	return e.count(ctx, "SELECT count(*) FROM transactions WHERE (from_address = $1 OR to_address = $1)"+statusClause, hash, statusArg(filter))
+}
+
+func (e *PostgresDAO) TraceCount(ctx context.Context, hash string) (int, error) {
+	return e.count(ctx, "SELECT count(*) FROM traces WHERE from_address = $1 OR to_address = $1", hash)
+}
+
+func (e *PostgresDAO) TotalTxnCount(ctx context.Context) (int, error) {
+	return e.estimatedCount(ctx, "transactions")
+}
+
+func (e *PostgresDAO) TokenTransferCount(ctx context.Context, hash string) (int, error) {
+	return e.count(ctx, "SELECT count(*) FROM tokentransfers WHERE from_address = $1 OR to_address = $1", hash)
+}
+
+func (e *PostgresDAO) TokenTransferCountByContract(ctx context.Context, hash string) (int, error) {
+	return e.count(ctx, "SELECT count(*) FROM tokentransfers WHERE contract = $1", hash)
+}
+
+func (e *PostgresDAO) TotalTokenTransferCount(ctx context.Context) (int, error) {
+	return e.estimatedCount(ctx, "tokentransfers")
+}
+
+func (e *PostgresDAO) TokenTransferByAccountCount(ctx context.Context, token string, account string) (int, error) {
+	return e.count(ctx, "SELECT count(*) FROM tokentransfers WHERE contract = $1 AND (from_address = $2 OR to_address = $2)", token, account)
+}
+
+func (e *PostgresDAO) TotalBlockCount(ctx context.Context) (int, error) {
+	return e.estimatedCount(ctx, "blocks")
+}
+
+func (e *PostgresDAO) TotalUncleCount(ctx context.Context) (int, error) {
+	return e.estimatedCount(ctx, "uncles")
+}
+
+func (e *PostgresDAO) Emission(ctx context.Context, from uint64, interval uint64) ([]Emission, error) {
+	ctx, cancel := e.timeout(ctx)
+	defer cancel()
+	periods := newEmissionPeriods(interval)
+
+	rows, err := e.db.QueryContext(ctx, `SELECT timestamp - timestamp % $2 AS period, count(*),
+		coalesce(sum(NULLIF(block_reward, '')::numeric), 0)::text, coalesce(sum(NULLIF(uncles_reward, '')::numeric), 0)::text
+		FROM blocks WHERE timestamp >= $1 GROUP BY period`, from, interval)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var start, blocks uint64
+		var blockRewards, unclesReward string
+		if err := rows.Scan(&start, &blocks, &blockRewards, &unclesReward); err != nil {
+			return nil, err
+		}
+		periods.addBlock(start, blocks, blockRewards, unclesReward)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = e.db.QueryContext(ctx, `SELECT timestamp - timestamp % $2 AS period, coalesce(sum(NULLIF(reward, '')::numeric), 0)::text
+		FROM uncles WHERE timestamp >= $1 GROUP BY period`, from, interval)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var start uint64
+		var reward string
+		if err := rows.Scan(&start, &reward); err != nil {
+			return nil, err
+		}
+		periods.addUncle(start, reward)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return periods.list(), nil
+}
+
+const priceColumns = "token, fiat, timestamp, price, decimals"
+
+func scanPrice(row scanner) (PricePoint, error) {
+	var p PricePoint
+	err := row.Scan(&p.Token, &p.Fiat, &p.Timestamp, &p.Price, &p.Decimals)
+	return p, err
+}
+
+func (e *PostgresDAO) PriceAt(ctx context.Context, token string, fiat string, timestamp uint64) (PricePoint, error) {
+	ctx, cancel := e.timeout(ctx)
+	defer cancel()
+	p, err := scanPrice(e.db.QueryRowContext(ctx, "SELECT "+priceColumns+` FROM prices
+		WHERE token = $1 AND fiat = $2 AND timestamp <= $3 ORDER BY timestamp DESC LIMIT 1`, token, fiat, timestamp))
+	return p, notFound(err)
+}
+
+func (e *PostgresDAO) PriceHistory(ctx context.Context, token string, fiat string, from uint64, to uint64, limit int) ([]PricePoint, error) {
+	ctx, cancel := e.timeout(ctx)
+	defer cancel()
+	rows, err := e.db.QueryContext(ctx, "SELECT "+priceColumns+` FROM prices
+		WHERE token = $1 AND fiat = $2 AND timestamp BETWEEN $3 AND $4 ORDER BY timestamp LIMIT NULLIF($5, 0)`,
+		token, fiat, from, to, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []PricePoint
+	for rows.Next() {
+		p, err := scanPrice(rows)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+const labelColumns = "address, name, tags"
+
+func scanLabel(row scanner) (Label, error) {
+	var l Label
+	err := row.Scan(&l.Address, &l.Name, pq.Array(&l.Tags))
+	return l, err
+}
+
+func (e *PostgresDAO) Label(ctx context.Context, address string) (Label, error) {
+	ctx, cancel := e.timeout(ctx)
+	defer cancel()
+	l, err := scanLabel(e.db.QueryRowContext(ctx, "SELECT "+labelColumns+" FROM labels WHERE address = $1", address))
+	return l, notFound(err)
+}
+
+func (e *PostgresDAO) Labels(ctx context.Context, tag string) ([]Label, error) {
+	ctx, cancel := e.timeout(ctx)
+	defer cancel()
+	rows, err := e.db.QueryContext(ctx, "SELECT "+labelColumns+` FROM labels
+		WHERE $1 = '' OR tags @> ARRAY[$1] ORDER BY address`, tag)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var labels []Label
+	for rows.Next() {
+		l, err := scanLabel(rows)
+		if err != nil {
+			return nil, err
+		}
+		labels = append(labels, l)
+	}
+	return labels, rows.Err()
+}
+
+func (e *PostgresDAO) ContractSource(ctx context.Context, address string) (ContractSource, error) {
+	ctx, cancel := e.timeout(ctx)
+	defer cancel()
+	var s ContractSource
+	var sources []byte
+	err := e.db.QueryRowContext(ctx, `SELECT address, contract_name, compiler_version, optimization, runs, evm_version,
+		sources, abi, constructor_arguments, match, verified_at FROM contract_sources WHERE address = $1`, address).
+		Scan(&s.Address, &s.ContractName, &s.CompilerVersion, &s.Optimization, &s.Runs, &s.EvmVersion,
+			&sources, &s.ABI, &s.ConstructorArguments, &s.Match, &s.VerifiedAt)
+	if err != nil {
+		return s, notFound(err)
+	}
+	return s, json.Unmarshal(sources, &s.Sources)
+}
+
+func (e *PostgresDAO) Contracts(ctx context.Context, filter ContractFilter, limit int) ([]Contract, error) {
+	ctx, cancel := e.timeout(ctx)
+	defer cancel()
+	rows, err := e.db.QueryContext(ctx, `SELECT t.contract_address, t.from_address, t.block_number, t.timestamp, t.hash,
+		length(t.input), EXISTS (SELECT 1 FROM tokentransfers WHERE contract = t.contract_address), coalesce(s.match, '')
+		FROM transactions t LEFT JOIN contract_sources s ON s.address = t.contract_address
+		WHERE t.contract_address <> '' AND ($1 = '' OR t.from_address = $1) AND t.block_number >= $2 AND ($3 = 0 OR t.block_number <= $3)
+		ORDER BY t.block_number DESC, t.transaction_index DESC LIMIT NULLIF($4, 0)`,
+		filter.Creator, filter.FromBlock, filter.ToBlock, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contracts []Contract
+	for rows.Next() {
+		var c Contract
+		if err := rows.Scan(&c.Address, &c.Creator, &c.BlockNumber, &c.Timestamp, &c.TransactionHash,
+			&c.BytecodeSize, &c.IsToken, &c.Verified); err != nil {
+			return nil, err
+		}
+		c.BytecodeSize = inputSize(c.BytecodeSize)
+		contracts = append(contracts, c)
+	}
+	return contracts, rows.Err()
+}
+
+func (e *PostgresDAO) ContractCounts(ctx context.Context, from uint64, interval uint64) ([]ContractCount, error) {
+	ctx, cancel := e.timeout(ctx)
+	defer cancel()
+	rows, err := e.db.QueryContext(ctx, `SELECT timestamp - timestamp % $2 AS period, count(*)
+		FROM transactions WHERE contract_address <> '' AND timestamp >= $1 GROUP BY period`, from, interval)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[uint64]uint64{}
+	for rows.Next() {
+		var start, n uint64
+		if err := rows.Scan(&start, &n); err != nil {
+			return nil, err
+		}
+		counts[start] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return countPeriods(counts), nil
+}
+
+func (e *PostgresDAO) BalanceChanges(ctx context.Context, address string, from uint64, to uint64) ([]BalanceChange, error) {
+	ledger := newBalanceLedger(address)
+	if from == 0 {
+		ledger.addGenesis(e.Genesis)
+	}
+	const inRange = " AND block_number BETWEEN $2 AND $3"
+
+	// Logs don't move funds, so transactions are read without them.
+	txns, err := func() ([]Transaction, error) {
+		ctx, cancel := e.timeout(ctx)
+		defer cancel()
+		rows, err := e.db.QueryContext(ctx, "SELECT "+transactionColumns+" FROM ("+
+			"SELECT * FROM transactions WHERE from_address = $1"+inRange+" UNION "+
+			"SELECT * FROM transactions WHERE to_address = $1"+inRange+" UNION "+
+			"SELECT * FROM transactions WHERE contract_address = $1"+inRange+
+			") t", address, from, to)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		var txns []Transaction
+		for rows.Next() {
+			t, err := scanTransaction(rows)
+			if err != nil {
+				return nil, err
+			}
+			txns = append(txns, t)
+		}
+		return txns, rows.Err()
+	}()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range txns {
+		ledger.addTxn(t)
+	}
+
+	traces, err := e.traces(ctx, "SELECT "+traceColumns+" FROM ("+
+		"SELECT * FROM traces WHERE from_address = $1"+inRange+" UNION "+
+		"SELECT * FROM traces WHERE to_address = $1"+inRange+
+		") t", address, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range traces {
+		ledger.addTrace(t)
+	}
+
+	blocks, err := e.blocks(ctx, "SELECT "+blockColumns+" FROM blocks WHERE miner = $1 AND number BETWEEN $2 AND $3", address, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range blocks {
+		ledger.addBlock(b)
+	}
+
+	uncles, err := e.uncles(ctx, "SELECT "+uncleColumns+" FROM uncles WHERE miner = $1"+inRange, address, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range uncles {
+		ledger.addUncle(u)
+	}
+
+	transfers, err := e.transfers(ctx, "SELECT "+transferColumns+" FROM ("+
+		"SELECT * FROM tokentransfers WHERE from_address = $1"+inRange+" UNION "+
+		"SELECT * FROM tokentransfers WHERE to_address = $1"+inRange+
+		") t", address, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range transfers {
+		ledger.addTransfer(t)
+	}
+	return ledger.list(), nil
+}
+
+func (e *PostgresDAO) BalanceCheckpoint(ctx context.Context, address string, number uint64) (Balance, error) {
+	ctx, cancel := e.timeout(ctx)
+	defer cancel()
+	var b Balance
+	var tokens []byte
+	err := e.db.QueryRowContext(ctx, `SELECT address, block_number, balance, tokens FROM balance_checkpoints
+		WHERE address = $1 AND block_number <= $2 ORDER BY block_number DESC LIMIT 1`, address, number).
+		Scan(&b.Address, &b.BlockNumber, &b.Balance, &tokens)
+	if err != nil {
+		return b, notFound(err)
+	}
+	return b, json.Unmarshal(tokens, &b.Tokens)
+}
+
+func (e *PostgresDAO) BalanceAccounts(ctx context.Context, from uint64, to uint64) ([]string, error) {
+	const inRange = " WHERE block_number BETWEEN $1 AND $2"
+	accounts := accountSet{}
+	if from == 0 {
+		accounts.add(e.Genesis.movements()...)
+	}
+	err := e.scanEach(ctx, func(row scanner) error {
+		var address string
+		err := row.Scan(&address)
+		accounts[address] = true
+		return err
+	}, "SELECT address FROM ("+
+		"SELECT from_address AS address FROM transactions"+inRange+" UNION "+
+		"SELECT to_address FROM transactions"+inRange+" UNION "+
+		"SELECT contract_address FROM transactions"+inRange+" UNION "+
+		"SELECT from_address FROM traces"+inRange+" UNION "+
+		"SELECT to_address FROM traces"+inRange+" UNION "+
+		"SELECT miner FROM blocks WHERE number BETWEEN $1 AND $2 UNION "+
+		"SELECT miner FROM uncles"+inRange+" UNION "+
+		"SELECT from_address FROM tokentransfers"+inRange+" UNION "+
+		"SELECT to_address FROM tokentransfers"+inRange+
+		") a WHERE address <> ''", from, to)
+	if err != nil {
+		return nil, err
+	}
+	return accounts.list(), nil
+}
+
+// scanEach runs query and calls fn with every row, for results too large to
+// hold at once.
+func (e *PostgresDAO) scanEach(ctx context.Context, fn func(row scanner) error, query string, args ...interface{}) error {
+	ctx, cancel := e.timeout(ctx)
+	defer cancel()
+	rows, err := e.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (e *PostgresDAO) Holders(ctx context.Context, contract string, number uint64) ([]Holder, error) {
+	ledger := holderLedger{}
+	if contract != "" {
+		err := e.scanEach(ctx, func(row scanner) error {
+			t, err := scanTransfer(row)
+			if err != nil {
+				return err
+			}
+			ledger.add(transferMovements(t)...)
+			return nil
+		}, "SELECT "+transferColumns+" FROM tokentransfers WHERE contract = $1 AND block_number <= $2", contract, number)
+		if err != nil {
+			return nil, err
+		}
+		return ledger.list(), nil
+	}
+
+	ledger.add(e.Genesis.movements()...)
+	err := e.scanEach(ctx, func(row scanner) error {
+		t, err := scanTransaction(row)
+		if err != nil {
+			return err
+		}
+		ledger.add(txnMovements(t)...)
+		return nil
+	}, "SELECT "+transactionColumns+" FROM transactions WHERE block_number <= $1", number)
+	if err != nil {
+		return nil, err
+	}
+	err = e.scanEach(ctx, func(row scanner) error {
+		t, err := scanTrace(row)
+		if err != nil {
+			return err
+		}
+		ledger.add(traceMovements(t)...)
+		return nil
+	}, "SELECT "+traceColumns+" FROM traces WHERE block_number <= $1 AND error = ''", number)
+	if err != nil {
+		return nil, err
+	}
+	err = e.scanEach(ctx, func(row scanner) error {
+		b, err := scanBlock(row)
+		if err != nil {
+			return err
+		}
+		ledger.add(blockMovement(b))
+		return nil
+	}, "SELECT "+blockColumns+" FROM blocks WHERE number <= $1", number)
+	if err != nil {
+		return nil, err
+	}
+	err = e.scanEach(ctx, func(row scanner) error {
+		u, err := scanUncle(row)
+		if err != nil {
+			return err
+		}
+		ledger.add(uncleMovement(u))
+		return nil
+	}, "SELECT "+uncleColumns+" FROM uncles WHERE block_number <= $1", number)
+	if err != nil {
+		return nil, err
+	}
+	return ledger.list(), nil
+}
+
+const watchColumns = "id, address, contract, url, secret, confirmations, from_block, created_at"
+
+func scanWatch(row scanner) (Watch, error) {
+	var w Watch
+	err := row.Scan(&w.ID, &w.Address, &w.Contract, &w.URL, &w.Secret, &w.Confirmations, &w.FromBlock, &w.CreatedAt)
+	return w, err
+}
+
+func (e *PostgresDAO) Watch(ctx context.Context, id string) (Watch, error) {
+	ctx, cancel := e.timeout(ctx)
+	defer cancel()
+	w, err := scanWatch(e.db.QueryRowContext(ctx, "SELECT "+watchColumns+" FROM watches WHERE id = $1", id))
+	return w, notFound(err)
+}
+
+func (e *PostgresDAO) Watches(ctx context.Context) ([]Watch, error) {
+	var watches []Watch
+	err := e.scanEach(ctx, func(row scanner) error {
+		w, err := scanWatch(row)
+		if err != nil {
+			return err
+		}
+		watches = append(watches, w)
+		return nil
+	}, "SELECT "+watchColumns+" FROM watches ORDER BY id")
+	return watches, err
+}
+
+const deliveryColumns = `id, watch_id, event, block_number, block_hash, hash, txn, transfer, status, attempts,
+	next_attempt, last_attempt, response_status, error, created_at`
+
+func scanDelivery(row scanner) (Delivery, error) {
+	var d Delivery
+	var txn, transfer []byte
+	err := row.Scan(&d.ID, &d.WatchID, &d.Event, &d.BlockNumber, &d.BlockHash, &d.Hash, &txn, &transfer, &d.Status,
+		&d.Attempts, &d.NextAttempt, &d.LastAttempt, &d.ResponseStatus, &d.Error, &d.CreatedAt)
+	if err != nil {
+		return d, err
+	}
+	if len(txn) > 0 {
+		if err := json.Unmarshal(txn, &d.Transaction); err != nil {
+			return d, err
+		}
+	}
+	if len(transfer) > 0 {
+		err = json.Unmarshal(transfer, &d.Transfer)
+	}
+	return d, err
+}
+
+func (e *PostgresDAO) Delivery(ctx context.Context, id string) (Delivery, error) {
+	ctx, cancel := e.timeout(ctx)
+	defer cancel()
+	d, err := scanDelivery(e.db.QueryRowContext(ctx, "SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE id = $1", id))
+	return d, notFound(err)
+}
+
+func (e *PostgresDAO) Deliveries(ctx context.Context, filter DeliveryFilter, limit int) ([]Delivery, error) {
+	var deliveries []Delivery
+	err := e.scanEach(ctx, func(row scanner) error {
+		d, err := scanDelivery(row)
+		if err != nil {
+			return err
+		}
+		deliveries = append(deliveries, d)
+		return nil
+	}, "SELECT "+deliveryColumns+` FROM webhook_deliveries
+		WHERE ($1 = '' OR watch_id = $1) AND ($2 = '' OR status = $2)
+		ORDER BY block_number DESC, id DESC LIMIT NULLIF($3, 0)`, filter.WatchID, filter.Status, limit)
+	return deliveries, err
+}
+
+func (e *PostgresDAO) DueDeliveries(ctx context.Context, watchID string, toBlock uint64, now uint64, limit int) ([]Delivery, error) {
+	var deliveries []Delivery
+	err := e.scanEach(ctx, func(row scanner) error {
+		d, err := scanDelivery(row)
+		if err != nil {
+			return err
+		}
+		deliveries = append(deliveries, d)
+		return nil
+	}, "SELECT "+deliveryColumns+` FROM webhook_deliveries
+		WHERE watch_id = $1 AND status = 'pending' AND block_number <= $2 AND next_attempt <= $3
+		ORDER BY block_number, id LIMIT NULLIF($4, 0)`, watchID, toBlock, now, limit)
+	return deliveries, err
+}
+
+func (e *PostgresDAO) WatchCursor(ctx context.Context) (uint64, error) {
+	ctx, cancel := e.timeout(ctx)
+	defer cancel()
+	var cursor sql.NullInt64
+	if err := e.db.QueryRowContext(ctx, "SELECT watch_cursor FROM sysstore LIMIT 1").Scan(&cursor); err != nil {
+		return 0, notFound(err)
+	}
+	if !cursor.Valid {
+		return 0, ErrNotFound
+	}
+	return uint64(cursor.Int64), nil
+}
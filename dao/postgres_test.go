@@ -0,0 +1,52 @@
+package dao
+
+import (
+	"database/sql"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testPostgres connects to the server at the POSTGRES_URL url, skipping the
+// test without one, with a schema of its own that is dropped afterwards.
+func testPostgres(t *testing.T) *PostgresDAO {
+	server := os.Getenv("POSTGRES_URL")
+	if server == "" {
+		t.Skip("POSTGRES_URL is not set")
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := sql.Open("postgres", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := "spectrum_test_" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	})
+
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	db := &PostgresDAO{URL: u.String(), QueryTimeout: 10 * time.Second}
+	db.Connect()
+	t.Cleanup(db.Close)
+	return db
+}
+
+func TestPostgresRewriteBlock(t *testing.T) {
+	testRewriteBlock(t, testPostgres(t))
+}
+
+func TestPostgresForkBlock(t *testing.T) {
+	testForkBlock(t, testPostgres(t))
+}
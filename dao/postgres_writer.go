@@ -0,0 +1,274 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+func (e *PostgresDAO) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := e.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertBlock(ctx context.Context, tx *sql.Tx, table string, b Block) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO "+table+" ("+blockColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)",
+		b.Number, b.Hash, b.ParentHash, b.Sha3Uncles, b.Miner, b.Difficulty, b.TotalDifficulty, b.Size, b.GasUsed, b.GasLimit,
+		b.Nonce, b.Timestamp, b.Transactions, b.Uncles, b.BlockReward, b.UnclesReward, b.AvgGasPrice, b.TxFees, b.ExtraData)
+	return err
+}
+
+// deleteBlockRows removes everything stored for the block at number; logs
+// go with their transactions through ON DELETE CASCADE.
+func deleteBlockRows(ctx context.Context, tx *sql.Tx, number uint64) error {
+	for _, table := range []string{"transactions", "uncles", "tokentransfers", "traces"} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE block_number = $1", number); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *PostgresDAO) AddBlock(ctx context.Context, block Block, txns []Transaction, uncles []Uncle, transfers []TokenTransfer, traces []Trace) error {
+	return e.inTx(ctx, func(tx *sql.Tx) error {
+		if err := deleteBlockRows(ctx, tx, block.Number); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM blocks WHERE number = $1", block.Number); err != nil {
+			return err
+		}
+		hashes := make([]string, len(txns))
+		for i, t := range txns {
+			hashes[i] = t.Hash
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM transactions WHERE hash = ANY($1)", pq.Array(hashes)); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM traces WHERE hash = ANY($1)", pq.Array(hashes)); err != nil {
+			return err
+		}
+
+		for _, t := range txns {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO transactions ("+transactionColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)",
+				t.Hash, t.BlockHash, t.BlockNumber, t.Timestamp, t.Input, t.Value, t.Gas, t.GasUsed, t.GasPrice, t.Nonce,
+				t.TransactionIndex, t.From, t.To, t.ContractAddress, t.Status, t.CumulativeGasUsed, t.LogsBloom,
+				t.EffectiveGasPrice, t.Type); err != nil {
+				return err
+			}
+			for _, l := range t.Logs {
+				if _, err := tx.ExecContext(ctx, "INSERT INTO logs ("+logColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+					l.Address, pq.Array(l.Topics), l.Data, l.BlockNumber, l.TransactionIndex, l.TransactionHash, l.BlockHash,
+					l.LogIndex, l.Removed); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, u := range uncles {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO uncles ("+uncleColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)",
+				u.Number, u.Position, u.BlockNumber, u.Hash, u.ParentHash, u.Sha3Uncles, u.Miner, u.Difficulty, u.GasUsed,
+				u.GasLimit, u.Timestamp, u.Reward); err != nil {
+				return err
+			}
+		}
+
+		for _, t := range transfers {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO tokentransfers ("+transferColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+				t.BlockNumber, t.Hash, t.Timestamp, t.From, t.To, t.Value, t.Contract, t.Method); err != nil {
+				return err
+			}
+		}
+
+		for _, t := range traces {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO traces ("+traceColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)",
+				t.Hash, t.Index, t.BlockNumber, t.TransactionIndex, t.Timestamp, t.Type, t.From, t.To, t.Value, t.Gas, t.GasUsed,
+				t.Depth, t.Error); err != nil {
+				return err
+			}
+		}
+
+		return insertBlock(ctx, tx, "blocks", block)
+	})
+}
+
+// forkTransactions copies the transactions of a block to forkedtransactions
+// with their logs, which are deleted with the block, as a JSON array.
+const forkTransactions = `INSERT INTO forkedtransactions (` + transactionColumns + `, logs)
+SELECT ` + transactionColumns + `, COALESCE((
+    SELECT jsonb_agg(jsonb_build_object(
+        'address', l.address, 'topics', l.topics, 'data', l.data, 'blockNumber', l.block_number,
+        'transactionIndex', l.transaction_index, 'transactionHash', l.transaction_hash,
+        'blockHash', l.block_hash, 'logIndex', l.log_index, 'removed', l.removed) ORDER BY l.log_index)
+    FROM logs l WHERE l.transaction_hash = t.hash), '[]')
+FROM transactions t WHERE t.block_number = $1
+ON CONFLICT DO NOTHING`
+
+func (e *PostgresDAO) ForkBlock(ctx context.Context, number uint64) (Block, error) {
+	block, err := e.BlockByNumber(ctx, number)
+	if err != nil {
+		return block, err
+	}
+
+	err = e.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM forkedblocks WHERE hash = $1", block.Hash); err != nil {
+			return err
+		}
+		if err := insertBlock(ctx, tx, "forkedblocks", block); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, forkTransactions, number); err != nil {
+			return err
+		}
+		if err := deleteBlockRows(ctx, tx, number); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "DELETE FROM blocks WHERE number = $1", number)
+		return err
+	})
+	return block, err
+}
+
+func (e *PostgresDAO) SetLatestBlock(ctx context.Context, block Block) error {
+	latest, err := json.Marshal(block)
+	if err != nil {
+		return err
+	}
+	_, err = e.db.ExecContext(ctx, `INSERT INTO sysstore (id, timestamp, latest_block) VALUES (1, $1, $2)
+		ON CONFLICT (id) DO UPDATE SET timestamp = EXCLUDED.timestamp, latest_block = EXCLUDED.latest_block`,
+		time.Now().Unix(), latest)
+	return err
+}
+
+func (e *PostgresDAO) AddPrices(ctx context.Context, points []PricePoint) error {
+	return e.inTx(ctx, func(tx *sql.Tx) error {
+		for _, p := range points {
+			_, err := tx.ExecContext(ctx, "INSERT INTO prices ("+priceColumns+`) VALUES ($1, $2, $3, $4, $5)
+				ON CONFLICT (token, fiat, timestamp) DO UPDATE SET price = EXCLUDED.price, decimals = EXCLUDED.decimals`,
+				p.Token, p.Fiat, p.Timestamp, p.Price, p.Decimals)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (e *PostgresDAO) SetLabel(ctx context.Context, label Label) error {
+	_, err := e.db.ExecContext(ctx, "INSERT INTO labels ("+labelColumns+`) VALUES ($1, $2, $3)
+		ON CONFLICT (address) DO UPDATE SET name = EXCLUDED.name, tags = EXCLUDED.tags`,
+		label.Address, label.Name, pq.Array(label.Tags))
+	return err
+}
+
+func (e *PostgresDAO) DeleteLabel(ctx context.Context, address string) error {
+	res, err := e.db.ExecContext(ctx, "DELETE FROM labels WHERE address = $1", address)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (e *PostgresDAO) SetContractSource(ctx context.Context, s ContractSource) error {
+	sources, err := json.Marshal(s.Sources)
+	if err != nil {
+		return err
+	}
+	return e.inTx(ctx, func(tx *sql.Tx) error {
+		var stored ContractSource
+		err := tx.QueryRowContext(ctx, "SELECT address, match FROM contract_sources WHERE address = $1 FOR UPDATE", s.Address).
+			Scan(&stored.Address, &stored.Match)
+		if err == nil {
+			err = replaceSource(stored, s)
+		}
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO contract_sources (address, contract_name, compiler_version, optimization,
+			runs, evm_version, sources, abi, constructor_arguments, match, verified_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			ON CONFLICT (address) DO UPDATE SET contract_name = EXCLUDED.contract_name,
+			compiler_version = EXCLUDED.compiler_version, optimization = EXCLUDED.optimization, runs = EXCLUDED.runs,
+			evm_version = EXCLUDED.evm_version, sources = EXCLUDED.sources, abi = EXCLUDED.abi,
+			constructor_arguments = EXCLUDED.constructor_arguments, match = EXCLUDED.match, verified_at = EXCLUDED.verified_at`,
+			s.Address, s.ContractName, s.CompilerVersion, s.Optimization, s.Runs, s.EvmVersion,
+			sources, s.ABI, s.ConstructorArguments, s.Match, s.VerifiedAt)
+		return err
+	})
+}
+
+func (e *PostgresDAO) SetBalanceCheckpoint(ctx context.Context, b Balance) error {
+	tokens, err := json.Marshal(b.Tokens)
+	if err != nil {
+		return err
+	}
+	_, err = e.db.ExecContext(ctx, `INSERT INTO balance_checkpoints (address, block_number, balance, tokens)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (address, block_number) DO UPDATE SET balance = EXCLUDED.balance, tokens = EXCLUDED.tokens`,
+		b.Address, b.BlockNumber, b.Balance, tokens)
+	return err
+}
+
+func (e *PostgresDAO) SetWatch(ctx context.Context, w Watch) error {
+	_, err := e.db.ExecContext(ctx, "INSERT INTO watches ("+watchColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (id) DO UPDATE SET address = EXCLUDED.address, contract = EXCLUDED.contract, url = EXCLUDED.url,
+		secret = EXCLUDED.secret, confirmations = EXCLUDED.confirmations, from_block = EXCLUDED.from_block,
+		created_at = EXCLUDED.created_at`,
+		w.ID, w.Address, w.Contract, w.URL, w.Secret, w.Confirmations, w.FromBlock, w.CreatedAt)
+	return err
+}
+
+// DeleteWatch relies on webhook_deliveries referencing watches with ON
+// DELETE CASCADE to remove the deliveries.
+func (e *PostgresDAO) DeleteWatch(ctx context.Context, id string) error {
+	res, err := e.db.ExecContext(ctx, "DELETE FROM watches WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (e *PostgresDAO) SetDelivery(ctx context.Context, d Delivery) error {
+	txn, err := json.Marshal(d.Transaction)
+	if err != nil {
+		return err
+	}
+	transfer, err := json.Marshal(d.Transfer)
+	if err != nil {
+		return err
+	}
+	_, err = e.db.ExecContext(ctx, "INSERT INTO webhook_deliveries ("+deliveryColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		ON CONFLICT (id) DO UPDATE SET status = EXCLUDED.status, attempts = EXCLUDED.attempts,
+		next_attempt = EXCLUDED.next_attempt, last_attempt = EXCLUDED.last_attempt,
+		response_status = EXCLUDED.response_status, error = EXCLUDED.error`,
+		d.ID, d.WatchID, d.Event, d.BlockNumber, d.BlockHash, d.Hash, txn, transfer, d.Status,
+		d.Attempts, d.NextAttempt, d.LastAttempt, d.ResponseStatus, d.Error, d.CreatedAt)
+	return err
+}
+
+func (e *PostgresDAO) SetWatchCursor(ctx context.Context, number uint64) error {
+	_, err := e.db.ExecContext(ctx, `INSERT INTO sysstore (id, watch_cursor) VALUES (1, $1)
+		ON CONFLICT (id) DO UPDATE SET watch_cursor = EXCLUDED.watch_cursor`, number)
+	return err
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ubiq/spectrum-api/apierr"
+	. "github.com/ubiq/spectrum-api/dao"
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+var fiatCode = regexp.MustCompile(`^[a-z]{3,5}$`)
+
+// fiatParam reads ?fiat=, the currency values are converted to, empty when
+// it isn't given.
+func fiatParam(r *http.Request) (string, error) {
+	fiat := strings.ToLower(r.URL.Query().Get("fiat"))
+	if fiat != "" && !fiatCode.MatchString(fiat) {
+		return "", apierr.InvalidArgumentf("fiat must be a currency code like usd")
+	}
+	return fiat, nil
+}
+
+// priceSeries holds the prices of one coin or token over the timestamps of
+// a response: the point in force at the earliest and every later one.
+type priceSeries []PricePoint
+
+func loadPrices(ctx context.Context, token string, fiat string, from uint64, to uint64) (priceSeries, error) {
+	var series priceSeries
+	first, err := backend(ctx).PriceAt(ctx, token, fiat, from)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+	if err == nil {
+		series = append(series, first)
+	}
+	later, err := backend(ctx).PriceHistory(ctx, token, fiat, from+1, to, 0)
+	if err != nil {
+		return nil, err
+	}
+	return append(series, later...), nil
+}
+
+// at returns the latest price at or before timestamp.
+func (s priceSeries) at(timestamp uint64) (PricePoint, bool) {
+	i := sort.Search(len(s), func(i int) bool { return s[i].Timestamp > timestamp })
+	if i == 0 {
+		return PricePoint{}, false
+	}
+	return s[i-1], true
+}
+
+// decimalString formats r with up to 18 decimals, without trailing zeros.
+func decimalString(r *big.Rat) string {
+	s := r.FloatString(18)
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
+
+// valueFiat converts an amount in the smallest unit of p's coin or token,
+// empty if either isn't a number.
+func valueFiat(amount string, p PricePoint) string {
+	value, ok := new(big.Rat).SetString(amount)
+	if !ok {
+		return ""
+	}
+	price, ok := new(big.Rat).SetString(p.Price)
+	if !ok {
+		return ""
+	}
+	unit := new(big.Int).Exp(big.NewInt(10), new(big.Int).SetUint64(p.Decimals), nil)
+	value.Mul(value, price)
+	return decimalString(value.Quo(value, new(big.Rat).SetInt(unit)))
+}
+
+func timestampRange(n int, timestamp func(i int) uint64) (uint64, uint64) {
+	from, to := uint64(0), uint64(0)
+	for i := 0; i < n; i++ {
+		ts := timestamp(i)
+		if i == 0 || ts < from {
+			from = ts
+		}
+		if ts > to {
+			to = ts
+		}
+	}
+	return from, to
+}
+
+// fiatTxns sets ValueFiat on txns from the coin's price at their timestamp,
+// when the request asks for it with ?fiat=.
+func fiatTxns(r *http.Request, txns []Transaction) error {
+	fiat, err := fiatParam(r)
+	if err != nil || fiat == "" || len(txns) == 0 {
+		return err
+	}
+	from, to := timestampRange(len(txns), func(i int) uint64 { return txns[i].Timestamp })
+	series, err := loadPrices(r.Context(), "", fiat, from, to)
+	if err != nil {
+		return err
+	}
+	for i := range txns {
+		if p, ok := series.at(txns[i].Timestamp); ok {
+			txns[i].ValueFiat = valueFiat(txns[i].Value, p)
+		}
+	}
+	return nil
+}
+
+func fiatTxn(r *http.Request, txn *Transaction) error {
+	txns := []Transaction{*txn}
+	err := fiatTxns(r, txns)
+	*txn = txns[0]
+	return err
+}
+
+// fiatTransfers sets ValueFiat on transfers from their token's price at their
+// timestamp. Transfers of tokens without prices are left without one.
+func fiatTransfers(r *http.Request, transfers []TokenTransfer) error {
+	fiat, err := fiatParam(r)
+	if err != nil || fiat == "" || len(transfers) == 0 {
+		return err
+	}
+	byToken := map[string][]int{}
+	for i, t := range transfers {
+		byToken[t.Contract] = append(byToken[t.Contract], i)
+	}
+	for token, indexes := range byToken {
+		from, to := timestampRange(len(indexes), func(i int) uint64 { return transfers[indexes[i]].Timestamp })
+		series, err := loadPrices(r.Context(), token, fiat, from, to)
+		if err != nil {
+			return err
+		}
+		for _, i := range indexes {
+			if p, ok := series.at(transfers[i].Timestamp); ok {
+				transfers[i].ValueFiat = valueFiat(transfers[i].Value, p)
+			}
+		}
+	}
+	return nil
+}
+
+// getPriceHistory returns the prices of the coin, or of ?token=, in ?fiat=
+// between ?from= and ?to=, oldest first.
+func getPriceHistory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	fiat, err := fiatParam(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	if fiat == "" {
+		fiat = "usd"
+	}
+	token := strings.ToLower(q.Get("token"))
+	if token != "" && !validHex(token, []int{20}) {
+		respondWithError(w, r, apierr.InvalidArgumentf("token must be 0x-prefixed hex of 20 bytes"))
+		return
+	}
+
+	from, to := uint64(0), uint64(time.Now().Unix())
+	for name, v := range map[string]*uint64{"from": &from, "to": &to} {
+		if s := q.Get(name); s != "" {
+			n, err := strconv.ParseUint(s, 10, 64)
+			if err != nil {
+				respondWithError(w, r, apierr.InvalidArgumentf("%s must be a unix timestamp", name))
+				return
+			}
+			*v = n
+		}
+	}
+	limit := config_.RouteLimit("/price/history")
+	if v := q.Get("limit"); v != "" {
+		if limit, err = parseLimit(r, v); err != nil {
+			respondWithError(w, r, err)
+			return
+		}
+	}
+
+	points, err := backend(r.Context()).PriceHistory(r.Context(), token, fiat, from, to, limit)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	if points == nil {
+		points = []PricePoint{}
+	}
+	respondWithJson(w, r, http.StatusOK, points)
+}
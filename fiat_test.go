@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+func TestValueFiat(t *testing.T) {
+	tests := []struct {
+		amount string
+		price  PricePoint
+		want   string
+	}{
+		{amount: "1000000000000000000", price: PricePoint{Price: "0.25", Decimals: 18}, want: "0.25"},
+		{amount: "1500000000000000000", price: PricePoint{Price: "2", Decimals: 18}, want: "3"},
+		{amount: "1", price: PricePoint{Price: "1", Decimals: 18}, want: "0.000000000000000001"},
+		{amount: "1", price: PricePoint{Price: "0.1", Decimals: 18}, want: "0"},
+		{amount: "12345678", price: PricePoint{Price: "1e3", Decimals: 8}, want: "123.45678"},
+		{amount: "7", price: PricePoint{Price: "1.5", Decimals: 0}, want: "10.5"},
+		{amount: "0", price: PricePoint{Price: "300", Decimals: 18}, want: "0"},
+		{amount: "", price: PricePoint{Price: "1", Decimals: 18}, want: ""},
+		{amount: "ten", price: PricePoint{Price: "1", Decimals: 18}, want: ""},
+		{amount: "1", price: PricePoint{Price: "", Decimals: 18}, want: ""},
+	}
+	for _, tt := range tests {
+		if got := valueFiat(tt.amount, tt.price); got != tt.want {
+			t.Errorf("valueFiat(%q, %s with %d decimals) = %q, want %q", tt.amount, tt.price.Price, tt.price.Decimals, got, tt.want)
+		}
+	}
+}
+
+func TestPriceSeriesAt(t *testing.T) {
+	series := priceSeries{
+		{Timestamp: 100, Price: "1"},
+		{Timestamp: 200, Price: "2"},
+		{Timestamp: 200, Price: "2.5"},
+		{Timestamp: 300, Price: "3"},
+	}
+	tests := []struct {
+		timestamp uint64
+		want      string
+		ok        bool
+	}{
+		{timestamp: 0},
+		{timestamp: 99},
+		{timestamp: 100, want: "1", ok: true},
+		{timestamp: 199, want: "1", ok: true},
+		{timestamp: 200, want: "2.5", ok: true},
+		{timestamp: 299, want: "2.5", ok: true},
+		{timestamp: 300, want: "3", ok: true},
+		{timestamp: 1 << 40, want: "3", ok: true},
+	}
+	for _, tt := range tests {
+		p, ok := series.at(tt.timestamp)
+		if ok != tt.ok || p.Price != tt.want {
+			t.Errorf("at(%d) = %q, %v, want %q, %v", tt.timestamp, p.Price, ok, tt.want, tt.ok)
+		}
+	}
+	if _, ok := (priceSeries{}).at(100); ok {
+		t.Error("an empty series has a price")
+	}
+}
+
+func TestTimestampRange(t *testing.T) {
+	timestamps := []uint64{300, 100, 500, 200}
+	from, to := timestampRange(len(timestamps), func(i int) uint64 { return timestamps[i] })
+	if from != 100 || to != 500 {
+		t.Errorf("range = %d..%d, want 100..500", from, to)
+	}
+	if from, to := timestampRange(0, nil); from != 0 || to != 0 {
+		t.Errorf("empty range = %d..%d", from, to)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/ubiq/spectrum-api/apierr"
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+// Percentiles of recent gas prices suggested by GET /gas.
+const (
+	safePercentile     = 30
+	standardPercentile = 60
+	fastPercentile     = 90
+)
+
+// gasBlock is a block's gas summary along with the prices it was computed
+// from, sorted ascending.
+type gasBlock struct {
+	GasBlock
+	hash   string
+	prices []*big.Int
+}
+
+// gasOracle caches the gas summaries of a chain's latest blocks. It is refreshed
+// when a request sees a new head, fetching only the blocks it doesn't hold.
+type gasOracle struct {
+	mu     sync.Mutex
+	head   string
+	blocks []gasBlock // newest first
+}
+
+// percentile returns the p-th percentile of sorted by nearest rank, or 0 if
+// it is empty.
+func percentile(sorted []*big.Int, p int) *big.Int {
+	if len(sorted) == 0 {
+		return new(big.Int)
+	}
+	i := (len(sorted)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func sortPrices(prices []*big.Int) {
+	sort.Slice(prices, func(i, j int) bool { return prices[i].Cmp(prices[j]) < 0 })
+}
+
+func summarize(ctx context.Context, block Block) (gasBlock, error) {
+	txns, err := backend(ctx).TransactionsByBlockNumber(ctx, block.Number)
+	if err != nil {
+		return gasBlock{}, err
+	}
+	g := gasBlock{hash: block.Hash}
+	for _, txn := range txns {
+		if price, ok := new(big.Int).SetString(txn.GasPrice, 10); ok {
+			g.prices = append(g.prices, price)
+		}
+	}
+	sortPrices(g.prices)
+
+	g.Number = block.Number
+	g.Timestamp = block.Timestamp
+	g.Transactions = uint64(len(txns))
+	g.MinGasPrice = percentile(g.prices, 0).String()
+	g.MedianGasPrice = percentile(g.prices, 50).String()
+	g.MaxGasPrice = percentile(g.prices, 100).String()
+	g.GasUsed = block.GasUsed
+	g.GasLimit = block.GasLimit
+	if block.GasLimit > 0 {
+		g.Utilization = float64(block.GasUsed) / float64(block.GasLimit)
+	}
+	return g, nil
+}
+
+// refresh brings the cache up to the latest gasOracleBlocks blocks. Blocks
+// already summarized are reused when their hash still matches, so a reorg
+// only refetches the blocks it replaced.
+func (o *gasOracle) refresh(ctx context.Context) ([]gasBlock, error) {
+	latest, err := backend(ctx).LatestBlocks(ctx, config_.GasOracleBlocks)
+	if err != nil {
+		return nil, err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(latest) > 0 && latest[0].Hash == o.head {
+		return o.blocks, nil
+	}
+
+	cached := make(map[string]gasBlock, len(o.blocks))
+	for _, g := range o.blocks {
+		cached[g.hash] = g
+	}
+	blocks := make([]gasBlock, 0, len(latest))
+	for _, b := range latest {
+		g, ok := cached[b.Hash]
+		if !ok {
+			if g, err = summarize(ctx, b); err != nil {
+				return nil, err
+			}
+		}
+		blocks = append(blocks, g)
+	}
+
+	o.blocks = blocks
+	if len(latest) > 0 {
+		o.head = latest[0].Hash
+	}
+	return o.blocks, nil
+}
+
+func getGasPrices(w http.ResponseWriter, r *http.Request) {
+	blocks, err := chainOf(r.Context()).gas.refresh(r.Context())
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	var prices []*big.Int
+	for _, g := range blocks {
+		prices = append(prices, g.prices...)
+	}
+	sortPrices(prices)
+
+	res := GasPrices{
+		Safe:     percentile(prices, safePercentile).String(),
+		Standard: percentile(prices, standardPercentile).String(),
+		Fast:     percentile(prices, fastPercentile).String(),
+		Blocks:   uint64(len(blocks)),
+		Samples:  uint64(len(prices)),
+	}
+	if len(blocks) > 0 {
+		res.BlockNumber = blocks[0].Number
+	}
+	respondWithJson(w, r, http.StatusOK, res)
+}
+
+// getGasHistory returns the gas summary of the latest ?blocks= blocks, at
+// most gasOracleBlocks.
+func getGasHistory(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if v := r.URL.Query().Get("blocks"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			respondWithError(w, r, apierr.InvalidArgumentf("blocks must be a positive number"))
+			return
+		}
+	}
+
+	blocks, err := chainOf(r.Context()).gas.refresh(r.Context())
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	if len(blocks) > limit {
+		blocks = blocks[:limit]
+	}
+
+	history := make([]GasBlock, len(blocks))
+	for i, g := range blocks {
+		history[i] = g.GasBlock
+	}
+	respondWithJson(w, r, http.StatusOK, history)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestPercentile(t *testing.T) {
+	prices := func(ns ...int64) []*big.Int {
+		list := make([]*big.Int, len(ns))
+		for i, n := range ns {
+			list[i] = big.NewInt(n)
+		}
+		return list
+	}
+	ten := prices(1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+	tests := []struct {
+		sorted []*big.Int
+		p      int
+		want   int64
+	}{
+		{sorted: nil, p: 50, want: 0},
+		{sorted: prices(7), p: 0, want: 7},
+		{sorted: prices(7), p: 50, want: 7},
+		{sorted: prices(7), p: 100, want: 7},
+		{sorted: prices(1, 2), p: 50, want: 1},
+		{sorted: prices(1, 2), p: 51, want: 2},
+		{sorted: ten, p: 0, want: 1},
+		{sorted: ten, p: 1, want: 1},
+		{sorted: ten, p: 11, want: 2},
+		{sorted: ten, p: 50, want: 5},
+		{sorted: ten, p: 90, want: 9},
+		{sorted: ten, p: 91, want: 10},
+		{sorted: ten, p: 100, want: 10},
+	}
+	for _, tt := range tests {
+		if got := percentile(tt.sorted, tt.p); got.Int64() != tt.want {
+			t.Errorf("percentile(%v, %d) = %s, want %d", tt.sorted, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestSortPrices(t *testing.T) {
+	list := []*big.Int{big.NewInt(30), new(big.Int).Lsh(big.NewInt(1), 70), big.NewInt(1), big.NewInt(20)}
+	sortPrices(list)
+	for i := 1; i < len(list); i++ {
+		if list[i-1].Cmp(list[i]) > 0 {
+			t.Fatalf("not sorted: %v", list)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	. "github.com/ubiq/spectrum-api/dao"
+	. "github.com/ubiq/spectrum-api/models"
+	"github.com/ubiq/spectrum-api/prices"
+)
+
+// runImport loads a mongoexport dump into the embedded database.
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	collection := fs.String("collection", "", "mongo collection the dump was exported from (e.g blocks)")
+	file := fs.String("file", "", "mongoexport output, one document per line or a json array")
+	name := fs.String("chain", "", "chain to import into (default defaultChain)")
+	loadConfig(fs, args)
+
+	if *collection == "" || *file == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	bolt, ok := openBackend(configuredChain(*name)).(*BoltDAO)
+	if !ok {
+		log.Fatal("import requires backend = \"bolt\"")
+	}
+	defer bolt.Close()
+
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	n, err := bolt.ImportMongoExport(*collection, f)
+	if err != nil {
+		log.Fatalf("Import stopped after %d documents: %v", n, err)
+	}
+	log.Infof("Imported %d %s", n, *collection)
+}
+
+// runPrices loads a csv of historical prices, as read by prices.ReadCSV, into
+// a chain's database.
+func runPrices(args []string) {
+	fs := flag.NewFlagSet("prices", flag.ExitOnError)
+	file := fs.String("file", "", "csv of timestamp,price rows, or with a header naming timestamp, price, fiat, token and decimals columns")
+	fiat := fs.String("fiat", "usd", "currency of rows without a fiat column")
+	token := fs.String("token", "", "token contract of rows without a token column (default the chain's coin)")
+	decimals := fs.Uint64("decimals", 18, "decimals of rows without a decimals column")
+	name := fs.String("chain", "", "chain to import into (default defaultChain)")
+	loadConfig(fs, args)
+
+	if *file == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	db := openBackend(configuredChain(*name))
+	defer db.Close()
+	writer, ok := db.(Writer)
+	if !ok {
+		log.Fatal("The backend can't store prices")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	defaults := PricePoint{Fiat: strings.ToLower(*fiat), Token: strings.ToLower(*token), Decimals: *decimals}
+	points, err := prices.ReadCSV(f, defaults)
+	if err != nil {
+		log.Fatal(*file, ": ", err)
+	}
+	if err := writer.AddPrices(context.Background(), points); err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("Imported %d prices", len(points))
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/ubiq/spectrum-api/indexer"
+)
+
+// runIndex fills the configured backend from a node: a backfill of a fixed
+// range when -to is given, otherwise following the chain from the stored
+// head until interrupted.
+func runIndex(args []string) {
+	fs := flag.NewFlagSet("index", flag.ExitOnError)
+	from := fs.Uint64("from", 0, "first block to index (default indexStart)")
+	to := fs.Uint64("to", 0, "backfill from..to and exit instead of following the chain")
+	workers := fs.Int("workers", 0, "blocks fetched concurrently during a backfill (default indexWorkers)")
+	name := fs.String("chain", "", "chain to index (default defaultChain)")
+	loadConfig(fs, args)
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if !set["from"] {
+		*from = config_.IndexStart
+	}
+	if !set["workers"] {
+		*workers = config_.IndexWorkers
+	}
+
+	ch := configuredChain(*name)
+	dao := openBackend(ch)
+	db, ok := dao.(indexer.Database)
+	if !ok {
+		log.Fatal("Backend ", config_.Backend, " can't be indexed into")
+	}
+	defer dao.Close()
+
+	ix := &indexer.Indexer{
+		RPC:                indexer.NewRPC(ch.RpcUrl),
+		DB:                 db,
+		Start:              *from,
+		PollInterval:       config_.IndexPollInterval,
+		Workers:            *workers,
+		MaxReorgDepth:      config_.MaxReorgDepth,
+		Tracer:             config_.IndexTracer,
+		CheckpointInterval: config_.BalanceCheckpointInterval,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if *to > 0 {
+		log.Infof("Backfilling blocks %d to %d from %s", *from, *to, ch.RpcUrl)
+		if err := ix.Backfill(ctx, *from, *to); err != nil {
+			log.Error("Backfill stopped: ", err)
+			os.Exit(1)
+		}
+		log.Info("Backfill done")
+		return
+	}
+
+	log.Info("Following ", ch.RpcUrl)
+	if err := ix.Follow(ctx); err != nil && err != context.Canceled {
+		log.Error("Indexer stopped: ", err)
+		os.Exit(1)
+	}
+	log.Info("Indexer stopped")
+}
@@ -0,0 +1,55 @@
+package indexer
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/ubiq/spectrum-api/dao"
+)
+
+// settledCheckpoint returns the checkpoint that indexing block number
+// settles: the block MaxReorgDepth blocks behind it, which Follow never
+// unwinds, when that is a multiple of CheckpointInterval.
+func (ix *Indexer) settledCheckpoint(number uint64) (uint64, bool) {
+	interval, depth := ix.CheckpointInterval, uint64(ix.MaxReorgDepth)
+	if interval == 0 || number < interval+depth {
+		return 0, false
+	}
+	settled := number - depth
+	return settled, settled%interval == 0
+}
+
+// checkpoint stores the balances at the end of block number of every
+// account that moved funds since the previous checkpoint block, summed from
+// the account's latest checkpoint. The native balance is the node's when it
+// answers, so that what the ledger can't see, like blocks before the index
+// start, is accounted for from there on.
+func (ix *Indexer) checkpoint(ctx context.Context, number uint64) error {
+	from := uint64(0)
+	if number >= ix.CheckpointInterval {
+		from = number - ix.CheckpointInterval + 1
+	}
+	accounts, err := ix.DB.BalanceAccounts(ctx, from, number)
+	if err != nil {
+		return err
+	}
+	for _, address := range accounts {
+		b, err := dao.BalanceAt(ctx, ix.DB, address, number)
+		if err != nil {
+			return err
+		}
+		fields := log.Fields{"address": address, "block": number}
+		if node, err := ix.RPC.Balance(ctx, address, number); err != nil {
+			log.WithFields(fields).Debug("No balance from the node: ", err)
+		} else if node.String() != b.Balance {
+			fields["ledger"], fields["node"] = b.Balance, node.String()
+			log.WithFields(fields).Warn("Balance checkpoint differs from the node's, keeping the node's")
+			b.Balance = node.String()
+		}
+		if err := ix.DB.SetBalanceCheckpoint(ctx, b); err != nil {
+			return err
+		}
+	}
+	log.Debugf("Stored the balances of %d accounts at block %d", len(accounts), number)
+	return nil
+}
@@ -0,0 +1,291 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/ubiq/spectrum-api/dao"
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+// Database is what the indexer needs from a backend: the reads used to find
+// where it left off, and the writes.
+type Database interface {
+	dao.Backend
+	dao.Writer
+}
+
+// Indexer follows a node over json-rpc and writes blocks, transactions,
+// uncles and token transfers in the schema the api reads.
+type Indexer struct {
+	RPC *RPC
+	DB  Database
+
+	// Start is the first block indexed into an empty store.
+	Start uint64
+	// PollInterval is how often Follow asks the node for a new head.
+	PollInterval time.Duration
+	// Workers is the number of blocks Backfill fetches concurrently.
+	Workers int
+	// MaxReorgDepth bounds how far Follow unwinds before giving up.
+	MaxReorgDepth int
+	// Policy is the block reward schedule, UbiqMonetaryPolicy if nil.
+	Policy []RewardStep
+	// Tracer is TraceDebug or TraceParity to index internal transactions,
+	// or empty to skip them.
+	Tracer string
+	// CheckpointInterval is the number of blocks between the balance
+	// checkpoints stored for the accounts, 0 to store none.
+	CheckpointInterval uint64
+}
+
+// fetched is a block with everything the indexer stores for it.
+type fetched struct {
+	block     Block
+	txns      []Transaction
+	uncles    []Uncle
+	transfers []TokenTransfer
+	traces    []Trace
+}
+
+func (ix *Indexer) policy() []RewardStep {
+	if ix.Policy == nil {
+		return UbiqMonetaryPolicy
+	}
+	return ix.Policy
+}
+
+// Head returns the node's latest block number.
+func (ix *Indexer) Head(ctx context.Context) (uint64, error) {
+	var head string
+	if err := ix.RPC.Call(ctx, &head, "eth_blockNumber"); err != nil {
+		return 0, err
+	}
+	return hexUint(head), nil
+}
+
+// fetch reads block number with its receipts, uncles and, if a Tracer is
+// set, traces from the node.
+func (ix *Indexer) fetch(ctx context.Context, number uint64) (*fetched, error) {
+	var raw rpcBlock
+	if err := ix.RPC.Call(ctx, &raw, "eth_getBlockByNumber", hexNumber(number), true); err != nil {
+		return nil, fmt.Errorf("block %d: %v", number, err)
+	}
+
+	receipts := make([]rpcReceipt, len(raw.Transactions))
+	uncles := make([]rpcBlock, len(raw.Uncles))
+	batch := make([]BatchElem, 0, len(receipts)+len(uncles))
+	for i, t := range raw.Transactions {
+		batch = append(batch, BatchElem{Method: "eth_getTransactionReceipt", Params: []interface{}{t.Hash}, Result: &receipts[i]})
+	}
+	for i := range raw.Uncles {
+		batch = append(batch, BatchElem{Method: "eth_getUncleByBlockHashAndIndex", Params: []interface{}{raw.Hash, hexNumber(uint64(i))}, Result: &uncles[i]})
+	}
+	if err := ix.RPC.BatchCall(ctx, batch); err != nil {
+		return nil, fmt.Errorf("block %d: %v", number, err)
+	}
+	for _, e := range batch {
+		if e.Error != nil {
+			return nil, fmt.Errorf("block %d: %s %v: %v", number, e.Method, e.Params, e.Error)
+		}
+	}
+
+	f := &fetched{block: raw.header()}
+	for i := range raw.Transactions {
+		txn := raw.Transactions[i].transaction(f.block.Timestamp, &receipts[i])
+		f.txns = append(f.txns, txn)
+		f.transfers = append(f.transfers, transfers(txn)...)
+	}
+	for i := range uncles {
+		f.uncles = append(f.uncles, uncles[i].uncle(i, number))
+	}
+	applyRewards(ix.policy(), &f.block, f.uncles, f.txns)
+
+	var err error
+	if f.traces, err = ix.traces(ctx, number, f.txns); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (ix *Indexer) write(ctx context.Context, f *fetched) error {
+	return ix.DB.AddBlock(ctx, f.block, f.txns, f.uncles, f.transfers, f.traces)
+}
+
+// Backfill indexes blocks from..to inclusive with Workers concurrent
+// fetches, and records to as the head and stores the balance checkpoints
+// the range settles when it is done. It does not check
+// parent hashes, so it is meant for ranges deep enough to be final; Follow
+// handles the tip.
+func (ix *Indexer) Backfill(ctx context.Context, from, to uint64) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := ix.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	numbers := make(chan uint64)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range numbers {
+				f, err := ix.fetch(ctx, n)
+				if err == nil {
+					err = ix.write(ctx, f)
+				}
+				if err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+				if n%1000 == 0 {
+					log.Info("Backfilled block ", n)
+				}
+			}
+		}()
+	}
+
+feed:
+	for n := from; n <= to; n++ {
+		select {
+		case numbers <- n:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(numbers)
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		return err
+	default:
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := ix.advanceHead(ctx, to); err != nil {
+		return err
+	}
+	for n := from; n <= to; n++ {
+		if number, ok := ix.settledCheckpoint(n); ok {
+			if err := ix.checkpoint(ctx, number); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// advanceHead records block number as the head once a backfill up to it is
+// complete, unless the stored head is already past it.
+func (ix *Indexer) advanceHead(ctx context.Context, number uint64) error {
+	store, err := ix.DB.Store(ctx)
+	if err != nil && err != dao.ErrNotFound {
+		return err
+	}
+	if err == nil && store.LatestBlock.Hash != "" && store.LatestBlock.Number >= number {
+		return nil
+	}
+	block, err := ix.DB.BlockByNumber(ctx, number)
+	if err != nil {
+		return err
+	}
+	return ix.DB.SetLatestBlock(ctx, block)
+}
+
+// Follow indexes new blocks as the node sees them until ctx is done.
+func (ix *Indexer) Follow(ctx context.Context) error {
+	for {
+		if err := ix.sync(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Error("Sync failed: ", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(ix.PollInterval):
+		}
+	}
+}
+
+// sync indexes everything between the stored head and the node's head. When
+// the next block's parent isn't the stored head, the chain has reorganized:
+// stored blocks are moved to forkedblocks until one is an ancestor of the
+// node's chain again.
+func (ix *Indexer) sync(ctx context.Context) error {
+	head, err := ix.Head(ctx)
+	if err != nil {
+		return err
+	}
+
+	local, err := ix.DB.LatestBlock(ctx)
+	empty := err == dao.ErrNotFound
+	if err != nil && !empty {
+		return err
+	}
+
+	next := local.Number + 1
+	if empty {
+		next = ix.Start
+	}
+
+	depth := 0
+	for next <= head {
+		f, err := ix.fetch(ctx, next)
+		if err != nil {
+			return err
+		}
+
+		if !empty && f.block.ParentHash != local.Hash {
+			if depth++; depth > ix.MaxReorgDepth {
+				return fmt.Errorf("reorg at block %d deeper than %d blocks", next, ix.MaxReorgDepth)
+			}
+			orphan, err := ix.DB.ForkBlock(ctx, local.Number)
+			if err != nil {
+				return err
+			}
+			log.Warnf("Reorg: block %d %s orphaned", orphan.Number, orphan.Hash)
+
+			// The first indexed block has no stored parent to compare
+			// with, so indexing starts over from it.
+			if orphan.Number <= ix.Start {
+				local, empty, next = Block{}, true, orphan.Number
+				continue
+			}
+			if local, err = ix.DB.BlockByNumber(ctx, orphan.Number-1); err != nil {
+				return err
+			}
+			next = local.Number + 1
+			continue
+		}
+
+		if err := ix.write(ctx, f); err != nil {
+			return err
+		}
+		if err := ix.DB.SetLatestBlock(ctx, f.block); err != nil {
+			return err
+		}
+		// A checkpoint that can't be stored only slows balance lookups
+		// down, so it doesn't hold up the chain.
+		if number, ok := ix.settledCheckpoint(f.block.Number); ok {
+			if err := ix.checkpoint(ctx, number); err != nil {
+				log.WithField("block", number).Warn("Can't store balance checkpoints: ", err)
+			}
+		}
+		local, empty, depth = f.block, false, 0
+		next++
+	}
+	return nil
+}
@@ -0,0 +1,526 @@
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ubiq/spectrum-api/dao"
+)
+
+// fakeNode answers the json-rpc calls the indexer makes from blocks held in
+// memory, either recorded from a node or made up by chain.
+type fakeNode struct {
+	mu       sync.Mutex
+	blocks   map[uint64]json.RawMessage
+	receipts map[string]json.RawMessage
+	uncles   map[string][]json.RawMessage
+	calls    map[string]json.RawMessage
+	traces   map[uint64]json.RawMessage
+	// balances are what eth_getBalance answers for every account, by block
+	// number. Other blocks have no state.
+	balances map[uint64]string
+	// pending is the node's pending pool by hash, answered by txpool_content,
+	// or by eth_pendingTransactions when noTxpool is set.
+	pending  map[string]rpcTransaction
+	noTxpool bool
+}
+
+func newFakeNode(t *testing.T) (*fakeNode, *RPC) {
+	n := &fakeNode{
+		blocks:   map[uint64]json.RawMessage{},
+		receipts: map[string]json.RawMessage{},
+		uncles:   map[string][]json.RawMessage{},
+		calls:    map[string]json.RawMessage{},
+		traces:   map[uint64]json.RawMessage{},
+		balances: map[uint64]string{},
+		pending:  map[string]rpcTransaction{},
+	}
+	srv := httptest.NewServer(n)
+	t.Cleanup(srv.Close)
+	return n, NewRPC(srv.URL)
+}
+
+// recorded is a block as recorded from a node with what the indexer asks
+// about it.
+type recorded struct {
+	Block    json.RawMessage            `json:"block"`
+	Receipts map[string]json.RawMessage `json:"receipts"`
+	Uncles   []json.RawMessage          `json:"uncles"`
+}
+
+// load serves the block recorded in testdata/name.
+func (n *fakeNode) load(t *testing.T, name string) rpcBlock {
+	var rec recorded
+	readFixture(t, name, &rec)
+	var b rpcBlock
+	if err := json.Unmarshal(rec.Block, &b); err != nil {
+		t.Fatal(err)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.blocks[hexUint(b.Number)] = rec.Block
+	for hash, r := range rec.Receipts {
+		n.receipts[hash] = r
+	}
+	n.uncles[b.Hash] = rec.Uncles
+	return b
+}
+
+// chainHash is the made up hash of block number on fork.
+func chainHash(fork string, number uint64) string {
+	return fmt.Sprintf("0x%s%062x", fork, number)
+}
+
+// chainMiner mines every block chain makes up.
+const chainMiner = "0x3fb9f5e5b1e8b9d1d95e0b5ee1d3b83fa6d0e6a9"
+
+// chain serves empty blocks from..to, with the blocks from forkAt on having
+// hashes of fork instead of "aa".
+func (n *fakeNode) chain(from, to, forkAt uint64, fork string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for k := range n.blocks {
+		delete(n.blocks, k)
+	}
+	hash := func(number uint64) string {
+		if number >= forkAt {
+			return chainHash(fork, number)
+		}
+		return chainHash("aa", number)
+	}
+	for i := from; i <= to; i++ {
+		parent := ""
+		if i > 0 {
+			parent = hash(i - 1)
+		}
+		b := rpcBlock{
+			Number:          hexNumber(i),
+			Hash:            hash(i),
+			ParentHash:      parent,
+			Miner:           chainMiner,
+			Difficulty:      "0x1",
+			TotalDifficulty: hexNumber(i + 1),
+			Size:            "0x21c",
+			GasLimit:        "0x7a1200",
+			GasUsed:         "0x0",
+			Nonce:           "0x0",
+			Timestamp:       hexNumber(1500000000 + 88*i),
+			ExtraData:       "0x",
+			Transactions:    []rpcTransaction{},
+			Uncles:          []string{},
+		}
+		n.blocks[i], _ = json.Marshal(b)
+	}
+}
+
+func (n *fakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		var reqs []rpcRequest
+		json.Unmarshal(body, &reqs)
+		res := make([]map[string]interface{}, len(reqs))
+		for i, req := range reqs {
+			res[i] = n.answer(req)
+		}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	var req rpcRequest
+	json.Unmarshal(body, &req)
+	json.NewEncoder(w).Encode(n.answer(req))
+}
+
+func (n *fakeNode) answer(req rpcRequest) map[string]interface{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	param := func(i int) string {
+		s, _ := req.Params[i].(string)
+		return s
+	}
+	var result interface{}
+	switch req.Method {
+	case "eth_blockNumber":
+		var head uint64
+		for number := range n.blocks {
+			if number > head {
+				head = number
+			}
+		}
+		result = hexNumber(head)
+	case "eth_getBlockByNumber":
+		if b, ok := n.blocks[hexUint(param(0))]; ok {
+			result = b
+		}
+	case "eth_getTransactionReceipt":
+		if r, ok := n.receipts[param(0)]; ok {
+			result = r
+		}
+	case "eth_getUncleByBlockHashAndIndex":
+		uncles := n.uncles[param(0)]
+		if i := hexUint(param(1)); i < uint64(len(uncles)) {
+			result = uncles[i]
+		}
+	case "debug_traceTransaction":
+		if c, ok := n.calls[param(0)]; ok {
+			result = c
+		}
+	case "trace_block":
+		if t, ok := n.traces[hexUint(param(0))]; ok {
+			result = t
+		}
+	case "eth_getBalance":
+		b, ok := n.balances[hexUint(param(1))]
+		if !ok {
+			return map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "error": rpcError{Code: -32000, Message: "missing trie node"}}
+		}
+		result = b
+	case "txpool_content":
+		if n.noTxpool {
+			return map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "error": rpcError{Code: -32601, Message: "the method txpool_content does not exist"}}
+		}
+		content := txpoolContent{Pending: map[string]map[string]rpcTransaction{}}
+		for _, t := range n.pending {
+			if content.Pending[t.From] == nil {
+				content.Pending[t.From] = map[string]rpcTransaction{}
+			}
+			content.Pending[t.From][fmt.Sprint(hexUint(t.Nonce))] = t
+		}
+		result = content
+	case "eth_pendingTransactions":
+		txns := []rpcTransaction{}
+		for _, t := range n.pending {
+			txns = append(txns, t)
+		}
+		result = txns
+	default:
+		return map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "error": rpcError{Code: -32601, Message: "the method " + req.Method + " does not exist"}}
+	}
+	return map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": result}
+}
+
+func newTestIndexer(t *testing.T, rpc *RPC) *Indexer {
+	db := &dao.BoltDAO{DataDir: t.TempDir()}
+	db.Connect()
+	t.Cleanup(db.Close)
+	return &Indexer{RPC: rpc, DB: db, PollInterval: 10 * time.Millisecond, Workers: 3, MaxReorgDepth: 4}
+}
+
+// stored returns the hashes of the stored blocks from..to, with "" for the
+// missing ones.
+func stored(t *testing.T, ix *Indexer, from, to uint64) []string {
+	var hashes []string
+	for i := from; i <= to; i++ {
+		b, err := ix.DB.BlockByNumber(context.Background(), i)
+		if err != nil && err != dao.ErrNotFound {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, b.Hash)
+	}
+	return hashes
+}
+
+func chainHashes(fork string, from, to uint64) []string {
+	var hashes []string
+	for i := from; i <= to; i++ {
+		hashes = append(hashes, chainHash(fork, i))
+	}
+	return hashes
+}
+
+func TestFetch(t *testing.T) {
+	node, rpc := newFakeNode(t)
+	raw := node.load(t, "block_436.json")
+	ix := newTestIndexer(t, rpc)
+
+	f, err := ix.fetch(context.Background(), 436)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := f.block
+	if b.Number != 436 || b.Hash != raw.Hash || b.Transactions != 2 || b.Uncles != 1 || b.Timestamp != 0x5a2f1e3c {
+		t.Errorf("block = %d %s with %d txns, %d uncles at %d", b.Number, b.Hash, b.Transactions, b.Uncles, b.Timestamp)
+	}
+	// 8 UBQ, plus 8/32 for the uncle; fees are gasUsed * gasPrice summed.
+	if b.BlockReward != "8000000000000000000" || b.UnclesReward != "250000000000000000" {
+		t.Errorf("rewards = %s, %s", b.BlockReward, b.UnclesReward)
+	}
+	if b.TxFees != "1350080000000000" || b.AvgGasPrice != "25000000000" {
+		t.Errorf("fees = %s, avg gas price %s", b.TxFees, b.AvgGasPrice)
+	}
+
+	if len(f.txns) != 2 {
+		t.Fatalf("%d txns, want 2", len(f.txns))
+	}
+	txn := f.txns[0]
+	if txn.GasUsed != 0x8ca4 || txn.Status == nil || *txn.Status != 1 || len(txn.Logs) != 1 || txn.EffectiveGasPrice != "20000000000" {
+		t.Errorf("txn from receipt = gas %d, status %v, %d logs, effective price %s", txn.GasUsed, txn.Status, len(txn.Logs), txn.EffectiveGasPrice)
+	}
+	if f.txns[1].Value != "1000000000000000000" || f.txns[1].TransactionIndex != 1 {
+		t.Errorf("second txn = value %s at %d", f.txns[1].Value, f.txns[1].TransactionIndex)
+	}
+
+	if len(f.uncles) != 1 || f.uncles[0].Number != 435 || f.uncles[0].BlockNumber != 436 || f.uncles[0].Reward != "4000000000000000000" {
+		t.Errorf("uncles = %+v", f.uncles)
+	}
+
+	if len(f.transfers) != 1 {
+		t.Fatalf("%d transfers, want 1", len(f.transfers))
+	}
+	tr := f.transfers[0]
+	if tr.From != "0x8d3e2c6a4b7f1e9d0c5a3b2f4e6d8c1a9b7e5f3d" || tr.To != "0x1f0e2d3c4b5a69788796a5b4c3d2e1f0a1b2c3d4" || tr.Value != "1000000" || tr.Method != "transfer" || tr.Contract != "0x4b4899a10f3e507db207b0ee2426029efa168a67" {
+		t.Errorf("transfer = %+v", tr)
+	}
+	if len(f.traces) != 0 {
+		t.Errorf("%d traces without a tracer", len(f.traces))
+	}
+}
+
+func TestFetchMissingBlock(t *testing.T) {
+	_, rpc := newFakeNode(t)
+	ix := newTestIndexer(t, rpc)
+	if _, err := ix.fetch(context.Background(), 1); err == nil || !strings.Contains(err.Error(), ErrNotFound.Error()) {
+		t.Errorf("fetch of a missing block = %v", err)
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	node, rpc := newFakeNode(t)
+	node.chain(0, 20, 21, "")
+	ix := newTestIndexer(t, rpc)
+	ctx := context.Background()
+
+	if err := ix.Backfill(ctx, 0, 20); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stored(t, ix, 0, 20), chainHashes("aa", 0, 20); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("stored %v, want %v", got, want)
+	}
+	store, err := ix.DB.Store(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.LatestBlock.Number != 20 || store.LatestBlock.Hash != chainHash("aa", 20) {
+		t.Errorf("head after backfill = %d %s, want 20", store.LatestBlock.Number, store.LatestBlock.Hash)
+	}
+
+	// Backfilling an older range leaves the head where it is.
+	if err := ix.Backfill(ctx, 3, 5); err != nil {
+		t.Fatal(err)
+	}
+	if store, _ := ix.DB.Store(ctx); store.LatestBlock.Number != 20 {
+		t.Errorf("head after an older backfill = %d, want 20", store.LatestBlock.Number)
+	}
+}
+
+func TestBackfillMissingBlock(t *testing.T) {
+	node, rpc := newFakeNode(t)
+	node.chain(0, 5, 6, "")
+	ix := newTestIndexer(t, rpc)
+	ctx := context.Background()
+
+	if err := ix.Backfill(ctx, 0, 8); err == nil {
+		t.Fatal("backfill past the node's head succeeded")
+	}
+	if _, err := ix.DB.Store(ctx); err != dao.ErrNotFound {
+		t.Errorf("head set by a failed backfill: %v", err)
+	}
+}
+
+func TestSyncReorg(t *testing.T) {
+	tests := []struct {
+		name   string
+		start  uint64
+		forkAt uint64
+		head   uint64
+		forked int
+		err    bool
+	}{
+		{name: "no reorg", start: 0, forkAt: 100, head: 12},
+		{name: "tip replaced", start: 0, forkAt: 10, head: 11, forked: 1},
+		{name: "reorg", start: 0, forkAt: 8, head: 12, forked: 3},
+		{name: "at depth", start: 0, forkAt: 7, head: 12, forked: 4},
+		{name: "too deep", start: 0, forkAt: 6, head: 12, err: true},
+		{name: "first block orphaned", start: 8, forkAt: 8, head: 12, forked: 3},
+		{name: "first of one block orphaned", start: 10, forkAt: 10, head: 11, forked: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, rpc := newFakeNode(t)
+			ix := newTestIndexer(t, rpc)
+			ix.Start = tt.start
+			ctx := context.Background()
+
+			node.chain(tt.start, 10, 100, "")
+			if err := ix.sync(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			node.chain(tt.start, tt.head, tt.forkAt, "bb")
+			err := ix.sync(ctx)
+			if tt.err {
+				if err == nil {
+					t.Fatal("sync succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var want []string
+			for i := tt.start; i <= tt.head; i++ {
+				if i >= tt.forkAt {
+					want = append(want, chainHash("bb", i))
+				} else {
+					want = append(want, chainHash("aa", i))
+				}
+			}
+			if got := stored(t, ix, tt.start, tt.head); strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("stored %v, want %v", got, want)
+			}
+			latest, err := ix.DB.LatestBlock(ctx)
+			if err != nil || latest.Number != tt.head {
+				t.Errorf("latest = %d, %v, want %d", latest.Number, err, tt.head)
+			}
+			forked, err := ix.DB.LatestForkedBlocks(ctx, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(forked) != tt.forked {
+				t.Errorf("%d forked blocks, want %d", len(forked), tt.forked)
+			}
+			for _, b := range forked {
+				if b.Hash != chainHash("aa", b.Number) {
+					t.Errorf("forked block %d is %s, not the orphaned one", b.Number, b.Hash)
+				}
+			}
+		})
+	}
+}
+
+func TestFollow(t *testing.T) {
+	node, rpc := newFakeNode(t)
+	ix := newTestIndexer(t, rpc)
+	node.chain(0, 3, 100, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- ix.Follow(ctx) }()
+
+	waitFor := func(number uint64, hash string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if b, err := ix.DB.LatestBlock(context.Background()); err == nil && b.Number == number && b.Hash == hash {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("head never reached %d %s", number, hash)
+	}
+	waitFor(3, chainHash("aa", 3))
+	node.chain(0, 5, 3, "bb")
+	waitFor(5, chainHash("bb", 5))
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Follow returned %v after cancel", err)
+	}
+	if got := stored(t, ix, 2, 3); got[0] != chainHash("aa", 2) || got[1] != chainHash("bb", 3) {
+		t.Errorf("stored %v across the fork", got)
+	}
+}
+
+// mined sums the rewards chainMiner was paid in the stored blocks from..to.
+func mined(t *testing.T, ix *Indexer, from, to uint64) *big.Int {
+	sum := new(big.Int)
+	for i := from; i <= to; i++ {
+		b, err := ix.DB.BlockByNumber(context.Background(), i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, wei := range []string{b.BlockReward, b.UnclesReward, b.TxFees} {
+			n, _ := new(big.Int).SetString(wei, 10)
+			sum.Add(sum, n)
+		}
+	}
+	return sum
+}
+
+func TestCheckpoints(t *testing.T) {
+	node, rpc := newFakeNode(t)
+	node.chain(0, 20, 100, "")
+	node.balances[10] = "0x3635c9adc5dea00000" // 1000 UBQ, a genesis allocation the ledger can't see
+	ix := newTestIndexer(t, rpc)
+	ix.CheckpointInterval = 5
+	ctx := context.Background()
+
+	if err := ix.Backfill(ctx, 0, 20); err != nil {
+		t.Fatal(err)
+	}
+	// The head is 30 after the sync.
+	node.chain(0, 30, 100, "")
+	if err := ix.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	allocation, _ := new(big.Int).SetString("1000000000000000000000", 10)
+	atNode := new(big.Int).Add(allocation, mined(t, ix, 11, 15))
+	tests := []struct {
+		number uint64
+		want   *big.Int
+	}{
+		{number: 4},
+		{number: 5, want: mined(t, ix, 0, 5)},
+		{number: 9, want: mined(t, ix, 0, 5)},
+		{number: 10, want: allocation},
+		{number: 15, want: atNode},
+		{number: 20, want: new(big.Int).Add(atNode, mined(t, ix, 16, 20))},
+		{number: 25, want: new(big.Int).Add(atNode, mined(t, ix, 16, 25))},
+		// 30 isn't maxReorgDepth blocks behind the head yet.
+		{number: 30, want: new(big.Int).Add(atNode, mined(t, ix, 16, 25))},
+	}
+	for _, tt := range tests {
+		b, err := ix.DB.BalanceCheckpoint(ctx, chainMiner, tt.number)
+		if tt.want == nil {
+			if err != dao.ErrNotFound {
+				t.Errorf("checkpoint at %d = %+v, %v, want none", tt.number, b, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("checkpoint at %d: %v", tt.number, err)
+		}
+		if b.Balance != tt.want.String() {
+			t.Errorf("checkpoint at %d = %s at block %d, want %s", tt.number, b.Balance, b.BlockNumber, tt.want)
+		}
+	}
+}
+
+func TestCheckpointsDisabled(t *testing.T) {
+	node, rpc := newFakeNode(t)
+	node.chain(0, 20, 100, "")
+	ix := newTestIndexer(t, rpc)
+	ctx := context.Background()
+
+	if err := ix.Backfill(ctx, 0, 20); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ix.DB.BalanceCheckpoint(ctx, chainMiner, 20); err != dao.ErrNotFound {
+		t.Errorf("checkpoint stored without an interval: %+v, %v", b, err)
+	}
+}
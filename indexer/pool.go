@@ -0,0 +1,155 @@
+package indexer
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+// Pool mirrors a node's pending transactions in memory. Each poll replaces
+// the set, so transactions leave it once mined or dropped by the node.
+type Pool struct {
+	RPC *RPC
+
+	mu   sync.RWMutex
+	txns map[string]Transaction
+}
+
+func NewPool(rpc *RPC) *Pool {
+	return &Pool{RPC: rpc, txns: map[string]Transaction{}}
+}
+
+// txpoolContent is the result of txpool_content, keyed by sender and nonce.
+type txpoolContent struct {
+	Pending map[string]map[string]rpcTransaction `json:"pending"`
+}
+
+func (t *rpcTransaction) pending(seen uint64) Transaction {
+	return Transaction{
+		Hash:      strings.ToLower(t.Hash),
+		Timestamp: seen,
+		Input:     t.Input,
+		Value:     decimal(t.Value),
+		Gas:       hexUint(t.Gas),
+		GasPrice:  decimal(t.GasPrice),
+		Nonce:     hexUint(t.Nonce),
+		From:      strings.ToLower(t.From),
+		To:        strings.ToLower(t.To),
+		Type:      hexUint(t.Type),
+		Logs:      []TxLog{},
+	}
+}
+
+// fetch reads the node's pending transactions with txpool_content, falling
+// back to eth_pendingTransactions on nodes without the txpool api.
+func (p *Pool) fetch(ctx context.Context) ([]rpcTransaction, error) {
+	var content txpoolContent
+	err := p.RPC.Call(ctx, &content, "txpool_content")
+	if err == nil {
+		var txns []rpcTransaction
+		for _, byNonce := range content.Pending {
+			for _, t := range byNonce {
+				txns = append(txns, t)
+			}
+		}
+		return txns, nil
+	}
+	if _, ok := err.(*rpcError); !ok {
+		return nil, err
+	}
+
+	var txns []rpcTransaction
+	if err := p.RPC.Call(ctx, &txns, "eth_pendingTransactions"); err != nil && err != ErrNotFound {
+		return nil, err
+	}
+	return txns, nil
+}
+
+// poll replaces the pool with the node's pending set. Timestamp records when
+// a transaction was first seen, and is kept across polls.
+func (p *Pool) poll(ctx context.Context) error {
+	raw, err := p.fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := uint64(time.Now().Unix())
+	p.mu.RLock()
+	txns := make(map[string]Transaction, len(raw))
+	for i := range raw {
+		hash := strings.ToLower(raw[i].Hash)
+		seen := now
+		if old, ok := p.txns[hash]; ok {
+			seen = old.Timestamp
+		}
+		txns[hash] = raw[i].pending(seen)
+	}
+	p.mu.RUnlock()
+
+	p.mu.Lock()
+	p.txns = txns
+	p.mu.Unlock()
+	return nil
+}
+
+// Run polls the node every interval until ctx is done.
+func (p *Pool) Run(ctx context.Context, interval time.Duration) {
+	for {
+		if err := p.poll(ctx); err != nil && ctx.Err() == nil {
+			log.Error("Pending pool poll failed: ", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Get returns the pending transaction with hash.
+func (p *Pool) Get(hash string) (Transaction, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	t, ok := p.txns[strings.ToLower(hash)]
+	return t, ok
+}
+
+// All returns the pending transactions, newest first.
+func (p *Pool) All() []Transaction {
+	return p.filter(func(Transaction) bool { return true })
+}
+
+// ByAddress returns the pending transactions sent or received by address,
+// newest first.
+func (p *Pool) ByAddress(address string) []Transaction {
+	address = strings.ToLower(address)
+	return p.filter(func(t Transaction) bool { return t.From == address || t.To == address })
+}
+
+func (p *Pool) filter(keep func(Transaction) bool) []Transaction {
+	p.mu.RLock()
+	txns := []Transaction{}
+	for _, t := range p.txns {
+		if keep(t) {
+			txns = append(txns, t)
+		}
+	}
+	p.mu.RUnlock()
+
+	sort.Slice(txns, func(i, j int) bool {
+		if txns[i].Timestamp != txns[j].Timestamp {
+			return txns[i].Timestamp > txns[j].Timestamp
+		}
+		if txns[i].From != txns[j].From {
+			return txns[i].From < txns[j].From
+		}
+		return txns[i].Nonce < txns[j].Nonce
+	})
+	return txns
+}
@@ -0,0 +1,68 @@
+package indexer
+
+import (
+	"context"
+	"testing"
+)
+
+// pendingTxn is a pending transfer of 1 UBQ from a made up sender.
+func pendingTxn(hash string, nonce string) rpcTransaction {
+	return rpcTransaction{
+		Hash:     hash,
+		From:     "0x3FB9F5E5B1E8B9D1D95E0B5EE1D3B83FA6D0E6A9",
+		To:       "0x1111111111111111111111111111111111111111",
+		Gas:      "0x5208",
+		GasPrice: "0x4a817c800",
+		Input:    "0x",
+		Nonce:    nonce,
+		Value:    "0xde0b6b3a7640000",
+		Type:     "0x0",
+	}
+}
+
+func TestPool(t *testing.T) {
+	for _, noTxpool := range []bool{false, true} {
+		node, rpc := newFakeNode(t)
+		node.noTxpool = noTxpool
+		node.pending["0xA1"] = pendingTxn("0xA1", "0x0")
+		node.pending["0xa2"] = pendingTxn("0xa2", "0x1")
+		pool := NewPool(rpc)
+		ctx := context.Background()
+
+		if err := pool.poll(ctx); err != nil {
+			t.Fatal(err)
+		}
+		txn, ok := pool.Get("0xa1")
+		if !ok {
+			t.Fatalf("txpool api %v: 0xa1 is not pending", !noTxpool)
+		}
+		if txn.Hash != "0xa1" || txn.From != "0x3fb9f5e5b1e8b9d1d95e0b5ee1d3b83fa6d0e6a9" || txn.Value != "1000000000000000000" ||
+			txn.GasPrice != "20000000000" || txn.Gas != 21000 || txn.Timestamp == 0 || txn.Logs == nil {
+			t.Errorf("pending 0xa1: %+v", txn)
+		}
+		if all := pool.All(); len(all) != 2 || all[0].Nonce != 0 || all[1].Nonce != 1 {
+			t.Errorf("pending %+v", all)
+		}
+		if txns := pool.ByAddress("0x1111111111111111111111111111111111111111"); len(txns) != 2 {
+			t.Errorf("pending to the payee %+v", txns)
+		}
+		if txns := pool.ByAddress("0x2222222222222222222222222222222222222222"); len(txns) != 0 {
+			t.Errorf("pending of another account %+v", txns)
+		}
+
+		// 0xa1 is mined and 0xa2 stays, still seen when first polled.
+		node.mu.Lock()
+		delete(node.pending, "0xA1")
+		node.mu.Unlock()
+		seen, _ := pool.Get("0xa2")
+		if err := pool.poll(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := pool.Get("0xa1"); ok {
+			t.Error("mined 0xa1 is still pending")
+		}
+		if txn, ok := pool.Get("0xa2"); !ok || txn.Timestamp != seen.Timestamp {
+			t.Errorf("0xa2 pending %v since %d, first seen at %d", ok, txn.Timestamp, seen.Timestamp)
+		}
+	}
+}
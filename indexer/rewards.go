@@ -0,0 +1,87 @@
+package indexer
+
+import (
+	"math/big"
+
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+// RewardStep sets the base block reward, in wei, for blocks after Block
+// (from genesis for the first step).
+type RewardStep struct {
+	Block  uint64
+	Reward *big.Int
+}
+
+func ubq(n int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(n), big.NewInt(1e18))
+}
+
+// UbiqMonetaryPolicy is the mainnet reward schedule: 8 UBQ, dropping by one
+// roughly every 358,363 blocks down to 1 UBQ.
+var UbiqMonetaryPolicy = []RewardStep{
+	{Block: 0, Reward: ubq(8)},
+	{Block: 358363, Reward: ubq(7)},
+	{Block: 716727, Reward: ubq(6)},
+	{Block: 1075090, Reward: ubq(5)},
+	{Block: 1433454, Reward: ubq(4)},
+	{Block: 1791818, Reward: ubq(3)},
+	{Block: 2150181, Reward: ubq(2)},
+	{Block: 2508545, Reward: ubq(1)},
+}
+
+func baseReward(policy []RewardStep, number uint64) *big.Int {
+	reward := new(big.Int)
+	for _, step := range policy {
+		if step.Block > 0 && number <= step.Block {
+			break
+		}
+		reward = step.Reward
+	}
+	return reward
+}
+
+// applyRewards fills in the reward and fee fields the crawler derives for a
+// block and its uncles: the base reward, reward/32 per included uncle for the
+// miner, (uncle + 2 - block) * reward / 2 for each uncle's miner, and the
+// total and average of the fees paid by txns. Transactions without a gas
+// price are left out of both.
+func applyRewards(policy []RewardStep, block *Block, uncles []Uncle, txns []Transaction) {
+	reward := baseReward(policy, block.Number)
+
+	nephews := new(big.Int).Div(reward, big.NewInt(32))
+	nephews.Mul(nephews, big.NewInt(int64(len(uncles))))
+
+	for i := range uncles {
+		r := new(big.Int).SetUint64(uncles[i].Number + 2)
+		r.Sub(r, new(big.Int).SetUint64(block.Number))
+		r.Mul(r, reward)
+		r.Div(r, big.NewInt(2))
+		if r.Sign() < 0 {
+			r.SetInt64(0)
+		}
+		uncles[i].Reward = r.String()
+	}
+
+	fees := new(big.Int)
+	prices := new(big.Int)
+	priced := int64(0)
+	for _, txn := range txns {
+		price, _ := new(big.Int).SetString(txn.GasPrice, 10)
+		if price == nil {
+			continue
+		}
+		prices.Add(prices, price)
+		priced++
+		fees.Add(fees, new(big.Int).Mul(price, new(big.Int).SetUint64(txn.GasUsed)))
+	}
+	avg := new(big.Int)
+	if priced > 0 {
+		avg.Div(prices, big.NewInt(priced))
+	}
+
+	block.BlockReward = reward.String()
+	block.UnclesReward = nephews.String()
+	block.TxFees = fees.String()
+	block.AvgGasPrice = avg.String()
+}
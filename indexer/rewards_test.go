@@ -0,0 +1,115 @@
+package indexer
+
+import (
+	"math/big"
+	"testing"
+
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+func TestBaseReward(t *testing.T) {
+	custom := []RewardStep{{Block: 0, Reward: big.NewInt(5)}, {Block: 10, Reward: big.NewInt(3)}}
+	tests := []struct {
+		name   string
+		policy []RewardStep
+		number uint64
+		want   string
+	}{
+		{name: "genesis", policy: UbiqMonetaryPolicy, number: 0, want: "8000000000000000000"},
+		{name: "last block of the first step", policy: UbiqMonetaryPolicy, number: 358363, want: "8000000000000000000"},
+		{name: "first block of the second step", policy: UbiqMonetaryPolicy, number: 358364, want: "7000000000000000000"},
+		{name: "last block of the second step", policy: UbiqMonetaryPolicy, number: 716727, want: "7000000000000000000"},
+		{name: "third step", policy: UbiqMonetaryPolicy, number: 716728, want: "6000000000000000000"},
+		{name: "last block before the floor", policy: UbiqMonetaryPolicy, number: 2508545, want: "2000000000000000000"},
+		{name: "floor", policy: UbiqMonetaryPolicy, number: 2508546, want: "1000000000000000000"},
+		{name: "long after the floor", policy: UbiqMonetaryPolicy, number: 10000000, want: "1000000000000000000"},
+		{name: "custom policy before its step", policy: custom, number: 10, want: "5"},
+		{name: "custom policy after its step", policy: custom, number: 11, want: "3"},
+		{name: "no policy", number: 1, want: "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := baseReward(tt.policy, tt.number).String(); got != tt.want {
+				t.Errorf("baseReward(%d) = %s, want %s", tt.number, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyRewards(t *testing.T) {
+	tests := []struct {
+		name    string
+		number  uint64
+		uncles  []uint64
+		txns    []Transaction
+		block   Block
+		rewards []string
+	}{
+		{
+			name:   "empty block",
+			number: 100,
+			block:  Block{BlockReward: "8000000000000000000", UnclesReward: "0", TxFees: "0", AvgGasPrice: "0"},
+		},
+		{
+			name:    "uncle one block back",
+			number:  100,
+			uncles:  []uint64{99},
+			block:   Block{BlockReward: "8000000000000000000", UnclesReward: "250000000000000000", TxFees: "0", AvgGasPrice: "0"},
+			rewards: []string{"4000000000000000000"},
+		},
+		{
+			name:    "uncles at and past the reward depth",
+			number:  100,
+			uncles:  []uint64{98, 97},
+			block:   Block{BlockReward: "8000000000000000000", UnclesReward: "500000000000000000", TxFees: "0", AvgGasPrice: "0"},
+			rewards: []string{"0", "0"},
+		},
+		{
+			name:    "uncles after a step",
+			number:  358364,
+			uncles:  []uint64{358363, 358362},
+			block:   Block{BlockReward: "7000000000000000000", UnclesReward: "437500000000000000", TxFees: "0", AvgGasPrice: "0"},
+			rewards: []string{"3500000000000000000", "0"},
+		},
+		{
+			name:   "fees",
+			number: 100,
+			txns: []Transaction{
+				{GasPrice: "20000000000", GasUsed: 21000},
+				{GasPrice: "30000000000", GasUsed: 50000},
+				{GasPrice: "25000000000", GasUsed: 0},
+			},
+			block: Block{BlockReward: "8000000000000000000", UnclesReward: "0", TxFees: "1920000000000000", AvgGasPrice: "25000000000"},
+		},
+		{
+			name:   "transactions without a gas price",
+			number: 100,
+			txns: []Transaction{
+				{GasPrice: "20000000000", GasUsed: 21000},
+				{GasPrice: "", GasUsed: 21000},
+				{GasPrice: "0x1", GasUsed: 21000},
+			},
+			block: Block{BlockReward: "8000000000000000000", UnclesReward: "0", TxFees: "420000000000000", AvgGasPrice: "20000000000"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block := Block{Number: tt.number}
+			var uncles []Uncle
+			for _, n := range tt.uncles {
+				uncles = append(uncles, Uncle{Number: n, BlockNumber: tt.number})
+			}
+			applyRewards(UbiqMonetaryPolicy, &block, uncles, tt.txns)
+
+			tt.block.Number = tt.number
+			if block != tt.block {
+				t.Errorf("block = %+v\nwant %+v", block, tt.block)
+			}
+			for i, u := range uncles {
+				if u.Reward != tt.rewards[i] {
+					t.Errorf("uncle %d reward = %s, want %s", u.Number, u.Reward, tt.rewards[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// RPC is a minimal json-rpc 2.0 client for a node's http endpoint.
+type RPC struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewRPC(url string) *RPC {
+	return &RPC{URL: url, Client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+type rpcResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+// BatchElem is one call of a batch; Result is decoded into and Error set per
+// element.
+type BatchElem struct {
+	Method string
+	Params []interface{}
+	Result interface{}
+	Error  error
+}
+
+func (c *RPC) post(ctx context.Context, body interface{}, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("rpc %s: %s", c.URL, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// Call invokes method and decodes its result into result. A null result
+// leaves result untouched and returns ErrNotFound.
+func (c *RPC) Call(ctx context.Context, result interface{}, method string, params ...interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	var res rpcResponse
+	if err := c.post(ctx, rpcRequest{JSONRPC: "2.0", ID: 1, Method: method, Params: params}, &res); err != nil {
+		return err
+	}
+	if res.Error != nil {
+		return res.Error
+	}
+	if len(res.Result) == 0 || string(res.Result) == "null" {
+		return ErrNotFound
+	}
+	return json.Unmarshal(res.Result, result)
+}
+
+// Balance returns the wei balance of address at block number.
+func (c *RPC) Balance(ctx context.Context, address string, number uint64) (*big.Int, error) {
+	var balance string
+	if err := c.Call(ctx, &balance, "eth_getBalance", address, hexNumber(number)); err != nil {
+		return nil, err
+	}
+	return hexBig(balance), nil
+}
+
+// BatchCall sends all elems in a single request.
+func (c *RPC) BatchCall(ctx context.Context, elems []BatchElem) error {
+	if len(elems) == 0 {
+		return nil
+	}
+	reqs := make([]rpcRequest, len(elems))
+	for i, e := range elems {
+		params := e.Params
+		if params == nil {
+			params = []interface{}{}
+		}
+		reqs[i] = rpcRequest{JSONRPC: "2.0", ID: i, Method: e.Method, Params: params}
+	}
+
+	var res []rpcResponse
+	if err := c.post(ctx, reqs, &res); err != nil {
+		return err
+	}
+	seen := make([]bool, len(elems))
+	for _, r := range res {
+		if r.ID < 0 || r.ID >= len(elems) {
+			return fmt.Errorf("rpc batch: unexpected response id %d", r.ID)
+		}
+		seen[r.ID] = true
+		switch {
+		case r.Error != nil:
+			elems[r.ID].Error = r.Error
+		case len(r.Result) == 0 || string(r.Result) == "null":
+			elems[r.ID].Error = ErrNotFound
+		default:
+			elems[r.ID].Error = json.Unmarshal(r.Result, elems[r.ID].Result)
+		}
+	}
+	for i, ok := range seen {
+		if !ok {
+			elems[i].Error = fmt.Errorf("rpc batch: no response for %s", elems[i].Method)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+// Tracers the indexer can ask a node for internal transactions with.
+const (
+	// TraceDebug calls debug_traceTransaction with geth's callTracer for
+	// every transaction.
+	TraceDebug = "debug"
+	// TraceParity calls trace_block once per block.
+	TraceParity = "trace"
+)
+
+// callFrame is a frame of callTracer output.
+type callFrame struct {
+	Type    string      `json:"type"`
+	From    string      `json:"from"`
+	To      string      `json:"to"`
+	Value   string      `json:"value"`
+	Gas     string      `json:"gas"`
+	GasUsed string      `json:"gasUsed"`
+	Error   string      `json:"error"`
+	Calls   []callFrame `json:"calls"`
+}
+
+// parityTrace is one element of trace_block output.
+type parityTrace struct {
+	Type   string `json:"type"`
+	Action struct {
+		CallType      string `json:"callType"`
+		From          string `json:"from"`
+		To            string `json:"to"`
+		Value         string `json:"value"`
+		Gas           string `json:"gas"`
+		Address       string `json:"address"`
+		RefundAddress string `json:"refundAddress"`
+		Balance       string `json:"balance"`
+	} `json:"action"`
+	Result *struct {
+		GasUsed string `json:"gasUsed"`
+		Address string `json:"address"`
+	} `json:"result"`
+	Error           string `json:"error"`
+	TraceAddress    []int  `json:"traceAddress"`
+	TransactionHash string `json:"transactionHash"`
+}
+
+// movesValue reports whether a call of type moves its value between the
+// accounts it names; the others run code in the caller's context.
+func movesValue(typ string) bool {
+	switch typ {
+	case "delegatecall", "staticcall", "callcode":
+		return false
+	}
+	return true
+}
+
+func traceValue(typ string, value string) string {
+	if !movesValue(typ) {
+		return "0"
+	}
+	return decimal(value)
+}
+
+// callTraces flattens the callTracer output of txn depth first, skipping
+// the top-level frame which is the transaction itself.
+func callTraces(txn Transaction, root callFrame) []Trace {
+	var traces []Trace
+	var walk func(frame callFrame, depth uint64, reverted string)
+	walk = func(frame callFrame, depth uint64, reverted string) {
+		if frame.Error != "" && reverted == "" {
+			reverted = frame.Error
+		}
+		if depth > 0 {
+			typ := strings.ToLower(frame.Type)
+			traces = append(traces, Trace{
+				Hash:             txn.Hash,
+				BlockNumber:      txn.BlockNumber,
+				TransactionIndex: txn.TransactionIndex,
+				Index:            uint64(len(traces)),
+				Timestamp:        txn.Timestamp,
+				Type:             typ,
+				From:             strings.ToLower(frame.From),
+				To:               strings.ToLower(frame.To),
+				Value:            traceValue(typ, frame.Value),
+				Gas:              hexUint(frame.Gas),
+				GasUsed:          hexUint(frame.GasUsed),
+				Depth:            depth,
+				Error:            reverted,
+			})
+		}
+		for _, call := range frame.Calls {
+			walk(call, depth+1, reverted)
+		}
+	}
+	walk(root, 0, "")
+	return traces
+}
+
+// parityTraces converts the trace_block output for the block holding txns.
+// Block and uncle rewards, which belong to no transaction, are skipped.
+func parityTraces(txns []Transaction, raw []parityTrace) []Trace {
+	byHash := make(map[string]Transaction, len(txns))
+	for _, txn := range txns {
+		byHash[txn.Hash] = txn
+	}
+
+	var traces []Trace
+	index := make(map[string]uint64)
+	// failed maps the trace address of failed calls to their error, so that
+	// the calls beneath them can be marked reverted.
+	failed := make(map[string]string)
+	for _, r := range raw {
+		txn, ok := byHash[r.TransactionHash]
+		if !ok || r.Type == "reward" {
+			continue
+		}
+
+		address := make([]string, len(r.TraceAddress))
+		for i, n := range r.TraceAddress {
+			address[i] = strconv.Itoa(n)
+		}
+		reverted := r.Error
+		for i := len(address); i >= 0 && reverted == ""; i-- {
+			reverted = failed[txn.Hash+"/"+strings.Join(address[:i], ".")]
+		}
+		if r.Error != "" {
+			failed[txn.Hash+"/"+strings.Join(address, ".")] = r.Error
+		}
+		if len(r.TraceAddress) == 0 {
+			continue
+		}
+
+		t := Trace{
+			Hash:             txn.Hash,
+			BlockNumber:      txn.BlockNumber,
+			TransactionIndex: txn.TransactionIndex,
+			Index:            index[txn.Hash],
+			Timestamp:        txn.Timestamp,
+			Type:             r.Type,
+			From:             strings.ToLower(r.Action.From),
+			To:               strings.ToLower(r.Action.To),
+			Value:            decimal(r.Action.Value),
+			Gas:              hexUint(r.Action.Gas),
+			Depth:            uint64(len(r.TraceAddress)),
+			Error:            reverted,
+		}
+		switch r.Type {
+		case "call":
+			t.Type = r.Action.CallType
+			t.Value = traceValue(t.Type, r.Action.Value)
+		case "suicide":
+			t.Type = "selfdestruct"
+			t.From = strings.ToLower(r.Action.Address)
+			t.To = strings.ToLower(r.Action.RefundAddress)
+			t.Value = decimal(r.Action.Balance)
+		}
+		if r.Result != nil {
+			t.GasUsed = hexUint(r.Result.GasUsed)
+			if r.Type == "create" {
+				t.To = strings.ToLower(r.Result.Address)
+			}
+		}
+		index[txn.Hash]++
+		traces = append(traces, t)
+	}
+	return traces
+}
+
+// traces fetches the internal transactions of txns, mined in block number,
+// with the configured tracer.
+func (ix *Indexer) traces(ctx context.Context, number uint64, txns []Transaction) ([]Trace, error) {
+	switch ix.Tracer {
+	case "":
+		return nil, nil
+	case TraceParity:
+		var raw []parityTrace
+		if err := ix.RPC.Call(ctx, &raw, "trace_block", hexNumber(number)); err != nil && err != ErrNotFound {
+			return nil, fmt.Errorf("block %d: trace_block: %v", number, err)
+		}
+		return parityTraces(txns, raw), nil
+	case TraceDebug:
+		frames := make([]callFrame, len(txns))
+		batch := make([]BatchElem, len(txns))
+		for i, txn := range txns {
+			batch[i] = BatchElem{Method: "debug_traceTransaction", Params: []interface{}{txn.Hash, map[string]string{"tracer": "callTracer"}}, Result: &frames[i]}
+		}
+		if err := ix.RPC.BatchCall(ctx, batch); err != nil {
+			return nil, fmt.Errorf("block %d: %v", number, err)
+		}
+		var traces []Trace
+		for i, e := range batch {
+			if e.Error != nil {
+				return nil, fmt.Errorf("block %d: %s %v: %v", number, e.Method, e.Params, e.Error)
+			}
+			traces = append(traces, callTraces(txns[i], frames[i])...)
+		}
+		return traces, nil
+	}
+	return nil, fmt.Errorf("unknown tracer %q", ix.Tracer)
+}
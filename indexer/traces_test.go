@@ -0,0 +1,204 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+// Transactions traced in testdata/calltracer.json and
+// testdata/trace_block.json: a swap with a reverted subcall, a plain
+// transfer, a contract created and destroyed by a factory, and a call that
+// ran out of gas.
+var (
+	swap     = Transaction{Hash: "0xe1d2c3b4a5968778695a4b3c2d1e0ff1e2d3c4b5a69788796a5b4c3d2e1f0a1b", BlockNumber: 436, TransactionIndex: 0, Timestamp: 0x5a2f1e3c}
+	payment  = Transaction{Hash: "0x7b6a5948372615f4e3d2c1b0a99887766554433221100ffeeddccbbaa9988776", BlockNumber: 436, TransactionIndex: 1, Timestamp: 0x5a2f1e3c}
+	deploy   = Transaction{Hash: "0xc35a8f2e1d4b6c7a9e0f1d2c3b4a59687f6e5d4c3b2a19080f7e6d5c4b3a2910", BlockNumber: 436, TransactionIndex: 2, Timestamp: 0x5a2f1e3c}
+	outOfGas = Transaction{Hash: "0xd4e3f2a1b0c9d8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3", BlockNumber: 436, TransactionIndex: 3, Timestamp: 0x5a2f1e3c}
+)
+
+const (
+	sender  = "0x8d3e2c6a4b7f1e9d0c5a3b2f4e6d8c1a9b7e5f3d"
+	router  = "0x7a250d5630b4cf539739df2c5dacb4c659f2488d"
+	token   = "0x4b4899a10f3e507db207b0ee2426029efa168a67"
+	pair    = "0xa478c2975ab1ea89e8196811f51a7b7ade33eb11"
+	oracle  = "0x5f4ec3df9cbd43714fe2740f5e3616155c5b8419"
+	payee   = "0x1f0e2d3c4b5a69788796a5b4c3d2e1f0a1b2c3d4"
+	library = "0x9e1163c39a1e4a2b8c7d6e5f4a3b2c1d0e9f8a7b"
+	factory = "0x0c6e8d4f2a1b3c5d7e9f0a2b4c6d8e0f1a3b5c7d"
+	created = "0x3f2a9c8b7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a"
+)
+
+func readFixture(t *testing.T, name string, out interface{}) {
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// ofTxn fills in the fields traces take from txn, and their index.
+func ofTxn(txn Transaction, traces ...Trace) []Trace {
+	for i := range traces {
+		traces[i].Hash = txn.Hash
+		traces[i].BlockNumber = txn.BlockNumber
+		traces[i].TransactionIndex = txn.TransactionIndex
+		traces[i].Timestamp = txn.Timestamp
+		traces[i].Index = uint64(i)
+	}
+	return traces
+}
+
+func checkTraces(t *testing.T, got []Trace, want []Trace) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%d traces, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("trace %d = %+v\nwant %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestCallTraces(t *testing.T) {
+	var frames map[string]callFrame
+	readFixture(t, "calltracer.json", &frames)
+
+	tests := []struct {
+		name string
+		txn  Transaction
+		want []Trace
+	}{
+		{
+			name: "reverted subcall",
+			txn:  swap,
+			want: ofTxn(swap,
+				Trace{Type: "call", From: router, To: token, Value: "0", Gas: 90000, GasUsed: 15000, Depth: 1},
+				Trace{Type: "call", From: router, To: pair, Value: "0", Gas: 60000, GasUsed: 60000, Depth: 1, Error: "execution reverted"},
+				Trace{Type: "staticcall", From: pair, To: oracle, Value: "0", Gas: 30000, GasUsed: 2500, Depth: 2, Error: "execution reverted"},
+				Trace{Type: "call", From: pair, To: payee, Value: "10000000000000000", Gas: 2300, Depth: 2, Error: "execution reverted"},
+				Trace{Type: "delegatecall", From: router, To: library, Value: "0", Gas: 10000, GasUsed: 500, Depth: 1},
+			),
+		},
+		{
+			name: "no internal calls",
+			txn:  payment,
+		},
+		{
+			name: "create and selfdestruct",
+			txn:  deploy,
+			want: ofTxn(deploy,
+				Trace{Type: "create", From: factory, To: created, Value: "16", Gas: 2767680, GasUsed: 70000, Depth: 1},
+				Trace{Type: "selfdestruct", From: created, To: sender, Value: "16", Depth: 2},
+			),
+		},
+		{
+			name: "failed transaction",
+			txn:  outOfGas,
+			want: ofTxn(outOfGas,
+				Trace{Type: "call", From: router, To: token, Value: "0", Gas: 40000, GasUsed: 1500, Depth: 1, Error: "out of gas"},
+			),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, ok := frames[tt.txn.Hash]
+			if !ok {
+				t.Fatalf("no recorded frame for %s", tt.txn.Hash)
+			}
+			checkTraces(t, callTraces(tt.txn, frame), tt.want)
+		})
+	}
+}
+
+func TestParityTraces(t *testing.T) {
+	var raw []parityTrace
+	readFixture(t, "trace_block.json", &raw)
+
+	swapTraces := ofTxn(swap,
+		Trace{Type: "call", From: router, To: token, Value: "0", Gas: 90000, GasUsed: 15000, Depth: 1},
+		Trace{Type: "call", From: router, To: pair, Value: "0", Gas: 60000, Depth: 1, Error: "Reverted"},
+		Trace{Type: "staticcall", From: pair, To: oracle, Value: "0", Gas: 30000, GasUsed: 2500, Depth: 2, Error: "Reverted"},
+		Trace{Type: "call", From: pair, To: payee, Value: "10000000000000000", Gas: 2300, Depth: 2, Error: "Reverted"},
+		Trace{Type: "delegatecall", From: router, To: library, Value: "0", Gas: 10000, GasUsed: 500, Depth: 1},
+	)
+	deployTraces := ofTxn(deploy,
+		Trace{Type: "create", From: factory, To: created, Value: "16", Gas: 2767680, GasUsed: 70000, Depth: 1},
+		Trace{Type: "selfdestruct", From: created, To: sender, Value: "16", Depth: 2},
+	)
+	outOfGasTraces := ofTxn(outOfGas,
+		Trace{Type: "call", From: router, To: token, Value: "0", Gas: 40000, GasUsed: 1500, Depth: 1, Error: "Out of gas"},
+	)
+
+	tests := []struct {
+		name string
+		txns []Transaction
+		want []Trace
+	}{
+		{
+			name: "block",
+			txns: []Transaction{swap, payment, deploy, outOfGas},
+			want: append(append(append([]Trace{}, swapTraces...), deployTraces...), outOfGasTraces...),
+		},
+		{
+			name: "traces of other transactions skipped",
+			txns: []Transaction{deploy},
+			want: deployTraces,
+		},
+		{
+			name: "no transactions",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkTraces(t, parityTraces(tt.txns, raw), tt.want)
+		})
+	}
+}
+
+func TestFetchTraces(t *testing.T) {
+	tests := []struct {
+		tracer string
+		want   []Trace
+	}{
+		{tracer: TraceDebug, want: ofTxn(swap,
+			Trace{Type: "call", From: router, To: token, Value: "0", Gas: 90000, GasUsed: 15000, Depth: 1},
+			Trace{Type: "call", From: router, To: pair, Value: "0", Gas: 60000, GasUsed: 60000, Depth: 1, Error: "execution reverted"},
+			Trace{Type: "staticcall", From: pair, To: oracle, Value: "0", Gas: 30000, GasUsed: 2500, Depth: 2, Error: "execution reverted"},
+			Trace{Type: "call", From: pair, To: payee, Value: "10000000000000000", Gas: 2300, Depth: 2, Error: "execution reverted"},
+			Trace{Type: "delegatecall", From: router, To: library, Value: "0", Gas: 10000, GasUsed: 500, Depth: 1},
+		)},
+		{tracer: TraceParity, want: ofTxn(swap,
+			Trace{Type: "call", From: router, To: token, Value: "0", Gas: 90000, GasUsed: 15000, Depth: 1},
+			Trace{Type: "call", From: router, To: pair, Value: "0", Gas: 60000, Depth: 1, Error: "Reverted"},
+			Trace{Type: "staticcall", From: pair, To: oracle, Value: "0", Gas: 30000, GasUsed: 2500, Depth: 2, Error: "Reverted"},
+			Trace{Type: "call", From: pair, To: payee, Value: "10000000000000000", Gas: 2300, Depth: 2, Error: "Reverted"},
+			Trace{Type: "delegatecall", From: router, To: library, Value: "0", Gas: 10000, GasUsed: 500, Depth: 1},
+		)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tracer, func(t *testing.T) {
+			node, rpc := newFakeNode(t)
+			node.load(t, "block_436.json")
+			readFixture(t, "calltracer.json", &node.calls)
+			var traces json.RawMessage
+			readFixture(t, "trace_block.json", &traces)
+			node.traces[436] = traces
+
+			ix := newTestIndexer(t, rpc)
+			ix.Tracer = tt.tracer
+			f, err := ix.fetch(context.Background(), 436)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkTraces(t, f.traces, tt.want)
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package indexer
+
+import (
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+// ErrNotFound is returned when the node answers a lookup with null.
+var ErrNotFound = errors.New("not found")
+
+// transferTopic is keccak256("Transfer(address,address,uint256)").
+const transferTopic = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+
+// tokenMethods names the erc20 calls a transfer is usually made through,
+// keyed by 4-byte selector.
+var tokenMethods = map[string]string{
+	"0xa9059cbb": "transfer",
+	"0x23b872dd": "transferFrom",
+	"0x40c10f19": "mint",
+	"0x42966c68": "burn",
+}
+
+type rpcBlock struct {
+	Number          string           `json:"number"`
+	Hash            string           `json:"hash"`
+	ParentHash      string           `json:"parentHash"`
+	Sha3Uncles      string           `json:"sha3Uncles"`
+	Miner           string           `json:"miner"`
+	Difficulty      string           `json:"difficulty"`
+	TotalDifficulty string           `json:"totalDifficulty"`
+	Size            string           `json:"size"`
+	GasUsed         string           `json:"gasUsed"`
+	GasLimit        string           `json:"gasLimit"`
+	Nonce           string           `json:"nonce"`
+	Timestamp       string           `json:"timestamp"`
+	ExtraData       string           `json:"extraData"`
+	Transactions    []rpcTransaction `json:"transactions"`
+	Uncles          []string         `json:"uncles"`
+}
+
+type rpcTransaction struct {
+	Hash             string `json:"hash"`
+	BlockHash        string `json:"blockHash"`
+	BlockNumber      string `json:"blockNumber"`
+	From             string `json:"from"`
+	To               string `json:"to"`
+	Gas              string `json:"gas"`
+	GasPrice         string `json:"gasPrice"`
+	Input            string `json:"input"`
+	Nonce            string `json:"nonce"`
+	TransactionIndex string `json:"transactionIndex"`
+	Value            string `json:"value"`
+	Type             string `json:"type"`
+}
+
+type rpcReceipt struct {
+	TransactionHash   string   `json:"transactionHash"`
+	GasUsed           string   `json:"gasUsed"`
+	CumulativeGasUsed string   `json:"cumulativeGasUsed"`
+	EffectiveGasPrice string   `json:"effectiveGasPrice"`
+	ContractAddress   string   `json:"contractAddress"`
+	Logs              []rpcLog `json:"logs"`
+	LogsBloom         string   `json:"logsBloom"`
+	Status            string   `json:"status"`
+	Type              string   `json:"type"`
+}
+
+type rpcLog struct {
+	Address          string   `json:"address"`
+	Topics           []string `json:"topics"`
+	Data             string   `json:"data"`
+	BlockNumber      string   `json:"blockNumber"`
+	TransactionIndex string   `json:"transactionIndex"`
+	TransactionHash  string   `json:"transactionHash"`
+	BlockHash        string   `json:"blockHash"`
+	LogIndex         string   `json:"logIndex"`
+	Removed          bool     `json:"removed"`
+}
+
+func hexUint(s string) uint64 {
+	n, _ := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 64)
+	return n
+}
+
+func hexBig(s string) *big.Int {
+	n, ok := new(big.Int).SetString(strings.TrimPrefix(s, "0x"), 16)
+	if !ok {
+		return new(big.Int)
+	}
+	return n
+}
+
+// decimal renders a hex quantity the way the crawler stores amounts.
+func decimal(s string) string {
+	return hexBig(s).String()
+}
+
+func hexNumber(n uint64) string {
+	return "0x" + strconv.FormatUint(n, 16)
+}
+
+func (b *rpcBlock) header() Block {
+	return Block{
+		Number:          hexUint(b.Number),
+		Timestamp:       hexUint(b.Timestamp),
+		Transactions:    uint64(len(b.Transactions)),
+		Hash:            b.Hash,
+		ParentHash:      b.ParentHash,
+		Sha3Uncles:      b.Sha3Uncles,
+		Miner:           b.Miner,
+		Difficulty:      decimal(b.Difficulty),
+		TotalDifficulty: decimal(b.TotalDifficulty),
+		Size:            hexUint(b.Size),
+		GasUsed:         hexUint(b.GasUsed),
+		GasLimit:        hexUint(b.GasLimit),
+		Nonce:           b.Nonce,
+		Uncles:          uint64(len(b.Uncles)),
+		ExtraData:       b.ExtraData,
+	}
+}
+
+func (b *rpcBlock) uncle(position int, blockNumber uint64) Uncle {
+	return Uncle{
+		Number:      hexUint(b.Number),
+		Position:    uint64(position),
+		BlockNumber: blockNumber,
+		Hash:        b.Hash,
+		ParentHash:  b.ParentHash,
+		Sha3Uncles:  b.Sha3Uncles,
+		Miner:       b.Miner,
+		Difficulty:  decimal(b.Difficulty),
+		GasUsed:     hexUint(b.GasUsed),
+		GasLimit:    hexUint(b.GasLimit),
+		Timestamp:   hexUint(b.Timestamp),
+	}
+}
+
+func (t *rpcTransaction) transaction(timestamp uint64, receipt *rpcReceipt) Transaction {
+	txn := Transaction{
+		BlockHash:        t.BlockHash,
+		BlockNumber:      hexUint(t.BlockNumber),
+		Hash:             t.Hash,
+		Timestamp:        timestamp,
+		Input:            t.Input,
+		Value:            decimal(t.Value),
+		Gas:              hexUint(t.Gas),
+		GasUsed:          hexUint(receipt.GasUsed),
+		GasPrice:         decimal(t.GasPrice),
+		Nonce:            hexUint(t.Nonce),
+		TransactionIndex: hexUint(t.TransactionIndex),
+		From:             t.From,
+		To:               t.To,
+		ContractAddress:  receipt.ContractAddress,
+		Logs:             make([]TxLog, len(receipt.Logs)),
+
+		CumulativeGasUsed: hexUint(receipt.CumulativeGasUsed),
+		LogsBloom:         receipt.LogsBloom,
+		EffectiveGasPrice: decimal(receipt.EffectiveGasPrice),
+		Type:              hexUint(receipt.Type),
+	}
+	// Nodes that predate the london fork report neither effectiveGasPrice
+	// nor a type on receipts; every transaction then paid its gas price.
+	if receipt.EffectiveGasPrice == "" {
+		txn.EffectiveGasPrice = txn.GasPrice
+	}
+	if receipt.Type == "" {
+		txn.Type = hexUint(t.Type)
+	}
+	// Receipts of pre-byzantium blocks carry a state root instead of a
+	// status, which is left unset.
+	if receipt.Status != "" {
+		status := hexUint(receipt.Status)
+		txn.Status = &status
+	}
+	for i, l := range receipt.Logs {
+		txn.Logs[i] = TxLog{
+			Address:          l.Address,
+			Topics:           l.Topics,
+			Data:             l.Data,
+			BlockNumber:      hexUint(l.BlockNumber),
+			TransactionIndex: hexUint(l.TransactionIndex),
+			TransactionHash:  l.TransactionHash,
+			BlockHash:        l.BlockHash,
+			LogIndex:         hexUint(l.LogIndex),
+			Removed:          l.Removed,
+		}
+	}
+	return txn
+}
+
+// transfers decodes the erc20 Transfer events emitted by txn. Events with a
+// non-indexed from/to (erc721-style or malformed) are skipped.
+func transfers(txn Transaction) []TokenTransfer {
+	method := ""
+	if len(txn.Input) >= 10 {
+		selector := strings.ToLower(txn.Input[:10])
+		if method = tokenMethods[selector]; method == "" {
+			method = selector
+		}
+	}
+
+	var out []TokenTransfer
+	for _, l := range txn.Logs {
+		if len(l.Topics) != 3 || l.Topics[0] != transferTopic {
+			continue
+		}
+		out = append(out, TokenTransfer{
+			BlockNumber: txn.BlockNumber,
+			Hash:        txn.Hash,
+			Timestamp:   txn.Timestamp,
+			From:        topicAddress(l.Topics[1]),
+			To:          topicAddress(l.Topics[2]),
+			Value:       decimal(l.Data),
+			Contract:    l.Address,
+			Method:      method,
+		})
+	}
+	return out
+}
+
+func topicAddress(topic string) string {
+	if len(topic) < 40 {
+		return topic
+	}
+	return "0x" + strings.ToLower(topic[len(topic)-40:])
+}
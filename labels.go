@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/ubiq/spectrum-api/apierr"
+	. "github.com/ubiq/spectrum-api/dao"
+	. "github.com/ubiq/spectrum-api/models"
+	"gopkg.in/yaml.v3"
+)
+
+// labelCacheTTL is how long labels are kept in memory for ?labels=true
+// before they are read again, so that edits made through another instance
+// show up.
+const labelCacheTTL = time.Minute
+
+// labelCache holds a chain's labels by address.
+type labelCache struct {
+	mu     sync.Mutex
+	loaded time.Time
+	names  map[string]Label
+}
+
+func (c *labelCache) get(ctx context.Context) (map[string]Label, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.names != nil && time.Since(c.loaded) < labelCacheTTL {
+		return c.names, nil
+	}
+	labels, err := backend(ctx).Labels(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	c.names = make(map[string]Label, len(labels))
+	for _, l := range labels {
+		c.names[l.Address] = l
+	}
+	c.loaded = time.Now()
+	return c.names, nil
+}
+
+func (c *labelCache) invalidate() {
+	c.mu.Lock()
+	c.names = nil
+	c.mu.Unlock()
+}
+
+// normalizeLabel lowercases the address and tags of l, and checks it names
+// an address.
+func normalizeLabel(l *Label) error {
+	l.Address = strings.ToLower(l.Address)
+	l.Name = strings.TrimSpace(l.Name)
+	if !validHex(l.Address, []int{20}) {
+		return apierr.InvalidArgumentf("label address %q is not an address", l.Address)
+	}
+	if l.Name == "" {
+		return apierr.InvalidArgumentf("label of %s has no name", l.Address)
+	}
+	tags := make([]string, 0, len(l.Tags))
+	for _, t := range l.Tags {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	l.Tags = tags
+	return nil
+}
+
+// seedLabels stores the labels of ch.LabelsFile, a yaml or json list,
+// replacing the stored labels of the same addresses.
+func seedLabels(ctx context.Context, c *chain) {
+	body, err := os.ReadFile(c.LabelsFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var labels []Label
+	if err := yaml.Unmarshal(body, &labels); err != nil {
+		log.Fatal(c.LabelsFile, ": ", err)
+	}
+	writer, ok := c.dao.(Writer)
+	if !ok {
+		log.Fatal("The ", c.Name, " backend can't store labels")
+	}
+	for i := range labels {
+		if err := normalizeLabel(&labels[i]); err != nil {
+			log.Fatal(c.LabelsFile, ": ", err)
+		}
+		if err := writer.SetLabel(ctx, labels[i]); err != nil {
+			log.Fatal(err)
+		}
+	}
+	log.Infof("Stored %d labels of %s from %s", len(labels), c.Name, c.LabelsFile)
+}
+
+// labelled returns a copy of v in which every struct with FromLabel,
+// ToLabel or MinerLabel fields has them set to the names of its From, To
+// and Miner addresses. Slices and structs are copied rather than changed in
+// place, since handlers may respond with cached data.
+func labelled(v reflect.Value, names map[string]Label) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		inner := labelled(v.Elem(), names)
+		if v.Kind() == reflect.Ptr {
+			c := reflect.New(inner.Type())
+			c.Elem().Set(inner)
+			return c
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(inner)
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(labelled(v.Index(i), names))
+		}
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < c.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				c.Field(i).Set(labelled(c.Field(i), names))
+			}
+		}
+		for _, f := range [][2]string{{"From", "FromLabel"}, {"To", "ToLabel"}, {"Miner", "MinerLabel"}} {
+			address, label := c.FieldByName(f[0]), c.FieldByName(f[1])
+			if address.Kind() != reflect.String || label.Kind() != reflect.String {
+				continue
+			}
+			if l, ok := names[address.String()]; ok {
+				label.SetString(l.Name)
+			}
+		}
+		return c
+	}
+	return v
+}
+
+func wantsLabels(r *http.Request) bool {
+	return r.URL.Query().Get("labels") == "true"
+}
+
+// labelPayload names the known addresses of a response when the request
+// asks for it with ?labels=true.
+func labelPayload(r *http.Request, payload interface{}) (interface{}, error) {
+	if !wantsLabels(r) || payload == nil {
+		return payload, nil
+	}
+	names, err := chainOf(r.Context()).labels.get(r.Context())
+	if err != nil || len(names) == 0 {
+		return payload, err
+	}
+	return labelled(reflect.ValueOf(payload), names).Interface(), nil
+}
+
+// adminOnly serves h only to requests bearing adminToken.
+func adminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config_.AdminToken == "" {
+			respondWithError(w, r, apierr.Unauthenticatedf("the admin api is disabled, set adminToken to enable it"))
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config_.AdminToken)) != 1 {
+			respondWithError(w, r, apierr.Unauthenticatedf("missing or wrong admin token"))
+			return
+		}
+		h(w, r)
+	}
+}
+
+// writer is the database of the chain a request is for, to change it.
+func writer(ctx context.Context) (Writer, error) {
+	w, ok := backend(ctx).(Writer)
+	if !ok {
+		return nil, apierr.Unavailablef("the backend is read only")
+	}
+	return w, nil
+}
+
+func getLabel(w http.ResponseWriter, r *http.Request) {
+	label, err := backend(r.Context()).Label(r.Context(), mux.Vars(r)["address"])
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	respondWithJson(w, r, http.StatusOK, label)
+}
+
+// getLabels lists the labels tagged ?tag=, or every label, by address.
+func getLabels(w http.ResponseWriter, r *http.Request) {
+	labels, err := backend(r.Context()).Labels(r.Context(), strings.ToLower(r.URL.Query().Get("tag")))
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	if labels == nil {
+		labels = []Label{}
+	}
+	respondWithJson(w, r, http.StatusOK, labels)
+}
+
+func putLabel(w http.ResponseWriter, r *http.Request) {
+	var label Label
+	if err := json.NewDecoder(r.Body).Decode(&label); err != nil {
+		respondWithError(w, r, apierr.InvalidArgumentf("invalid label: %v", err))
+		return
+	}
+	label.Address = mux.Vars(r)["address"]
+	if err := normalizeLabel(&label); err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	db, err := writer(r.Context())
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	if err := db.SetLabel(r.Context(), label); err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	chainOf(r.Context()).labels.invalidate()
+	respondWithJson(w, r, http.StatusOK, label)
+}
+
+func deleteLabel(w http.ResponseWriter, r *http.Request) {
+	db, err := writer(r.Context())
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	if err := db.DeleteLabel(r.Context(), mux.Vars(r)["address"]); err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	chainOf(r.Context()).labels.invalidate()
+	w.WriteHeader(http.StatusNoContent)
+}
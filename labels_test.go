@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "github.com/ubiq/spectrum-api/config"
+	. "github.com/ubiq/spectrum-api/dao"
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+func TestLabelled(t *testing.T) {
+	const payee = "0x2222222222222222222222222222222222222222"
+	names := map[string]Label{miner: {Address: miner, Name: "Pool"}, payee: {Address: payee, Name: "Exchange"}}
+
+	type page struct {
+		Txns    []*Transaction
+		Blocks  [][]Block
+		Pending *PendingTxn
+		Any     interface{}
+		None    []Transaction
+		Missing *Block
+	}
+	in := page{
+		Txns:    []*Transaction{{From: miner, To: payee}, nil},
+		Blocks:  [][]Block{{{Miner: miner}}, {{Miner: payee}, {Miner: "0x3333333333333333333333333333333333333333"}}},
+		Pending: &PendingTxn{Status: "pending", Transaction: Transaction{From: payee}},
+		Any:     Uncle{Miner: miner},
+	}
+	out := labelled(reflect.ValueOf(in), names).Interface().(page)
+
+	if out.Txns[0].FromLabel != "Pool" || out.Txns[0].ToLabel != "Exchange" || out.Txns[1] != nil {
+		t.Errorf("transactions %+v", out.Txns)
+	}
+	if out.Blocks[0][0].MinerLabel != "Pool" || out.Blocks[1][0].MinerLabel != "Exchange" || out.Blocks[1][1].MinerLabel != "" {
+		t.Errorf("blocks %+v", out.Blocks)
+	}
+	if out.Pending.Transaction.FromLabel != "Exchange" || out.Pending.Transaction.ToLabel != "" {
+		t.Errorf("pending %+v", out.Pending)
+	}
+	if u := out.Any.(Uncle); u.MinerLabel != "Pool" {
+		t.Errorf("interface %+v", u)
+	}
+	if out.None != nil || out.Missing != nil {
+		t.Errorf("nil fields became %v, %v", out.None, out.Missing)
+	}
+
+	// The input, which handlers may have cached, is left as it was.
+	if in.Txns[0].FromLabel != "" || in.Blocks[0][0].MinerLabel != "" || in.Pending.Transaction.FromLabel != "" || in.Any.(Uncle).MinerLabel != "" {
+		t.Errorf("labelled changed its input: %+v", in)
+	}
+}
+
+func TestAdminOnly(t *testing.T) {
+	defer func(saved Config) { config_ = saved }(config_)
+	h := adminOnly(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+
+	tests := []struct {
+		name   string
+		admin  string
+		header string
+		status int
+	}{
+		{name: "disabled", header: "Bearer ", status: http.StatusUnauthorized},
+		{name: "missing", admin: "0123456789abcdef", status: http.StatusUnauthorized},
+		{name: "wrong", admin: "0123456789abcdef", header: "Bearer fedcba9876543210", status: http.StatusUnauthorized},
+		{name: "prefix", admin: "0123456789abcdef", header: "Bearer 0123456789abcde", status: http.StatusUnauthorized},
+		{name: "right", admin: "0123456789abcdef", header: "Bearer 0123456789abcdef", status: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		config_.AdminToken = tt.admin
+		req := httptest.NewRequest("PUT", "/v1/labels/"+miner, nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: answered %d %s", tt.name, rec.Code, rec.Body)
+		}
+	}
+}
+
+// Labels are cached for labelCacheTTL, but a PUT or DELETE shows up at once.
+func TestLabelCacheInvalidation(t *testing.T) {
+	defer func(saved Config) { config_ = saved }(config_)
+	config_.AdminToken = "0123456789abcdef"
+	db := &BoltDAO{DataDir: t.TempDir()}
+	db.Connect()
+	t.Cleanup(db.Close)
+	if err := db.AddBlock(context.Background(), Block{Number: 1, Hash: "0x1", Miner: miner}, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	saved := chains
+	t.Cleanup(func() { chains = saved })
+	chains = []*chain{{Chain: Chain{Name: "ubiq"}, dao: db, labels: &labelCache{}}}
+	r := newRouter()
+
+	minerLabel := func() string {
+		t.Helper()
+		var b Block
+		getJSON(t, "/v1/block/1?labels=true", &b)
+		return b.MinerLabel
+	}
+	send := func(method string, body string, status int) {
+		t.Helper()
+		req := httptest.NewRequest(method, "/v1/labels/"+miner, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+config_.AdminToken)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != status {
+			t.Fatalf("%s answered %d %s", method, rec.Code, rec.Body)
+		}
+	}
+
+	if l := minerLabel(); l != "" {
+		t.Errorf("unlabelled miner named %q", l)
+	}
+	send("PUT", `{"name": "Pool"}`, http.StatusOK)
+	if l := minerLabel(); l != "Pool" {
+		t.Errorf("after PUT the miner is named %q", l)
+	}
+	send("PUT", `{"name": "Renamed pool"}`, http.StatusOK)
+	if l := minerLabel(); l != "Renamed pool" {
+		t.Errorf("after a second PUT the miner is named %q", l)
+	}
+	send("DELETE", "", http.StatusNoContent)
+	if l := minerLabel(); l != "" {
+		t.Errorf("after DELETE the miner is named %q", l)
+	}
+}
@@ -1,30 +1,48 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"flag"
 	"net/http"
 	"os"
+	"os/signal"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
+	"github.com/ubiq/spectrum-api/apierr"
 	. "github.com/ubiq/spectrum-api/config"
 	. "github.com/ubiq/spectrum-api/dao"
 	. "github.com/ubiq/spectrum-api/models"
 )
 
 var config_ = Config{}
-var dao_ = SpectrumDAO{}
-var port string
 
 type AccountTxn struct {
 	Txns  []Transaction `bson:"txns" json:"txns"`
 	Total int           `bson:"total" json:"total"`
 }
 
+type PendingTxn struct {
+	Status      string      `bson:"status" json:"status"`
+	Transaction Transaction `bson:"transaction" json:"transaction"`
+}
+
+type AccountTraces struct {
+	Traces []Trace `bson:"traces" json:"traces"`
+	Total  int     `bson:"total" json:"total"`
+}
+
 type AccountTokenTransfer struct {
 	Txns  []TokenTransfer `bson:"txns" json:"txns"`
 	Total int             `bson:"total" json:"total"`
@@ -40,61 +58,91 @@ type UncleRes struct {
 	Total  int     `bson:"total" json:"total"`
 }
 
+// BlockDetail is everything a block page shows: the block, its transactions
+// by index, the uncles it included and its token transfers.
+type BlockDetail struct {
+	Block        Block           `bson:"block" json:"block"`
+	Transactions []Transaction   `bson:"transactions" json:"transactions"`
+	Uncles       []Uncle         `bson:"uncles" json:"uncles"`
+	Transfers    []TokenTransfer `bson:"transfers" json:"transfers"`
+}
+
 func getBlockByHash(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
-	block, err := dao_.BlockByHash(params["hash"])
+	block, err := backend(r.Context()).BlockByHash(r.Context(), params["hash"])
+	canonical := true
+	if err == ErrNotFound {
+		block, err = backend(r.Context()).ForkedBlockByHash(r.Context(), params["hash"])
+		canonical = false
+	}
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	head, err := chainHead(r.Context())
 	if err != nil {
-		respondWithError(w, r, http.StatusBadRequest, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
+	markBlock(head, &block, canonical)
 
 	respondWithJson(w, r, http.StatusOK, block)
 }
 
 func getBlockByNumber(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
-	number, uerr := strconv.ParseUint(params["number"], 10, 64)
-	if uerr != nil {
-		respondWithError(w, r, http.StatusBadRequest, uerr.Error())
+	number, err := parseBlockNumber(params["number"])
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	block, err := backend(r.Context()).BlockByNumber(r.Context(), number)
+	if err != nil {
+		respondWithError(w, r, err)
 		return
 	}
-	block, err := dao_.BlockByNumber(number)
+	head, err := chainHead(r.Context())
 	if err != nil {
-		respondWithError(w, r, http.StatusBadRequest, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
+	markBlock(head, &block, true)
 	respondWithJson(w, r, http.StatusOK, block)
 }
 
 func getLatestBlock(w http.ResponseWriter, r *http.Request) {
-	blocks, err := dao_.LatestBlock()
+	blocks, err := backend(r.Context()).LatestBlock(r.Context())
 	if err != nil {
-		respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
+	markBlock(blocks.Number, &blocks, true)
 	respondWithJson(w, r, http.StatusOK, blocks)
 }
 
 func getLatestBlocks(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
-	limit, err := strconv.Atoi(params["limit"])
+	limit, err := parseLimit(r, params["limit"])
 	if err != nil {
-		respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
-	if limit > 1000 {
-		limit = 1000
+	blocks, err := backend(r.Context()).LatestBlocks(r.Context(), limit)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
 	}
-	blocks, err := dao_.LatestBlocks(limit)
+	count, err := backend(r.Context()).TotalBlockCount(r.Context())
 	if err != nil {
-		respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
-	count, err := dao_.TotalBlockCount()
+	head, err := chainHead(r.Context())
 	if err != nil {
-		respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
+	markBlocks(head, blocks, true)
 
 	var res BlockRes
 	res.Blocks = blocks
@@ -105,40 +153,45 @@ func getLatestBlocks(w http.ResponseWriter, r *http.Request) {
 
 func getLatestForkedBlocks(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
-	limit, err := strconv.Atoi(params["limit"])
+	limit, err := parseLimit(r, params["limit"])
 	if err != nil {
-		respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
-	if limit > 1000 {
-		limit = 1000
-	}
-	blocks, err := dao_.LatestForkedBlocks(limit)
+	blocks, err := backend(r.Context()).LatestForkedBlocks(r.Context(), limit)
 	if err != nil {
-		respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
+	markBlocks(0, blocks, false)
 	respondWithJson(w, r, http.StatusOK, blocks)
 }
 
 func getLatestTransactions(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
-	limit, err := strconv.Atoi(params["limit"])
+	limit, err := parseLimit(r, params["limit"])
 	if err != nil {
-		respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
-	if limit > 1000 {
-		limit = 1000
+	txns, err := backend(r.Context()).LatestTransactions(r.Context(), limit)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
 	}
-	txns, err := dao_.LatestTransactions(limit)
+	count, err := backend(r.Context()).TotalTxnCount(r.Context())
 	if err != nil {
-		respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
-	count, err := dao_.TotalTxnCount()
+	head, err := chainHead(r.Context())
 	if err != nil {
-		respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
+		return
+	}
+	markTxns(head, txns)
+	if err := fiatTxns(r, txns); err != nil {
+		respondWithError(w, r, err)
 		return
 	}
 
@@ -149,16 +202,41 @@ func getLatestTransactions(w http.ResponseWriter, r *http.Request) {
 	respondWithJson(w, r, http.StatusOK, res)
 }
 
+// txnFilter reads the ?status= filter of account transaction routes.
+func txnFilter(r *http.Request) (TxnFilter, error) {
+	filter := TxnFilter{Status: r.URL.Query().Get("status")}
+	switch filter.Status {
+	case "", "success", "failed":
+		return filter, nil
+	}
+	return filter, apierr.InvalidArgumentf("status must be success or failed")
+}
+
 func getLatestTransactionsByAccount(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
-	txns, err := dao_.LatestTransactionsByAccount(params["hash"])
+	filter, err := txnFilter(r)
 	if err != nil {
-		respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
-	count, err := dao_.TxnCount(params["hash"])
+	txns, err := backend(r.Context()).LatestTransactionsByAccount(r.Context(), params["address"], filter)
 	if err != nil {
-		respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
+		return
+	}
+	count, err := backend(r.Context()).TxnCount(r.Context(), params["address"], filter)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	head, err := chainHead(r.Context())
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	markTxns(head, txns)
+	if err := fiatTxns(r, txns); err != nil {
+		respondWithError(w, r, err)
 		return
 	}
 
@@ -171,31 +249,121 @@ func getLatestTransactionsByAccount(w http.ResponseWriter, r *http.Request) {
 
 func getTransactionsByBlockNumber(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
-	number, uerr := strconv.ParseUint(params["number"], 10, 64)
-	if uerr != nil {
-		respondWithError(w, r, http.StatusBadRequest, uerr.Error())
+	number, err := parseBlockNumber(params["number"])
+	if err != nil {
+		respondWithError(w, r, err)
 		return
 	}
-	txns, err := dao_.TransactionsByBlockNumber(number)
+	txns, err := backend(r.Context()).TransactionsByBlockNumber(r.Context(), number)
 	if err != nil {
-		respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
+		return
+	}
+	head, err := chainHead(r.Context())
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	markTxns(head, txns)
+	if err := fiatTxns(r, txns); err != nil {
+		respondWithError(w, r, err)
 		return
 	}
 
 	respondWithJson(w, r, http.StatusOK, txns)
 }
 
+// getBlockDetail returns a block, by number or hash, with its transactions,
+// uncles and token transfers, read in parallel. A forked block is returned
+// with the transactions it held; its uncles and transfers aren't kept.
+func getBlockDetail(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	block, canonical, err := blockByID(r, mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	detail := BlockDetail{Block: block, Transactions: []Transaction{}, Uncles: []Uncle{}, Transfers: []TokenTransfer{}}
+
+	var wg sync.WaitGroup
+	var head uint64
+	var txns []Transaction
+	var uncles []Uncle
+	var transfers []TokenTransfer
+	errs := make([]error, 4)
+	fetch := func(i int, fn func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn()
+		}()
+	}
+	fetch(0, func() (err error) {
+		head, err = chainHead(ctx)
+		return err
+	})
+	if canonical {
+		fetch(1, func() (err error) {
+			txns, err = backend(ctx).TransactionsByBlockNumber(ctx, block.Number)
+			return err
+		})
+		fetch(2, func() (err error) {
+			uncles, err = backend(ctx).UnclesByBlockNumber(ctx, block.Number)
+			return err
+		})
+		fetch(3, func() (err error) {
+			transfers, err = backend(ctx).TokenTransfersByBlockNumber(ctx, block.Number)
+			return err
+		})
+	} else {
+		fetch(1, func() (err error) {
+			txns, err = backend(ctx).ForkedTransactions(ctx, block.Hash)
+			return err
+		})
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			respondWithError(w, r, err)
+			return
+		}
+	}
+
+	markBlock(head, &detail.Block, canonical)
+	sort.Slice(txns, func(i, j int) bool { return txns[i].TransactionIndex < txns[j].TransactionIndex })
+	if canonical {
+		markTxns(head, txns)
+	}
+	if err := fiatTxns(r, txns); err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	if err := fiatTransfers(r, transfers); err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	detail.Transactions = append(detail.Transactions, txns...)
+	detail.Uncles = append(detail.Uncles, uncles...)
+	detail.Transfers = append(detail.Transfers, transfers...)
+	respondWithJson(w, r, http.StatusOK, detail)
+}
+
 func getLatestTokenTransfersByAccount(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
-	txns, err := dao_.LatestTokenTransfersByAccount(params["hash"])
+	txns, err := backend(r.Context()).LatestTokenTransfersByAccount(r.Context(), params["address"])
 	if err != nil {
-		respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
 
-	count, err := dao_.TokenTransferCount(params["hash"])
+	count, err := backend(r.Context()).TokenTransferCount(r.Context(), params["address"])
 	if err != nil {
-		respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
+		return
+	}
+
+	if err := fiatTransfers(r, txns); err != nil {
+		respondWithError(w, r, err)
 		return
 	}
 
@@ -208,15 +376,20 @@ func getLatestTokenTransfersByAccount(w http.ResponseWriter, r *http.Request) {
 
 func getTokenTransfersByAccount(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
-	txns, err := dao_.TokenTransfersByAccount(params["token"], params["account"])
+	txns, err := backend(r.Context()).TokenTransfersByAccount(r.Context(), params["token"], params["account"])
 	if err != nil {
-		respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
 
-	count, err := dao_.TokenTransferByAccountCount(params["token"], params["account"])
+	count, err := backend(r.Context()).TokenTransferByAccountCount(r.Context(), params["token"], params["account"])
 	if err != nil {
-		respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
+		return
+	}
+
+	if err := fiatTransfers(r, txns); err != nil {
+		respondWithError(w, r, err)
 		return
 	}
 
@@ -229,24 +402,26 @@ func getTokenTransfersByAccount(w http.ResponseWriter, r *http.Request) {
 
 func getLatestTokenTransfers(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
-	limit, err := strconv.Atoi(params["limit"])
+	limit, err := parseLimit(r, params["limit"])
 	if err != nil {
-		respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
-	if limit > 1000 {
-		limit = 1000
-	}
 
-	transfers, err := dao_.LatestTokenTransfers(limit)
+	transfers, err := backend(r.Context()).LatestTokenTransfers(r.Context(), limit)
 	if err != nil {
-		respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
 
-	count, err := dao_.TotalTokenTransferCount()
+	count, err := backend(r.Context()).TotalTokenTransferCount(r.Context())
 	if err != nil {
-		respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
+		return
+	}
+
+	if err := fiatTransfers(r, transfers); err != nil {
+		respondWithError(w, r, err)
 		return
 	}
 
@@ -259,15 +434,20 @@ func getLatestTokenTransfers(w http.ResponseWriter, r *http.Request) {
 
 func getLatestTransfersByToken(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
-	txns, err := dao_.LatestTransfersByToken(params["hash"])
+	txns, err := backend(r.Context()).LatestTransfersByToken(r.Context(), params["token"])
 	if err != nil {
-		respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
 
-	count, err := dao_.TokenTransferCountByContract(params["hash"])
+	count, err := backend(r.Context()).TokenTransferCountByContract(r.Context(), params["token"])
 	if err != nil {
-		respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
+		return
+	}
+
+	if err := fiatTransfers(r, txns); err != nil {
+		respondWithError(w, r, err)
 		return
 	}
 
@@ -280,22 +460,19 @@ func getLatestTransfersByToken(w http.ResponseWriter, r *http.Request) {
 
 func getLatestUncles(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
-	limit, err := strconv.Atoi(params["limit"])
+	limit, err := parseLimit(r, params["limit"])
 	if err != nil {
-		respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
-	if limit > 1000 {
-		limit = 1000
-	}
-	uncles, err := dao_.LatestUncles(limit)
+	uncles, err := backend(r.Context()).LatestUncles(r.Context(), limit)
 	if err != nil {
-		respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
-	count, err := dao_.TotalUncleCount()
+	count, err := backend(r.Context()).TotalUncleCount(r.Context())
 	if err != nil {
-		respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
 
@@ -308,19 +485,136 @@ func getLatestUncles(w http.ResponseWriter, r *http.Request) {
 
 func getTransactionByHash(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
-	txn, err := dao_.TransactionByHash(params["hash"])
+	txn, err := backend(r.Context()).TransactionByHash(r.Context(), params["hash"])
+	if err == ErrNotFound {
+		if pending, ok := pendingTxn(r.Context(), params["hash"]); ok {
+			respondWithJson(w, r, http.StatusOK, PendingTxn{Status: "pending", Transaction: pending})
+			return
+		}
+		respondWithError(w, r, err)
+		return
+	}
 	if err != nil {
-		respondWithError(w, r, http.StatusOK, err.Error())
+		respondWithError(w, r, err)
+		return
+	}
+	head, err := chainHead(r.Context())
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	markTxn(head, &txn)
+	if err := fiatTxn(r, &txn); err != nil {
+		respondWithError(w, r, err)
 		return
 	}
 	respondWithJson(w, r, http.StatusOK, txn)
 }
 
+func pendingTxn(ctx context.Context, hash string) (Transaction, bool) {
+	pool := chainOf(ctx).pool
+	if pool == nil {
+		return Transaction{}, false
+	}
+	return pool.Get(hash)
+}
+
+func getPending(w http.ResponseWriter, r *http.Request) {
+	pool := chainOf(r.Context()).pool
+	if pool == nil {
+		respondWithError(w, r, apierr.Unavailablef("pending pool disabled"))
+		return
+	}
+	respondWithJson(w, r, http.StatusOK, pool.All())
+}
+
+func getPendingByAddress(w http.ResponseWriter, r *http.Request) {
+	pool := chainOf(r.Context()).pool
+	if pool == nil {
+		respondWithError(w, r, apierr.Unavailablef("pending pool disabled"))
+		return
+	}
+	params := mux.Vars(r)
+	respondWithJson(w, r, http.StatusOK, pool.ByAddress(params["address"]))
+}
+
+func getTransactionReceipt(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	txn, err := backend(r.Context()).TransactionByHash(r.Context(), params["hash"])
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	head, err := chainHead(r.Context())
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	respondWithJson(w, r, http.StatusOK, Receipt{
+		TransactionHash:   txn.Hash,
+		TransactionIndex:  txn.TransactionIndex,
+		BlockHash:         txn.BlockHash,
+		BlockNumber:       txn.BlockNumber,
+		From:              txn.From,
+		To:                txn.To,
+		GasUsed:           txn.GasUsed,
+		CumulativeGasUsed: txn.CumulativeGasUsed,
+		EffectiveGasPrice: txn.EffectiveGasPrice,
+		ContractAddress:   txn.ContractAddress,
+		Logs:              txn.Logs,
+		LogsBloom:         txn.LogsBloom,
+		Status:            txn.Status,
+		Type:              txn.Type,
+		Confirmations:     confirmations(head, txn.BlockNumber),
+	})
+}
+
+func getTransactionTraces(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	traces, err := backend(r.Context()).TracesByTransaction(r.Context(), params["hash"])
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	if traces == nil {
+		traces = []Trace{}
+	}
+	respondWithJson(w, r, http.StatusOK, traces)
+}
+
+func getLatestTracesByAccount(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	traces, err := backend(r.Context()).LatestTracesByAccount(r.Context(), params["address"])
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	count, err := backend(r.Context()).TraceCount(r.Context(), params["address"])
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	if traces == nil {
+		traces = []Trace{}
+	}
+	respondWithJson(w, r, http.StatusOK, AccountTraces{Traces: traces, Total: count})
+}
+
 func getTransactionByContractAddress(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
-	txn, err := dao_.TransactionByContractAddress(params["hash"])
+	txn, err := backend(r.Context()).TransactionByContractAddress(r.Context(), params["address"])
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	head, err := chainHead(r.Context())
 	if err != nil {
-		respondWithError(w, r, http.StatusOK, err.Error())
+		respondWithError(w, r, err)
+		return
+	}
+	markTxn(head, &txn)
+	if err := fiatTxn(r, &txn); err != nil {
+		respondWithError(w, r, err)
 		return
 	}
 	respondWithJson(w, r, http.StatusOK, txn)
@@ -328,36 +622,112 @@ func getTransactionByContractAddress(w http.ResponseWriter, r *http.Request) {
 
 func getUncleByHash(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
-	uncle, err := dao_.UncleByHash(params["hash"])
+	uncle, err := backend(r.Context()).UncleByHash(r.Context(), params["hash"])
 	if err != nil {
-		respondWithError(w, r, http.StatusOK, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
 	respondWithJson(w, r, http.StatusOK, uncle)
 }
 
 func getStore(w http.ResponseWriter, r *http.Request) {
-	store, err := dao_.Store()
+	store, err := backend(r.Context()).Store(r.Context())
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	head, err := chainHead(r.Context())
 	if err != nil {
-		respondWithError(w, r, http.StatusOK, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
+	markBlock(head, &store.LatestBlock, true)
 	respondWithJson(w, r, http.StatusOK, store)
 }
 
-func respondWithError(w http.ResponseWriter, r *http.Request, code int, msg string) {
+type requestIDKey struct{}
+
+// withRequestID tags each request with an id, taken from an X-Request-Id
+// header when the client or a proxy sent one, that is echoed back and
+// included in error bodies and logs.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if id == "" || len(id) > 128 {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-Id", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
 
-	log.WithFields(log.Fields{
-		"path":  r.URL,
-		"ip":    r.RemoteAddr,
-		"error": msg,
-	}).Error()
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
 
-	respondWithJson(w, r, code, map[string]string{"error": msg})
+// parseLimit reads a limit: a positive number, capped at the route's
+// routeLimits entry or maxLimit.
+func parseLimit(r *http.Request, v string) (int, error) {
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 {
+		return 0, apierr.InvalidArgumentf("limit must be a positive number")
+	}
+	path := r.URL.Path
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			path = chainPrefix.ReplaceAllString(tpl, "")
+		}
+	}
+	if max := config_.RouteLimit(strings.TrimPrefix(path, "/v1")); limit > max {
+		limit = max
+	}
+	return limit, nil
+}
+
+func parseBlockNumber(v string) (uint64, error) {
+	number, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, apierr.InvalidArgumentf("invalid block number %q", v)
+	}
+	return number, nil
+}
+
+// respondWithError reports err with the status of its apierr code; errors
+// that aren't an apierr.Error are internal. The error it wraps, if any, is
+// only logged.
+func respondWithError(w http.ResponseWriter, r *http.Request, err error) {
+	e := apierr.From(err)
+
+	fields := log.Fields{
+		"path":      r.URL,
+		"ip":        r.RemoteAddr,
+		"requestId": requestID(r.Context()),
+		"code":      e.Code,
+		"error":     e.Message,
+	}
+	if e.Err != nil {
+		fields["cause"] = e.Err.Error()
+	}
+	// Client mistakes are routine; only failures of the api are errors.
+	if e.Code.Status() >= http.StatusInternalServerError {
+		log.WithFields(fields).Error()
+	} else {
+		log.WithFields(fields).Info()
+	}
+
+	respondWithJson(w, r, e.Code.Status(), map[string]string{"error": e.Message, "code": string(e.Code), "requestId": requestID(r.Context())})
 }
 
 func respondWithJson(w http.ResponseWriter, r *http.Request, code int, payload interface{}) {
 
+	payload, err := labelPayload(r, payload)
+	if err != nil {
+		log.Errorf("Could not label response: %v", err)
+	}
+
 	response, err := json.Marshal(payload)
 
 	if err != nil {
@@ -369,30 +739,84 @@ func respondWithJson(w http.ResponseWriter, r *http.Request, code int, payload i
 		"ip":   r.RemoteAddr,
 	}).Info()
 
+	if wantsChecksum(r) {
+		response = checksumJSON(response)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
 	w.Write(response)
 }
 
-func init() {
-	config_.Read()
+// loadConfig reads the configuration with fs, which may already hold the
+// flags of a command, and applies the logging settings.
+func loadConfig(fs *flag.FlagSet, args []string) {
+	if err := config_.Load(fs, args); err != nil {
+		log.Fatal(err)
+	}
+	if err := config_.Validate(); err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
 
-	port = config_.Port
-	dao_.Server = config_.Server
-	dao_.Database = config_.Database
-	dao_.Connect()
+	level, _ := log.ParseLevel(config_.LogLevel)
+	log.SetLevel(level)
+	if config_.LogFormat == "json" {
+		log.SetFormatter(&log.JSONFormatter{})
+	}
 }
 
-func init() {
-	log.SetFormatter(&log.TextFormatter{DisableLevelTruncation: true, FullTimestamp: true, TimestampFormat: time.RFC822})
-	log.SetOutput(os.Stdout)
-	log.SetLevel(log.InfoLevel)
+// openBackend connects to the database of ch with the configured backend,
+// which adds the alloc of ch.GenesisFile to the balances it sums.
+func openBackend(ch Chain) Backend {
+	var genesis *Genesis
+	if ch.GenesisFile != "" {
+		var err error
+		if genesis, err = ReadGenesis(ch.GenesisFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var dao Backend
+	switch config_.Backend {
+	case "mongo":
+		server := ch.Server
+		if ch.MongoUri != "" {
+			server = ch.MongoUri
+		}
+		dao = &SpectrumDAO{
+			Server:              server,
+			Database:            ch.Database,
+			Username:            config_.MongoUsername,
+			Password:            config_.MongoPassword,
+			AuthSource:          config_.MongoAuthSource,
+			QueryTimeout:        config_.QueryTimeout,
+			MaxPoolSize:         config_.MaxPoolSize,
+			MinPoolSize:         config_.MinPoolSize,
+			HeavyReadPreference: config_.HeavyReadPreference,
+			Genesis:             genesis,
+		}
+	case "postgres":
+		dao = &PostgresDAO{
+			URL:          ch.PostgresUrl,
+			QueryTimeout: config_.QueryTimeout,
+			MaxOpenConns: int(config_.MaxPoolSize),
+			Genesis:      genesis,
+		}
+	case "bolt":
+		dao = &BoltDAO{
+			DataDir: ch.DataDir,
+			Genesis: genesis,
+		}
+	default:
+		log.Fatal("Unknown backend ", config_.Backend)
+	}
+	dao.Connect()
+	return dao
 }
 
-func main() {
-	log.Info("Api started on port ", port)
-
-	r := mux.NewRouter()
+// routesV1 registers the original api, served under /v1 and, deprecated, at
+// the root.
+func routesV1(r *mux.Router) {
 	r.HandleFunc("/status", getStore).Methods("GET")
 	r.HandleFunc("/block/{number}", getBlockByNumber).Methods("GET")
 	r.HandleFunc("/blockbyhash/{hash}", getBlockByHash).Methods("GET")
@@ -401,18 +825,131 @@ func main() {
 	r.HandleFunc("/latestblocks/{limit}", getLatestBlocks).Methods("GET")
 	r.HandleFunc("/latestforkedblocks/{limit}", getLatestForkedBlocks).Methods("GET")
 	r.HandleFunc("/latesttransactions/{limit}", getLatestTransactions).Methods("GET")
-	r.HandleFunc("/latestaccounttxns/{hash}", getLatestTransactionsByAccount).Methods("GET")
-	r.HandleFunc("/latestaccounttokentxns/{hash}", getLatestTokenTransfersByAccount).Methods("GET")
-	r.HandleFunc("/latesttransfersbytoken/{hash}", getLatestTransfersByToken).Methods("GET")
+	r.HandleFunc("/latestaccounttxns/{address}", getLatestTransactionsByAccount).Methods("GET")
+	r.HandleFunc("/latestaccounttokentxns/{address}", getLatestTokenTransfersByAccount).Methods("GET")
+	r.HandleFunc("/latesttransfersbytoken/{token}", getLatestTransfersByToken).Methods("GET")
 	r.HandleFunc("/tokentransfersbyaccount/{token}/{account}", getTokenTransfersByAccount).Methods("GET")
 	r.HandleFunc("/latesttokentransfers/{limit}", getLatestTokenTransfers).Methods("GET")
 	r.HandleFunc("/latestuncles/{limit}", getLatestUncles).Methods("GET")
 	r.HandleFunc("/transaction/{hash}", getTransactionByHash).Methods("GET")
-	r.HandleFunc("/transactionbycontract/{hash}", getTransactionByContractAddress).Methods("GET")
+	r.HandleFunc("/transaction/{hash}/receipt", getTransactionReceipt).Methods("GET")
+	r.HandleFunc("/transaction/{hash}/internal", getTransactionTraces).Methods("GET")
+	r.HandleFunc("/account/{address}/internal", getLatestTracesByAccount).Methods("GET")
+	r.HandleFunc("/account/{address}/balance", getBalance).Methods("GET")
+	r.HandleFunc("/account/{address}/balance/history", getBalanceHistory).Methods("GET")
+	r.HandleFunc("/snapshot", adminOnly(getSnapshot)).Methods("GET")
+	r.HandleFunc("/watches", adminOnly(getWatches)).Methods("GET")
+	r.HandleFunc("/watches", adminOnly(createWatch)).Methods("POST")
+	r.HandleFunc("/watches/{id}", adminOnly(getWatch)).Methods("GET")
+	r.HandleFunc("/watches/{id}", adminOnly(deleteWatch)).Methods("DELETE")
+	r.HandleFunc("/watches/{id}/test", adminOnly(testWatch)).Methods("POST")
+	r.HandleFunc("/watches/{id}/deliveries", adminOnly(getDeliveries)).Methods("GET")
+	r.HandleFunc("/watches/{id}/deliveries/{delivery}/retry", adminOnly(retryDelivery)).Methods("POST")
+	r.HandleFunc("/transactionbycontract/{address}", getTransactionByContractAddress).Methods("GET")
 	r.HandleFunc("/uncle/{hash}", getUncleByHash).Methods("GET")
+	r.HandleFunc("/pending", getPending).Methods("GET")
+	r.HandleFunc("/pending/{address}", getPendingByAddress).Methods("GET")
+	r.HandleFunc("/gas", getGasPrices).Methods("GET")
+	r.HandleFunc("/gas/history", getGasHistory).Methods("GET")
+	r.HandleFunc("/reorgs", getReorgs).Methods("GET")
+	r.HandleFunc("/reorg/{hash}", getReorg).Methods("GET")
+	r.HandleFunc("/supply", getSupply).Methods("GET")
+	r.HandleFunc("/supply/history", getSupplyHistory).Methods("GET")
+	r.HandleFunc("/supply/circulating", getCirculatingSupply).Methods("GET")
+	r.HandleFunc("/price/history", getPriceHistory).Methods("GET")
+	r.HandleFunc("/labels", getLabels).Methods("GET")
+	r.HandleFunc("/labels/{address}", getLabel).Methods("GET")
+	r.HandleFunc("/labels/{address}", adminOnly(putLabel)).Methods("PUT")
+	r.HandleFunc("/labels/{address}", adminOnly(deleteLabel)).Methods("DELETE")
+	r.HandleFunc("/contract/{address}/verify", adminOnly(verifyContract)).Methods("POST")
+	r.HandleFunc("/contract/{address}/source", getContractSource).Methods("GET")
+	r.HandleFunc("/contracts", getContracts).Methods("GET")
+	r.HandleFunc("/contracts/history", getContractHistory).Methods("GET")
+	r.HandleFunc("/block/{id}/full", getBlockDetail).Methods("GET")
+}
 
-	handler := cors.Default().Handler(r)
-	if err := http.ListenAndServe(port, handler); err != nil {
-		log.Fatal(err)
-	}
+func init() {
+	log.SetFormatter(&log.TextFormatter{DisableLevelTruncation: true, FullTimestamp: true, TimestampFormat: time.RFC822})
+	log.SetOutput(os.Stdout)
+	log.SetLevel(log.InfoLevel)
+}
+
+// newRouter registers the api routes of every served chain.
+func newRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.Use(validateVars, withChain)
+	r.HandleFunc("/chains", getChains).Methods("GET")
+	routesV1(r.PathPrefix("/v1").Subrouter())
+	routesV2(r.PathPrefix("/v2").Subrouter())
+	routesChains(r)
+	legacy := r.NewRoute().Subrouter()
+	legacy.Use(deprecated)
+	routesV1(legacy)
+	return r
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "import":
+			runImport(os.Args[2:])
+			return
+		case "index":
+			runIndex(os.Args[2:])
+			return
+		case "prices":
+			runPrices(os.Args[2:])
+			return
+		case "snapshot":
+			runSnapshot(os.Args[2:])
+			return
+		}
+	}
+
+	loadConfig(flag.NewFlagSet("spectrum-api", flag.ExitOnError), os.Args[1:])
+	log.Info("Api started on port ", config_.Port)
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	openChains(ctx)
+
+	r := newRouter()
+	serveOpenAPI(r)
+
+	c := cors.New(cors.Options{
+		AllowedOrigins: config_.CorsOrigins,
+		ExposedHeaders: []string{"X-Request-Id", "Deprecation", "Sunset", "Link"},
+	})
+	srv := &http.Server{
+		Addr:         config_.Port,
+		Handler:      withRequestID(c.Handler(r)),
+		ReadTimeout:  config_.ReadTimeout,
+		WriteTimeout: config_.WriteTimeout,
+		IdleTimeout:  config_.IdleTimeout,
+	}
+
+	go func() {
+		var err error
+		if config_.TlsCertFile != "" {
+			err = srv.ListenAndServeTLS(config_.TlsCertFile, config_.TlsKeyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	log.Info("Received ", <-sig, ", draining connections")
+	stop()
+
+	shutdown, cancel := context.WithTimeout(context.Background(), config_.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdown); err != nil {
+		log.Error("Shutdown did not complete: ", err)
+	}
+	closeChains()
+	log.Info("Api stopped")
 }
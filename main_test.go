@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/ubiq/spectrum-api/apierr"
+	. "github.com/ubiq/spectrum-api/config"
+	. "github.com/ubiq/spectrum-api/dao"
+	"github.com/ubiq/spectrum-api/indexer"
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+func TestRespondWithError(t *testing.T) {
+	hook := test.NewGlobal()
+	defer log.StandardLogger().ReplaceHooks(log.LevelHooks{})
+
+	tests := []struct {
+		name    string
+		err     error
+		status  int
+		message string
+		cause   string
+		level   log.Level
+	}{
+		{
+			name:    "api error",
+			err:     apierr.NotFoundf("block 7 not found"),
+			status:  http.StatusNotFound,
+			message: "block 7 not found",
+			level:   log.InfoLevel,
+		},
+		{
+			name:    "internal",
+			err:     fmt.Errorf("reading blocks: %w", errors.New("dial tcp 10.0.0.5:27017: connection refused")),
+			status:  http.StatusInternalServerError,
+			message: "internal error",
+			cause:   "reading blocks: dial tcp 10.0.0.5:27017: connection refused",
+			level:   log.ErrorLevel,
+		},
+		{
+			name:    "unavailable with a cause",
+			err:     &apierr.Error{Code: apierr.Unavailable, Message: "database timed out", Err: errors.New("server 10.0.0.5:27017 timed out")},
+			status:  http.StatusServiceUnavailable,
+			message: "database timed out",
+			cause:   "server 10.0.0.5:27017 timed out",
+			level:   log.ErrorLevel,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook.Reset()
+			h := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				respondWithError(w, r, tt.err)
+			}))
+			req := httptest.NewRequest("GET", "/block/7", nil)
+			req.Header.Set("X-Request-Id", "req-1")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			var body map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.status || body["error"] != tt.message || body["requestId"] != "req-1" {
+				t.Errorf("answered %d %v", rec.Code, body)
+			}
+			if strings.Contains(rec.Body.String(), "10.0.0.5") {
+				t.Errorf("the cause leaked to the client: %s", rec.Body.String())
+			}
+
+			var logged *log.Entry
+			for _, e := range hook.AllEntries() {
+				if e.Data["code"] != nil {
+					logged = e
+				}
+			}
+			if logged == nil {
+				t.Fatal("nothing logged")
+			}
+			if logged.Level != tt.level {
+				t.Errorf("logged at %s, want %s", logged.Level, tt.level)
+			}
+			if logged.Data["requestId"] != "req-1" || logged.Data["error"] != tt.message {
+				t.Errorf("logged %v", logged.Data)
+			}
+			if cause, _ := logged.Data["cause"].(string); cause != tt.cause {
+				t.Errorf("logged cause %q, want %q", cause, tt.cause)
+			}
+		})
+	}
+}
+
+func TestTransactionPending(t *testing.T) {
+	hash := "0x" + strings.Repeat("a1", 32)
+	unknown := "0x" + strings.Repeat("a2", 32)
+
+	// The node's txpool_content holds hash until it is mined.
+	var mu sync.Mutex
+	pending := true
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		content := map[string]map[string]map[string]string{}
+		if pending {
+			content[miner] = map[string]map[string]string{"0": {"hash": hash, "from": miner, "to": contractAddress, "value": "0x64", "gas": "0x5208", "gasPrice": "0x1", "nonce": "0x0"}}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "result": map[string]interface{}{"pending": content}})
+	}))
+	defer node.Close()
+
+	db := &BoltDAO{DataDir: t.TempDir()}
+	db.Connect()
+	t.Cleanup(db.Close)
+	if err := db.AddBlock(context.Background(), Block{Number: 1, Hash: "0x1"}, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	pool := indexer.NewPool(indexer.NewRPC(node.URL))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pool.Run(ctx, 10*time.Millisecond)
+	saved := chains
+	t.Cleanup(func() { chains = saved })
+	chains = []*chain{{Chain: Chain{Name: "ubiq"}, dao: db, pool: pool}}
+	r := newRouter()
+
+	// waitFor waits for the pool to poll the node until hash is pooled or not.
+	waitFor := func(pooled bool) {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			if _, ok := pool.Get(hash); ok == pooled {
+				return
+			}
+		}
+		t.Fatalf("%s pooled is not %v", hash, pooled)
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/transaction/"+unknown, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown hash answered %d %s", rec.Code, rec.Body)
+	}
+
+	waitFor(true)
+	var p PendingTxn
+	getJSON(t, "/v1/transaction/"+hash, &p)
+	if p.Status != "pending" || p.Transaction.Hash != hash || p.Transaction.Value != "100" || p.Transaction.BlockNumber != 0 {
+		t.Errorf("pending answer %+v", p)
+	}
+
+	// Once mined the transaction is stored and leaves the node's pool.
+	mined := Transaction{Hash: hash, BlockNumber: 2, BlockHash: "0x2", From: miner, To: contractAddress, Value: "100"}
+	if err := db.AddBlock(context.Background(), Block{Number: 2, Hash: "0x2"}, []Transaction{mined}, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	pending = false
+	mu.Unlock()
+	waitFor(false)
+	var txn Transaction
+	getJSON(t, "/v1/transaction/"+hash, &txn)
+	if txn.Hash != hash || txn.BlockNumber != 2 || txn.Confirmations != 1 {
+		t.Errorf("mined answer %+v", txn)
+	}
+}
+
+func TestGetBlockDetail(t *testing.T) {
+	hash := func(n int) string { return fmt.Sprintf("0x%064x", n) }
+	db := &BoltDAO{DataDir: t.TempDir()}
+	db.Connect()
+	t.Cleanup(db.Close)
+	ctx := context.Background()
+	txns := []Transaction{
+		{Hash: hash(0x22), BlockNumber: 2, BlockHash: hash(2), TransactionIndex: 1},
+		{Hash: hash(0x21), BlockNumber: 2, BlockHash: hash(2)},
+	}
+	uncles := []Uncle{{Hash: hash(0x2c), BlockNumber: 2, Number: 1}}
+	transfers := []TokenTransfer{{Hash: hash(0x21), BlockNumber: 2, Contract: contractAddress, Value: "1"}}
+	if err := db.AddBlock(ctx, Block{Number: 1, Hash: hash(1)}, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddBlock(ctx, Block{Number: 2, Hash: hash(2)}, txns, uncles, transfers, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Block 3 is forked off with its transaction, then replaced.
+	forked := Transaction{Hash: hash(0x31), BlockNumber: 3, BlockHash: hash(0xf3)}
+	if err := db.AddBlock(ctx, Block{Number: 3, Hash: hash(0xf3)}, []Transaction{forked}, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ForkBlock(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddBlock(ctx, Block{Number: 3, Hash: hash(3)}, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	saved := chains
+	t.Cleanup(func() { chains = saved })
+	chains = []*chain{{Chain: Chain{Name: "ubiq"}, dao: db}}
+
+	tests := []struct {
+		id        string
+		hash      string
+		canonical bool
+		txns      []string
+		uncles    int
+		transfers int
+	}{
+		{id: "2", hash: hash(2), canonical: true, txns: []string{hash(0x21), hash(0x22)}, uncles: 1, transfers: 1},
+		{id: hash(2), hash: hash(2), canonical: true, txns: []string{hash(0x21), hash(0x22)}, uncles: 1, transfers: 1},
+		{id: "0x" + strings.ToUpper(hash(2)[2:]), hash: hash(2), canonical: true, txns: []string{hash(0x21), hash(0x22)}, uncles: 1, transfers: 1},
+		{id: "1", hash: hash(1), canonical: true},
+		{id: hash(0xf3), hash: hash(0xf3), canonical: false, txns: []string{hash(0x31)}},
+		{id: "3", hash: hash(3), canonical: true},
+	}
+	for _, tt := range tests {
+		var detail BlockDetail
+		getJSON(t, "/v1/block/"+tt.id+"/full", &detail)
+		var got []string
+		for _, txn := range detail.Transactions {
+			got = append(got, txn.Hash)
+		}
+		if detail.Block.Hash != tt.hash || detail.Block.Canonical != tt.canonical {
+			t.Errorf("%s: block %s, canonical %v", tt.id, detail.Block.Hash, detail.Block.Canonical)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.txns, ",") || len(detail.Uncles) != tt.uncles || len(detail.Transfers) != tt.transfers {
+			t.Errorf("%s: transactions %v, %d uncles, %d transfers", tt.id, got, len(detail.Uncles), len(detail.Transfers))
+		}
+		if detail.Transactions == nil || detail.Uncles == nil || detail.Transfers == nil {
+			t.Errorf("%s: null lists %+v", tt.id, detail)
+		}
+	}
+
+	r := newRouter()
+	for id, status := range map[string]int{
+		"9":                                  http.StatusNotFound,
+		hash(9):                              http.StatusNotFound,
+		"latest":                             http.StatusBadRequest,
+		"0x1234":                             http.StatusBadRequest,
+		strings.TrimPrefix(hash(0xab), "0x"): http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/block/"+id+"/full", nil))
+		if rec.Code != status {
+			t.Errorf("%s: answered %d %s, want %d", id, rec.Code, rec.Body, status)
+		}
+	}
+}
+
+// testReceipts serves a chain whose head is block 4, with a successful
+// contract creation by miner in block 2, a failed call to the contract in
+// block 3 and, in block 1, a transaction stored before receipts were.
+func testReceipts(t *testing.T) (created, failed, old Transaction) {
+	success, failure := uint64(1), uint64(0)
+	log := TxLog{Address: contractAddress, Topics: []string{"0xe1"}, Data: "0x", BlockNumber: 2, TransactionHash: "0x" + strings.Repeat("c2", 32), BlockHash: "0x2"}
+	created = Transaction{Hash: "0x" + strings.Repeat("c2", 32), BlockNumber: 2, BlockHash: "0x2", TransactionIndex: 0, From: miner,
+		ContractAddress: contractAddress, GasUsed: 90000, CumulativeGasUsed: 90000, EffectiveGasPrice: "20000000000",
+		LogsBloom: "0x" + strings.Repeat("0", 512), Status: &success, Type: 2, Logs: []TxLog{log}}
+	failed = Transaction{Hash: "0x" + strings.Repeat("c3", 32), BlockNumber: 3, BlockHash: "0x3", TransactionIndex: 1, From: miner,
+		To: contractAddress, GasUsed: 30000, CumulativeGasUsed: 51000, EffectiveGasPrice: "1", Status: &failure}
+	old = Transaction{Hash: "0x" + strings.Repeat("c1", 32), BlockNumber: 1, BlockHash: "0x1", From: miner, To: contractAddress}
+
+	db := &BoltDAO{DataDir: t.TempDir()}
+	db.Connect()
+	t.Cleanup(db.Close)
+	for i, txns := range [][]Transaction{{old}, {created}, {failed}, nil} {
+		n := uint64(i + 1)
+		if err := db.AddBlock(context.Background(), Block{Number: n, Hash: fmt.Sprint("0x", n)}, txns, nil, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved := chains
+	t.Cleanup(func() { chains = saved })
+	chains = []*chain{{Chain: Chain{Name: "ubiq"}, dao: db}}
+	return created, failed, old
+}
+
+func TestGetTransactionReceipt(t *testing.T) {
+	created, failed, old := testReceipts(t)
+
+	for _, tt := range []struct {
+		txn           Transaction
+		confirmations uint64
+	}{{created, 3}, {failed, 2}, {old, 4}} {
+		var got Receipt
+		getJSON(t, "/v1/transaction/"+tt.txn.Hash+"/receipt", &got)
+		want := Receipt{
+			TransactionHash: tt.txn.Hash, TransactionIndex: tt.txn.TransactionIndex, BlockHash: tt.txn.BlockHash,
+			BlockNumber: tt.txn.BlockNumber, From: tt.txn.From, To: tt.txn.To, GasUsed: tt.txn.GasUsed,
+			CumulativeGasUsed: tt.txn.CumulativeGasUsed, EffectiveGasPrice: tt.txn.EffectiveGasPrice,
+			ContractAddress: tt.txn.ContractAddress, Logs: tt.txn.Logs, LogsBloom: tt.txn.LogsBloom, Status: tt.txn.Status,
+			Type: tt.txn.Type, Confirmations: tt.confirmations,
+		}
+		if want.Logs == nil {
+			want.Logs = []TxLog{}
+		}
+		if got.Logs == nil {
+			got.Logs = []TxLog{}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("receipt of block %d:\n got %+v\nwant %+v", tt.txn.BlockNumber, got, want)
+		}
+	}
+
+	// A transaction stored before receipts has a null status, not a failed one.
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, httptest.NewRequest("GET", "/v1/transaction/"+old.Hash+"/receipt", nil))
+	if !strings.Contains(rec.Body.String(), `"status":null`) {
+		t.Errorf("receipt of a transaction without one: %s", rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, httptest.NewRequest("GET", "/v1/transaction/0x"+strings.Repeat("c9", 32)+"/receipt", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("receipt of an unknown transaction answered %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestTxnStatusFilter(t *testing.T) {
+	created, failed, old := testReceipts(t)
+	r := newRouter()
+
+	tests := []struct {
+		status string
+		code   int
+		txns   []string
+	}{
+		{status: "", code: http.StatusOK, txns: []string{failed.Hash, created.Hash, old.Hash}},
+		{status: "success", code: http.StatusOK, txns: []string{created.Hash}},
+		{status: "failed", code: http.StatusOK, txns: []string{failed.Hash}},
+		{status: "Success", code: http.StatusBadRequest},
+		{status: "0", code: http.StatusBadRequest},
+		{status: "pending", code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		for _, path := range []string{"/v1/latestaccounttxns/" + miner, "/v2/accounts/" + miner + "/transactions"} {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest("GET", path+"?status="+tt.status, nil))
+			if rec.Code != tt.code {
+				t.Errorf("%s?status=%s answered %d %s", path, tt.status, rec.Code, rec.Body)
+				continue
+			}
+			if tt.code != http.StatusOK {
+				continue
+			}
+			var res struct {
+				Txns         []Transaction `json:"txns"`
+				Transactions []Transaction `json:"transactions"`
+				Total        int           `json:"total"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, txn := range append(res.Txns, res.Transactions...) {
+				got = append(got, txn.Hash)
+			}
+			if !reflect.DeepEqual(got, tt.txns) || res.Total != len(tt.txns) {
+				t.Errorf("%s?status=%s: %v of %d, want %v", path, tt.status, got, res.Total, tt.txns)
+			}
+		}
+	}
+}
@@ -20,6 +20,9 @@ type Block struct {
 	AvgGasPrice     string `bson:"avgGasPrice" json:"avgGasPrice"`
 	TxFees          string `bson:"txFees" json:"txFees"`
 	ExtraData       string `bson:"extraData" json:"extraData"`
+	Confirmations   uint64 `bson:"-" json:"confirmations"`
+	Canonical       bool   `bson:"-" json:"canonical"`
+	MinerLabel      string `bson:"-" json:"minerLabel,omitempty"`
 }
 
 type TxLog struct {
@@ -50,6 +53,38 @@ type Transaction struct {
 	To               string  `bson:"to" json:"to"`
 	ContractAddress  string  `bson:"contractAddress" json:"contractAddress"`
 	Logs             []TxLog `bson:"logs" json:"logs"`
+	// Receipt fields. Documents stored before these were recorded decode
+	// with a nil Status and zero values for the rest.
+	Status            *uint64 `bson:"status,omitempty" json:"status"`
+	CumulativeGasUsed uint64  `bson:"cumulativeGasUsed" json:"cumulativeGasUsed"`
+	LogsBloom         string  `bson:"logsBloom" json:"logsBloom"`
+	EffectiveGasPrice string  `bson:"effectiveGasPrice" json:"effectiveGasPrice"`
+	Type              uint64  `bson:"type" json:"type"`
+	Confirmations     uint64  `bson:"-" json:"confirmations"`
+	Canonical         bool    `bson:"-" json:"canonical"`
+	// ValueFiat is Value in the currency asked for with ?fiat=.
+	ValueFiat string `bson:"-" json:"valueFiat,omitempty"`
+	// FromLabel and ToLabel name known addresses when ?labels=true.
+	FromLabel string `bson:"-" json:"fromLabel,omitempty"`
+	ToLabel   string `bson:"-" json:"toLabel,omitempty"`
+}
+
+type Receipt struct {
+	TransactionHash   string  `bson:"transactionHash" json:"transactionHash"`
+	TransactionIndex  uint64  `bson:"transactionIndex" json:"transactionIndex"`
+	BlockHash         string  `bson:"blockHash" json:"blockHash"`
+	BlockNumber       uint64  `bson:"blockNumber" json:"blockNumber"`
+	From              string  `bson:"from" json:"from"`
+	To                string  `bson:"to" json:"to"`
+	GasUsed           uint64  `bson:"gasUsed" json:"gasUsed"`
+	CumulativeGasUsed uint64  `bson:"cumulativeGasUsed" json:"cumulativeGasUsed"`
+	EffectiveGasPrice string  `bson:"effectiveGasPrice" json:"effectiveGasPrice"`
+	ContractAddress   string  `bson:"contractAddress" json:"contractAddress"`
+	Logs              []TxLog `bson:"logs" json:"logs"`
+	LogsBloom         string  `bson:"logsBloom" json:"logsBloom"`
+	Status            *uint64 `bson:"status" json:"status"`
+	Type              uint64  `bson:"type" json:"type"`
+	Confirmations     uint64  `bson:"-" json:"confirmations"`
 }
 
 type TokenTransfer struct {
@@ -61,6 +96,31 @@ type TokenTransfer struct {
 	Value       string `bson:"value" json:"value"`
 	Contract    string `bson:"contract" json:"contract"`
 	Method      string `bson:"method" json:"method"`
+	ValueFiat   string `bson:"-" json:"valueFiat,omitempty"`
+	FromLabel   string `bson:"-" json:"fromLabel,omitempty"`
+	ToLabel     string `bson:"-" json:"toLabel,omitempty"`
+}
+
+// Trace is an internal transaction: a call, create or selfdestruct made by
+// contract code while executing transaction Hash. Index orders the traces of
+// a transaction depth first, and the top-level call itself is not stored.
+// Value is what the call moved between accounts, so it is "0" for
+// delegatecall, staticcall and callcode. Error is set on failed calls and on
+// every call beneath one, since their effects were reverted too.
+type Trace struct {
+	Hash             string `bson:"hash" json:"hash"`
+	BlockNumber      uint64 `bson:"blockNumber" json:"blockNumber"`
+	TransactionIndex uint64 `bson:"transactionIndex" json:"transactionIndex"`
+	Index            uint64 `bson:"index" json:"index"`
+	Timestamp        uint64 `bson:"timestamp" json:"timestamp"`
+	Type             string `bson:"type" json:"type"`
+	From             string `bson:"from" json:"from"`
+	To               string `bson:"to" json:"to"`
+	Value            string `bson:"value" json:"value"`
+	Gas              uint64 `bson:"gas" json:"gas"`
+	GasUsed          uint64 `bson:"gasUsed" json:"gasUsed"`
+	Depth            uint64 `bson:"depth" json:"depth"`
+	Error            string `bson:"error" json:"error"`
 }
 
 type Uncle struct {
@@ -76,6 +136,7 @@ type Uncle struct {
 	GasLimit    uint64 `bson:"gasLimit" json:"gasLimit"`
 	Timestamp   uint64 `bson:"timestamp" json:"timestamp"`
 	Reward      string `bson:"reward" json:"reward"`
+	MinerLabel  string `bson:"-" json:"minerLabel,omitempty"`
 }
 
 type TxnCounts struct {
@@ -91,3 +152,206 @@ type Store struct {
 	Price       string    `bson:"price" json:"price"`
 	TxnCounts   TxnCounts `bson:"txnCounts" json:"txnCounts"`
 }
+
+type GasPrices struct {
+	Safe        string `bson:"safe" json:"safe"`
+	Standard    string `bson:"standard" json:"standard"`
+	Fast        string `bson:"fast" json:"fast"`
+	BlockNumber uint64 `bson:"blockNumber" json:"blockNumber"`
+	Blocks      uint64 `bson:"blocks" json:"blocks"`
+	Samples     uint64 `bson:"samples" json:"samples"`
+}
+
+type GasBlock struct {
+	Number         uint64  `bson:"number" json:"number"`
+	Timestamp      uint64  `bson:"timestamp" json:"timestamp"`
+	Transactions   uint64  `bson:"transactions" json:"transactions"`
+	MinGasPrice    string  `bson:"minGasPrice" json:"minGasPrice"`
+	MedianGasPrice string  `bson:"medianGasPrice" json:"medianGasPrice"`
+	MaxGasPrice    string  `bson:"maxGasPrice" json:"maxGasPrice"`
+	GasUsed        uint64  `bson:"gasUsed" json:"gasUsed"`
+	GasLimit       uint64  `bson:"gasLimit" json:"gasLimit"`
+	Utilization    float64 `bson:"utilization" json:"utilization"`
+}
+
+type Reorg struct {
+	CommonAncestor string   `bson:"commonAncestor" json:"commonAncestor"`
+	AncestorNumber uint64   `bson:"ancestorNumber" json:"ancestorNumber"`
+	Depth          uint64   `bson:"depth" json:"depth"`
+	Orphaned       []string `bson:"orphaned" json:"orphaned"`
+	Replacing      []string `bson:"replacing" json:"replacing"`
+	Timestamp      uint64   `bson:"timestamp" json:"timestamp"`
+}
+
+type ReorgDetail struct {
+	Reorg      `bson:",inline"`
+	Dropped    []Transaction `bson:"dropped" json:"dropped"`
+	Reincluded []Transaction `bson:"reincluded" json:"reincluded"`
+}
+
+// Emission is the coin minted in one period: the base rewards and uncle
+// inclusion rewards paid to block miners, and the rewards paid to the miners
+// of uncles. Amounts are in wei.
+type Emission struct {
+	Timestamp     uint64 `bson:"timestamp" json:"timestamp"`
+	Blocks        uint64 `bson:"blocks" json:"blocks"`
+	BlockRewards  string `bson:"blockRewards" json:"blockRewards"`
+	NephewRewards string `bson:"nephewRewards" json:"nephewRewards"`
+	UncleRewards  string `bson:"uncleRewards" json:"uncleRewards"`
+}
+
+type Supply struct {
+	BlockNumber uint64 `bson:"blockNumber" json:"blockNumber"`
+	Genesis     string `bson:"genesis" json:"genesis"`
+	Mined       string `bson:"mined" json:"mined"`
+	Total       string `bson:"total" json:"total"`
+	Excluded    string `bson:"excluded" json:"excluded"`
+	Circulating string `bson:"circulating" json:"circulating"`
+}
+
+type SupplyPoint struct {
+	Timestamp uint64 `bson:"timestamp" json:"timestamp"`
+	Blocks    uint64 `bson:"blocks" json:"blocks"`
+	Minted    string `bson:"minted" json:"minted"`
+	Mined     string `bson:"mined" json:"mined"`
+	Total     string `bson:"total" json:"total"`
+}
+
+// PricePoint is the price of one whole coin, or of one whole token when
+// Token is set, in Fiat at Timestamp. Decimals is how many decimals the
+// amounts of the coin or token have.
+type PricePoint struct {
+	Token     string `bson:"token" json:"token"`
+	Fiat      string `bson:"fiat" json:"fiat"`
+	Timestamp uint64 `bson:"timestamp" json:"timestamp"`
+	Price     string `bson:"price" json:"price"`
+	Decimals  uint64 `bson:"decimals" json:"decimals"`
+}
+
+// Label names a known address, such as an exchange, pool or bridge, and
+// groups it under tags.
+type Label struct {
+	Address string   `bson:"address" json:"address" yaml:"address"`
+	Name    string   `bson:"name" json:"name" yaml:"name"`
+	Tags    []string `bson:"tags" json:"tags" yaml:"tags"`
+}
+
+// ContractSource is the verified source of a contract, with the settings it
+// was compiled with. Match is "full" when the metadata hash matched too,
+// "partial" when only the code did.
+type ContractSource struct {
+	Address              string            `bson:"address" json:"address"`
+	ContractName         string            `bson:"contractName" json:"contractName"`
+	CompilerVersion      string            `bson:"compilerVersion" json:"compilerVersion"`
+	Optimization         bool              `bson:"optimization" json:"optimization"`
+	Runs                 uint64            `bson:"runs" json:"runs"`
+	EvmVersion           string            `bson:"evmVersion" json:"evmVersion"`
+	Sources              map[string]string `bson:"sources" json:"sources"`
+	ABI                  string            `bson:"abi" json:"abi"`
+	ConstructorArguments string            `bson:"constructorArguments" json:"constructorArguments"`
+	Match                string            `bson:"match" json:"match"`
+	VerifiedAt           uint64            `bson:"verifiedAt" json:"verifiedAt"`
+}
+
+// Contract is a contract and the transaction that created it. BytecodeSize
+// is the size of that transaction's input, the creation code and its
+// constructor arguments, in bytes. IsToken is set once the contract has
+// token transfers, and Verified is the match of its verified source, empty
+// when it has none.
+type Contract struct {
+	Address         string `bson:"address" json:"address"`
+	Creator         string `bson:"creator" json:"creator"`
+	BlockNumber     uint64 `bson:"blockNumber" json:"blockNumber"`
+	Timestamp       uint64 `bson:"timestamp" json:"timestamp"`
+	TransactionHash string `bson:"transactionHash" json:"transactionHash"`
+	BytecodeSize    uint64 `bson:"bytecodeSize" json:"bytecodeSize"`
+	IsToken         bool   `bson:"isToken" json:"isToken"`
+	Verified        string `bson:"verified" json:"verified"`
+}
+
+// ContractCount is the number of contracts created in one period.
+type ContractCount struct {
+	Timestamp uint64 `bson:"timestamp" json:"timestamp"`
+	Contracts uint64 `bson:"contracts" json:"contracts"`
+}
+
+// BalanceChange is what the transactions, internal transactions, rewards or
+// token transfers of one block added to an account's balance of Contract,
+// empty for the native coin. Amount is negative when the balance went down.
+type BalanceChange struct {
+	BlockNumber uint64 `bson:"blockNumber" json:"blockNumber"`
+	Timestamp   uint64 `bson:"timestamp" json:"timestamp"`
+	Contract    string `bson:"contract" json:"contract"`
+	Amount      string `bson:"amount" json:"amount"`
+}
+
+// TokenBalance is an account's balance of the token at Contract, in its
+// smallest unit.
+type TokenBalance struct {
+	Contract string `bson:"contract" json:"contract"`
+	Balance  string `bson:"balance" json:"balance"`
+}
+
+// Balance is what an account held at the end of block BlockNumber: Balance
+// in wei, and the tokens it had a non-zero balance of.
+type Balance struct {
+	Address     string         `bson:"address" json:"address"`
+	BlockNumber uint64         `bson:"blockNumber" json:"blockNumber"`
+	Balance     string         `bson:"balance" json:"balance"`
+	Tokens      []TokenBalance `bson:"tokens" json:"tokens"`
+}
+
+// BalancePoint is an account's balance at the end of one period, as of the
+// last block in it that changed the balance.
+type BalancePoint struct {
+	Timestamp   uint64 `bson:"timestamp" json:"timestamp"`
+	BlockNumber uint64 `bson:"blockNumber" json:"blockNumber"`
+	Balance     string `bson:"balance" json:"balance"`
+}
+
+// Holder is an account's balance of a token or the coin, in its smallest
+// unit.
+type Holder struct {
+	Address string `bson:"address" json:"address"`
+	Balance string `bson:"balance" json:"balance"`
+}
+
+// Watch is an address or token contract whose new transactions and token
+// transfers are posted to URL, signed with Secret, once they are
+// Confirmations blocks deep. With Address set, the transactions and
+// transfers from or to it match, only those of the token Contract when that
+// is set too; with just Contract, every transfer of the token does. Blocks
+// up to FromBlock, the head when the watch was created, aren't matched.
+type Watch struct {
+	ID            string `bson:"id" json:"id"`
+	Address       string `bson:"address" json:"address"`
+	Contract      string `bson:"contract" json:"contract"`
+	URL           string `bson:"url" json:"url"`
+	Secret        string `bson:"secret" json:"secret"`
+	Confirmations uint64 `bson:"confirmations" json:"confirmations"`
+	FromBlock     uint64 `bson:"fromBlock" json:"fromBlock"`
+	CreatedAt     uint64 `bson:"createdAt" json:"createdAt"`
+}
+
+// Delivery is the webhook of one transaction or token transfer matching a
+// watch, with the outcome of its latest attempt. Event is "transaction",
+// "transfer" or "test". Status is "pending" until the receiver answers with
+// a 2xx, then "delivered"; "failed" once the attempts run out, and
+// "orphaned" when the block left the chain before it was sent.
+type Delivery struct {
+	ID             string         `bson:"id" json:"id"`
+	WatchID        string         `bson:"watchId" json:"watchId"`
+	Event          string         `bson:"event" json:"event"`
+	BlockNumber    uint64         `bson:"blockNumber" json:"blockNumber"`
+	BlockHash      string         `bson:"blockHash" json:"blockHash"`
+	Hash           string         `bson:"hash" json:"hash"`
+	Transaction    *Transaction   `bson:"transaction,omitempty" json:"transaction,omitempty"`
+	Transfer       *TokenTransfer `bson:"transfer,omitempty" json:"transfer,omitempty"`
+	Status         string         `bson:"status" json:"status"`
+	Attempts       int            `bson:"attempts" json:"attempts"`
+	NextAttempt    uint64         `bson:"nextAttempt" json:"nextAttempt"`
+	LastAttempt    uint64         `bson:"lastAttempt" json:"lastAttempt"`
+	ResponseStatus int            `bson:"responseStatus" json:"responseStatus"`
+	Error          string         `bson:"error" json:"error"`
+	CreatedAt      uint64         `bson:"createdAt" json:"createdAt"`
+}
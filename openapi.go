@@ -0,0 +1,241 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/ubiq/spectrum-api/apierr"
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+// openapiPaths is the hand-maintained part of the spec: routes, parameters
+// and shared responses. The schemas of the response types are generated
+// from their structs by schemaOf, so they can't drift from the json
+// the api writes.
+//
+//go:embed openapi.json
+var openapiPaths []byte
+
+// redocFiles hold the redoc bundle /docs loads, served by the api itself so
+// that the docs work offline and only change when redoc/VERSION does. go
+// generate fetches the bundle of that version.
+//
+//go:generate sh -c "curl -fsSL -o redoc/redoc.standalone.js https://cdn.redoc.ly/redoc/v$(cat redoc/VERSION)/bundles/redoc.standalone.js"
+//go:embed redoc
+var redocFiles embed.FS
+
+// openapiModels are the types responses are made of, by schema name.
+var openapiModels = []interface{}{
+	Block{}, TxLog{}, Transaction{}, Receipt{}, TokenTransfer{}, Trace{}, Uncle{}, TxnCounts{}, Store{}, GasPrices{},
+	GasBlock{}, Reorg{}, ReorgDetail{}, AccountTxn{}, PendingTxn{}, AccountTraces{}, AccountTokenTransfer{}, BlockRes{},
+	UncleRes{}, AccountTransactions{}, ChainInfo{}, Supply{},
+	SupplyPoint{}, PricePoint{}, Label{},
+	ContractSource{}, VerifyRequest{}, Contract{}, ContractCount{},
+	BlockDetail{}, Balance{}, BalancePoint{}, Snapshot{},
+	Watch{}, Delivery{}, WebhookPayload{},
+}
+
+// schemaOf describes t the way encoding/json writes it. Named structs are
+// referenced, and added to schemas on first use.
+func schemaOf(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		// OpenAPI 3.0 ignores the siblings of $ref, so a nullable reference
+		// is wrapped in allOf.
+		s := schemaOf(t.Elem(), schemas)
+		if _, ok := s["$ref"]; ok {
+			return map[string]interface{}{"allOf": []interface{}{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		if _, ok := schemas[t.Name()]; !ok {
+			schemas[t.Name()] = nil
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+func structSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var fields func(t reflect.Type)
+	fields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Anonymous {
+				fields(f.Type)
+				continue
+			}
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if name == "-" || f.PkgPath != "" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			properties[name] = schemaOf(f.Type, schemas)
+		}
+	}
+	fields(t)
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+// openapiSpec returns the full spec: openapi.json with the model schemas
+// merged into its components, every versioned path repeated under /{chain},
+// and every /v1 path repeated as a deprecated root alias.
+func openapiSpec() (map[string]interface{}, error) {
+	var spec map[string]interface{}
+	if err := json.Unmarshal(openapiPaths, &spec); err != nil {
+		return nil, err
+	}
+	components, _ := spec["components"].(map[string]interface{})
+	if components == nil {
+		components = map[string]interface{}{}
+		spec["components"] = components
+	}
+	schemas, _ := components["schemas"].(map[string]interface{})
+	if schemas == nil {
+		schemas = map[string]interface{}{}
+		components["schemas"] = schemas
+	}
+	for _, m := range openapiModels {
+		schemaOf(reflect.TypeOf(m), schemas)
+	}
+
+	paths, _ := spec["paths"].(map[string]interface{})
+	for path, item := range paths {
+		if !strings.HasPrefix(path, "/v1/") && !strings.HasPrefix(path, "/v2/") {
+			continue
+		}
+		paths["/{chain}"+path] = copyOperations(item, func(o map[string]interface{}) {
+			params, _ := o["parameters"].([]interface{})
+			chain := map[string]interface{}{"$ref": "#/components/parameters/chain"}
+			o["parameters"] = append([]interface{}{chain}, params...)
+		})
+		if strings.HasPrefix(path, "/v1/") {
+			paths[strings.TrimPrefix(path, "/v1")] = copyOperations(item, func(o map[string]interface{}) {
+				o["deprecated"] = true
+			})
+			paths["/{chain}"+strings.TrimPrefix(path, "/v1")] = copyOperations(item, func(o map[string]interface{}) {
+				params, _ := o["parameters"].([]interface{})
+				chain := map[string]interface{}{"$ref": "#/components/parameters/chain"}
+				o["parameters"] = append([]interface{}{chain}, params...)
+				o["deprecated"] = true
+			})
+		}
+	}
+	return spec, nil
+}
+
+// copyOperations copies the operations of a path item, changed by edit and
+// without their operationId, which must be unique.
+func copyOperations(item interface{}, edit func(map[string]interface{})) map[string]interface{} {
+	alias := map[string]interface{}{}
+	for method, op := range item.(map[string]interface{}) {
+		o := map[string]interface{}{}
+		for k, v := range op.(map[string]interface{}) {
+			o[k] = v
+		}
+		delete(o, "operationId")
+		edit(o)
+		alias[method] = o
+	}
+	return alias
+}
+
+// undocumentedRoutes lists the "METHOD /path" of every route registered on
+// r that the spec doesn't describe. The tests check it finds none, so that
+// openapi.json is updated along with the routes.
+func undocumentedRoutes(r *mux.Router, spec map[string]interface{}) []string {
+	paths, _ := spec["paths"].(map[string]interface{})
+	var missing []string
+	r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		path = chainPrefix.ReplaceAllString(path, "/{chain}")
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		ops, _ := paths[path].(map[string]interface{})
+		for _, m := range methods {
+			if _, ok := ops[strings.ToLower(m)]; !ok {
+				missing = append(missing, m+" "+path)
+			}
+		}
+		return nil
+	})
+	sort.Strings(missing)
+	return missing
+}
+
+// serveOpenAPI registers /openapi.json, /docs and the redoc bundle on r. A
+// broken spec leaves them out rather than keeping the api from starting.
+func serveOpenAPI(r *mux.Router) {
+	spec, err := openapiSpec()
+	var body []byte
+	if err == nil {
+		body, err = json.Marshal(spec)
+	}
+	if err != nil {
+		log.Error("Invalid openapi.json, not serving it: ", err)
+		return
+	}
+	r.HandleFunc("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}).Methods("GET")
+	r.HandleFunc("/docs", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(docsPage))
+	}).Methods("GET")
+
+	bundle, err := redocFiles.ReadFile("redoc/redoc.standalone.js")
+	if err != nil {
+		log.Warn("The redoc bundle is missing, /docs won't render until go generate fetches it")
+	}
+	r.HandleFunc("/docs/redoc.standalone.js", func(w http.ResponseWriter, r *http.Request) {
+		if bundle == nil {
+			respondWithError(w, r, apierr.NotFoundf("the redoc bundle was not built in"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/javascript")
+		w.Write(bundle)
+	}).Methods("GET")
+}
+
+const docsPage = `<!DOCTYPE html>
+<html>
+<head>
+<title>Spectrum API</title>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+</head>
+<body>
+<redoc spec-url="openapi.json"></redoc>
+<script src="docs/redoc.standalone.js"></script>
+</body>
+</html>
+`
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	. "github.com/ubiq/spectrum-api/config"
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+func TestRoutesDocumented(t *testing.T) {
+	defer func(saved []*chain) { chains = saved }(chains)
+	chains = []*chain{{Chain: Chain{Name: "ubiq"}}, {Chain: Chain{Name: "testnet"}}}
+
+	spec, err := openapiSpec()
+	if err != nil {
+		t.Fatal("openapi.json: ", err)
+	}
+	if missing := undocumentedRoutes(newRouter(), spec); len(missing) > 0 {
+		t.Errorf("routes missing from openapi.json: %v", missing)
+	}
+}
+
+type nullableDoc struct {
+	Block  *Block  `json:"block"`
+	Status *uint64 `json:"status"`
+}
+
+func TestSchemaOfNullable(t *testing.T) {
+	schemas := map[string]interface{}{}
+	schemaOf(reflect.TypeOf(nullableDoc{}), schemas)
+	props := schemas["nullableDoc"].(map[string]interface{})["properties"].(map[string]interface{})
+
+	tests := []struct {
+		field string
+		want  map[string]interface{}
+	}{
+		{"block", map[string]interface{}{
+			"allOf":    []interface{}{map[string]interface{}{"$ref": "#/components/schemas/Block"}},
+			"nullable": true,
+		}},
+		{"status", map[string]interface{}{"type": "integer", "format": "int64", "nullable": true}},
+	}
+	for _, tt := range tests {
+		if got := props[tt.field]; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: schema %v, want %v", tt.field, got, tt.want)
+		}
+	}
+	if _, ok := schemas["Block"]; !ok {
+		t.Error("Block schema not added")
+	}
+}
+
+func TestServeDocs(t *testing.T) {
+	r := mux.NewRouter()
+	serveOpenAPI(r)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/docs", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `<script src="docs/redoc.standalone.js">`) {
+		t.Errorf("/docs answered %d %s", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), "http") {
+		t.Errorf("/docs loads from another host: %s", rec.Body)
+	}
+
+	bundle, err := redocFiles.ReadFile("redoc/redoc.standalone.js")
+	if err != nil {
+		t.Skip("the redoc bundle isn't fetched, run go generate")
+	}
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/docs/redoc.standalone.js", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/javascript" || !bytes.Equal(rec.Body.Bytes(), bundle) {
+		t.Errorf("the bundle answered %d %s, %d bytes", rec.Code, rec.Header().Get("Content-Type"), rec.Body.Len())
+	}
+}
@@ -0,0 +1,237 @@
+package prices
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+// Fetcher reads the current price of a chain's coin in each of fiats, as
+// decimal strings keyed by fiat.
+type Fetcher interface {
+	Fetch(ctx context.Context, fiats []string) (map[string]string, error)
+}
+
+// fetchers builds a Fetcher by name for a coin id.
+var fetchers = map[string]func(coinId string) Fetcher{
+	"coingecko": func(coinId string) Fetcher { return &CoinGecko{CoinId: coinId} },
+	"stub":      func(string) Fetcher { return &Stub{} },
+}
+
+// Register makes a Fetcher available to the priceFetcher setting.
+func Register(name string, build func(coinId string) Fetcher) {
+	fetchers[name] = build
+}
+
+// New returns the fetcher registered as name.
+func New(name string, coinId string) (Fetcher, error) {
+	build, ok := fetchers[name]
+	if !ok {
+		names := make([]string, 0, len(fetchers))
+		for n := range fetchers {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown price fetcher %q, want one of %s", name, strings.Join(names, ", "))
+	}
+	return build(coinId), nil
+}
+
+// CoinGecko reads prices from the CoinGecko simple price api.
+type CoinGecko struct {
+	CoinId string
+	URL    string
+	Client *http.Client
+}
+
+func (g *CoinGecko) Fetch(ctx context.Context, fiats []string) (map[string]string, error) {
+	base, client := g.URL, g.Client
+	if base == "" {
+		base = "https://api.coingecko.com/api/v3"
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	q := url.Values{"ids": {g.CoinId}, "vs_currencies": {strings.Join(fiats, ",")}, "precision": {"full"}}
+	req, err := http.NewRequestWithContext(ctx, "GET", base+"/simple/price?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("coingecko: %s", res.Status)
+	}
+
+	var body map[string]map[string]json.Number
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	prices := map[string]string{}
+	for fiat, price := range body[g.CoinId] {
+		prices[fiat] = price.String()
+	}
+	return prices, nil
+}
+
+// Stub answers Prices, or a price of 1 in every fiat, without any network
+// access, for tests and local setups.
+type Stub struct {
+	Prices map[string]string
+}
+
+func (s *Stub) Fetch(ctx context.Context, fiats []string) (map[string]string, error) {
+	prices := map[string]string{}
+	for _, fiat := range fiats {
+		prices[fiat] = "1"
+		if p, ok := s.Prices[fiat]; ok {
+			prices[fiat] = p
+		}
+	}
+	return prices, nil
+}
+
+// Writer is where polled and imported prices are written.
+type Writer interface {
+	AddPrices(ctx context.Context, points []PricePoint) error
+}
+
+// Poller stores the price of a chain's coin in each of Fiats every Interval.
+type Poller struct {
+	Fetcher  Fetcher
+	DB       Writer
+	Fiats    []string
+	Interval time.Duration
+}
+
+func (p *Poller) poll(ctx context.Context) error {
+	prices, err := p.Fetcher.Fetch(ctx, p.Fiats)
+	if err != nil {
+		return err
+	}
+	now := uint64(time.Now().Unix())
+	var points []PricePoint
+	for _, fiat := range p.Fiats {
+		price, ok := prices[fiat]
+		if !ok {
+			log.Warn("No ", fiat, " price from the price fetcher")
+			continue
+		}
+		points = append(points, PricePoint{Fiat: fiat, Timestamp: now, Price: price, Decimals: 18})
+	}
+	return p.DB.AddPrices(ctx, points)
+}
+
+// Run polls every interval until ctx is done.
+func (p *Poller) Run(ctx context.Context) {
+	for {
+		if err := p.poll(ctx); err != nil && ctx.Err() == nil {
+			log.Error("Price poll failed: ", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.Interval):
+		}
+	}
+}
+
+// ParseTimestamp reads unix seconds, a date (2006-01-02) or an RFC 3339
+// time.
+func ParseTimestamp(s string) (uint64, error) {
+	if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return n, nil
+	}
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil && t.Unix() >= 0 {
+			return uint64(t.Unix()), nil
+		}
+	}
+	return 0, fmt.Errorf("%q is not unix seconds, a date or an RFC 3339 time", s)
+}
+
+// ReadCSV reads price points, one per row. Rows are either timestamp,price
+// or, when the first row is a header naming them, any of the columns
+// timestamp, price, fiat, token and decimals. Columns left out or empty
+// take the values of defaults.
+func ReadCSV(r io.Reader, defaults PricePoint) ([]PricePoint, error) {
+	rows := csv.NewReader(r)
+	rows.FieldsPerRecord = -1
+	rows.TrimLeadingSpace = true
+
+	columns := map[string]int{"timestamp": 0, "price": 1}
+	var points []PricePoint
+	for line := 1; ; line++ {
+		row, err := rows.Read()
+		if err == io.EOF {
+			return points, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 1 {
+			if _, err := ParseTimestamp(row[0]); err != nil {
+				columns = map[string]int{}
+				for i, name := range row {
+					columns[strings.ToLower(strings.TrimSpace(name))] = i
+				}
+				if _, ok := columns["timestamp"]; !ok {
+					return nil, fmt.Errorf("line 1: no timestamp column")
+				}
+				if _, ok := columns["price"]; !ok {
+					return nil, fmt.Errorf("line 1: no price column")
+				}
+				continue
+			}
+		}
+
+		p := defaults
+		field := func(name string) (string, bool) {
+			i, ok := columns[name]
+			if !ok || i >= len(row) {
+				return "", false
+			}
+			v := strings.TrimSpace(row[i])
+			return v, v != ""
+		}
+		ts, _ := field("timestamp")
+		if p.Timestamp, err = ParseTimestamp(ts); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		p.Price, _ = field("price")
+		if _, ok := new(big.Rat).SetString(p.Price); !ok {
+			return nil, fmt.Errorf("line %d: %q is not a price", line, p.Price)
+		}
+		if fiat, ok := field("fiat"); ok {
+			p.Fiat = strings.ToLower(fiat)
+		}
+		if token, ok := field("token"); ok {
+			p.Token = strings.ToLower(token)
+		}
+		if decimals, ok := field("decimals"); ok {
+			if p.Decimals, err = strconv.ParseUint(decimals, 10, 64); err != nil {
+				return nil, fmt.Errorf("line %d: %q is not a number of decimals", line, decimals)
+			}
+		}
+		if p.Fiat == "" {
+			return nil, fmt.Errorf("line %d: no fiat", line)
+		}
+		points = append(points, p)
+	}
+}
@@ -0,0 +1,155 @@
+package prices
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+func TestReadCSV(t *testing.T) {
+	defaults := PricePoint{Fiat: "usd", Decimals: 18}
+	tests := []struct {
+		name string
+		csv  string
+		want []PricePoint
+	}{
+		{
+			name: "without a header",
+			csv:  "1500000000,0.25\n2017-07-15, 0.3\n2017-07-16T12:00:00Z,1e-2\n",
+			want: []PricePoint{
+				{Fiat: "usd", Timestamp: 1500000000, Price: "0.25", Decimals: 18},
+				{Fiat: "usd", Timestamp: 1500076800, Price: "0.3", Decimals: 18},
+				{Fiat: "usd", Timestamp: 1500206400, Price: "1e-2", Decimals: 18},
+			},
+		},
+		{
+			name: "with a header",
+			csv:  "Price,Timestamp,Fiat,Token,Decimals\n1.5,1500000000,EUR,0x4B4899A10F3E507DB207B0EE2426029EFA168A67,8\n2,1500000600,,,\n",
+			want: []PricePoint{
+				{Token: "0x4b4899a10f3e507db207b0ee2426029efa168a67", Fiat: "eur", Timestamp: 1500000000, Price: "1.5", Decimals: 8},
+				{Fiat: "usd", Timestamp: 1500000600, Price: "2", Decimals: 18},
+			},
+		},
+		{
+			name: "header with some columns",
+			csv:  "timestamp,fiat,price\n1500000000,btc,0.00001\n",
+			want: []PricePoint{{Fiat: "btc", Timestamp: 1500000000, Price: "0.00001", Decimals: 18}},
+		},
+		{
+			name: "short rows take the defaults",
+			csv:  "timestamp,price,fiat\n1500000000,3\n",
+			want: []PricePoint{{Fiat: "usd", Timestamp: 1500000000, Price: "3", Decimals: 18}},
+		},
+		{
+			name: "empty",
+			csv:  "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadCSV(strings.NewReader(tt.csv), defaults)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadCSVMalformed(t *testing.T) {
+	tests := []struct {
+		name     string
+		csv      string
+		defaults PricePoint
+		err      string
+	}{
+		{name: "bad timestamp", csv: "1500000000,1\nyesterday,1\n", err: "line 2: \"yesterday\" is not unix seconds"},
+		{name: "bad price", csv: "1500000000,one\n", err: "line 1: \"one\" is not a price"},
+		{name: "missing price", csv: "1500000000\n", err: "line 1: \"\" is not a price"},
+		{name: "bad decimals", csv: "timestamp,price,decimals\n1500000000,1,-2\n", err: "line 2: \"-2\" is not a number of decimals"},
+		{name: "no timestamp column", csv: "time,price\n1500000000,1\n", err: "line 1: no timestamp column"},
+		{name: "no price column", csv: "timestamp,close\n1500000000,1\n", err: "line 1: no price column"},
+		{name: "no fiat", csv: "1500000000,1\n", defaults: PricePoint{}, err: "line 1: no fiat"},
+		{name: "bad quoting", csv: "1500000000,\"1\n", err: "extraneous or missing \" in quoted-field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaults := tt.defaults
+			if tt.name != "no fiat" {
+				defaults.Fiat = "usd"
+			}
+			points, err := ReadCSV(strings.NewReader(tt.csv), defaults)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %v, %v, want an error containing %q", points, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint64
+		err  bool
+	}{
+		{in: "0", want: 0},
+		{in: "1500000000", want: 1500000000},
+		{in: "2017-07-14", want: 1499990400},
+		{in: "2017-07-14T02:40:00Z", want: 1500000000},
+		{in: "2017-07-14T04:40:00+02:00", want: 1500000000},
+		{in: "1960-01-01", err: true},
+		{in: "-1", err: true},
+		{in: "14/07/2017", err: true},
+		{in: "", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTimestamp(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParseTimestamp(%q) = %d, %v", tt.in, got, err)
+		}
+	}
+}
+
+type pricesWriter struct {
+	points []PricePoint
+}
+
+func (w *pricesWriter) AddPrices(ctx context.Context, points []PricePoint) error {
+	w.points = append(w.points, points...)
+	return nil
+}
+
+func TestPollStub(t *testing.T) {
+	fetcher, err := New("stub", "ubiq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetcher.(*Stub).Prices = map[string]string{"usd": "0.42"}
+	db := &pricesWriter{}
+	p := &Poller{Fetcher: fetcher, DB: db, Fiats: []string{"usd", "eur"}}
+	if err := p.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.points) != 2 {
+		t.Fatalf("stored %+v", db.points)
+	}
+	for i, want := range []PricePoint{{Fiat: "usd", Price: "0.42", Decimals: 18}, {Fiat: "eur", Price: "1", Decimals: 18}} {
+		got := db.points[i]
+		if got.Timestamp == 0 {
+			t.Errorf("point %d has no timestamp", i)
+		}
+		got.Timestamp = 0
+		if got != want {
+			t.Errorf("point %d = %+v, want %+v", i, got, want)
+		}
+	}
+
+	if _, err := New("nasdaq", "ubiq"); err == nil || !strings.Contains(err.Error(), "coingecko, stub") {
+		t.Errorf("unknown fetcher: %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+	. "github.com/ubiq/spectrum-api/dao"
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+// chainHead returns the number of the latest canonical block, or 0 if none
+// has been stored yet.
+func chainHead(ctx context.Context) (uint64, error) {
+	block, err := backend(ctx).LatestBlock(ctx)
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	return block.Number, err
+}
+
+func confirmations(head uint64, number uint64) uint64 {
+	if number > head {
+		return 0
+	}
+	return head - number + 1
+}
+
+func markBlock(head uint64, block *Block, canonical bool) {
+	block.Canonical = canonical
+	if canonical {
+		block.Confirmations = confirmations(head, block.Number)
+	}
+}
+
+func markBlocks(head uint64, blocks []Block, canonical bool) {
+	for i := range blocks {
+		markBlock(head, &blocks[i], canonical)
+	}
+}
+
+// markTxn flags a transaction read from the transactions collection, which
+// only holds those of canonical blocks.
+func markTxn(head uint64, txn *Transaction) {
+	txn.Canonical = true
+	txn.Confirmations = confirmations(head, txn.BlockNumber)
+}
+
+func markTxns(head uint64, txns []Transaction) {
+	for i := range txns {
+		markTxn(head, &txns[i])
+	}
+}
+
+// orphanChains groups forked blocks into the chains orphaned by each reorg.
+// Orphans are linked by parent hash; every orphan that no other orphan builds
+// on ends one chain, which starts at the first orphan whose parent is
+// canonical. Chains are returned newest first.
+func orphanChains(forked []Block) [][]Block {
+	byHash := make(map[string]Block, len(forked))
+	hasChild := make(map[string]bool, len(forked))
+	for _, b := range forked {
+		byHash[b.Hash] = b
+	}
+	for _, b := range forked {
+		if _, ok := byHash[b.ParentHash]; ok {
+			hasChild[b.ParentHash] = true
+		}
+	}
+
+	var chains [][]Block
+	for _, tip := range forked {
+		if hasChild[tip.Hash] {
+			continue
+		}
+		var chain []Block
+		for b, ok := tip, true; ok; b, ok = byHash[b.ParentHash] {
+			chain = append([]Block{b}, chain...)
+		}
+		chains = append(chains, chain)
+	}
+
+	sort.SliceStable(chains, func(i, j int) bool { return chains[i][0].Number > chains[j][0].Number })
+	return chains
+}
+
+// reorg describes the event that orphaned chain, looking up the canonical
+// blocks that replaced it.
+func reorg(ctx context.Context, chain []Block) (Reorg, error) {
+	event := Reorg{
+		CommonAncestor: chain[0].ParentHash,
+		Depth:          uint64(len(chain)),
+		Orphaned:       []string{},
+		Replacing:      []string{},
+		Timestamp:      chain[len(chain)-1].Timestamp,
+	}
+	if chain[0].Number > 0 {
+		event.AncestorNumber = chain[0].Number - 1
+	}
+	for _, b := range chain {
+		event.Orphaned = append(event.Orphaned, b.Hash)
+		canonical, err := backend(ctx).BlockByNumber(ctx, b.Number)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return event, err
+		}
+		event.Replacing = append(event.Replacing, canonical.Hash)
+	}
+	return event, nil
+}
+
+func getReorgs(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = parseLimit(r, v); err != nil {
+			respondWithError(w, r, err)
+			return
+		}
+	}
+
+	forked, err := backend(r.Context()).LatestForkedBlocks(r.Context(), 1000)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	chains := orphanChains(forked)
+	if len(chains) > limit {
+		chains = chains[:limit]
+	}
+
+	events := make([]Reorg, 0, len(chains))
+	for _, chain := range chains {
+		event, err := reorg(r.Context(), chain)
+		if err != nil {
+			respondWithError(w, r, err)
+			return
+		}
+		events = append(events, event)
+	}
+	respondWithJson(w, r, http.StatusOK, events)
+}
+
+// getReorg returns the reorg that orphaned block {hash}, with the orphaned
+// transactions split into those dropped from the chain and those mined again
+// in a canonical block.
+func getReorg(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	params := mux.Vars(r)
+	orphan, err := backend(ctx).ForkedBlockByHash(ctx, params["hash"])
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	forked, err := backend(ctx).LatestForkedBlocks(ctx, 0)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	var detail ReorgDetail
+	for _, chain := range orphanChains(forked) {
+		for _, b := range chain {
+			if b.Hash != orphan.Hash {
+				continue
+			}
+			if detail.Reorg, err = reorg(ctx, chain); err != nil {
+				respondWithError(w, r, err)
+				return
+			}
+		}
+	}
+
+	head, err := chainHead(ctx)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	detail.Dropped = []Transaction{}
+	detail.Reincluded = []Transaction{}
+	for _, hash := range detail.Orphaned {
+		txns, err := backend(ctx).ForkedTransactions(ctx, hash)
+		if err != nil {
+			respondWithError(w, r, err)
+			return
+		}
+		for _, txn := range txns {
+			canonical, err := backend(ctx).TransactionByHash(ctx, txn.Hash)
+			switch {
+			case err == ErrNotFound:
+				detail.Dropped = append(detail.Dropped, txn)
+			case err != nil:
+				respondWithError(w, r, err)
+				return
+			default:
+				detail.Reincluded = append(detail.Reincluded, canonical)
+			}
+		}
+	}
+	markTxns(head, detail.Reincluded)
+
+	respondWithJson(w, r, http.StatusOK, detail)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+func TestOrphanChains(t *testing.T) {
+	block := func(number uint64, hash, parent string) Block {
+		return Block{Number: number, Hash: hash, ParentHash: parent}
+	}
+	a10 := block(10, "a10", "c9")
+	a11 := block(11, "a11", "a10")
+	a12 := block(12, "a12", "a11")
+	b20 := block(20, "b20", "c19")
+	c11 := block(11, "c11", "a10")
+
+	tests := []struct {
+		name   string
+		forked []Block
+		want   [][]Block
+	}{
+		{name: "none"},
+		{name: "one block", forked: []Block{a10}, want: [][]Block{{a10}}},
+		{name: "chain oldest first", forked: []Block{a12, a10, a11}, want: [][]Block{{a10, a11, a12}}},
+		{name: "separate reorgs newest first", forked: []Block{a10, b20, a11}, want: [][]Block{{b20}, {a10, a11}}},
+		{name: "branches share their ancestor", forked: []Block{a10, a11, c11}, want: [][]Block{{a10, a11}, {a10, c11}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := orphanChains(tt.forked); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("orphanChains = %v\nwant %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/ubiq/spectrum-api/apierr"
+	. "github.com/ubiq/spectrum-api/dao"
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+// Snapshot lists the holders of a token, or of the coin when Token is
+// empty, at the end of BlockNumber, largest balance first. Total is the sum
+// of the listed balances.
+type Snapshot struct {
+	Token       string   `json:"token"`
+	BlockNumber uint64   `json:"blockNumber"`
+	Holders     []Holder `json:"holders"`
+	Total       string   `json:"total"`
+}
+
+// snapshotOptions narrow a snapshot to the holders of at least minBalance
+// that aren't in exclude.
+type snapshotOptions struct {
+	token      string
+	minBalance *big.Int
+	exclude    map[string]bool
+}
+
+// parseSnapshotOptions validates the options of a snapshot as given to the
+// snapshot command or route: exclude is a comma separated list of
+// addresses, and minBalance an amount in the token's smallest unit.
+func parseSnapshotOptions(token string, minBalance string, exclude string) (snapshotOptions, error) {
+	opts := snapshotOptions{token: strings.ToLower(token), minBalance: new(big.Int), exclude: map[string]bool{}}
+	if opts.token != "" && !validHex(opts.token, []int{20}) {
+		return opts, apierr.InvalidArgumentf("token must be 0x-prefixed hex of 20 bytes")
+	}
+	if minBalance != "" {
+		if _, ok := opts.minBalance.SetString(minBalance, 10); !ok || opts.minBalance.Sign() < 0 {
+			return opts, apierr.InvalidArgumentf("minBalance must be a non-negative integer amount in the token's smallest unit")
+		}
+	}
+	for _, address := range strings.Split(exclude, ",") {
+		address = strings.ToLower(strings.TrimSpace(address))
+		if address == "" {
+			continue
+		}
+		if !validHex(address, []int{20}) {
+			return opts, apierr.InvalidArgumentf("exclude must list 0x-prefixed addresses of 20 bytes, not %q", address)
+		}
+		opts.exclude[address] = true
+	}
+	return opts, nil
+}
+
+// takeSnapshot lists the holders of opts.token at the end of block number
+// that opts lets through.
+func takeSnapshot(ctx context.Context, db Backend, number uint64, opts snapshotOptions) (Snapshot, error) {
+	holders, err := db.Holders(ctx, opts.token, number)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	snapshot := Snapshot{Token: opts.token, BlockNumber: number, Holders: []Holder{}}
+	total := new(big.Int)
+	for _, h := range holders {
+		balance := wei(h.Balance)
+		if opts.exclude[h.Address] || balance.Cmp(opts.minBalance) < 0 {
+			continue
+		}
+		snapshot.Holders = append(snapshot.Holders, h)
+		total.Add(total, balance)
+	}
+	sort.SliceStable(snapshot.Holders, func(i, j int) bool {
+		return wei(snapshot.Holders[i].Balance).Cmp(wei(snapshot.Holders[j].Balance)) > 0
+	})
+	snapshot.Total = total.String()
+	return snapshot, nil
+}
+
+// writeSnapshotCSV writes one address,balance row per holder under a
+// header row.
+func writeSnapshotCSV(w io.Writer, s Snapshot) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"address", "balance"})
+	for _, h := range s.Holders {
+		out.Write([]string{h.Address, h.Balance})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// getSnapshot answers with the holders of ?token=, or of the coin, at the
+// end of ?block=, the latest block by default, as json or, with
+// ?format=csv, as a csv download.
+func getSnapshot(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	q := r.URL.Query()
+	opts, err := parseSnapshotOptions(q.Get("token"), q.Get("minBalance"), q.Get("exclude"))
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	format := q.Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		respondWithError(w, r, apierr.InvalidArgumentf("format must be json or csv"))
+		return
+	}
+	head, err := chainHead(ctx)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	number := head
+	if v := q.Get("block"); v != "" {
+		if number, err = parseBlockNumber(v); err != nil {
+			respondWithError(w, r, err)
+			return
+		}
+		if number > head {
+			respondWithError(w, r, apierr.NotFoundf("block %d is not indexed yet", number))
+			return
+		}
+	}
+
+	snapshot, err := takeSnapshot(ctx, backend(ctx), number, opts)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	if format != "csv" {
+		respondWithJson(w, r, http.StatusOK, snapshot)
+		return
+	}
+	name := opts.token
+	if name == "" {
+		name = strings.ToLower(chainOf(ctx).Symbol)
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"snapshot-%s-%d.csv\"", name, number))
+	if err := writeSnapshotCSV(w, snapshot); err != nil {
+		log.Warn("Writing snapshot: ", err)
+	}
+}
+
+// runSnapshot writes the holders of a token, or of the coin, at a block to
+// a file or stdout.
+func runSnapshot(args []string) {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	token := fs.String("token", "", "token contract (default the chain's coin)")
+	block := fs.Uint64("block", 0, "block the balances are taken at the end of (default the latest)")
+	minBalance := fs.String("min-balance", "", "smallest balance listed, in the token's smallest unit")
+	exclude := fs.String("exclude", "", "comma separated addresses left out, such as exchanges or the token contract")
+	format := fs.String("format", "csv", "csv or json")
+	out := fs.String("out", "", "file to write (default stdout)")
+	name := fs.String("chain", "", "chain to read (default defaultChain)")
+	loadConfig(fs, args)
+	// The snapshot may go to stdout, so keep the log out of it.
+	log.SetOutput(os.Stderr)
+
+	opts, err := parseSnapshotOptions(*token, *minBalance, *exclude)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *format != "csv" && *format != "json" {
+		log.Fatal("-format must be csv or json")
+	}
+
+	ch := configuredChain(*name)
+	db := openBackend(ch)
+	defer db.Close()
+	ctx := context.Background()
+	number := *block
+	if number == 0 {
+		latest, err := db.LatestBlock(ctx)
+		if err != nil {
+			log.Fatal("No latest block: ", err)
+		}
+		number = latest.Number
+	}
+
+	snapshot, err := takeSnapshot(ctx, db, number, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(snapshot)
+	} else {
+		err = writeSnapshotCSV(w, snapshot)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	held := snapshot.Token
+	if held == "" {
+		held = ch.Symbol
+	}
+	log.Infof("%d holders of %s at block %d, %s in total", len(snapshot.Holders), held, number, snapshot.Total)
+}
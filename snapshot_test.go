@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	. "github.com/ubiq/spectrum-api/config"
+	. "github.com/ubiq/spectrum-api/dao"
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+func TestRunSnapshot(t *testing.T) {
+	defer func(saved Config) { config_ = saved }(config_)
+
+	const (
+		funded = "0x1111111111111111111111111111111111111111" // 1000 UBQ at genesis
+		payee  = "0x2222222222222222222222222222222222222222"
+	)
+	dir := t.TempDir()
+	genesis := filepath.Join(dir, "genesis.json")
+	alloc := `{"timestamp": "0x0", "alloc": {"1111111111111111111111111111111111111111": {"balance": "0x3635c9adc5dea00000"}}}`
+	if err := os.WriteFile(genesis, []byte(alloc), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The funded account pays 100 wei and a fee of 21000 in block 2.
+	db := &BoltDAO{DataDir: filepath.Join(dir, "db")}
+	db.Connect()
+	ctx := context.Background()
+	success := uint64(1)
+	pay := Transaction{Hash: "0xa1", BlockNumber: 2, From: funded, To: payee, Value: "100", GasUsed: 21000, GasPrice: "1", Status: &success}
+	for i, txns := range [][]Transaction{nil, {pay}} {
+		b := Block{Number: uint64(i + 1), Hash: "0x" + strconv.Itoa(i+1), Miner: miner, BlockReward: "10", TxFees: "0"}
+		if len(txns) > 0 {
+			b.TxFees = "21000"
+		}
+		if err := db.AddBlock(ctx, b, txns, nil, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	out := filepath.Join(dir, "snapshot.json")
+	runSnapshot([]string{"--backend=bolt", "--data-dir=" + db.DataDir, "--genesis-file=" + genesis, "-format=json", "-out=" + out})
+
+	body, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(body, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	want := []Holder{
+		{Address: funded, Balance: "999999999999999978900"},
+		{Address: miner, Balance: "21020"},
+		{Address: payee, Balance: "100"},
+	}
+	if snapshot.BlockNumber != 2 || !reflect.DeepEqual(snapshot.Holders, want) {
+		t.Errorf("snapshot at %d: %+v", snapshot.BlockNumber, snapshot.Holders)
+	}
+	if snapshot.Total != "1000000000000000000020" {
+		t.Errorf("total %s", snapshot.Total)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ubiq/spectrum-api/apierr"
+	. "github.com/ubiq/spectrum-api/dao"
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+const day = 24 * 60 * 60
+
+// emissionCache holds a chain's emission by day. When the head changes only
+// the last, partial, day is summed again along with the days after it.
+type emissionCache struct {
+	mu     sync.Mutex
+	head   string
+	number uint64
+	days   []Emission
+}
+
+// refresh returns the daily emission up to the head, and the head's number.
+func (c *emissionCache) refresh(ctx context.Context) ([]Emission, uint64, error) {
+	head, err := backend(ctx).LatestBlock(ctx)
+	if err == ErrNotFound {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if head.Hash == c.head {
+		return c.days, c.number, nil
+	}
+
+	keep, from := c.days, uint64(0)
+	if len(keep) > 0 {
+		from = keep[len(keep)-1].Timestamp
+		keep = keep[:len(keep)-1]
+	}
+	fresh, err := backend(ctx).Emission(ctx, from, day)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Callers may still be reading the old slice, so build a new one.
+	days := make([]Emission, 0, len(keep)+len(fresh))
+	c.days = append(append(days, keep...), fresh...)
+	c.head, c.number = head.Hash, head.Number
+	return c.days, c.number, nil
+}
+
+func wei(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return new(big.Int)
+	}
+	return n
+}
+
+// minted is everything paid out in a period: block, nephew and uncle rewards.
+func minted(e Emission) *big.Int {
+	n := wei(e.BlockRewards)
+	n.Add(n, wei(e.NephewRewards))
+	return n.Add(n, wei(e.UncleRewards))
+}
+
+// currentSupply sums the rewards of every stored block on top of the
+// genesis allocation, and takes the balances of supplyExcluded at the head
+// from the node to get the circulating supply.
+func currentSupply(ctx context.Context) (Supply, error) {
+	c := chainOf(ctx)
+	days, number, err := c.emission.refresh(ctx)
+	if err != nil {
+		return Supply{}, err
+	}
+
+	mined := new(big.Int)
+	for _, d := range days {
+		mined.Add(mined, minted(d))
+	}
+	genesis := wei(c.GenesisSupply)
+	total := new(big.Int).Add(genesis, mined)
+
+	excluded := new(big.Int)
+	for _, address := range c.SupplyExcluded {
+		balance, err := c.rpc.Balance(ctx, strings.ToLower(address), number)
+		if err != nil {
+			return Supply{}, &apierr.Error{Code: apierr.Unavailable, Message: "node unavailable", Err: err}
+		}
+		excluded.Add(excluded, balance)
+	}
+	circulating := new(big.Int).Sub(total, excluded)
+	if circulating.Sign() < 0 {
+		circulating.SetInt64(0)
+	}
+
+	return Supply{
+		BlockNumber: number,
+		Genesis:     genesis.String(),
+		Mined:       mined.String(),
+		Total:       total.String(),
+		Excluded:    excluded.String(),
+		Circulating: circulating.String(),
+	}, nil
+}
+
+// coins formats an amount of wei in whole coins, without trailing zeros.
+func coins(amount string) string {
+	return decimalString(new(big.Rat).SetFrac(wei(amount), big.NewInt(1e18)))
+}
+
+func getSupply(w http.ResponseWriter, r *http.Request) {
+	supply, err := currentSupply(r.Context())
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	respondWithJson(w, r, http.StatusOK, supply)
+}
+
+// getCirculatingSupply answers with just the circulating supply in coins, as
+// plain text, the way coin aggregators poll for it.
+func getCirculatingSupply(w http.ResponseWriter, r *http.Request) {
+	supply, err := currentSupply(r.Context())
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(coins(supply.Circulating)))
+}
+
+// periodStart returns the start of the ?interval= period holding timestamp:
+// the UTC day, the ISO week starting on Monday, or the calendar month.
+func periodStart(interval string, timestamp uint64) uint64 {
+	t := time.Unix(int64(timestamp), 0).UTC()
+	switch interval {
+	case "week":
+		t = t.AddDate(0, 0, -(int(t.Weekday())+6)%7)
+	case "month":
+		t = t.AddDate(0, 0, 1-t.Day())
+	}
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix()
+	if start < 0 {
+		return 0
+	}
+	return uint64(start)
+}
+
+// parseInterval reads the ?interval= of history routes, a day by default.
+func parseInterval(r *http.Request) (string, error) {
+	switch interval := r.URL.Query().Get("interval"); interval {
+	case "":
+		return "day", nil
+	case "day", "week", "month":
+		return interval, nil
+	}
+	return "", apierr.InvalidArgumentf("interval must be day, week or month")
+}
+
+// getSupplyHistory returns what was minted in every ?interval= period, with
+// the mined and total supply at its end, oldest first.
+func getSupplyHistory(w http.ResponseWriter, r *http.Request) {
+	interval, err := parseInterval(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	c := chainOf(r.Context())
+	days, _, err := c.emission.refresh(r.Context())
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	history := []SupplyPoint{}
+	mined := new(big.Int)
+	genesis := wei(c.GenesisSupply)
+	for _, d := range days {
+		start := periodStart(interval, d.Timestamp)
+		if n := len(history); n == 0 || history[n-1].Timestamp != start {
+			history = append(history, SupplyPoint{Timestamp: start, Minted: "0"})
+		}
+		p := &history[len(history)-1]
+		m := minted(d)
+		mined.Add(mined, m)
+		p.Blocks += d.Blocks
+		p.Minted = m.Add(m, wei(p.Minted)).String()
+		p.Mined = mined.String()
+		p.Total = new(big.Int).Add(genesis, mined).String()
+	}
+	respondWithJson(w, r, http.StatusOK, history)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/ubiq/spectrum-api/config"
+	. "github.com/ubiq/spectrum-api/dao"
+	"github.com/ubiq/spectrum-api/indexer"
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+func TestPeriodStart(t *testing.T) {
+	tests := []struct {
+		interval  string
+		timestamp uint64
+		want      uint64
+	}{
+		{interval: "day", timestamp: 1500000000, want: 1499990400},   // Friday 2017-07-14 02:40
+		{interval: "day", timestamp: 1499990400, want: 1499990400},   // its midnight
+		{interval: "day", timestamp: 1499990399, want: 1499904000},   // a second before
+		{interval: "week", timestamp: 1500000000, want: 1499644800},  // Monday 2017-07-10
+		{interval: "week", timestamp: 1500206400, want: 1499644800},  // Sunday 2017-07-16 12:00
+		{interval: "week", timestamp: 1499644800, want: 1499644800},  // the Monday itself
+		{interval: "week", timestamp: 1483228800, want: 1482710400},  // Sunday 2017-01-01, a week started in 2016
+		{interval: "month", timestamp: 1500000000, want: 1498867200}, // 2017-07-01
+		{interval: "month", timestamp: 1501545599, want: 1498867200}, // the last second of July
+		{interval: "month", timestamp: 1501545600, want: 1501545600}, // 2017-08-01
+		{interval: "day", timestamp: 0, want: 0},
+		{interval: "week", timestamp: 0, want: 0},                    // Monday 1969-12-29 is before the epoch
+		{interval: "month", timestamp: 86400 * 40, want: 86400 * 31}, // 1970-02-01
+	}
+	for _, tt := range tests {
+		if got := periodStart(tt.interval, tt.timestamp); got != tt.want {
+			t.Errorf("periodStart(%s, %d) = %d, want %d", tt.interval, tt.timestamp, got, tt.want)
+		}
+	}
+}
+
+func TestParseInterval(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+		err   bool
+	}{
+		{query: "", want: "day"},
+		{query: "interval=day", want: "day"},
+		{query: "interval=week", want: "week"},
+		{query: "interval=month", want: "month"},
+		{query: "interval=", want: "day"},
+		{query: "interval=year", err: true},
+		{query: "interval=Week", err: true},
+		{query: "interval=86400", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseInterval(httptest.NewRequest("GET", "/supply/history?"+tt.query, nil))
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("parseInterval(%q) = %q, %v", tt.query, got, err)
+		}
+	}
+}
+
+func TestSupplyNodeDown(t *testing.T) {
+	db := &BoltDAO{DataDir: t.TempDir()}
+	db.Connect()
+	t.Cleanup(db.Close)
+	if err := db.AddBlock(context.Background(), Block{Number: 1, Hash: "0x1", BlockReward: "10"}, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "upstream 10.0.0.5 refused", http.StatusBadGateway)
+	}))
+	defer node.Close()
+	saved := chains
+	t.Cleanup(func() { chains = saved })
+	chains = []*chain{{
+		Chain:    Chain{Name: "ubiq", SupplyExcluded: []string{"0x3fb9f5e5b1e8b9d1d95e0b5ee1d3b83fa6d0e6a9"}},
+		dao:      db,
+		rpc:      indexer.NewRPC(node.URL),
+		emission: &emissionCache{},
+	}}
+
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, httptest.NewRequest("GET", "/v1/supply", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), `"node unavailable"`) {
+		t.Errorf("answered %d %s", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), "10.0.0.5") || strings.Contains(rec.Body.String(), node.URL) {
+		t.Errorf("the node's error reached the client: %s", rec.Body)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/ubiq/spectrum-api/apierr"
+	"golang.org/x/crypto/sha3"
+)
+
+// hexVars are the route variables holding an address or hash, with the byte
+// lengths each accepts. {hash} is always a block or transaction hash.
+var hexVars = map[string][]int{
+	"hash":    {32},
+	"token":   {20},
+	"account": {20},
+	"address": {20},
+	"addr":    {20},
+}
+
+func validHex(v string, lengths []int) bool {
+	if !strings.HasPrefix(v, "0x") {
+		return false
+	}
+	if _, err := hex.DecodeString(v[2:]); err != nil {
+		return false
+	}
+	for _, n := range lengths {
+		if len(v) == 2+2*n {
+			return true
+		}
+	}
+	return false
+}
+
+// validateVars rejects requests whose address and hash route variables
+// aren't 0x-prefixed hex of the right length, and lowercases them, as they
+// are stored, before the handler queries with them.
+func validateVars(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if len(vars) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		normalized := make(map[string]string, len(vars))
+		for name, v := range vars {
+			if lengths, ok := hexVars[name]; ok {
+				v = strings.ToLower(v)
+				if !validHex(v, lengths) {
+					respondWithError(w, r, apierr.InvalidArgumentf("%s must be 0x-prefixed hex of %s bytes", name, byteLengths(lengths)))
+					return
+				}
+			}
+			normalized[name] = v
+		}
+		next.ServeHTTP(w, mux.SetURLVars(r, normalized))
+	})
+}
+
+func byteLengths(lengths []int) string {
+	s := make([]string, len(lengths))
+	for i, n := range lengths {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, " or ")
+}
+
+// checksumAddress returns the EIP-55 mixed-case form of a lowercase address.
+func checksumAddress(address string) string {
+	digits := address[2:]
+	h := sha3.NewLegacyKeccak256()
+	h.Write([]byte(digits))
+	hash := h.Sum(nil)
+
+	out := []byte(address)
+	for i := range digits {
+		c := digits[i]
+		if c < 'a' || c > 'f' {
+			continue
+		}
+		nibble := hash[i/2]
+		if i%2 == 0 {
+			nibble >>= 4
+		}
+		if nibble&0xf >= 8 {
+			out[2+i] = c - 'a' + 'A'
+		}
+	}
+	return string(out)
+}
+
+// quotedAddress matches a json string holding exactly a lowercase address;
+// hashes, topics and input data are longer and don't match.
+var quotedAddress = regexp.MustCompile(`"0x[0-9a-f]{40}"`)
+
+// checksumJSON rewrites every address string in a json response to its
+// EIP-55 form.
+func checksumJSON(body []byte) []byte {
+	return quotedAddress.ReplaceAllFunc(body, func(m []byte) []byte {
+		address := string(bytes.Trim(m, `"`))
+		return []byte(`"` + checksumAddress(address) + `"`)
+	})
+}
+
+func wantsChecksum(r *http.Request) bool {
+	return r.URL.Query().Get("checksum") == "true"
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateVars(t *testing.T) {
+	testChains(t)
+	r := newRouter()
+
+	address := "0x" + strings.Repeat("ab", 20)
+	hash := "0x" + strings.Repeat("cd", 32)
+	tests := []struct {
+		path    string
+		invalid string
+	}{
+		{path: "/v1/transaction/" + hash},
+		{path: "/v1/transaction/" + address, invalid: "hash must be 0x-prefixed hex of 32 bytes"},
+		{path: "/v1/transaction/" + address + "/receipt", invalid: "hash must be 0x-prefixed hex of 32 bytes"},
+		{path: "/v1/blockbyhash/0x" + strings.ToUpper(hash[2:])},
+		{path: "/v1/blockbyhash/" + hash},
+		{path: "/v1/blockbyhash/" + address, invalid: "hash must be 0x-prefixed hex of 32 bytes"},
+		{path: "/v1/uncle/" + address, invalid: "hash must be 0x-prefixed hex of 32 bytes"},
+		{path: "/v1/reorg/" + address, invalid: "hash must be 0x-prefixed hex of 32 bytes"},
+		{path: "/v1/latestaccounttxns/" + address},
+		{path: "/v1/latestaccounttxns/0x" + strings.ToUpper(address[2:])},
+		{path: "/v1/latestaccounttxns/" + hash, invalid: "address must be 0x-prefixed hex of 20 bytes"},
+		{path: "/v1/latesttransfersbytoken/" + hash, invalid: "token must be 0x-prefixed hex of 20 bytes"},
+		{path: "/v1/transactionbycontract/0xzz" + address[4:], invalid: "address must be 0x-prefixed hex of 20 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+			if tt.invalid == "" {
+				if rec.Code == http.StatusBadRequest {
+					t.Errorf("rejected: %s", rec.Body)
+				}
+				return
+			}
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), tt.invalid) {
+				t.Errorf("answered %d %s, want 400 %q", rec.Code, rec.Body, tt.invalid)
+			}
+		})
+	}
+}
+
+func TestChecksumAddress(t *testing.T) {
+	// The test vectors of EIP-55.
+	tests := []string{
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359",
+		"0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB",
+		"0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb",
+		"0x52908400098527886E0F7030069857D2E4169EE7",
+		"0x8617E340B3D01FA5F11F306F4090FD50E238070D",
+		"0xde709f2102306220921060314715629080e2fb77",
+		"0x27b1fdb04752bbc536007a920d24acb045561c26",
+	}
+	for _, want := range tests {
+		if got := checksumAddress(strings.ToLower(want)); got != want {
+			t.Errorf("checksumAddress(%s) = %s", strings.ToLower(want), got)
+		}
+	}
+
+	body := []byte(`{"from":"0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed","hash":"0x` + strings.Repeat("ab", 32) + `"}`)
+	want := `{"from":"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed","hash":"0x` + strings.Repeat("ab", 32) + `"}`
+	if got := string(checksumJSON(body)); got != want {
+		t.Errorf("checksumJSON = %s", got)
+	}
+}
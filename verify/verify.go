@@ -0,0 +1,250 @@
+// Package verify compiles Solidity source with a local solc binary and
+// checks the result against the code a contract was created with.
+package verify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ubiq/spectrum-api/apierr"
+)
+
+// Input is what a contract is compiled from.
+type Input struct {
+	// ContractName is the contract to compare, as Name or File.sol:Name. It
+	// may be left out when the sources define a single contract.
+	ContractName    string
+	Sources         map[string]string
+	CompilerVersion string
+	Optimization    bool
+	Runs            uint64
+	EvmVersion      string
+}
+
+// Compiled is one contract of solc's output.
+type Compiled struct {
+	// Name is File.sol:Name.
+	Name     string
+	Bytecode string
+	ABI      string
+}
+
+// Solc runs the solc binary at Path, in which {version} is replaced by the
+// requested compiler version, e.g /opt/solc/solc-{version}.
+type Solc struct {
+	Path string
+}
+
+var versionPattern = regexp.MustCompile(`^v?(\d+\.\d+\.\d+)(\+commit\.[0-9a-f]+)?$`)
+
+// version reduces a compiler version such as v0.8.19+commit.7dd6d404 to
+// 0.8.19.
+func version(v string) (string, error) {
+	m := versionPattern.FindStringSubmatch(strings.TrimSpace(v))
+	if m == nil {
+		return "", apierr.InvalidArgumentf("compilerVersion %q is not a solc version like 0.8.19", v)
+	}
+	return m[1], nil
+}
+
+// binary finds the solc of version and checks that it is that version.
+func (s *Solc) binary(ctx context.Context, v string) (string, error) {
+	path := strings.ReplaceAll(s.Path, "{version}", v)
+	out, err := exec.CommandContext(ctx, path, "--version").Output()
+	if err != nil {
+		return "", apierr.Unavailablef("solc %s is not installed", v)
+	}
+	if !strings.Contains(string(out), "Version: "+v+"+") {
+		return "", apierr.Unavailablef("solc %s is not installed, %s is a different version", v, path)
+	}
+	return path, nil
+}
+
+type solcInput struct {
+	Language string                       `json:"language"`
+	Sources  map[string]map[string]string `json:"sources"`
+	Settings solcSettings                 `json:"settings"`
+}
+
+type solcSettings struct {
+	Optimizer struct {
+		Enabled bool   `json:"enabled"`
+		Runs    uint64 `json:"runs"`
+	} `json:"optimizer"`
+	EvmVersion      string                         `json:"evmVersion,omitempty"`
+	OutputSelection map[string]map[string][]string `json:"outputSelection"`
+}
+
+type solcOutput struct {
+	Errors []struct {
+		Severity         string `json:"severity"`
+		FormattedMessage string `json:"formattedMessage"`
+	} `json:"errors"`
+	Contracts map[string]map[string]struct {
+		ABI json.RawMessage `json:"abi"`
+		EVM struct {
+			Bytecode struct {
+				Object string `json:"object"`
+			} `json:"bytecode"`
+		} `json:"evm"`
+	} `json:"contracts"`
+}
+
+// Compile compiles in with solc's standard json interface and returns the
+// contract it names.
+func (s *Solc) Compile(ctx context.Context, in Input) (Compiled, error) {
+	v, err := version(in.CompilerVersion)
+	if err != nil {
+		return Compiled{}, err
+	}
+	if len(in.Sources) == 0 {
+		return Compiled{}, apierr.InvalidArgumentf("no source")
+	}
+	path, err := s.binary(ctx, v)
+	if err != nil {
+		return Compiled{}, err
+	}
+
+	input := solcInput{Language: "Solidity", Sources: map[string]map[string]string{}}
+	for name, content := range in.Sources {
+		input.Sources[name] = map[string]string{"content": content}
+	}
+	input.Settings.Optimizer.Enabled = in.Optimization
+	input.Settings.Optimizer.Runs = in.Runs
+	input.Settings.EvmVersion = in.EvmVersion
+	input.Settings.OutputSelection = map[string]map[string][]string{"*": {"*": {"abi", "evm.bytecode.object"}}}
+	body, err := json.Marshal(input)
+	if err != nil {
+		return Compiled{}, err
+	}
+
+	cmd := exec.CommandContext(ctx, path, "--standard-json")
+	cmd.Stdin = bytes.NewReader(body)
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		return Compiled{}, apierr.Unavailablef("solc did not finish in time")
+	}
+	if err != nil {
+		return Compiled{}, fmt.Errorf("solc: %v", err)
+	}
+	var output solcOutput
+	if err := json.Unmarshal(out, &output); err != nil {
+		return Compiled{}, fmt.Errorf("solc output: %v", err)
+	}
+
+	var messages []string
+	for _, e := range output.Errors {
+		if e.Severity == "error" {
+			messages = append(messages, strings.TrimSpace(e.FormattedMessage))
+		}
+	}
+	if len(messages) > 0 {
+		return Compiled{}, apierr.InvalidArgumentf("compilation failed: %s", strings.Join(messages, "\n"))
+	}
+
+	var found []Compiled
+	for file, contracts := range output.Contracts {
+		for name, c := range contracts {
+			if in.ContractName == "" || in.ContractName == name || in.ContractName == file+":"+name {
+				found = append(found, Compiled{Name: file + ":" + name, Bytecode: c.EVM.Bytecode.Object, ABI: string(c.ABI)})
+			}
+		}
+	}
+	switch {
+	case len(found) == 0 && in.ContractName == "":
+		return Compiled{}, apierr.InvalidArgumentf("the sources define no contract")
+	case len(found) == 0:
+		return Compiled{}, apierr.InvalidArgumentf("the sources define no contract %s", in.ContractName)
+	case len(found) > 1:
+		names := make([]string, len(found))
+		for i, c := range found {
+			names[i] = c.Name
+		}
+		sort.Strings(names)
+		return Compiled{}, apierr.InvalidArgumentf("contractName must be one of %s", strings.Join(names, ", "))
+	}
+	if strings.Contains(found[0].Bytecode, "__$") {
+		return Compiled{}, apierr.InvalidArgumentf("contracts that link libraries are not supported")
+	}
+	return found[0], nil
+}
+
+// Match is how closely compiled code matches a contract's creation input.
+type Match string
+
+const (
+	// Full means the code is identical, metadata hashes included.
+	Full Match = "full"
+	// Partial means only the metadata hashes differ, which happens when the
+	// source differs in comments, whitespace or file names.
+	Partial Match = "partial"
+)
+
+// ErrMismatch is returned by Compare when the code differs.
+var ErrMismatch = errors.New("compiled bytecode doesn't match the contract's creation input")
+
+// metadataHashes are the CBOR prefixes solc writes the metadata hash
+// after, with the length of the hash in bytes: ipfs, then bzzr1 and bzzr0
+// used by older compilers.
+var metadataHashes = []struct {
+	prefix string
+	length int
+}{
+	{"64697066735822", 34},
+	{"65627a7a72315820", 32},
+	{"65627a7a72305820", 32},
+}
+
+// metadataRanges returns the hex offsets of the metadata hashes in code,
+// the contract's own and those of contracts it creates.
+func metadataRanges(code string) [][2]int {
+	var ranges [][2]int
+	for _, h := range metadataHashes {
+		for from := 0; ; {
+			i := strings.Index(code[from:], h.prefix)
+			if i < 0 {
+				break
+			}
+			start := from + i + len(h.prefix)
+			if (from+i)%2 == 0 && start+2*h.length <= len(code) {
+				ranges = append(ranges, [2]int{start, start + 2*h.length})
+			}
+			from += i + 2
+		}
+	}
+	return ranges
+}
+
+// Compare checks compiled creation bytecode against the input of the
+// transaction that created the contract, and returns what follows it there,
+// the abi-encoded constructor arguments.
+func Compare(compiled string, input string) (Match, string, error) {
+	compiled = strings.ToLower(strings.TrimPrefix(compiled, "0x"))
+	input = strings.ToLower(strings.TrimPrefix(input, "0x"))
+	if compiled == "" || len(input) < len(compiled) {
+		return "", "", ErrMismatch
+	}
+	code, args := input[:len(compiled)], input[len(compiled):]
+	if code == compiled {
+		return Full, args, nil
+	}
+
+	masked := []byte(compiled)
+	deployed := []byte(code)
+	for _, r := range metadataRanges(compiled) {
+		for i := r[0]; i < r[1]; i++ {
+			masked[i], deployed[i] = '0', '0'
+		}
+	}
+	if !bytes.Equal(masked, deployed) {
+		return "", "", ErrMismatch
+	}
+	return Partial, args, nil
+}
@@ -0,0 +1,89 @@
+package verify
+
+import (
+	"strings"
+	"testing"
+)
+
+// contract is creation code ending in solc's metadata: the ipfs hash of
+// metadata, here filled with digit, and the compiler version.
+func contract(body string, digit string) string {
+	return "608060405234801561001057600080fd5b50" + body +
+		"a2646970667358221220" + strings.Repeat(digit, 64) + "64736f6c63430008130033"
+}
+
+// legacy is creation code with the bzzr0 metadata hash of solc before 0.5.9.
+func legacy(digit string) string {
+	return "6080604052348015600f57600080fd5b50" +
+		"a165627a7a72305820" + strings.Repeat(digit, 64) + "0029"
+}
+
+func TestCompare(t *testing.T) {
+	args := "000000000000000000000000000000000000000000000000000000000000002a"
+	tests := []struct {
+		name     string
+		compiled string
+		input    string
+		match    Match
+		args     string
+		err      error
+	}{
+		{name: "identical", compiled: contract("6001", "1"), input: contract("6001", "1"), match: Full},
+		{name: "constructor arguments", compiled: contract("6001", "1"), input: contract("6001", "1") + args, match: Full, args: args},
+		{name: "prefix and case", compiled: "0x" + contract("6001", "a"), input: "0x" + strings.ToUpper(contract("6001", "a")) + args, match: Full, args: args},
+		{name: "metadata hash differs", compiled: contract("6001", "1"), input: contract("6001", "2") + args, match: Partial, args: args},
+		{name: "legacy metadata hash differs", compiled: legacy("3"), input: legacy("4"), match: Partial},
+		{
+			name:     "metadata of a created contract differs",
+			compiled: contract("6001"+contract("6002", "5"), "6"),
+			input:    contract("6001"+contract("6002", "7"), "8"),
+			match:    Partial,
+		},
+		{name: "code differs", compiled: contract("6001", "1"), input: contract("6002", "1"), err: ErrMismatch},
+		{name: "code and metadata differ", compiled: contract("6001", "1"), input: contract("6002", "2"), err: ErrMismatch},
+		{name: "input too short", compiled: contract("6001", "1"), input: contract("", "1"), err: ErrMismatch},
+		{name: "nothing compiled", compiled: "0x", input: contract("6001", "1"), err: ErrMismatch},
+		{
+			// The prefix only marks a hash at a byte boundary.
+			name:     "prefix across bytes",
+			compiled: "6" + "64697066735822" + strings.Repeat("1", 68) + "0",
+			input:    "6" + "64697066735822" + strings.Repeat("2", 68) + "0",
+			err:      ErrMismatch,
+		},
+		{
+			name:     "truncated hash",
+			compiled: "a2646970667358221220" + strings.Repeat("1", 60),
+			input:    "a2646970667358221220" + strings.Repeat("2", 60),
+			err:      ErrMismatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, args, err := Compare(tt.compiled, tt.input)
+			if err != tt.err || match != tt.match || args != tt.args {
+				t.Errorf("Compare = %q, %q, %v, want %q, %q, %v", match, args, err, tt.match, tt.args, tt.err)
+			}
+		})
+	}
+}
+
+func TestVersion(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		err  bool
+	}{
+		{in: "0.8.19", want: "0.8.19"},
+		{in: "v0.8.19+commit.7dd6d404", want: "0.8.19"},
+		{in: " 0.4.26 ", want: "0.4.26"},
+		{in: "0.8", err: true},
+		{in: "latest", err: true},
+		{in: "0.8.19; rm -rf /", err: true},
+	}
+	for _, tt := range tests {
+		got, err := version(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("version(%q) = %q, %v", tt.in, got, err)
+		}
+	}
+}
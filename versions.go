@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/ubiq/spectrum-api/apierr"
+	. "github.com/ubiq/spectrum-api/dao"
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+// deprecated marks responses of the unversioned aliases of the v1 routes,
+// pointing clients at the same path under /v1, or /{chain}/v1.
+func deprecated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		if !config_.LegacySunset.IsZero() {
+			w.Header().Set("Sunset", config_.LegacySunset.UTC().Format(http.TimeFormat))
+		}
+		prefix := ""
+		if name := mux.Vars(r)["chain"]; name != "" {
+			prefix = "/" + name
+		}
+		w.Header().Set("Link", "<"+prefix+"/v1"+strings.TrimPrefix(r.URL.Path, prefix)+`>; rel="successor-version"`)
+		next.ServeHTTP(w, r)
+	})
+}
+
+type AccountTransactions struct {
+	Transactions []Transaction `bson:"transactions" json:"transactions"`
+	Total        int           `bson:"total" json:"total"`
+}
+
+// routesV2 registers the resource-oriented api. Routes are added here as
+// they are redesigned; v1 keeps serving the old shapes unchanged.
+func routesV2(r *mux.Router) {
+	r.HandleFunc("/blocks/{id}", getBlockV2).Methods("GET")
+	r.HandleFunc("/accounts/{addr}/transactions", getAccountTransactionsV2).Methods("GET")
+}
+
+// blockByID looks a block up by number or by hash, falling back to forked
+// blocks for a hash. canonical reports which it was found among.
+func blockByID(r *http.Request, id string) (block Block, canonical bool, err error) {
+	if number, err := strconv.ParseUint(id, 10, 64); err == nil {
+		block, err = backend(r.Context()).BlockByNumber(r.Context(), number)
+		return block, true, err
+	}
+	hash := strings.ToLower(id)
+	if !validHex(hash, []int{32}) {
+		return block, false, apierr.InvalidArgumentf("id must be a block number or a 0x-prefixed 32 byte hash")
+	}
+	block, err = backend(r.Context()).BlockByHash(r.Context(), hash)
+	if err == ErrNotFound {
+		block, err = backend(r.Context()).ForkedBlockByHash(r.Context(), hash)
+		return block, false, err
+	}
+	return block, true, err
+}
+
+func getBlockV2(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	block, canonical, err := blockByID(r, params["id"])
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	head, err := chainHead(r.Context())
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	markBlock(head, &block, canonical)
+	respondWithJson(w, r, http.StatusOK, block)
+}
+
+func getAccountTransactionsV2(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	filter, err := txnFilter(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	txns, err := backend(r.Context()).LatestTransactionsByAccount(r.Context(), params["addr"], filter)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	count, err := backend(r.Context()).TxnCount(r.Context(), params["addr"], filter)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	head, err := chainHead(r.Context())
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	markTxns(head, txns)
+	if err := fiatTxns(r, txns); err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	if txns == nil {
+		txns = []Transaction{}
+	}
+	respondWithJson(w, r, http.StatusOK, AccountTransactions{Transactions: txns, Total: count})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/ubiq/spectrum-api/config"
+)
+
+// Only the unversioned aliases of the v1 routes are marked deprecated, on
+// errors too.
+func TestDeprecatedHeaders(t *testing.T) {
+	defer func(saved Config) { config_ = saved }(config_)
+	testChains(t)
+	config_.LegacySunset = time.Date(2027, time.June, 30, 0, 0, 0, 0, time.UTC)
+	r := newRouter()
+
+	tests := []struct {
+		path   string
+		status int
+		link   string
+	}{
+		{path: "/latest", status: http.StatusOK, link: `</v1/latest>; rel="successor-version"`},
+		{path: "/block/9", status: http.StatusNotFound, link: `</v1/block/9>; rel="successor-version"`},
+		{path: "/block/x", status: http.StatusBadRequest, link: `</v1/block/x>; rel="successor-version"`},
+		{path: "/testnet/block/1", status: http.StatusOK, link: `</testnet/v1/block/1>; rel="successor-version"`},
+		{path: "/v1/latest", status: http.StatusOK},
+		{path: "/v1/block/9", status: http.StatusNotFound},
+		{path: "/v2/blocks/1", status: http.StatusOK},
+		{path: "/testnet/v1/latest", status: http.StatusOK},
+		{path: "/testnet/v2/blocks/1", status: http.StatusOK},
+		{path: "/chains", status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s answered %d %s", tt.path, rec.Code, rec.Body)
+		}
+		h := rec.Header()
+		if tt.link == "" {
+			if h.Get("Deprecation") != "" || h.Get("Sunset") != "" || h.Get("Link") != "" {
+				t.Errorf("%s is marked deprecated: %v", tt.path, h)
+			}
+			continue
+		}
+		if h.Get("Deprecation") != "true" || h.Get("Sunset") != "Wed, 30 Jun 2027 00:00:00 GMT" || h.Get("Link") != tt.link {
+			t.Errorf("%s: Deprecation %q, Sunset %q, Link %q", tt.path, h.Get("Deprecation"), h.Get("Sunset"), h.Get("Link"))
+		}
+	}
+
+	// Without a sunset date there is no Sunset header.
+	config_.LegacySunset = time.Time{}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/latest", nil))
+	if rec.Header().Get("Deprecation") != "true" || rec.Header().Get("Sunset") != "" {
+		t.Errorf("without a sunset date: %v", rec.Header())
+	}
+}
@@ -0,0 +1,580 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/ubiq/spectrum-api/apierr"
+	. "github.com/ubiq/spectrum-api/dao"
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+// maxDueDeliveries caps the deliveries of a watch a poll tries, oldest
+// first.
+const maxDueDeliveries = 100
+
+// maxRetryDelay caps the wait between two attempts of a webhook.
+const maxRetryDelay = time.Hour
+
+// WebhookPayload is the json posted to a watch's url. Its hmac-sha256 with
+// the watch's secret is sent as the X-Spectrum-Signature header.
+type WebhookPayload struct {
+	Delivery      string         `json:"delivery"`
+	Watch         string         `json:"watch"`
+	Chain         string         `json:"chain"`
+	Event         string         `json:"event"`
+	BlockNumber   uint64         `json:"blockNumber"`
+	BlockHash     string         `json:"blockHash"`
+	Confirmations uint64         `json:"confirmations"`
+	Transaction   *Transaction   `json:"transaction,omitempty"`
+	Transfer      *TokenTransfer `json:"transfer,omitempty"`
+	Attempt       int            `json:"attempt"`
+	SentAt        uint64         `json:"sentAt"`
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// sign returns the X-Spectrum-Signature of body.
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryDelay is the wait after the attempts-th failed attempt of a webhook.
+func retryDelay(attempts int) time.Duration {
+	delay := config_.WebhookRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return delay
+}
+
+// post sends d to the url of w once, and records the outcome on d: delivered
+// when the receiver answers with a 2xx, failed when that was the last
+// attempt, and otherwise when to try again.
+func post(ctx context.Context, client *http.Client, chainName string, w Watch, d *Delivery, head uint64) {
+	now := time.Now()
+	d.Attempts++
+	d.LastAttempt = uint64(now.Unix())
+	payload := WebhookPayload{
+		Delivery:      d.ID,
+		Watch:         w.ID,
+		Chain:         chainName,
+		Event:         d.Event,
+		BlockNumber:   d.BlockNumber,
+		BlockHash:     d.BlockHash,
+		Confirmations: confirmations(head, d.BlockNumber),
+		Transfer:      d.Transfer,
+		Attempt:       d.Attempts,
+		SentAt:        d.LastAttempt,
+	}
+	if d.Transaction != nil {
+		txn := *d.Transaction
+		markTxn(head, &txn)
+		payload.Transaction = &txn
+	}
+
+	d.ResponseStatus, d.Error = 0, ""
+	body, err := json.Marshal(payload)
+	var req *http.Request
+	if err == nil {
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	}
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "spectrum-api")
+		req.Header.Set("X-Spectrum-Event", d.Event)
+		req.Header.Set("X-Spectrum-Delivery", d.ID)
+		req.Header.Set("X-Spectrum-Signature", sign(w.Secret, body))
+		var res *http.Response
+		if res, err = client.Do(req); err == nil {
+			io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+			res.Body.Close()
+			d.ResponseStatus = res.StatusCode
+			if res.StatusCode < 200 || res.StatusCode > 299 {
+				err = fmt.Errorf("receiver answered %s", res.Status)
+			}
+		}
+	}
+
+	switch {
+	case err == nil:
+		d.Status, d.NextAttempt = "delivered", 0
+	case d.Attempts >= config_.WebhookAttempts:
+		d.Status, d.NextAttempt, d.Error = "failed", 0, err.Error()
+	default:
+		d.NextAttempt, d.Error = uint64(now.Add(retryDelay(d.Attempts)).Unix()), err.Error()
+	}
+}
+
+// deliveryID names the delivery of the index-th transaction or transfer of
+// block hash to watch, so that matching a block again finds it stored.
+func deliveryID(watch string, hash string, event string, index int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%s|%d", watch, hash, event, index)))
+	return hex.EncodeToString(sum[:16])
+}
+
+// watchesTxn reports whether w matches txn.
+func watchesTxn(w Watch, txn Transaction) bool {
+	return w.Address != "" && w.Contract == "" && (txn.From == w.Address || txn.To == w.Address)
+}
+
+// watchesTransfer reports whether w matches transfer.
+func watchesTransfer(w Watch, transfer TokenTransfer) bool {
+	return (w.Contract == "" || transfer.Contract == w.Contract) &&
+		(w.Address == "" || transfer.From == w.Address || transfer.To == w.Address)
+}
+
+// notifier matches the new blocks of a chain against its watches, and
+// posts the webhooks of what they matched once it has enough confirmations.
+// Pending deliveries are stored, so they survive a restart.
+type notifier struct {
+	c      *chain
+	db     Writer
+	client *http.Client
+	// scanned holds the hash of the latest blocks, by number, as they were
+	// matched, so that blocks replaced by a reorg are matched again.
+	scanned map[uint64]string
+}
+
+func newNotifier(c *chain, db Writer) *notifier {
+	return &notifier{c: c, db: db, client: &http.Client{Timeout: config_.WebhookTimeout}, scanned: map[uint64]string{}}
+}
+
+// run polls every webhookPollInterval until ctx is done.
+func (n *notifier) run(ctx context.Context) {
+	for {
+		if err := n.poll(ctx); err != nil && ctx.Err() == nil {
+			log.WithField("chain", n.c.Name).Error("Webhook poll failed: ", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(config_.WebhookPollInterval):
+		}
+	}
+}
+
+func (n *notifier) poll(ctx context.Context) error {
+	head, err := n.c.dao.LatestBlock(ctx)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	watches, err := n.c.dao.Watches(ctx)
+	if err != nil {
+		return err
+	}
+	if err := n.match(ctx, head, watches); err != nil {
+		return err
+	}
+	return n.deliver(ctx, head, watches)
+}
+
+// match stores a pending delivery for everything the watches match in the
+// blocks indexed since the cursor, and in those of the latest
+// maxReorgDepth blocks that changed since they were matched. A new cursor
+// starts at the head, without matching the blocks before it.
+func (n *notifier) match(ctx context.Context, head Block, watches []Watch) error {
+	cursor, err := n.c.dao.WatchCursor(ctx)
+	if err == ErrNotFound {
+		cursor = head.Number
+	} else if err != nil {
+		return err
+	}
+	if len(watches) == 0 {
+		return n.db.SetWatchCursor(ctx, head.Number)
+	}
+	recent, err := n.c.dao.LatestBlocks(ctx, config_.MaxReorgDepth)
+	if err != nil {
+		return err
+	}
+	low := head.Number
+	if len(recent) > 0 {
+		low = recent[len(recent)-1].Number
+	}
+
+	for number := cursor + 1; number < low; number++ {
+		b, err := n.c.dao.BlockByNumber(ctx, number)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := n.matchBlock(ctx, b, watches); err != nil {
+			return err
+		}
+		if err := n.db.SetWatchCursor(ctx, number); err != nil {
+			return err
+		}
+	}
+	for i := len(recent) - 1; i >= 0; i-- {
+		b := recent[i]
+		if n.scanned[b.Number] == b.Hash {
+			continue
+		}
+		if err := n.matchBlock(ctx, b, watches); err != nil {
+			return err
+		}
+		n.scanned[b.Number] = b.Hash
+	}
+	for number := range n.scanned {
+		if number < low {
+			delete(n.scanned, number)
+		}
+	}
+	return n.db.SetWatchCursor(ctx, head.Number)
+}
+
+func (n *notifier) matchBlock(ctx context.Context, b Block, watches []Watch) error {
+	var live []Watch
+	for _, w := range watches {
+		if b.Number > w.FromBlock {
+			live = append(live, w)
+		}
+	}
+	if len(live) == 0 {
+		return nil
+	}
+	txns, err := n.c.dao.TransactionsByBlockNumber(ctx, b.Number)
+	if err != nil {
+		return err
+	}
+	transfers, err := n.c.dao.TokenTransfersByBlockNumber(ctx, b.Number)
+	if err != nil {
+		return err
+	}
+
+	for _, w := range live {
+		for i := range txns {
+			if watchesTxn(w, txns[i]) {
+				d := Delivery{Event: "transaction", Hash: txns[i].Hash, Transaction: &txns[i]}
+				if err := n.record(ctx, w, b, i, d); err != nil {
+					return err
+				}
+			}
+		}
+		for i := range transfers {
+			if watchesTransfer(w, transfers[i]) {
+				d := Delivery{Event: "transfer", Hash: transfers[i].Hash, Transfer: &transfers[i]}
+				if err := n.record(ctx, w, b, i, d); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// record stores d, the index-th match of w in block b, unless it already
+// was.
+func (n *notifier) record(ctx context.Context, w Watch, b Block, index int, d Delivery) error {
+	d.ID = deliveryID(w.ID, b.Hash, d.Event, index)
+	if _, err := n.c.dao.Delivery(ctx, d.ID); err != ErrNotFound {
+		return err
+	}
+	d.WatchID = w.ID
+	d.BlockNumber, d.BlockHash = b.Number, b.Hash
+	d.Status = "pending"
+	d.CreatedAt = uint64(time.Now().Unix())
+	return n.db.SetDelivery(ctx, d)
+}
+
+// deliver posts the due deliveries of every watch, up to WebhookWorkers
+// watches at once, so that a slow receiver only holds up its own watch.
+func (n *notifier) deliver(ctx context.Context, head Block, watches []Watch) error {
+	jobs := make(chan Watch)
+	errs := make(chan error, len(watches))
+	var wg sync.WaitGroup
+	for i := 0; i < config_.WebhookWorkers && i < len(watches); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for w := range jobs {
+				if err := n.deliverWatch(ctx, head, w); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	for _, w := range watches {
+		jobs <- w
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// deliverWatch posts, oldest first, the pending deliveries of w that have
+// the confirmations it asks for and are due. Those whose block was replaced
+// by a reorg are orphaned instead. The rest wait for the next poll once the
+// receiver fails to take one.
+func (n *notifier) deliverWatch(ctx context.Context, head Block, w Watch) error {
+	if w.Confirmations > head.Number+1 {
+		return nil
+	}
+	toBlock := head.Number
+	if w.Confirmations > 0 {
+		toBlock = head.Number + 1 - w.Confirmations
+	}
+	due, err := n.c.dao.DueDeliveries(ctx, w.ID, toBlock, uint64(time.Now().Unix()), maxDueDeliveries)
+	if err != nil {
+		return err
+	}
+	for _, d := range due {
+		canonical, err := n.canonical(ctx, d)
+		if err != nil {
+			return err
+		}
+		if canonical {
+			post(ctx, n.client, n.c.Name, w, &d, head.Number)
+		} else {
+			d.Status = "orphaned"
+		}
+		if d.Status == "failed" {
+			log.WithFields(log.Fields{"chain": n.c.Name, "watch": w.ID, "delivery": d.ID}).Warn("Webhook failed: ", d.Error)
+		}
+		if err := n.db.SetDelivery(ctx, d); err != nil {
+			return err
+		}
+		if d.Status != "delivered" && d.Status != "orphaned" {
+			break
+		}
+	}
+	return nil
+}
+
+// canonical reports whether the block of d is still on the chain.
+func (n *notifier) canonical(ctx context.Context, d Delivery) (bool, error) {
+	if d.Event == "test" {
+		return true, nil
+	}
+	if hash, ok := n.scanned[d.BlockNumber]; ok {
+		return hash == d.BlockHash, nil
+	}
+	b, err := n.c.dao.BlockByNumber(ctx, d.BlockNumber)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil && b.Hash == d.BlockHash, err
+}
+
+// normalizeWatch lowercases the addresses of w, and checks it watches
+// something and posts to an http(s) url.
+func normalizeWatch(w *Watch) error {
+	w.Address = strings.ToLower(w.Address)
+	w.Contract = strings.ToLower(w.Contract)
+	if w.Address == "" && w.Contract == "" {
+		return apierr.InvalidArgumentf("a watch needs an address, a contract or both")
+	}
+	for name, v := range map[string]string{"address": w.Address, "contract": w.Contract} {
+		if v != "" && !validHex(v, []int{20}) {
+			return apierr.InvalidArgumentf("%s must be 0x-prefixed hex of 20 bytes", name)
+		}
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return apierr.InvalidArgumentf("url must be an http or https url")
+	}
+	return nil
+}
+
+// createWatch stores a new watch, matched against the blocks indexed from
+// now on. A secret is generated when none is given.
+func createWatch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var watch Watch
+	if err := json.NewDecoder(r.Body).Decode(&watch); err != nil {
+		respondWithError(w, r, apierr.InvalidArgumentf("invalid watch: %v", err))
+		return
+	}
+	if err := normalizeWatch(&watch); err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	head, err := chainHead(ctx)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	watch.ID = randomHex(8)
+	if watch.Secret == "" {
+		watch.Secret = randomHex(32)
+	}
+	watch.FromBlock = head
+	watch.CreatedAt = uint64(time.Now().Unix())
+
+	db, err := writer(ctx)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	if err := db.SetWatch(ctx, watch); err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	respondWithJson(w, r, http.StatusCreated, watch)
+}
+
+func getWatches(w http.ResponseWriter, r *http.Request) {
+	watches, err := backend(r.Context()).Watches(r.Context())
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	if watches == nil {
+		watches = []Watch{}
+	}
+	respondWithJson(w, r, http.StatusOK, watches)
+}
+
+func getWatch(w http.ResponseWriter, r *http.Request) {
+	watch, err := backend(r.Context()).Watch(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	respondWithJson(w, r, http.StatusOK, watch)
+}
+
+func deleteWatch(w http.ResponseWriter, r *http.Request) {
+	db, err := writer(r.Context())
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	if err := db.DeleteWatch(r.Context(), mux.Vars(r)["id"]); err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getDeliveries lists the deliveries of a watch in ?status=, newest first.
+func getDeliveries(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	q := r.URL.Query()
+	filter := DeliveryFilter{WatchID: mux.Vars(r)["id"], Status: q.Get("status")}
+	switch filter.Status {
+	case "", "pending", "delivered", "failed", "orphaned":
+	default:
+		respondWithError(w, r, apierr.InvalidArgumentf("status must be pending, delivered, failed or orphaned"))
+		return
+	}
+	limit := config_.RouteLimit("/watches/{id}/deliveries")
+	if v := q.Get("limit"); v != "" {
+		var err error
+		if limit, err = parseLimit(r, v); err != nil {
+			respondWithError(w, r, err)
+			return
+		}
+	}
+	if _, err := backend(ctx).Watch(ctx, filter.WatchID); err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	deliveries, err := backend(ctx).Deliveries(ctx, filter, limit)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []Delivery{}
+	}
+	respondWithJson(w, r, http.StatusOK, deliveries)
+}
+
+// testWatch posts a test event to the url of a watch right away, once, and
+// answers with the delivery, which is kept in the watch's log.
+func testWatch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	watch, err := backend(ctx).Watch(ctx, mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	head, err := backend(ctx).LatestBlock(ctx)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	d := Delivery{
+		ID:          randomHex(16),
+		WatchID:     watch.ID,
+		Event:       "test",
+		BlockNumber: head.Number,
+		BlockHash:   head.Hash,
+		Status:      "pending",
+		CreatedAt:   uint64(time.Now().Unix()),
+	}
+	post(ctx, &http.Client{Timeout: config_.WebhookTimeout}, chainOf(ctx).Name, watch, &d, head.Number)
+	if d.Status == "pending" {
+		d.Status, d.NextAttempt = "failed", 0
+	}
+
+	db, err := writer(ctx)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	if err := db.SetDelivery(ctx, d); err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	respondWithJson(w, r, http.StatusOK, d)
+}
+
+// retryDelivery makes a delivery of a watch pending again, with all its
+// attempts, so that the next poll posts it.
+func retryDelivery(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	d, err := backend(ctx).Delivery(ctx, vars["delivery"])
+	if err == nil && d.WatchID != vars["id"] {
+		err = ErrNotFound
+	}
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	d.Status, d.Attempts, d.NextAttempt = "pending", 0, 0
+
+	db, err := writer(ctx)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	if err := db.SetDelivery(ctx, d); err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	respondWithJson(w, r, http.StatusOK, d)
+}
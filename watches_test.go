@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/ubiq/spectrum-api/config"
+	. "github.com/ubiq/spectrum-api/dao"
+	. "github.com/ubiq/spectrum-api/models"
+)
+
+const (
+	watched = "0x8d3e2c6a4b7f1e9d0c5a3b2f4e6d8c1a9b7e5f3d"
+	other   = "0x1f0e2d3c4b5a69788796a5b4c3d2e1f0a1b2c3d4"
+)
+
+// receiver is a webhook receiver answering with status, recording what it
+// was sent.
+type receiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newReceiver(t *testing.T, status int) (*receiver, string) {
+	rcv := &receiver{status: status}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rcv.mu.Lock()
+		defer rcv.mu.Unlock()
+		rcv.requests = append(rcv.requests, r)
+		rcv.bodies = append(rcv.bodies, body)
+		w.WriteHeader(rcv.status)
+	}))
+	t.Cleanup(srv.Close)
+	return rcv, srv.URL
+}
+
+func (rcv *receiver) payloads(t *testing.T) []WebhookPayload {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	var payloads []WebhookPayload
+	for _, body := range rcv.bodies {
+		var p WebhookPayload
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Fatal(err)
+		}
+		payloads = append(payloads, p)
+	}
+	return payloads
+}
+
+// notifierTest is a notifier over a bolt store holding a watch of watched
+// that posts to url.
+type notifierTest struct {
+	t  *testing.T
+	db *BoltDAO
+	n  *notifier
+	w  Watch
+}
+
+func newNotifierTest(t *testing.T, url string, confirmations uint64) *notifierTest {
+	saved := config_
+	t.Cleanup(func() { config_ = saved })
+	config_ = Config{}
+	config_.MaxReorgDepth = 8
+	config_.WebhookTimeout = 5 * time.Second
+	config_.WebhookAttempts = 3
+	config_.WebhookRetryDelay = time.Nanosecond
+	config_.WebhookWorkers = 2
+
+	db := &BoltDAO{DataDir: t.TempDir()}
+	db.Connect()
+	t.Cleanup(db.Close)
+	c := &chain{Chain: Chain{Name: "testnet"}, dao: db}
+	nt := &notifierTest{t: t, db: db, n: newNotifier(c, db)}
+	nt.w = Watch{ID: "w1", Address: watched, URL: url, Secret: "s3cret", Confirmations: confirmations}
+	if err := db.SetWatch(context.Background(), nt.w); err != nil {
+		t.Fatal(err)
+	}
+	return nt
+}
+
+func blockHash(fork string, number uint64) string {
+	return fmt.Sprintf("0x%s%062x", fork, number)
+}
+
+// addBlock stores block number of fork as the head, with a transaction
+// between from and to.
+func (nt *notifierTest) addBlock(number uint64, fork string, from string, to string) Block {
+	ctx := context.Background()
+	b := Block{Number: number, Hash: blockHash(fork, number), ParentHash: blockHash(fork, number-1), Transactions: 1}
+	txn := Transaction{Hash: blockHash(fork+"0"+fork, number), BlockHash: b.Hash, BlockNumber: number, From: from, To: to, Value: "1"}
+	if err := nt.db.AddBlock(ctx, b, []Transaction{txn}, nil, nil, nil); err != nil {
+		nt.t.Fatal(err)
+	}
+	if err := nt.db.SetLatestBlock(ctx, b); err != nil {
+		nt.t.Fatal(err)
+	}
+	return b
+}
+
+func (nt *notifierTest) poll() {
+	if err := nt.n.poll(context.Background()); err != nil {
+		nt.t.Fatal(err)
+	}
+}
+
+func (nt *notifierTest) deliveries() []Delivery {
+	list, err := nt.db.Deliveries(context.Background(), DeliveryFilter{WatchID: nt.w.ID}, 0)
+	if err != nil {
+		nt.t.Fatal(err)
+	}
+	return list
+}
+
+func TestWebhookSignature(t *testing.T) {
+	rcv, url := newReceiver(t, http.StatusOK)
+	nt := newNotifierTest(t, url, 0)
+	nt.addBlock(1, "aa", watched, other)
+	nt.addBlock(2, "aa", other, other)
+	nt.addBlock(3, "aa", other, watched)
+	nt.poll()
+
+	if len(rcv.bodies) != 2 {
+		t.Fatalf("%d webhooks posted, want 2", len(rcv.bodies))
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(rcv.bodies[0])
+	if got, want := rcv.requests[0].Header.Get("X-Spectrum-Signature"), "sha256="+hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("signature %s, want %s", got, want)
+	}
+	if got := rcv.requests[0].Header.Get("X-Spectrum-Event"); got != "transaction" {
+		t.Errorf("event header %q", got)
+	}
+
+	payloads := rcv.payloads(t)
+	if payloads[0].BlockNumber != 1 || payloads[1].BlockNumber != 3 {
+		t.Errorf("posted blocks %d, %d, want the oldest first", payloads[0].BlockNumber, payloads[1].BlockNumber)
+	}
+	p := payloads[0]
+	if p.Watch != "w1" || p.Chain != "testnet" || p.Attempt != 1 || p.Confirmations != 3 || p.Transaction == nil || p.Transaction.From != watched {
+		t.Errorf("payload = %+v", p)
+	}
+	if p.Delivery != rcv.requests[0].Header.Get("X-Spectrum-Delivery") {
+		t.Errorf("delivery %s, header %s", p.Delivery, rcv.requests[0].Header.Get("X-Spectrum-Delivery"))
+	}
+	for _, d := range nt.deliveries() {
+		if d.Status != "delivered" || d.Attempts != 1 || d.ResponseStatus != http.StatusOK {
+			t.Errorf("delivery of block %d %s after %d attempts, status %d", d.BlockNumber, d.Status, d.Attempts, d.ResponseStatus)
+		}
+	}
+
+	// Delivered webhooks are not posted again.
+	nt.poll()
+	if len(rcv.bodies) != 2 {
+		t.Errorf("%d webhooks posted after a second poll, want 2", len(rcv.bodies))
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	rcv, url := newReceiver(t, http.StatusInternalServerError)
+	nt := newNotifierTest(t, url, 0)
+	nt.addBlock(1, "aa", watched, other)
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		nt.poll()
+		d := nt.deliveries()[0]
+		want := "pending"
+		if attempt == 3 {
+			want = "failed"
+		}
+		if d.Status != want || d.Attempts != attempt || d.ResponseStatus != http.StatusInternalServerError || d.Error == "" {
+			t.Errorf("after attempt %d: %s, %d attempts, status %d, error %q", attempt, d.Status, d.Attempts, d.ResponseStatus, d.Error)
+		}
+	}
+	nt.poll()
+	if len(rcv.bodies) != 3 {
+		t.Errorf("%d attempts posted, want 3", len(rcv.bodies))
+	}
+	for i, p := range rcv.payloads(t) {
+		if p.Attempt != i+1 {
+			t.Errorf("attempt %d posted as %d", i+1, p.Attempt)
+		}
+	}
+}
+
+func TestWebhookNotDue(t *testing.T) {
+	rcv, url := newReceiver(t, http.StatusServiceUnavailable)
+	nt := newNotifierTest(t, url, 0)
+	config_.WebhookRetryDelay = time.Hour
+	nt.addBlock(1, "aa", watched, other)
+
+	nt.poll()
+	nt.poll()
+	if len(rcv.bodies) != 1 {
+		t.Errorf("%d attempts posted before the retry was due, want 1", len(rcv.bodies))
+	}
+	if d := nt.deliveries()[0]; d.NextAttempt < uint64(time.Now().Add(59*time.Minute).Unix()) {
+		t.Errorf("next attempt at %d, want an hour from now", d.NextAttempt)
+	}
+}
+
+func TestWebhookConfirmations(t *testing.T) {
+	rcv, url := newReceiver(t, http.StatusOK)
+	nt := newNotifierTest(t, url, 3)
+	nt.addBlock(1, "aa", watched, other)
+	nt.poll()
+	nt.addBlock(2, "aa", other, other)
+	nt.poll()
+	if len(rcv.bodies) != 0 {
+		t.Fatalf("posted with 2 confirmations of 3")
+	}
+	if d := nt.deliveries(); len(d) != 1 || d[0].Status != "pending" {
+		t.Fatalf("deliveries = %+v", d)
+	}
+
+	nt.addBlock(3, "aa", other, other)
+	nt.poll()
+	payloads := rcv.payloads(t)
+	if len(payloads) != 1 || payloads[0].Confirmations != 3 {
+		t.Fatalf("payloads = %+v", payloads)
+	}
+}
+
+func TestWebhookOrphaned(t *testing.T) {
+	rcv, url := newReceiver(t, http.StatusOK)
+	nt := newNotifierTest(t, url, 2)
+	nt.addBlock(1, "aa", other, other)
+	orphan := nt.addBlock(2, "aa", watched, other)
+	nt.poll()
+
+	if _, err := nt.db.ForkBlock(context.Background(), 2); err != nil {
+		t.Fatal(err)
+	}
+	nt.addBlock(2, "bb", other, other)
+	nt.addBlock(3, "bb", other, watched)
+	nt.poll()
+
+	if len(rcv.bodies) != 0 {
+		t.Errorf("posted %d webhooks of an orphaned block", len(rcv.bodies))
+	}
+	byBlock := map[string]Delivery{}
+	for _, d := range nt.deliveries() {
+		byBlock[d.BlockHash] = d
+	}
+	if d := byBlock[orphan.Hash]; d.Status != "orphaned" || d.Attempts != 0 {
+		t.Errorf("delivery of the orphaned block: %s after %d attempts", d.Status, d.Attempts)
+	}
+	if d := byBlock[blockHash("bb", 3)]; d.Status != "pending" {
+		t.Errorf("delivery of the new block: %q", d.Status)
+	}
+
+	nt.addBlock(4, "bb", other, other)
+	nt.poll()
+	if payloads := rcv.payloads(t); len(payloads) != 1 || payloads[0].BlockHash != blockHash("bb", 3) {
+		t.Errorf("payloads = %+v", payloads)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	defer func(saved Config) { config_ = saved }(config_)
+	config_.WebhookRetryDelay = 30 * time.Second
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{100, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(tt.attempts); got != tt.want {
+			t.Errorf("retryDelay(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeWatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		watch Watch
+		want  Watch
+		err   bool
+	}{
+		{
+			name:  "address",
+			watch: Watch{Address: "0x8D3E2C6A4B7F1E9D0C5A3B2F4E6D8C1A9B7E5F3D", URL: "https://example.com/hook"},
+			want:  Watch{Address: watched, URL: "https://example.com/hook"},
+		},
+		{
+			name:  "contract",
+			watch: Watch{Contract: other, URL: "http://10.0.0.1:8080/"},
+			want:  Watch{Contract: other, URL: "http://10.0.0.1:8080/"},
+		},
+		{name: "nothing watched", watch: Watch{URL: "https://example.com"}, err: true},
+		{name: "short address", watch: Watch{Address: "0x8d3e", URL: "https://example.com"}, err: true},
+		{name: "bad contract", watch: Watch{Address: watched, Contract: "8d3e2c6a4b7f1e9d0c5a3b2f4e6d8c1a9b7e5f3d", URL: "https://example.com"}, err: true},
+		{name: "no url", watch: Watch{Address: watched}, err: true},
+		{name: "ftp url", watch: Watch{Address: watched, URL: "ftp://example.com"}, err: true},
+		{name: "no host", watch: Watch{Address: watched, URL: "https:///hook"}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := tt.watch
+			err := normalizeWatch(&w)
+			if tt.err {
+				if err == nil {
+					t.Errorf("accepted %+v", tt.watch)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w != tt.want {
+				t.Errorf("got %+v, want %+v", w, tt.want)
+			}
+		})
+	}
+}